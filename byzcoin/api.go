@@ -2,7 +2,6 @@ package byzcoin
 
 import (
 	"bytes"
-	"math"
 	"math/rand"
 	"time"
 
@@ -131,6 +130,35 @@ func (c *Client) GetAllByzCoinIDs(si *network.ServerIdentity) (*GetAllByzCoinIDs
 	return reply, nil
 }
 
+// GetContractVersions returns the versions of the contracts supported by the
+// server given in parameter, and the versions active on the chain of the
+// client.
+func (c *Client) GetContractVersions(si *network.ServerIdentity) (*GetContractVersionsResponse, error) {
+	reply := &GetContractVersionsResponse{}
+	err := c.SendProtobuf(si, &GetContractVersions{SkipChainID: c.ID}, reply)
+	if err != nil {
+		return nil, xerrors.Errorf("client request: %v", err)
+	}
+
+	return reply, nil
+}
+
+// GetCheckpoint returns the checkpoint of the block at the given index, or
+// the latest checkpoint if the index is 0. The signature of the checkpoint is
+// not verified, use VerifyCheckpointFile on the file of the checkpoint.
+func (c *Client) GetCheckpoint(index int) (*GetCheckpointResponse, error) {
+	reply := &GetCheckpointResponse{}
+	_, err := c.SendProtobufParallel(c.Roster.List, &GetCheckpoint{
+		SkipChainID: c.ID,
+		Index:       index,
+	}, reply, c.options)
+	if err != nil {
+		return nil, xerrors.Errorf("client request: %v", err)
+	}
+
+	return reply, nil
+}
+
 // CreateTransaction creates a transaction from a list of instructions.
 func (c *Client) CreateTransaction(instrs ...Instruction) (ClientTransaction, error) {
 	if c.Latest == nil {
@@ -201,6 +229,28 @@ func (c *Client) AddTransactionAndWait(tx ClientTransaction, wait int) (*AddTxRe
 	return reply, nil
 }
 
+// SimulateTransaction runs the transaction against the latest state of the
+// ledger without adding it. If the transaction would be refused, the response
+// is returned together with an error holding the reason.
+func (c *Client) SimulateTransaction(tx ClientTransaction) (*SimulateTxResponse, error) {
+	reply := &SimulateTxResponse{}
+	_, err := c.SendProtobufParallel(c.Roster.List, &SimulateTxRequest{
+		Version:     CurrentVersion,
+		SkipchainID: c.ID,
+		Transaction: tx,
+	}, reply, c.options)
+	if err != nil {
+		return nil, xerrors.Errorf("sending: %v", err)
+	}
+
+	if reply.Error != "" {
+		return reply, xerrors.Errorf("instruction %d: %s", reply.ErrorIndex,
+			reply.Error)
+	}
+
+	return reply, nil
+}
+
 // GetProof returns a proof for the key stored in the skipchain starting from
 // the genesis block. The proof can prove the existence or the absence of the
 // key. Note that the integrity of the proof is verified.
@@ -264,6 +314,59 @@ func (c *Client) GetProofAfter(key []byte, full bool, block *skipchain.SkipBlock
 	return rep, cothority.ErrorOrNil(err, "request failed")
 }
 
+// GetProofAt returns a proof for the key against the state as it was after the
+// block with the given index has been applied. The proof starts from the
+// genesis block and its integrity is verified.
+func (c *Client) GetProofAt(key []byte, index int) (*GetProofResponse, error) {
+	if c.Genesis == nil {
+		if err := c.fetchGenesis(); err != nil {
+			return nil, xerrors.Errorf("fetching genesis block: %v", err)
+		}
+	}
+
+	decoder := func(buf []byte, msg interface{}) error {
+		err := protobuf.Decode(buf, msg)
+		if err != nil {
+			return xerrors.Errorf("decoding: %+v", err)
+		}
+
+		gpr, ok := msg.(*GetProofResponse)
+		if !ok {
+			return xerrors.New("couldn't cast msg")
+		}
+
+		if err := gpr.Proof.VerifyFromBlock(c.Genesis); err != nil {
+			return xerrors.Errorf("proof verification: %+v", err)
+		}
+
+		if gpr.Proof.Latest.Index != index {
+			return xerrors.Errorf("got proof for block %d instead of %d",
+				gpr.Proof.Latest.Index, index)
+		}
+
+		return nil
+	}
+
+	req := &GetProof{
+		Version: CurrentVersion,
+		Key:     key,
+		ID:      c.Genesis.Hash,
+	}
+	if index == 0 {
+		req.AtBlockID = c.Genesis.Hash
+	} else {
+		req.AtIndex = index
+	}
+
+	reply := &GetProofResponse{}
+	_, err := c.SendProtobufParallelWithDecoder(c.Roster.List, req, reply, c.options, decoder)
+	if err != nil {
+		return nil, xerrors.Errorf("sending: %+v", err)
+	}
+
+	return reply, nil
+}
+
 func (c *Client) getProofRaw(key []byte, from, include *skipchain.SkipBlock) (*GetProofResponse, error) {
 	decoder := func(buf []byte, msg interface{}) error {
 		err := protobuf.Decode(buf, msg)
@@ -310,6 +413,77 @@ func (c *Client) getProofRaw(key []byte, from, include *skipchain.SkipBlock) (*G
 	return reply, nil
 }
 
+// GetProofs returns one proof for all the keys, which is smaller than one proof
+// per key as the parts they share are only sent once. The proof starts from
+// the genesis block, and its integrity is verified, together with the fact
+// that it holds the path of every key. Use MultiProof.Proof to get the proof
+// of one of the keys.
+func (c *Client) GetProofs(keys ...[]byte) (*GetProofsResponse, error) {
+	if c.Genesis == nil {
+		if err := c.fetchGenesis(); err != nil {
+			return nil, xerrors.Errorf("fetching genesis block: %v", err)
+		}
+	}
+
+	rep, err := c.getProofsRaw(keys, c.Genesis)
+	return rep, cothority.ErrorOrNil(err, "request failed")
+}
+
+// GetProofsFromLatest works like GetProofs, but the proof starts from the
+// latest known block by this client, like with GetProofFromLatest.
+func (c *Client) GetProofsFromLatest(keys ...[]byte) (*GetProofsResponse, error) {
+	if c.Latest == nil {
+		return c.GetProofs(keys...)
+	}
+
+	rep, err := c.getProofsRaw(keys, c.Latest)
+	return rep, cothority.ErrorOrNil(err, "request failed")
+}
+
+func (c *Client) getProofsRaw(keys [][]byte, from *skipchain.SkipBlock) (*GetProofsResponse, error) {
+	decoder := func(buf []byte, msg interface{}) error {
+		err := protobuf.Decode(buf, msg)
+		if err != nil {
+			return xerrors.Errorf("decoding: %+v", err)
+		}
+
+		gpr, ok := msg.(*GetProofsResponse)
+		if !ok {
+			return xerrors.New("couldn't cast msg")
+		}
+
+		if err := gpr.Proof.VerifyFromBlock(from); err != nil {
+			return xerrors.Errorf("proof verification: %+v", err)
+		}
+
+		for _, key := range keys {
+			if _, err := gpr.Proof.InclusionProof.Exists(key); err != nil {
+				return xerrors.Errorf("proof of key %x: %+v", key, err)
+			}
+		}
+
+		return nil
+	}
+
+	req := &GetProofs{
+		Version: CurrentVersion,
+		Keys:    keys,
+		ID:      from.Hash,
+	}
+
+	reply := &GetProofsResponse{}
+	_, err := c.SendProtobufParallelWithDecoder(c.Roster.List, req, reply, c.options, decoder)
+	if err != nil {
+		return nil, xerrors.Errorf("sending: %+v", err)
+	}
+
+	if c.Latest == nil || c.Latest.Index < reply.Proof.Latest.Index {
+		c.Latest = &reply.Proof.Latest
+	}
+
+	return reply, nil
+}
+
 // GetDeferredData makes a request to retrieve the deferred instruction data
 // and return the reply if the proof can be verified.
 func (c *Client) GetDeferredData(instrID InstanceID) (*DeferredData, error) {
@@ -497,9 +671,15 @@ func (c *Client) WaitProof(id InstanceID, interval time.Duration, value []byte)
 // It contacts any random node by default. A specific node can be chosen by
 // using `c.UseNode`.
 func (c *Client) StreamTransactions(handler func(StreamingResponse, error)) error {
-	req := StreamingRequest{
-		ID: c.ID,
-	}
+	return c.StreamFilteredTransactions(StreamingRequest{}, handler)
+}
+
+// StreamFilteredTransactions works like StreamTransactions, but uses the
+// filters and the start index given in req. The ID of req is set to the ID of
+// the client. When filters are set, the payload of the blocks only holds the
+// matching transactions and cannot be verified against the block header.
+func (c *Client) StreamFilteredTransactions(req StreamingRequest, handler func(StreamingResponse, error)) error {
+	req.ID = c.ID
 	n := int(rand.Int31n(int32(len(c.Roster.List))))
 	if c.options != nil {
 		if c.options.DontShuffle {
@@ -581,10 +761,74 @@ func (c *Client) GetSignerCounters(ids ...string) (*GetSignerCountersResponse, e
 	return &reply, cothority.ErrorOrNil(err, "request failed")
 }
 
+// GetSignerLaneCounters gets the counters of the given lane of the signers.
+// The instructions using the lane must set it in SignerLanes and use the
+// counter of the lane, which is independent from the other lanes.
+func (c *Client) GetSignerLaneCounters(lane uint64, ids ...string) (*GetSignerCountersResponse, error) {
+	req := GetSignerCounters{
+		SkipchainID: c.ID,
+		SignerIDs:   ids,
+		SignerLanes: make([]uint64, len(ids)),
+	}
+	for i := range req.SignerLanes {
+		req.SignerLanes[i] = lane
+	}
+	var reply GetSignerCountersResponse
+	_, err := c.SendProtobufParallelWithDecoder(c.Roster.List, &req, &reply,
+		c.options, c.signerCounterDecoder)
+	return &reply, cothority.ErrorOrNil(err, "request failed")
+}
+
+// GetTransaction returns the transaction with the given hash, as returned by
+// ClientTransaction.Instructions.Hash, together with the block that holds it.
+// The block is verified to be part of the skipchain and to hold the
+// transaction. TxResult.Accepted tells whether the transaction has been
+// applied or refused.
+func (c *Client) GetTransaction(hash []byte) (*GetTxStatusResponse, error) {
+	if c.Genesis == nil {
+		if err := c.fetchGenesis(); err != nil {
+			return nil, xerrors.Errorf("fetching genesis block: %v", err)
+		}
+	}
+
+	decoder := func(buf []byte, msg interface{}) error {
+		err := protobuf.Decode(buf, msg)
+		if err != nil {
+			return xerrors.Errorf("decoding: %v", err)
+		}
+
+		reply, ok := msg.(*GetTxStatusResponse)
+		if !ok {
+			return xerrors.New("couldn't cast msg")
+		}
+
+		if err := reply.Verify(c.Genesis); err != nil {
+			return xerrors.Errorf("verification: %v", err)
+		}
+		if !bytes.Equal(reply.TxResult.ClientTransaction.Instructions.Hash(), hash) {
+			return xerrors.New("got the wrong transaction")
+		}
+		return nil
+	}
+
+	reply := &GetTxStatusResponse{}
+	_, err := c.SendProtobufParallelWithDecoder(c.Roster.List, &GetTxStatus{
+		SkipChainID: c.ID,
+		TxHash:      hash,
+	}, reply, c.options, decoder)
+	if err != nil {
+		return nil, xerrors.Errorf("sending: %v", err)
+	}
+
+	return reply, nil
+}
+
 // DownloadState is used by a new node to ask to download the global state.
 // The first call to DownloadState needs to have start = 0, so that the
 // service creates a snapshot of the current state which it will serve over
-// multiple requests.
+// multiple requests. As the nodes only open a snapshot for a signed request
+// of a node of the roster, the first call is refused: use DownloadStateFrom
+// with a request signed by DownloadState.Sign instead.
 //
 // Every subsequent request should have start incremented by 'len'.
 // If start > than the number of StateChanges available, an empty slice of
@@ -601,12 +845,7 @@ func (c *Client) DownloadState(byzcoinID skipchain.SkipBlockID, nonce uint64, le
 	}
 
 	reply = &DownloadStateResponse{}
-	l := len(c.Roster.List)
-	indexStart := 0
-	if l > 3 {
-		// This is the leader plus the subleaders, don't contact them
-		indexStart = 1 + int(math.Ceil(math.Pow(float64(l), 1./3.)))
-	}
+	indexStart := downloadStartIndex(len(c.Roster.List))
 
 	msg := &DownloadState{
 		ByzCoinID: byzcoinID,
@@ -631,6 +870,24 @@ func (c *Client) DownloadState(byzcoinID skipchain.SkipBlockID, nonce uint64, le
 	return
 }
 
+// DownloadStateFrom sends the request to the given node. Contrary to
+// DownloadState, the request can give the range of keys to download, so that
+// the state can be downloaded from many nodes at the same time, and a
+// download can be resumed after the last key received. A new download must
+// be signed by a node of the roster, see DownloadState.Sign.
+func (c *Client) DownloadStateFrom(si *network.ServerIdentity, req *DownloadState) (*DownloadStateResponse, error) {
+	if req.Length <= 0 {
+		return nil, xerrors.New("invalid parameter")
+	}
+
+	reply := &DownloadStateResponse{}
+	err := c.SendProtobuf(si, req, reply)
+	if err != nil {
+		return nil, xerrors.Errorf("request failed: %v", err)
+	}
+	return reply, nil
+}
+
 // ResolveInstanceID resolves the instance ID using the given darc ID and name.
 // The name must be already set by calling the naming contract.
 func (c *Client) ResolveInstanceID(darcID darc.ID, name string) (InstanceID, error) {
@@ -645,6 +902,99 @@ func (c *Client) ResolveInstanceID(darcID darc.ID, name string) (InstanceID, err
 	return reply.InstanceID, cothority.ErrorOrNil(err, "request failed")
 }
 
+// Query runs the read-only query method on the instance and returns its
+// result. The contract of the instance must implement ContractQuerier. The
+// result is not proven, so the node that answers must be trusted.
+func (c *Client) Query(iid InstanceID, method string, args Arguments) ([]byte, error) {
+	req := QueryInstance{
+		SkipChainID: c.ID,
+		InstanceID:  iid,
+		Method:      method,
+		Args:        args,
+	}
+	reply := QueryInstanceResponse{}
+
+	_, err := c.SendProtobufParallel(c.Roster.List, &req, &reply, c.options)
+	if err != nil {
+		return nil, xerrors.Errorf("request failed: %v", err)
+	}
+	return reply.Result, nil
+}
+
+// SearchEvents returns the events emitted in the blocks from the index from to
+// the index to, included, with the given name and contract ID. An empty name
+// or contract ID matches all the events, and to can be 0 to search up to the
+// latest block. If the range is too big, the Next field of the response is
+// the index where the search must continue. The events are not verified.
+func (c *Client) SearchEvents(name, contractID string, from, to int) (*SearchEventsResponse, error) {
+	req := SearchEvents{
+		SkipChainID: c.ID,
+		Name:        name,
+		ContractID:  contractID,
+		From:        from,
+		To:          to,
+	}
+	reply := &SearchEventsResponse{}
+
+	_, err := c.SendProtobufParallel(c.Roster.List, &req, reply, c.options)
+	if err != nil {
+		return nil, xerrors.Errorf("request failed: %v", err)
+	}
+	return reply, nil
+}
+
+// GetStateDiff returns the instances that have been created, updated or
+// removed by the blocks after the index from, up to and including the index
+// to, with their old and new values. The differences are not verified and
+// should be checked using proofs if the node is not trusted.
+func (c *Client) GetStateDiff(from, to int) (*GetStateDiffResponse, error) {
+	req := &GetStateDiff{
+		SkipChainID: c.ID,
+		FromIndex:   from,
+		ToIndex:     to,
+	}
+	reply := &GetStateDiffResponse{}
+
+	_, err := c.SendProtobufParallel(c.Roster.List, req, reply, c.options)
+	if err != nil {
+		return nil, xerrors.Errorf("request failed: %v", err)
+	}
+	return reply, nil
+}
+
+// ListInstancesByContract returns a page of at most limit instances of the
+// given contract, starting at the instance ID start. Use the Next field of
+// the response as start to get the next page. The list is not verified and
+// should be checked using proofs if the node is not trusted.
+func (c *Client) ListInstancesByContract(contractID string, start []byte, limit int) (*ListInstancesResponse, error) {
+	return c.listInstances(&ListInstances{
+		SkipChainID: c.ID,
+		ContractID:  contractID,
+		Start:       start,
+		Limit:       limit,
+	})
+}
+
+// ListInstancesByDarc works like ListInstancesByContract, but returns the
+// instances guarded by the given darc.
+func (c *Client) ListInstancesByDarc(darcID darc.ID, start []byte, limit int) (*ListInstancesResponse, error) {
+	return c.listInstances(&ListInstances{
+		SkipChainID: c.ID,
+		DarcID:      darcID,
+		Start:       start,
+		Limit:       limit,
+	})
+}
+
+func (c *Client) listInstances(req *ListInstances) (*ListInstancesResponse, error) {
+	reply := &ListInstancesResponse{}
+	_, err := c.SendProtobufParallel(c.Roster.List, req, reply, c.options)
+	if err != nil {
+		return nil, xerrors.Errorf("request failed: %v", err)
+	}
+	return reply, nil
+}
+
 // WaitPropagation contacts all nodes in the cl.Roster until they all
 // have the same latest block. If there is an error when calling
 // `GetProof`, the error will be ignored. This helps when waiting
@@ -732,6 +1082,27 @@ func DebugRemove(si *network.ServerIdentity, byzcoinID skipchain.SkipBlockID) er
 	return cothority.ErrorOrNil(err, "request failed")
 }
 
+// SetNodeConfig replaces the settings of the node. The private key of the
+// node must be in the server identity. The current time is used as the
+// sequence number of the request.
+func SetNodeConfig(si *network.ServerIdentity, config NodeConfig) error {
+	request := &SetNodeConfigRequest{
+		Config:   config,
+		Sequence: uint64(time.Now().UnixNano()),
+	}
+	msg, err := request.Hash()
+	if err != nil {
+		return err
+	}
+	request.Signature, err = schnorr.Sign(cothority.Suite, si.GetPrivate(), msg)
+	if err != nil {
+		return xerrors.Errorf("sign error: %v", err)
+	}
+	err = onet.NewClient(cothority.Suite, ServiceName).SendProtobuf(si, request,
+		&SetNodeConfigResponse{})
+	return cothority.ErrorOrNil(err, "request failed")
+}
+
 // DefaultGenesisMsg creates the message that is used to for creating the
 // genesis Darc and block. It will contain rules for spawning and evolving the
 // darc contract.
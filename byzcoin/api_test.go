@@ -278,6 +278,65 @@ func TestClient_Streaming(t *testing.T) {
 	require.NoError(t, c1.Close())
 }
 
+// Replay the blocks of the chain with filters. The blocks must be sent with
+// only the matching transactions.
+func TestClient_StreamingFiltered(t *testing.T) {
+	l := onet.NewTCPTest(cothority.Suite)
+	servers, roster, _ := l.GenTree(3, true)
+	registerDummy(servers)
+	defer l.CloseAll()
+
+	signer := darc.NewSignerEd25519(nil, nil)
+	msg, err := DefaultGenesisMsg(CurrentVersion, roster, []string{"spawn:dummy"}, signer.Identity())
+	require.NoError(t, err)
+	msg.BlockInterval = 100 * time.Millisecond
+	d := msg.GenesisDarc
+
+	c, _, err := NewLedger(msg, false)
+	require.NoError(t, err)
+
+	tx, err := createOneClientTxWithCounter(d.GetBaseID(), "dummy", []byte{1}, signer, 1)
+	require.NoError(t, err)
+	_, err = c.AddTransactionAndWait(tx, 10)
+	require.NoError(t, err)
+
+	stream := func(req StreamingRequest) TxResults {
+		c1 := NewClientKeep(c.ID, *roster)
+		defer c1.Close()
+
+		blocks := make(chan *skipchain.SkipBlock, 1)
+		go c1.StreamFilteredTransactions(req, func(resp StreamingResponse, err error) {
+			if err == nil {
+				select {
+				case blocks <- resp.Block:
+				default:
+				}
+			}
+		})
+
+		select {
+		case sb := <-blocks:
+			require.Equal(t, 1, sb.Index)
+			var body DataBody
+			require.NoError(t, protobuf.Decode(sb.Payload, &body))
+			return body.TxResults
+		case <-time.After(10 * msg.BlockInterval):
+			require.Fail(t, "didn't get the replayed block")
+		}
+		return nil
+	}
+
+	txs := stream(StreamingRequest{StartIndex: 1, ContractIDs: []string{"dummy"}})
+	require.Equal(t, 1, len(txs))
+	require.Equal(t, "dummy", txs[0].ClientTransaction.Instructions[0].ContractID())
+
+	txs = stream(StreamingRequest{StartIndex: 1, DarcIDs: []darc.ID{d.GetBaseID()}})
+	require.Equal(t, 1, len(txs))
+
+	txs = stream(StreamingRequest{StartIndex: 1, ContractIDs: []string{"other"}})
+	require.Equal(t, 0, len(txs))
+}
+
 func TestClient_NoPhantomSkipchain(t *testing.T) {
 	l := onet.NewTCPTest(cothority.Suite)
 	servers, roster, _ := l.GenTree(3, true)
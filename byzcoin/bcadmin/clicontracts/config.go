@@ -11,7 +11,6 @@ import (
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/byzcoin"
 	"go.dedis.ch/cothority/v3/byzcoin/bcadmin/lib"
-	"go.dedis.ch/cothority/v3/darc"
 	"go.dedis.ch/onet/v3/network"
 	"go.dedis.ch/protobuf"
 )
@@ -36,18 +35,6 @@ func ConfigInvokeUpdateConfig(c *cli.Context) error {
 		return err
 	}
 
-	var signer *darc.Signer
-
-	sstr := c.String("sign")
-	if sstr == "" {
-		signer, err = lib.LoadKey(cfg.AdminIdentity)
-	} else {
-		signer, err = lib.LoadKeyFromString(sstr)
-	}
-	if err != nil {
-		return err
-	}
-
 	// Get the latest chain config
 	pr, err := cl.GetProofFromLatest(byzcoin.ConfigInstanceID.Slice())
 	if err != nil {
@@ -85,6 +72,57 @@ func ConfigInvokeUpdateConfig(c *cli.Context) error {
 		config.MaxBlockSize = maxBlockSize
 	}
 
+	// CheckpointInterval
+	if c.IsSet("checkpointInterval") {
+		checkpointInterval := c.Int("checkpointInterval")
+		if checkpointInterval < 0 {
+			return xerrors.New("checkpointInterval must not be negative")
+		}
+		config.CheckpointInterval = checkpointInterval
+	}
+
+	// ArchiveNodes
+	// we need the addresses to be separated by commas
+	archiveNodes := c.String("archiveNodes")
+	if archiveNodes != "" {
+		config.ArchiveNodes = nil
+		for _, addr := range strings.Split(archiveNodes, ",") {
+			var found *network.ServerIdentity
+			for _, si := range config.Roster.List {
+				if si.Address.String() == addr {
+					found = si
+				}
+			}
+			if found == nil {
+				return xerrors.Errorf("archive node %s is not in the roster", addr)
+			}
+			config.ArchiveNodes = append(config.ArchiveNodes, found)
+		}
+	}
+
+	// TxQuota
+	quotas := []struct {
+		flag  string
+		value func(*byzcoin.TxQuota) *int
+	}{
+		{"maxPendingTxs", func(q *byzcoin.TxQuota) *int { return &q.MaxPendingTxs }},
+		{"maxPendingBytes", func(q *byzcoin.TxQuota) *int { return &q.MaxPendingBytes }},
+		{"maxSignerTxs", func(q *byzcoin.TxQuota) *int { return &q.MaxSignerTxs }},
+		{"maxSignerBytes", func(q *byzcoin.TxQuota) *int { return &q.MaxSignerBytes }},
+	}
+	for _, quota := range quotas {
+		if !c.IsSet(quota.flag) {
+			continue
+		}
+		if c.Int(quota.flag) < 0 {
+			return xerrors.Errorf("%s must not be negative", quota.flag)
+		}
+		if config.TxQuota == nil {
+			config.TxQuota = &byzcoin.TxQuota{}
+		}
+		*quota.value(config.TxQuota) = c.Int(quota.flag)
+	}
+
 	// DarcContractIDs
 	// we need the IDs to be separated by commas
 	darcContractIDs := c.String("darcContractIDs")
@@ -98,11 +136,6 @@ func ConfigInvokeUpdateConfig(c *cli.Context) error {
 		return xerrors.Errorf("failed to encode config: %v", err)
 	}
 
-	counters, err := cl.GetSignerCounters(signer.Identity().String())
-	if err != nil {
-		return xerrors.Errorf("couldn't get counters: %v", err)
-	}
-
 	invoke := byzcoin.Invoke{
 		ContractID: byzcoin.ContractConfigID,
 		Command:    "update_config",
@@ -117,20 +150,14 @@ func ConfigInvokeUpdateConfig(c *cli.Context) error {
 	// ---
 	// 2.
 	// ---
-	ctx, err := cl.CreateTransaction(byzcoin.Instruction{
-		InstanceID:    byzcoin.ConfigInstanceID,
-		Invoke:        &invoke,
-		SignerCounter: []uint64{counters.Counters[0] + 1},
+	ctx, err := lib.CreateTransaction(c, cfg, cl, byzcoin.Instruction{
+		InstanceID: byzcoin.ConfigInstanceID,
+		Invoke:     &invoke,
 	})
 	if err != nil {
 		return err
 	}
 
-	err = ctx.FillSignersAndSignWith(*signer)
-	if err != nil {
-		return err
-	}
-
 	if lib.FindRecursivefBool("export", c) {
 		return lib.ExportTransaction(ctx)
 	}
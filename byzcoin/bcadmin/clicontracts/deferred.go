@@ -62,23 +62,6 @@ func DeferredSpawn(c *cli.Context) error {
 		return err
 	}
 
-	var signer *darc.Signer
-
-	sstr := c.String("sign")
-	if sstr == "" {
-		signer, err = lib.LoadKey(cfg.AdminIdentity)
-	} else {
-		signer, err = lib.LoadKeyFromString(sstr)
-	}
-	if err != nil {
-		return err
-	}
-
-	counters, err := cl.GetSignerCounters(signer.Identity().String())
-	if err != nil {
-		return xerrors.Errorf("couldn't get signer counters: %+v", err)
-	}
-
 	spawn := byzcoin.Spawn{
 		ContractID: byzcoin.ContractDeferredID,
 		Args: []byzcoin.Argument{
@@ -89,20 +72,14 @@ func DeferredSpawn(c *cli.Context) error {
 		},
 	}
 
-	ctx, err := cl.CreateTransaction(byzcoin.Instruction{
-		InstanceID:    byzcoin.NewInstanceID(d.GetBaseID()),
-		Spawn:         &spawn,
-		SignerCounter: []uint64{counters.Counters[0] + 1},
+	ctx, err := lib.CreateTransaction(c, cfg, cl, byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID(d.GetBaseID()),
+		Spawn:      &spawn,
 	})
 	if err != nil {
 		return err
 	}
 
-	err = ctx.FillSignersAndSignWith(*signer)
-	if err != nil {
-		return err
-	}
-
 	if lib.FindRecursivefBool("export", c) {
 		return lib.ExportTransaction(ctx)
 	}
@@ -215,12 +192,7 @@ func DeferredInvokeAddProof(c *cli.Context) error {
 	// ---
 	// 3.
 	// ---
-	counters, err := cl.GetSignerCounters(signer.Identity().String())
-	if err != nil {
-		return xerrors.Errorf("couldn't get signer counters: %+v", err)
-	}
-
-	ctx, err := cl.CreateTransaction(byzcoin.Instruction{
+	ctx, err := lib.CreateTransaction(c, cfg, cl, byzcoin.Instruction{
 		InstanceID: byzcoin.NewInstanceID(instIDBuf),
 		Invoke: &byzcoin.Invoke{
 			ContractID: byzcoin.ContractDeferredID,
@@ -240,17 +212,11 @@ func DeferredInvokeAddProof(c *cli.Context) error {
 				},
 			},
 		},
-		SignerCounter: []uint64{counters.Counters[0] + 1},
 	})
 	if err != nil {
 		return err
 	}
 
-	err = ctx.FillSignersAndSignWith(*signer)
-	if err != nil {
-		return err
-	}
-
 	if lib.FindRecursivefBool("export", c) {
 		return lib.ExportTransaction(ctx)
 	}
@@ -309,18 +275,6 @@ func ExecProposedTx(c *cli.Context) error {
 		return xerrors.Errorf("couldn't load config: %+v", err)
 	}
 
-	var signer *darc.Signer
-
-	sstr := c.String("sign")
-	if sstr == "" {
-		signer, err = lib.LoadKey(cfg.AdminIdentity)
-	} else {
-		signer, err = lib.LoadKeyFromString(sstr)
-	}
-	if err != nil {
-		return xerrors.Errorf("couldn't load key: %+v", err)
-	}
-
 	instID := c.String("instid")
 	if instID == "" {
 		return xerrors.New("--instid flag is required")
@@ -333,28 +287,17 @@ func ExecProposedTx(c *cli.Context) error {
 	// ---
 	// 2.
 	// ---
-	counters, err := cl.GetSignerCounters(signer.Identity().String())
-	if err != nil {
-		return xerrors.Errorf("couldn't get counters: %+v", err)
-	}
-
-	ctx, err := cl.CreateTransaction(byzcoin.Instruction{
+	ctx, err := lib.CreateTransaction(c, cfg, cl, byzcoin.Instruction{
 		InstanceID: byzcoin.NewInstanceID(instIDBuf),
 		Invoke: &byzcoin.Invoke{
 			ContractID: byzcoin.ContractDeferredID,
 			Command:    "execProposedTx",
 		},
-		SignerCounter: []uint64{counters.Counters[0] + 1},
 	})
 	if err != nil {
 		return xerrors.Errorf("couldn't create transaction: %+v", err)
 	}
 
-	err = ctx.FillSignersAndSignWith(*signer)
-	if err != nil {
-		return xerrors.Errorf("couldn't fill signers and sign: %+v", err)
-	}
-
 	if lib.FindRecursivefBool("export", c) {
 		return lib.ExportTransaction(ctx)
 	}
@@ -475,39 +418,17 @@ func DeferredDelete(c *cli.Context) error {
 		dstr = cfg.AdminDarc.GetIdentityString()
 	}
 
-	var signer *darc.Signer
-
-	sstr := c.String("sign")
-	if sstr == "" {
-		signer, err = lib.LoadKey(cfg.AdminIdentity)
-	} else {
-		signer, err = lib.LoadKeyFromString(sstr)
-	}
-	if err != nil {
-		return err
-	}
-
-	counters, err := cl.GetSignerCounters(signer.Identity().String())
-	if err != nil {
-		return xerrors.Errorf("couldn't get signer counters: %+v", err)
-	}
-
 	delete := byzcoin.Delete{
 		ContractID: byzcoin.ContractDeferredID,
 	}
 
-	ctx, err := cl.CreateTransaction(byzcoin.Instruction{
-		InstanceID:    byzcoin.NewInstanceID([]byte(instIDBuf)),
-		Delete:        &delete,
-		SignerCounter: []uint64{counters.Counters[0] + 1},
+	ctx, err := lib.CreateTransaction(c, cfg, cl, byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID([]byte(instIDBuf)),
+		Delete:     &delete,
 	})
 	if err != nil {
 		return err
 	}
-	err = ctx.FillSignersAndSignWith(*signer)
-	if err != nil {
-		return err
-	}
 
 	if lib.FindRecursivefBool("export", c) {
 		return lib.ExportTransaction(ctx)
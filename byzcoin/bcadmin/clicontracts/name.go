@@ -7,7 +7,6 @@ import (
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/byzcoin"
 	"go.dedis.ch/cothority/v3/byzcoin/bcadmin/lib"
-	"go.dedis.ch/cothority/v3/darc"
 	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
 	"go.dedis.ch/protobuf"
@@ -31,31 +30,18 @@ func NameSpawn(c *cli.Context) error {
 		return xerrors.Errorf("failed to get genesis darc: %v", err)
 	}
 
-	var signer *darc.Signer
-
-	sstr := c.String("sign")
-	if sstr == "" {
-		signer, err = lib.LoadKey(cfg.AdminIdentity)
-	} else {
-		signer, err = lib.LoadKeyFromString(sstr)
-	}
-	if err != nil {
-		return err
-	}
-
-	counters, err := cl.GetSignerCounters(signer.Identity().String())
-
-	ctx, err := cl.CreateTransaction(byzcoin.Instruction{
+	ctx, err := lib.CreateTransaction(c, cfg, cl, byzcoin.Instruction{
 		InstanceID: byzcoin.NewInstanceID(gDarc.GetBaseID()),
 		Spawn: &byzcoin.Spawn{
 			ContractID: byzcoin.ContractNamingID,
 		},
-		SignerCounter: []uint64{counters.Counters[0] + 1},
 	})
-
-	err = ctx.FillSignersAndSignWith(*signer)
 	if err != nil {
-		return xerrors.Errorf("failed to fill signer: %v", err)
+		return err
+	}
+
+	if lib.FindRecursivefBool("export", c) {
+		return lib.ExportTransaction(ctx)
 	}
 
 	_, err = cl.AddTransactionAndWait(ctx, 10)
@@ -81,23 +67,6 @@ func NameInvokeAdd(c *cli.Context) error {
 		return err
 	}
 
-	var signer *darc.Signer
-
-	sstr := c.String("sign")
-	if sstr == "" {
-		signer, err = lib.LoadKey(cfg.AdminIdentity)
-	} else {
-		signer, err = lib.LoadKeyFromString(sstr)
-	}
-	if err != nil {
-		return err
-	}
-
-	counters, err := cl.GetSignerCounters(signer.Identity().String())
-	if err != nil {
-		return xerrors.Errorf("failed to get signer counters: %v", err)
-	}
-
 	name := c.String("name")
 	if name == "" {
 		return xerrors.New("--name flag is required")
@@ -146,18 +115,16 @@ func NameInvokeAdd(c *cli.Context) error {
 					},
 				},
 			},
-			SignerCounter: []uint64{counters.Counters[0] + 1 + uint64(i)},
 		}
 	}
 
-	ctx, err := cl.CreateTransaction(instructions...)
+	ctx, err := lib.CreateTransaction(c, cfg, cl, instructions...)
 	if err != nil {
 		return err
 	}
 
-	err = ctx.FillSignersAndSignWith(*signer)
-	if err != nil {
-		return err
+	if lib.FindRecursivefBool("export", c) {
+		return lib.ExportTransaction(ctx)
 	}
 
 	_, err = cl.AddTransactionAndWait(ctx, 10)
@@ -186,23 +153,6 @@ func NameInvokeRemove(c *cli.Context) error {
 		return err
 	}
 
-	var signer *darc.Signer
-
-	sstr := c.String("sign")
-	if sstr == "" {
-		signer, err = lib.LoadKey(cfg.AdminIdentity)
-	} else {
-		signer, err = lib.LoadKeyFromString(sstr)
-	}
-	if err != nil {
-		return err
-	}
-
-	counters, err := cl.GetSignerCounters(signer.Identity().String())
-	if err != nil {
-		return xerrors.Errorf("failed to get signer counters: %v", err)
-	}
-
 	name := c.String("name")
 	if name == "" {
 		return xerrors.New("--name flag is required")
@@ -218,7 +168,7 @@ func NameInvokeRemove(c *cli.Context) error {
 		return xerrors.New("failed to decode the instID string" + instID)
 	}
 
-	ctx, err := cl.CreateTransaction(byzcoin.Instruction{
+	ctx, err := lib.CreateTransaction(c, cfg, cl, byzcoin.Instruction{
 		InstanceID: byzcoin.NamingInstanceID,
 		Invoke: &byzcoin.Invoke{
 			ContractID: byzcoin.ContractNamingID,
@@ -234,15 +184,13 @@ func NameInvokeRemove(c *cli.Context) error {
 				},
 			},
 		},
-		SignerCounter: []uint64{counters.Counters[0] + 1},
 	})
 	if err != nil {
 		return err
 	}
 
-	err = ctx.FillSignersAndSignWith(*signer)
-	if err != nil {
-		return err
+	if lib.FindRecursivefBool("export", c) {
+		return lib.ExportTransaction(ctx)
 	}
 
 	_, err = cl.AddTransactionAndWait(ctx, 10)
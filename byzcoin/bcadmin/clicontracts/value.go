@@ -11,7 +11,6 @@ import (
 	"go.dedis.ch/cothority/v3/byzcoin"
 	"go.dedis.ch/cothority/v3/byzcoin/bcadmin/lib"
 	"go.dedis.ch/cothority/v3/byzcoin/contracts"
-	"go.dedis.ch/cothority/v3/darc"
 )
 
 // ValueSpawn is used to spawn a new contract.
@@ -41,20 +40,6 @@ func ValueSpawn(c *cli.Context) error {
 		return err
 	}
 
-	var signer *darc.Signer
-
-	sstr := c.String("sign")
-	if sstr == "" {
-		signer, err = lib.LoadKey(cfg.AdminIdentity)
-	} else {
-		signer, err = lib.LoadKeyFromString(sstr)
-	}
-	if err != nil {
-		return err
-	}
-
-	counters, err := cl.GetSignerCounters(signer.Identity().String())
-
 	spawn := byzcoin.Spawn{
 		ContractID: contracts.ContractValueID,
 		Args: []byzcoin.Argument{
@@ -65,20 +50,14 @@ func ValueSpawn(c *cli.Context) error {
 		},
 	}
 
-	ctx, err := cl.CreateTransaction(byzcoin.Instruction{
-		InstanceID:    byzcoin.NewInstanceID(d.GetBaseID()),
-		Spawn:         &spawn,
-		SignerCounter: []uint64{counters.Counters[0] + 1},
+	ctx, err := lib.CreateTransaction(c, cfg, cl, byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID(d.GetBaseID()),
+		Spawn:      &spawn,
 	})
 	if err != nil {
 		return err
 	}
 
-	err = ctx.FillSignersAndSignWith(*signer)
-	if err != nil {
-		return err
-	}
-
 	if lib.FindRecursivefBool("export", c) {
 		return lib.ExportTransaction(ctx)
 	}
@@ -126,20 +105,6 @@ func ValueInvokeUpdate(c *cli.Context) error {
 		dstr = cfg.AdminDarc.GetIdentityString()
 	}
 
-	var signer *darc.Signer
-
-	sstr := c.String("sign")
-	if sstr == "" {
-		signer, err = lib.LoadKey(cfg.AdminIdentity)
-	} else {
-		signer, err = lib.LoadKeyFromString(sstr)
-	}
-	if err != nil {
-		return err
-	}
-
-	counters, err := cl.GetSignerCounters(signer.Identity().String())
-
 	invoke := byzcoin.Invoke{
 		ContractID: contracts.ContractValueID,
 		Command:    "update",
@@ -151,18 +116,13 @@ func ValueInvokeUpdate(c *cli.Context) error {
 		},
 	}
 
-	ctx, err := cl.CreateTransaction(byzcoin.Instruction{
-		InstanceID:    byzcoin.NewInstanceID([]byte(instIDBuf)),
-		Invoke:        &invoke,
-		SignerCounter: []uint64{counters.Counters[0] + 1},
+	ctx, err := lib.CreateTransaction(c, cfg, cl, byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID([]byte(instIDBuf)),
+		Invoke:     &invoke,
 	})
 	if err != nil {
 		return err
 	}
-	err = ctx.FillSignersAndSignWith(*signer)
-	if err != nil {
-		return err
-	}
 
 	if lib.FindRecursivefBool("export", c) {
 		return lib.ExportTransaction(ctx)
@@ -256,36 +216,17 @@ func ValueDelete(c *cli.Context) error {
 		dstr = cfg.AdminDarc.GetIdentityString()
 	}
 
-	var signer *darc.Signer
-
-	sstr := c.String("sign")
-	if sstr == "" {
-		signer, err = lib.LoadKey(cfg.AdminIdentity)
-	} else {
-		signer, err = lib.LoadKeyFromString(sstr)
-	}
-	if err != nil {
-		return err
-	}
-
-	counters, err := cl.GetSignerCounters(signer.Identity().String())
-
 	delete := byzcoin.Delete{
 		ContractID: contracts.ContractValueID,
 	}
 
-	ctx, err := cl.CreateTransaction(byzcoin.Instruction{
-		InstanceID:    byzcoin.NewInstanceID([]byte(instIDBuf)),
-		Delete:        &delete,
-		SignerCounter: []uint64{counters.Counters[0] + 1},
+	ctx, err := lib.CreateTransaction(c, cfg, cl, byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID([]byte(instIDBuf)),
+		Delete:     &delete,
 	})
 	if err != nil {
 		return err
 	}
-	err = ctx.FillSignersAndSignWith(*signer)
-	if err != nil {
-		return err
-	}
 
 	if lib.FindRecursivefBool("export", c) {
 		return lib.ExportTransaction(ctx)
@@ -4,6 +4,8 @@ import (
 	"encoding/hex"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"strconv"
 	"strings"
 	"time"
 
@@ -345,6 +347,107 @@ func dbReset(c *cli.Context) error {
 	return nil
 }
 
+// dbCheckpoint verifies a checkpoint file and imports its state, together with
+// the blocks proving the checkpoint.
+func dbCheckpoint(c *cli.Context) error {
+	if c.NArg() < 3 {
+		return xerrors.New("please give the following arguments: " +
+			"conode.db byzCoinID checkpoint-file")
+	}
+	fb, err := newFetchBlocks(c)
+	if err != nil {
+		return xerrors.Errorf("couldn't create fetchBlock: %+v", err)
+	}
+
+	buf, err := ioutil.ReadFile(c.Args().Get(2))
+	if err != nil {
+		return xerrors.Errorf("couldn't read file: %+v", err)
+	}
+	var cf byzcoin.CheckpointFile
+	err = protobuf.DecodeWithConstructors(buf, &cf,
+		network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return xerrors.Errorf("couldn't decode checkpoint: %+v", err)
+	}
+	err = byzcoin.VerifyCheckpointFile(&cf, *fb.bcID)
+	if err != nil {
+		return xerrors.Errorf("invalid checkpoint: %+v", err)
+	}
+	log.Infof("Verified checkpoint of block %d with %d instances",
+		cf.Checkpoint.Index, len(cf.KeyValues))
+
+	bucketName := fb.bucketName
+	if c.Bool("conode") {
+		bucketName = []byte(fmt.Sprintf("%s_%x", byzcoin.ServiceName,
+			*fb.bcID))
+	}
+	err = fb.boltDB.Update(func(tx *bbolt.Tx) error {
+		if tx.Bucket(bucketName) != nil {
+			err := tx.DeleteBucket(bucketName)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := tx.CreateBucket(bucketName)
+		return err
+	})
+	if err != nil {
+		return xerrors.Errorf("couldn't add bucket: %+v", err)
+	}
+	err = byzcoin.ImportCheckpointFile(fb.boltDB, bucketName, &cf)
+	if err != nil {
+		return xerrors.Errorf("couldn't import state: %+v", err)
+	}
+
+	for _, sb := range cf.Proof {
+		if fb.db.GetByID(sb.Hash) == nil {
+			fb.db.Store(sb)
+		}
+	}
+	log.Infof("Imported state of block %d / %x", cf.Checkpoint.Index,
+		cf.Checkpoint.BlockID)
+	return nil
+}
+
+// dbDiff replays the chain to show the instances changed between two blocks.
+func dbDiff(c *cli.Context) error {
+	if c.NArg() < 4 {
+		return xerrors.New("please give the following arguments: " +
+			"conode.db byzCoinID from to")
+	}
+	from, err := strconv.Atoi(c.Args().Get(2))
+	if err != nil {
+		return xerrors.Errorf("couldn't parse from: %+v", err)
+	}
+	to, err := strconv.Atoi(c.Args().Get(3))
+	if err != nil {
+		return xerrors.Errorf("couldn't parse to: %+v", err)
+	}
+	fb, err := newFetchBlocks(c)
+	if err != nil {
+		return xerrors.Errorf("couldn't create fetchBlock: %+v", err)
+	}
+
+	log.Infof("Replaying the blocks up to %d", to)
+	resp, err := fb.service.ReplayStateDiff(*fb.bcID, from, to,
+		fb.blockFetcher)
+	if err != nil {
+		return xerrors.Errorf("couldn't get the diff: %+v", err)
+	}
+	for _, d := range resp.Diffs {
+		fmt.Fprintf(c.App.Writer, "%s %x\n", d.Action, d.InstanceID[:])
+		if d.Action != byzcoin.Create {
+			fmt.Fprintf(c.App.Writer, "\told: %s / %x: %x\n",
+				d.OldContractID, d.OldDarcID, d.OldValue)
+		}
+		if d.Action != byzcoin.Remove {
+			fmt.Fprintf(c.App.Writer, "\tnew: %s / %x: %x\n",
+				d.NewContractID, d.NewDarcID, d.NewValue)
+		}
+	}
+	return nil
+}
+
 // dbCheck verifies all the hashes and links from the blocks.
 func dbCheck(c *cli.Context) error {
 	fb, err := newFetchBlocks(c)
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/urfave/cli"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/byzcoin/bcadmin/lib"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// txSimulate reads a transaction from stdin, as exported by the --export
+// flag, signs it and asks the nodes to simulate it against the latest state.
+// The instructions without signers are signed by the --sign identity. The
+// counters of all the signers are set, but only the --sign identity signs.
+func txSimulate(c *cli.Context) error {
+	tx, err := readExportedTx()
+	if err != nil {
+		return err
+	}
+
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return xerrors.New("--bc flag is required")
+	}
+
+	cfg, cl, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return err
+	}
+
+	var signer *darc.Signer
+	sstr := c.String("sign")
+	if sstr == "" {
+		signer, err = lib.LoadKey(cfg.AdminIdentity)
+	} else {
+		signer, err = lib.LoadKeyFromString(sstr)
+	}
+	if err != nil {
+		return err
+	}
+	signerID := signer.Identity()
+
+	var ids []string
+	next := make(map[string]uint64)
+	for i := range tx.Instructions {
+		instr := &tx.Instructions[i]
+		if len(instr.SignerIdentities) == 0 {
+			instr.SignerIdentities = []darc.Identity{signerID}
+		}
+		for _, id := range instr.SignerIdentities {
+			if _, ok := next[id.String()]; !ok {
+				next[id.String()] = 0
+				ids = append(ids, id.String())
+			}
+		}
+	}
+	counters, err := cl.GetSignerCounters(ids...)
+	if err != nil {
+		return xerrors.Errorf("couldn't get signer counters: %v", err)
+	}
+	for i, id := range ids {
+		next[id] = counters.Counters[i]
+	}
+	// Every instruction increments the counters of its signers.
+	for i := range tx.Instructions {
+		instr := &tx.Instructions[i]
+		instr.SignerCounter = make([]uint64, len(instr.SignerIdentities))
+		for j, id := range instr.SignerIdentities {
+			next[id.String()]++
+			instr.SignerCounter[j] = next[id.String()]
+		}
+	}
+
+	// The digest depends on the version of the latest block.
+	vtx, err := cl.CreateTransaction(tx.Instructions...)
+	if err != nil {
+		return xerrors.Errorf("couldn't create transaction: %v", err)
+	}
+	tx.Instructions = vtx.Instructions
+	digest := tx.Digest()
+	for i := range tx.Instructions {
+		instr := &tx.Instructions[i]
+		instr.Signatures = make([][]byte, len(instr.SignerIdentities))
+		for j, id := range instr.SignerIdentities {
+			if !id.Equal(&signerID) {
+				continue
+			}
+			instr.Signatures[j], err = signer.Sign(digest)
+			if err != nil {
+				return xerrors.Errorf("couldn't sign transaction: %v", err)
+			}
+		}
+	}
+
+	reply, err := cl.SimulateTransaction(tx)
+	if err != nil {
+		return xerrors.Errorf("transaction would be refused: %v", err)
+	}
+
+	fmt.Fprintln(c.App.Writer, "Transaction would be accepted")
+	for _, sc := range reply.StateChanges {
+		if c.Bool("verbose") {
+			fmt.Fprintln(c.App.Writer, sc.String())
+		} else {
+			fmt.Fprintln(c.App.Writer, sc.ShortString())
+		}
+	}
+	for _, coin := range reply.Coins {
+		fmt.Fprintf(c.App.Writer, "leftover coin %x: %d\n", coin.Name[:],
+			coin.Value)
+	}
+
+	return nil
+}
+
+// readExportedTx reads a transaction from stdin, as exported by the --export
+// flag.
+func readExportedTx() (byzcoin.ClientTransaction, error) {
+	tx := byzcoin.ClientTransaction{}
+	txBuf, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return tx, xerrors.Errorf("failed to read from stdin: %v", err)
+	}
+	err = protobuf.Decode(txBuf, &tx)
+	if err != nil {
+		return tx, xerrors.Errorf("failed to decode transaction, did you use --export ?: %v", err)
+	}
+	return tx, nil
+}
+
+// txBuild reads a transaction from stdin, as exported by the --export flag,
+// and stores it in a file together with the identities and the counters of
+// the signers, so that it can be signed offline by "tx sign".
+func txBuild(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return xerrors.New("please give the following arguments: tx-file")
+	}
+
+	tx, err := readExportedTx()
+	if err != nil {
+		return err
+	}
+
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return xerrors.New("--bc flag is required")
+	}
+
+	cfg, cl, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return err
+	}
+
+	ids := []darc.Identity{cfg.AdminIdentity}
+	if signers := c.StringSlice("signer"); len(signers) > 0 {
+		ids = nil
+		for _, s := range signers {
+			id, err := darc.ParseIdentity(s)
+			if err != nil {
+				return xerrors.Errorf("couldn't parse signer %s: %v", s, err)
+			}
+			ids = append(ids, id)
+		}
+	}
+	idStrs := make([]string, len(ids))
+	for i, id := range ids {
+		idStrs[i] = id.String()
+	}
+	counters, err := cl.GetSignerCounters(idStrs...)
+	if err != nil {
+		return xerrors.Errorf("couldn't get signer counters: %v", err)
+	}
+
+	// The version of the latest block is needed to compute the digest that
+	// will be signed.
+	pr, err := cl.GetProofFromLatest(byzcoin.ConfigInstanceID.Slice())
+	if err != nil {
+		return xerrors.Errorf("couldn't get latest block: %v", err)
+	}
+	var header byzcoin.DataHeader
+	err = protobuf.Decode(pr.Proof.Latest.Data, &header)
+	if err != nil {
+		return xerrors.Errorf("couldn't decode header: %v", err)
+	}
+
+	f, err := lib.NewTxFile(cfg.ByzCoinID, header.Version, tx, ids,
+		counters.Counters)
+	if err != nil {
+		return err
+	}
+	err = f.Save(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.App.Writer, "Transaction with digest %x written to %s\n",
+		f.Transaction.Digest(), c.Args().First())
+	return nil
+}
+
+// txSign adds the signatures of a key to a transaction file. It doesn't need
+// to talk to the nodes, so it can be done on an offline machine.
+func txSign(c *cli.Context) error {
+	if c.NArg() < 2 {
+		return xerrors.New("please give the following arguments: " +
+			"tx-file key-xxx.cfg")
+	}
+
+	f, err := lib.LoadTxFile(c.Args().First())
+	if err != nil {
+		return err
+	}
+	signer, err := lib.LoadSigner(c.Args().Get(1))
+	if err != nil {
+		return xerrors.Errorf("couldn't load key-xxx.cfg: %v", err)
+	}
+	err = f.Sign(*signer)
+	if err != nil {
+		return err
+	}
+	err = f.Save(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.App.Writer, "Signed by %s\n", signer.Identity())
+	for _, id := range f.Missing() {
+		fmt.Fprintf(c.App.Writer, "Missing signature of %s\n", id)
+	}
+	return nil
+}
+
+// txInspect prints a transaction file and the signatures it still misses.
+func txInspect(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return xerrors.New("please give the following arguments: tx-file")
+	}
+
+	f, err := lib.LoadTxFile(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprint(c.App.Writer, f.String())
+	missing := f.Missing()
+	if len(missing) == 0 {
+		fmt.Fprintln(c.App.Writer, "All signatures are present")
+	}
+	for _, id := range missing {
+		fmt.Fprintf(c.App.Writer, "Missing signature of %s\n", id)
+	}
+	return nil
+}
+
+// txSubmit sends a signed transaction file to the nodes and waits for it to
+// be included.
+func txSubmit(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return xerrors.New("please give the following arguments: tx-file")
+	}
+
+	f, err := lib.LoadTxFile(c.Args().First())
+	if err != nil {
+		return err
+	}
+	if missing := f.Missing(); len(missing) > 0 {
+		return xerrors.Errorf("transaction is missing the signature of %s",
+			missing[0])
+	}
+
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return xerrors.New("--bc flag is required")
+	}
+
+	cfg, cl, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(cfg.ByzCoinID, f.ByzCoinID) {
+		return xerrors.Errorf("transaction is for ByzCoin %x, not %x",
+			f.ByzCoinID, cfg.ByzCoinID)
+	}
+
+	_, err = cl.AddTransactionAndWait(f.Transaction, 10)
+	if err != nil {
+		return xerrors.Errorf("transaction wasn't accepted: %v", err)
+	}
+
+	fmt.Fprintln(c.App.Writer, "Transaction accepted")
+	return lib.WaitPropagation(c, cl)
+}
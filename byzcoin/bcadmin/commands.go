@@ -25,6 +25,10 @@ var cmds = cli.Commands{
 		ArgsUsage: "bc-xxx.cfg key-xxx.cfg",
 		Action:    config,
 		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "export, x",
+				Usage: "redirects the transaction to stdout",
+			},
 			cli.StringFlag{
 				Name:  "interval",
 				Usage: "change the interval",
@@ -67,7 +71,9 @@ var cmds = cli.Commands{
                                       [--darc <darc id>] 
                                       [--sign <pub key>]     
                              }
-   CONTRACT   {value,deferred,config}`),
+   CONTRACT   {value,deferred,config}
+
+   bcadmin contract versions --bc <byzcoin config>`),
 		Subcommands: cli.Commands{
 			{
 				Name:  "value",
@@ -333,6 +339,30 @@ var cmds = cli.Commands{
 										Name:  "maxBlockSize",
 										Usage: "maxBlockSize (optional)",
 									},
+									cli.IntFlag{
+										Name:  "checkpointInterval",
+										Usage: "number of blocks between two checkpoints, 0 to disable them (optional)",
+									},
+									cli.StringFlag{
+										Name:  "archiveNodes",
+										Usage: "addresses of the archive nodes separated by comas (optional)",
+									},
+									cli.IntFlag{
+										Name:  "maxPendingTxs",
+										Usage: "number of transactions of the chain waiting in a node, 0 for no limit (optional)",
+									},
+									cli.IntFlag{
+										Name:  "maxPendingBytes",
+										Usage: "size of the transactions of the chain waiting in a node, 0 for no limit (optional)",
+									},
+									cli.IntFlag{
+										Name:  "maxSignerTxs",
+										Usage: "number of transactions of a signer waiting in a node, 0 for no limit (optional)",
+									},
+									cli.IntFlag{
+										Name:  "maxSignerBytes",
+										Usage: "size of the transactions of a signer waiting in a node, 0 for no limit (optional)",
+									},
 									cli.StringFlag{
 										Name:  "darcContractIDs",
 										Usage: "darcContractIDs separated by comas (optional)",
@@ -449,6 +479,18 @@ var cmds = cli.Commands{
 					},
 				},
 			},
+			{
+				Name:   "versions",
+				Usage:  "show the versions of the contracts supported by each node and active on the chain",
+				Action: contractVersions,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "bc",
+						EnvVar: "BC",
+						Usage:  "the ByzCoin config to use (required)",
+					},
+				},
+			},
 		},
 	},
 
@@ -475,6 +517,12 @@ var cmds = cli.Commands{
 		Name:    "darc",
 		Usage:   "tool used to manage darcs",
 		Aliases: []string{"d"},
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "export, x",
+				Usage: "redirects the transaction to stdout",
+			},
+		},
 		Subcommands: cli.Commands{
 			{
 				Name:   "show",
@@ -695,6 +743,28 @@ var cmds = cli.Commands{
 					},
 				},
 			},
+			{
+				Name: "checkpoint",
+				Usage: "Verify a checkpoint file and import its state, so" +
+					" that a replay continues from the checkpoint",
+				ArgsUsage: "checkpoint-file",
+				Action:    dbCheckpoint,
+				Flags: []cli.Flag{
+					cli.BoolFlag{
+						Name: "conode",
+						Usage: "import the state in the bucket of the" +
+							" conode instead of the replay bucket",
+					},
+				},
+			},
+			{
+				Name: "diff",
+				Usage: "Replay the chain and show the instances created," +
+					" updated or removed by the blocks after from, up to" +
+					" and including to",
+				ArgsUsage: "from to",
+				Action:    dbDiff,
+			},
 			{
 				Name: "check",
 				Usage: "Check that the chain is in a correct state with" +
@@ -800,6 +870,34 @@ var cmds = cli.Commands{
 		},
 	},
 
+	{
+		Name:  "node",
+		Usage: "administrate a node",
+		Subcommands: cli.Commands{
+			{
+				Name: "config",
+				Usage: "replace the settings of the node, the settings that" +
+					" are not given are reset",
+				ArgsUsage: "private.toml",
+				Action:    nodeConfig,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "checkpointDir",
+						Usage: "directory where the node writes the files of the checkpoints",
+					},
+					cli.IntFlag{
+						Name:  "pruneBlocks",
+						Usage: "number of latest blocks whose transactions are kept, 0 for all",
+					},
+					cli.StringFlag{
+						Name:  "gatewayAddress",
+						Usage: "address on which the node serves its chains as JSON over HTTP",
+					},
+				},
+			},
+		},
+	},
+
 	{
 		Name:   "info",
 		Usage:  "displays infos about the BC config",
@@ -837,6 +935,30 @@ var cmds = cli.Commands{
 					},
 				},
 			},
+			{
+				Name:   "list",
+				Usage:  "List the instances of a contract or guarded by a darc",
+				Action: listInstances,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "bc",
+						EnvVar: "BC",
+						Usage:  "the ByzCoin config to use (required)",
+					},
+					cli.StringFlag{
+						Name:  "contract",
+						Usage: "the contract ID of the instances",
+					},
+					cli.StringFlag{
+						Name:  "darc",
+						Usage: "the darc ID guarding the instances",
+					},
+					cli.IntFlag{
+						Name:  "limit",
+						Usage: "the maximum number of instances to list, 0 for all",
+					},
+				},
+			},
 		},
 	},
 
@@ -983,4 +1105,75 @@ var cmds = cli.Commands{
 			},
 		},
 	},
+
+	{
+		Name:  "tx",
+		Usage: "work with transactions before sending them",
+		Subcommands: cli.Commands{
+			{
+				Name: "build",
+				Usage: "store the transaction given in stdin in a file, with" +
+					" the identities and counters of its signers",
+				ArgsUsage: "tx-file",
+				Action:    txBuild,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "bc",
+						EnvVar: "BC",
+						Usage:  "the ByzCoin config to use (required)",
+					},
+					cli.StringSliceFlag{
+						Name:  "signer",
+						Usage: "identity of a signer, can be repeated (default is the admin identity)",
+					},
+				},
+			},
+			{
+				Name:      "inspect",
+				Usage:     "print a transaction file and the missing signatures",
+				ArgsUsage: "tx-file",
+				Action:    txInspect,
+			},
+			{
+				Name:      "sign",
+				Usage:     "add the signatures of a key to a transaction file, offline",
+				ArgsUsage: "tx-file key-xxx.cfg",
+				Action:    txSign,
+			},
+			{
+				Name: "simulate",
+				Usage: "sign the transaction given in stdin and simulate it" +
+					" against the latest state",
+				Action: txSimulate,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "bc",
+						EnvVar: "BC",
+						Usage:  "the ByzCoin config to use (required)",
+					},
+					cli.StringFlag{
+						Name:  "sign",
+						Usage: "public key of the signing entity (default is the admin public key)",
+					},
+					cli.BoolFlag{
+						Name:  "verbose, v",
+						Usage: "also print the values of the state changes",
+					},
+				},
+			},
+			{
+				Name:      "submit",
+				Usage:     "send a signed transaction file and wait for its inclusion",
+				ArgsUsage: "tx-file",
+				Action:    txSubmit,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "bc",
+						EnvVar: "BC",
+						Usage:  "the ByzCoin config to use (required)",
+					},
+				},
+			},
+		},
+	},
 }
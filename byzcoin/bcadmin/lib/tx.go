@@ -0,0 +1,144 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// TxFile holds a transaction that is being signed offline. The signer
+// identities and counters of the instructions are set when the file is built,
+// so that the signatures can be added one after the other, on machines that
+// don't need to talk to the ByzCoin. A missing signature is an empty slice.
+type TxFile struct {
+	ByzCoinID   skipchain.SkipBlockID
+	Version     byzcoin.Version
+	Transaction byzcoin.ClientTransaction
+}
+
+// NewTxFile prepares the transaction to be signed by the given identities,
+// using the current counters of the signers. The instructions keep the
+// signers they already have.
+func NewTxFile(id skipchain.SkipBlockID, v byzcoin.Version,
+	tx byzcoin.ClientTransaction, ids []darc.Identity, counters []uint64) (*TxFile, error) {
+	if len(ids) == 0 {
+		return nil, xerrors.New("need at least one signer")
+	}
+	if len(ids) != len(counters) {
+		return nil, xerrors.New("the number of signers does not match the number of counters")
+	}
+
+	tx.Instructions = append(byzcoin.Instructions{}, tx.Instructions...)
+	tx.Instructions.SetVersion(v)
+	for i := range tx.Instructions {
+		instr := &tx.Instructions[i]
+		instr.SignerIdentities = append([]darc.Identity{}, ids...)
+		instr.SignerCounter = make([]uint64, len(counters))
+		for j, counter := range counters {
+			instr.SignerCounter[j] = counter + uint64(i) + 1
+		}
+		instr.Signatures = make([][]byte, len(ids))
+	}
+	return &TxFile{ByzCoinID: id, Version: v, Transaction: tx}, nil
+}
+
+// LoadTxFile reads a transaction file as written by Save.
+func LoadTxFile(fn string) (*TxFile, error) {
+	buf, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read this path: '%s': %v", fn, err)
+	}
+	f := &TxFile{}
+	err = protobuf.DecodeWithConstructors(buf, f,
+		network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to decode transaction file: %v", err)
+	}
+	// The version is not part of the encoded instructions, but it changes
+	// the digest that is signed.
+	f.Transaction.Instructions.SetVersion(f.Version)
+	return f, nil
+}
+
+// Save writes the transaction file to fn.
+func (f *TxFile) Save(fn string) error {
+	buf, err := protobuf.Encode(f)
+	if err != nil {
+		return xerrors.Errorf("failed to encode transaction file: %v", err)
+	}
+	return ioutil.WriteFile(fn, buf, 0644)
+}
+
+// Sign adds the signatures of the signer to all the instructions it is a
+// signer of. The other signatures are kept, so that a transaction can be
+// signed by one signer after the other.
+func (f *TxFile) Sign(signer darc.Signer) error {
+	id := signer.Identity()
+	digest := f.Transaction.Digest()
+	signed := false
+	for i := range f.Transaction.Instructions {
+		instr := &f.Transaction.Instructions[i]
+		if len(instr.Signatures) != len(instr.SignerIdentities) {
+			instr.Signatures = make([][]byte, len(instr.SignerIdentities))
+		}
+		for j := range instr.SignerIdentities {
+			if !instr.SignerIdentities[j].Equal(&id) {
+				continue
+			}
+			sig, err := signer.Sign(digest)
+			if err != nil {
+				return xerrors.Errorf("signing failed: %v", err)
+			}
+			instr.Signatures[j] = sig
+			signed = true
+		}
+	}
+	if !signed {
+		return xerrors.Errorf("%s is not a signer of the transaction", id)
+	}
+	return nil
+}
+
+// Missing returns the identities that still have to sign the transaction.
+func (f *TxFile) Missing() []darc.Identity {
+	var missing []darc.Identity
+	seen := make(map[string]bool)
+	for _, instr := range f.Transaction.Instructions {
+		for j, id := range instr.SignerIdentities {
+			if j < len(instr.Signatures) && len(instr.Signatures[j]) > 0 {
+				continue
+			}
+			if !seen[id.String()] {
+				seen[id.String()] = true
+				missing = append(missing, id)
+			}
+		}
+	}
+	return missing
+}
+
+func (f *TxFile) String() string {
+	out := new(strings.Builder)
+	fmt.Fprintf(out, "ByzCoinID: %x\n", f.ByzCoinID)
+	fmt.Fprintf(out, "Version: %d\n", f.Version)
+	fmt.Fprintf(out, "Digest: %x\n", f.Transaction.Digest())
+	for i, instr := range f.Transaction.Instructions {
+		fmt.Fprintf(out, "Instruction %d:\n%s", i, instr)
+		for j, id := range instr.SignerIdentities {
+			status := "missing"
+			if j < len(instr.Signatures) && len(instr.Signatures[j]) > 0 {
+				status = "signed"
+			}
+			fmt.Fprintf(out, "-- signer %s: %s\n", id, status)
+		}
+	}
+	return out.String()
+}
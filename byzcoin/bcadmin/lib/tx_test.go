@@ -0,0 +1,78 @@
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+)
+
+func TestTxFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bcadmin-tx")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "tx.bin")
+
+	s1 := darc.NewSignerEd25519(nil, nil)
+	s2 := darc.NewSignerEd25519(nil, nil)
+	s3 := darc.NewSignerEd25519(nil, nil)
+	tx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{
+			{
+				InstanceID: byzcoin.NewInstanceID([]byte("darc")),
+				Spawn: &byzcoin.Spawn{
+					ContractID: "value",
+					Args:       byzcoin.Arguments{{Name: "value", Value: []byte("v")}},
+				},
+			},
+			{
+				InstanceID: byzcoin.NewInstanceID([]byte("value")),
+				Invoke: &byzcoin.Invoke{
+					ContractID: "value",
+					Command:    "update",
+					Args:       byzcoin.Arguments{{Name: "value", Value: []byte("w")}},
+				},
+			},
+		},
+	}
+
+	ids := []darc.Identity{s1.Identity(), s2.Identity()}
+	_, err = NewTxFile([]byte("bc"), byzcoin.CurrentVersion, tx, ids, []uint64{1})
+	require.Error(t, err)
+	f, err := NewTxFile([]byte("bc"), byzcoin.CurrentVersion, tx, ids, []uint64{1, 5})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{2, 6}, f.Transaction.Instructions[0].SignerCounter)
+	require.Equal(t, []uint64{3, 7}, f.Transaction.Instructions[1].SignerCounter)
+	require.Len(t, f.Missing(), 2)
+	require.NoError(t, f.Save(fn))
+
+	// The signatures are added one after the other, with a round trip
+	// through the file in between.
+	for _, s := range []darc.Signer{s1, s2} {
+		f, err = LoadTxFile(fn)
+		require.NoError(t, err)
+		require.NoError(t, f.Sign(s))
+		require.NoError(t, f.Save(fn))
+	}
+	require.Error(t, f.Sign(s3))
+
+	f, err = LoadTxFile(fn)
+	require.NoError(t, err)
+	require.Empty(t, f.Missing())
+
+	// The signatures must be on the digest of the transaction as it was
+	// built, which means that the version must have been kept.
+	expected, err := NewTxFile([]byte("bc"), byzcoin.CurrentVersion, tx, ids, []uint64{1, 5})
+	require.NoError(t, err)
+	digest := expected.Transaction.Digest()
+	require.Equal(t, digest, f.Transaction.Digest())
+	for _, instr := range f.Transaction.Instructions {
+		for j, id := range instr.SignerIdentities {
+			require.NoError(t, id.Verify(digest, instr.Signatures[j]))
+		}
+	}
+}
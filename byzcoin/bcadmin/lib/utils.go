@@ -115,6 +115,47 @@ func FindRecursivefBool(argname string, c *cli.Context) bool {
 	return false
 }
 
+// CreateTransaction creates the transaction of the instructions. With the
+// --export flag, the transaction is not signed and the key is not needed: the
+// identities and the counters of its signers are set by "tx build", and the
+// signatures by "tx sign". Else it is signed with the key of the --sign
+// identity, or of the admin identity.
+func CreateTransaction(c *cli.Context, cfg Config, cl *byzcoin.Client,
+	instrs ...byzcoin.Instruction) (byzcoin.ClientTransaction, error) {
+	if FindRecursivefBool("export", c) {
+		return cl.CreateTransaction(instrs...)
+	}
+
+	var signer *darc.Signer
+	var err error
+	sstr := c.String("sign")
+	if sstr == "" {
+		signer, err = LoadKey(cfg.AdminIdentity)
+	} else {
+		signer, err = LoadKeyFromString(sstr)
+	}
+	if err != nil {
+		return byzcoin.ClientTransaction{}, err
+	}
+
+	counters, err := cl.GetSignerCounters(signer.Identity().String())
+	if err != nil {
+		return byzcoin.ClientTransaction{}, xerrors.Errorf("couldn't get counters: %v", err)
+	}
+	for i := range instrs {
+		instrs[i].SignerCounter = []uint64{counters.Counters[0] + 1 + uint64(i)}
+	}
+	ctx, err := cl.CreateTransaction(instrs...)
+	if err != nil {
+		return byzcoin.ClientTransaction{}, err
+	}
+	err = ctx.FillSignersAndSignWith(*signer)
+	if err != nil {
+		return byzcoin.ClientTransaction{}, xerrors.Errorf("couldn't sign the transaction: %v", err)
+	}
+	return ctx, nil
+}
+
 // CombinationAnds returns a list that contains AND groups of M elements. It
 // allows to compute rule of kind "M out of N". Each single element and each
 // group is surrounded by parenthesis.
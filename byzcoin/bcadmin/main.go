@@ -439,10 +439,14 @@ func fmtRoster(r *onet.Roster) string {
 	return strings.Join(roster, ", ")
 }
 
+// getBcKey loads the config and the key given as arguments, together with
+// the chain config. With the --export flag, the key is not needed and the
+// signer is nil, as the exported transaction is signed by "tx sign".
 func getBcKey(c *cli.Context) (cfg lib.Config, cl *byzcoin.Client, signer *darc.Signer,
 	proof byzcoin.Proof, chainCfg byzcoin.ChainConfig, err error) {
 
-	if c.NArg() < 2 {
+	export := lib.FindRecursivefBool("export", c)
+	if c.NArg() < 1 || (c.NArg() < 2 && !export) {
 		err = xerrors.New("please give the following arguments: " +
 			"bc-xxx.cfg key-xxx.cfg")
 		return
@@ -454,10 +458,12 @@ func getBcKey(c *cli.Context) (cfg lib.Config, cl *byzcoin.Client, signer *darc.
 		return
 	}
 
-	signer, err = lib.LoadSigner(c.Args().Get(1))
-	if err != nil {
-		err = xerrors.Errorf("couldn't load key-xxx.cfg: %v", err)
-		return
+	if !export {
+		signer, err = lib.LoadSigner(c.Args().Get(1))
+		if err != nil {
+			err = xerrors.Errorf("couldn't load key-xxx.cfg: %v", err)
+			return
+		}
 	}
 
 	log.Lvl2("Getting latest chainConfig")
@@ -496,7 +502,11 @@ func getBcKeyPub(c *cli.Context) (cfg lib.Config, cl *byzcoin.Client,
 		return
 	}
 
+	// With --export, the key-xxx.cfg is not given.
 	fn := c.Args().Get(2)
+	if signer == nil {
+		fn = c.Args().Get(1)
+	}
 	if fn == "" {
 		err = xerrors.New("no TOML file provided")
 		return
@@ -520,25 +530,36 @@ func getBcKeyPub(c *cli.Context) (cfg lib.Config, cl *byzcoin.Client,
 	return
 }
 
-func updateConfig(cl *byzcoin.Client, signer *darc.Signer, chainConfig byzcoin.ChainConfig) error {
-	counters, err := cl.GetSignerCounters(signer.Identity().String())
-	if err != nil {
-		return xerrors.Errorf("couldn't get counters: %v", err)
-	}
-	counters.Counters[0]++
+func updateConfig(c *cli.Context, cl *byzcoin.Client, signer *darc.Signer, chainConfig byzcoin.ChainConfig) error {
 	ccBuf, err := protobuf.Encode(&chainConfig)
 	if err != nil {
 		return xerrors.Errorf("couldn't encode chainConfig: %v", err)
 	}
-	ctx, err := cl.CreateTransaction(byzcoin.Instruction{
+	instr := byzcoin.Instruction{
 		InstanceID: byzcoin.ConfigInstanceID,
 		Invoke: &byzcoin.Invoke{
 			ContractID: byzcoin.ContractConfigID,
 			Command:    "update_config",
 			Args:       byzcoin.Arguments{{Name: "config", Value: ccBuf}},
 		},
-		SignerCounter: counters.Counters,
-	})
+	}
+
+	// The exported transaction gets its signers from "tx build".
+	if lib.FindRecursivefBool("export", c) {
+		ctx, err := cl.CreateTransaction(instr)
+		if err != nil {
+			return err
+		}
+		return lib.ExportTransaction(ctx)
+	}
+
+	counters, err := cl.GetSignerCounters(signer.Identity().String())
+	if err != nil {
+		return xerrors.Errorf("couldn't get counters: %v", err)
+	}
+	counters.Counters[0]++
+	instr.SignerCounter = counters.Counters
+	ctx, err := cl.CreateTransaction(instr)
 	if err != nil {
 		return err
 	}
@@ -576,10 +597,13 @@ func config(c *cli.Context) error {
 		chainConfig.MaxBlockSize = blockSize
 	}
 
-	err = updateConfig(cl, signer, chainConfig)
+	err = updateConfig(c, cl, signer, chainConfig)
 	if err != nil {
 		return err
 	}
+	if c.Bool("export") {
+		return nil
+	}
 
 	log.Lvl1("Updated configuration")
 
@@ -762,7 +786,7 @@ func rosterAdd(c *cli.Context) error {
 	chainConfig.Roster = *old.Concat(pub)
 	log.Lvl2("New roster is:", chainConfig.Roster.List)
 
-	err = updateConfig(cl, signer, chainConfig)
+	err = updateConfig(c, cl, signer, chainConfig)
 	if err != nil {
 		return err
 	}
@@ -794,7 +818,7 @@ func rosterDel(c *cli.Context) error {
 	chainConfig.Roster = *onet.NewRoster(list)
 	log.Lvl2("New roster is:", chainConfig.Roster.List)
 
-	err = updateConfig(cl, signer, chainConfig)
+	err = updateConfig(c, cl, signer, chainConfig)
 	if err != nil {
 		return err
 	}
@@ -828,7 +852,7 @@ func rosterLeader(c *cli.Context) error {
 	log.Lvl2("New roster is:", chainConfig.Roster.List)
 
 	// Do it twice to make sure the new roster is active - there is an issue ;)
-	err = updateConfig(cl, signer, chainConfig)
+	err = updateConfig(c, cl, signer, chainConfig)
 	if err != nil {
 		return err
 	}
@@ -942,20 +966,6 @@ func darcCdesc(c *cli.Context) error {
 		return err
 	}
 
-	var signer *darc.Signer
-
-	sstr := c.String("sign")
-	if sstr == "" {
-		signer, err = lib.LoadKey(cfg.AdminIdentity)
-	} else {
-		signer, err = lib.LoadKeyFromString(sstr)
-	}
-	if err != nil {
-		return err
-	}
-
-	counters, err := cl.GetSignerCounters(signer.Identity().String())
-
 	invoke := byzcoin.Invoke{
 		ContractID: byzcoin.ContractDarcID,
 		Command:    "evolve",
@@ -967,17 +977,16 @@ func darcCdesc(c *cli.Context) error {
 		},
 	}
 
-	ctx, err := cl.CreateTransaction(byzcoin.Instruction{
-		InstanceID:    byzcoin.NewInstanceID(d2.GetBaseID()),
-		Invoke:        &invoke,
-		SignerCounter: []uint64{counters.Counters[0] + 1},
+	ctx, err := lib.CreateTransaction(c, cfg, cl, byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID(d2.GetBaseID()),
+		Invoke:     &invoke,
 	})
 	if err != nil {
 		return err
 	}
-	err = ctx.FillSignersAndSignWith(*signer)
-	if err != nil {
-		return err
+
+	if lib.FindRecursivefBool("export", c) {
+		return lib.ExportTransaction(ctx)
 	}
 
 	_, err = cl.AddTransactionAndWait(ctx, 10)
@@ -1274,6 +1283,32 @@ func debugRemove(c *cli.Context) error {
 	return nil
 }
 
+func nodeConfig(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return xerrors.New("please give the following argument: private.toml")
+	}
+
+	ccfg, err := app.LoadCothority(c.Args().First())
+	if err != nil {
+		return err
+	}
+	si, err := ccfg.GetServerIdentity()
+	if err != nil {
+		return err
+	}
+	config := byzcoin.NodeConfig{
+		CheckpointDir:  c.String("checkpointDir"),
+		PruneBlocks:    c.Int("pruneBlocks"),
+		GatewayAddress: c.String("gatewayAddress"),
+	}
+	err = byzcoin.SetNodeConfig(si, config)
+	if err != nil {
+		return err
+	}
+	log.Infof("Successfully set the config of %s", si.Address)
+	return nil
+}
+
 func debugCounters(c *cli.Context) error {
 	if c.NArg() < 2 {
 		return xerrors.New("please give the following arguments: bc-xxx.cfg key-xxx.cfg")
@@ -1315,8 +1350,6 @@ func darcAdd(c *cli.Context) error {
 		return err
 	}
 
-	var signer *darc.Signer
-
 	identities := c.StringSlice("identity")
 
 	if len(identities) == 0 {
@@ -1338,16 +1371,6 @@ func darcAdd(c *cli.Context) error {
 		}
 	}
 
-	sstr := c.String("sign")
-	if sstr == "" {
-		signer, err = lib.LoadKey(cfg.AdminIdentity)
-	} else {
-		signer, err = lib.LoadKeyFromString(sstr)
-	}
-	if err != nil {
-		return err
-	}
-
 	var desc []byte
 	if c.String("desc") == "" {
 		desc = []byte(lib.RandString(10))
@@ -1385,8 +1408,6 @@ func darcAdd(c *cli.Context) error {
 
 	instID := byzcoin.NewInstanceID(dSpawn.GetBaseID())
 
-	counters, err := cl.GetSignerCounters(signer.Identity().String())
-
 	spawn := byzcoin.Spawn{
 		ContractID: byzcoin.ContractDarcID,
 		Args: []byzcoin.Argument{
@@ -1397,25 +1418,25 @@ func darcAdd(c *cli.Context) error {
 		},
 	}
 
-	ctx, err := cl.CreateTransaction(byzcoin.Instruction{
-		InstanceID:    instID,
-		Spawn:         &spawn,
-		SignerCounter: []uint64{counters.Counters[0] + 1},
+	ctx, err := lib.CreateTransaction(c, cfg, cl, byzcoin.Instruction{
+		InstanceID: instID,
+		Spawn:      &spawn,
 	})
 	if err != nil {
 		return err
 	}
-	err = ctx.FillSignersAndSignWith(*signer)
-	if err != nil {
-		return err
-	}
 
-	_, err = cl.AddTransactionAndWait(ctx, 10)
-	if err != nil {
-		return err
+	// When exporting, stdout holds the transaction, so the darc is only
+	// given through the output files.
+	export := lib.FindRecursivefBool("export", c)
+	if export {
+		err = lib.ExportTransaction(ctx)
+	} else {
+		_, err = cl.AddTransactionAndWait(ctx, 10)
+		if err == nil {
+			_, err = fmt.Fprintln(c.App.Writer, d.String())
+		}
 	}
-
-	_, err = fmt.Fprintln(c.App.Writer, d.String())
 	if err != nil {
 		return err
 	}
@@ -1438,6 +1459,9 @@ func darcAdd(c *cli.Context) error {
 		}
 	}
 
+	if export {
+		return nil
+	}
 	return lib.WaitPropagation(c, cl)
 }
 
@@ -1461,18 +1485,6 @@ func darcRule(c *cli.Context) error {
 		return err
 	}
 
-	var signer *darc.Signer
-
-	sstr := c.String("sign")
-	if sstr == "" {
-		signer, err = lib.LoadKey(cfg.AdminIdentity)
-	} else {
-		signer, err = lib.LoadKeyFromString(sstr)
-	}
-	if err != nil {
-		return err
-	}
-
 	action := c.String("rule")
 	if action == "" {
 		return xerrors.New("--rule flag is required")
@@ -1533,8 +1545,6 @@ func darcRule(c *cli.Context) error {
 		return err
 	}
 
-	counters, err := cl.GetSignerCounters(signer.Identity().String())
-
 	command := "evolve_unrestricted"
 	if c.Bool("restricted") {
 		command = "evolve"
@@ -1551,17 +1561,16 @@ func darcRule(c *cli.Context) error {
 		},
 	}
 
-	ctx, err := cl.CreateTransaction(byzcoin.Instruction{
-		InstanceID:    byzcoin.NewInstanceID(d2.GetBaseID()),
-		Invoke:        &invoke,
-		SignerCounter: []uint64{counters.Counters[0] + 1},
+	ctx, err := lib.CreateTransaction(c, cfg, cl, byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID(d2.GetBaseID()),
+		Invoke:     &invoke,
 	})
 	if err != nil {
 		return err
 	}
-	err = ctx.FillSignersAndSignWith(*signer)
-	if err != nil {
-		return err
+
+	if lib.FindRecursivefBool("export", c) {
+		return lib.ExportTransaction(ctx)
 	}
 
 	_, err = cl.AddTransactionAndWait(ctx, 10)
@@ -1792,6 +1801,99 @@ func getInstance(c *cli.Context) error {
 	return nil
 }
 
+// listInstances prints the IDs of the instances of a contract or of the
+// instances guarded by a darc, as found in the instance index of the nodes.
+func listInstances(c *cli.Context) error {
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return xerrors.New("--bc flag is required")
+	}
+
+	_, cl, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return err
+	}
+
+	contractID := c.String("contract")
+	dstr := c.String("darc")
+	if (contractID == "") == (dstr == "") {
+		return xerrors.New("exactly one of --contract and --darc is required")
+	}
+	var darcID darc.ID
+	if dstr != "" {
+		darcID, err = lib.StringToDarcID(dstr)
+		if err != nil {
+			return xerrors.Errorf("failed to parse darc: %v", err)
+		}
+	}
+
+	limit := c.Int("limit")
+	var start []byte
+	count := 0
+	for {
+		var reply *byzcoin.ListInstancesResponse
+		if contractID != "" {
+			reply, err = cl.ListInstancesByContract(contractID, start, 0)
+		} else {
+			reply, err = cl.ListInstancesByDarc(darcID, start, 0)
+		}
+		if err != nil {
+			return xerrors.Errorf("couldn't list instances: %v", err)
+		}
+
+		for _, iid := range reply.InstanceIDs {
+			if limit > 0 && count == limit {
+				return nil
+			}
+			fmt.Fprintf(c.App.Writer, "%x\n", iid[:])
+			count++
+		}
+
+		if len(reply.Next) == 0 {
+			return nil
+		}
+		start = reply.Next
+	}
+}
+
+// contractVersions shows the versions of the contracts supported by each node
+// of the roster, and the versions active on the chain.
+func contractVersions(c *cli.Context) error {
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return xerrors.New("--bc flag is required")
+	}
+
+	cfg, cl, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return err
+	}
+
+	var active []byzcoin.ContractVersion
+	for _, si := range cfg.Roster.List {
+		reply, err := cl.GetContractVersions(si)
+		if err != nil {
+			fmt.Fprintf(c.App.Writer, "%s: error: %v\n", si.Address, err)
+			continue
+		}
+		active = reply.Active
+
+		fmt.Fprintf(c.App.Writer, "%s:\n", si.Address)
+		for _, v := range reply.Supported {
+			fmt.Fprintf(c.App.Writer, "\t%s: %d\n", v.ContractID, v.Version)
+		}
+	}
+
+	fmt.Fprintln(c.App.Writer, "active on the chain:")
+	if len(active) == 0 {
+		fmt.Fprintln(c.App.Writer, "\tall the contracts are at version 0")
+	}
+	for _, v := range active {
+		fmt.Fprintf(c.App.Writer, "\t%s: %d\n", v.ContractID, v.Version)
+	}
+	return nil
+}
+
 type configPrivate struct {
 	Owner darc.Signer
 }
@@ -49,10 +49,15 @@ main(){
     run testUpdateDarcDesc
     run testResolveiid
     run testInstructionGet
+    run testInstanceList
+    run testContractVersions
     run testContractValue
     run testContractDeferred
     run testContractConfig
     run testContractName
+    run testTxSimulate
+    run testTxOffline
+    run testTxOfflineKey
     stopTest
 }
 
@@ -559,5 +564,101 @@ testInstructionGet() {
   testOK runBA0 instance get -i 0000000000000000000000000000000000000000000000000000000000000000 --hex
 }
 
+# In this test we list the instances of the config contract, which is only the
+# config instance.
+testInstanceList() {
+  runCoBG 1 2 3
+  runGrepSed "export BC=" "" runBA create --roster public.toml --interval .5s
+  eval $SED
+  [ -z "$BC" ] && exit 1
+
+  testGrep 0000000000000000000000000000000000000000000000000000000000000000 runBA0 instance list --contract config
+  testFail runBA0 instance list
+}
+
+testContractVersions() {
+  runCoBG 1 2 3
+  runGrepSed "export BC=" "" runBA create --roster public.toml --interval .5s
+  eval $SED
+  [ -z "$BC" ] && exit 1
+
+  testGrep "config: 0" runBA0 contract versions
+  testGrep "all the contracts are at version 0" runBA0 contract versions
+}
+
+# In this test we simulate a value spawn that is exported with the --export
+# flag. The simulation must not create the instance.
+testTxSimulate() {
+  runCoBG 1 2 3
+  runGrepSed "export BC=" "" runBA create --roster public.toml --interval .5s
+  eval $SED
+  [ -z "$BC" ] && exit 1
+
+  testOK runBA darc add -out_id ./darc_id.txt -out_key ./darc_key.txt -unrestricted
+  ID=`cat ./darc_id.txt`
+  KEY=`cat ./darc_key.txt`
+  testOK runBA darc rule -rule "spawn:value" --identity "$KEY" --darc "$ID" --sign "$KEY"
+
+  OUTRES=`runBA0 contract -x value spawn --value "myValue" --darc "$ID" --sign "$KEY" | runBA0 tx simulate --sign "$KEY"`
+  testGrep "Transaction would be accepted" echo "$OUTRES"
+  testGrep "contractID: value" echo "$OUTRES"
+
+  # Without the rule for the admin key, the simulation must fail.
+  testFail runBA0 tx simulate < <(runBA0 contract -x value spawn --value "myValue" --darc "$ID" --sign "$KEY")
+}
+
+# In this test a darc rule is exported, signed offline by two keys one after
+# the other, and then submitted.
+testTxOffline() {
+  runCoBG 1 2 3
+  runGrepSed "export BC=" "" runBA create --roster public.toml --interval .5s
+  eval $SED
+  [ -z "$BC" ] && exit 1
+
+  testOK runBA darc add -out_id ./darc_id.txt -out_key ./darc_key.txt -unrestricted
+  ID=`cat ./darc_id.txt`
+  KEY=`cat ./darc_key.txt`
+  testOK runBA key -save ./key.txt
+  KEY2=`cat ./key.txt`
+
+  runBA0 darc -x rule -rule "spawn:value" --identity "$KEY" --darc "$ID" --sign "$KEY" > tx_export.bin
+  testOK runBA tx build --signer "$KEY" --signer "$KEY2" tx.bin < tx_export.bin
+  testGrep "Missing signature of $KEY2" runBA tx inspect tx.bin
+  testFail runBA tx submit tx.bin
+  testGrep "Missing signature of $KEY2" runBA tx sign tx.bin "config/key-$KEY.cfg"
+  testOK runBA tx sign tx.bin "config/key-$KEY2.cfg"
+  testGrep "All signatures are present" runBA tx inspect tx.bin
+  testOK runBA tx submit tx.bin
+  testGrep "spawn:value" runBA darc show --darc "$ID"
+}
+
+# In this test the transactions are exported and built without the admin key,
+# which is only used by "tx sign".
+testTxOfflineKey() {
+  runCoBG 1 2 3
+  runGrepSed "export BC=" "" runBA create --roster public.toml --interval .5s
+  eval $SED
+  [ -z "$BC" ] && exit 1
+
+  mkdir -p keys
+  mv config/key-*.cfg keys/
+  KEYFILE=`ls keys/key-*.cfg`
+
+  runBA0 contract -x config invoke updateConfig --blockInterval 1s > tx_export.bin
+  testOK runBA tx build tx.bin < tx_export.bin
+  testGrep "Missing signature" runBA tx inspect tx.bin
+  testOK runBA tx sign tx.bin "$KEYFILE"
+  testOK runBA tx submit tx.bin
+  testGrep "BlockInterval: 1s" runBA0 contract config get
+
+  runBA0 darc -x cdesc --desc "offline" > tx_export.bin
+  testOK runBA tx build tx.bin < tx_export.bin
+  testOK runBA tx sign tx.bin "$KEYFILE"
+  testOK runBA tx submit tx.bin
+  testGrep "offline" runBA darc show
+
+  mv keys/key-*.cfg config/
+}
+
 main
 
@@ -0,0 +1,244 @@
+// Package bctest allows to test ByzCoin contracts without running nodes. A
+// State holds the global state in memory, starting with the configuration and
+// the genesis darc of a new chain, and runs the instructions through the same
+// verification and execution as the nodes.
+//
+// The contracts are taken from the global registry, so the package of the
+// contract under test must be imported.
+package bctest
+
+import (
+	"fmt"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/kyber/v3/util/key"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// errNoBlocks is returned by the skipchain methods of the State, as there are
+// no blocks in the tests.
+var errNoBlocks = xerrors.New("there are no blocks in bctest")
+
+// State is an in-memory global state, backed by a trie.MemDB. It implements
+// byzcoin.GlobalState, but without any blocks.
+type State struct {
+	// Admin is the signer of the rules of the genesis darc.
+	Admin darc.Signer
+	// GenesisDarc is the darc of the configuration.
+	GenesisDarc darc.Darc
+	// Index is the block index returned by GetIndex.
+	Index int
+	// Version is the version of the ByzCoin protocol returned by
+	// GetVersion.
+	Version byzcoin.Version
+
+	sst *trie.StagingTrie
+}
+
+var _ byzcoin.GlobalState = (*State)(nil)
+
+// NewState returns the state of a new chain, as it is after the genesis
+// block. The rules are added to the genesis darc, for the Admin signer.
+func NewState(rules ...string) (*State, error) {
+	admin := darc.NewSignerEd25519(nil, nil)
+	msg, err := byzcoin.DefaultGenesisMsg(byzcoin.CurrentVersion, newRoster(3),
+		rules, admin.Identity())
+	if err != nil {
+		return nil, xerrors.Errorf("creating genesis message: %v", err)
+	}
+	instr, err := byzcoin.NewGenesisInstruction(msg)
+	if err != nil {
+		return nil, xerrors.Errorf("creating genesis instruction: %v", err)
+	}
+	t, err := trie.NewTrie(trie.NewMemDB(), instr.Spawn.Args.Search("trie_nonce"))
+	if err != nil {
+		return nil, xerrors.Errorf("creating trie: %v", err)
+	}
+
+	s := &State{
+		Admin:       admin,
+		GenesisDarc: msg.GenesisDarc,
+		Version:     msg.Version,
+		sst:         t.MakeStagingTrie(),
+	}
+	if _, _, err = s.Run(instr); err != nil {
+		return nil, xerrors.Errorf("running genesis instruction: %v", err)
+	}
+	return s, nil
+}
+
+// newRoster returns a roster of n nodes that are never contacted.
+func newRoster(n int) *onet.Roster {
+	list := make([]*network.ServerIdentity, n)
+	for i := range list {
+		kp := key.NewKeyPair(cothority.Suite)
+		addr := network.NewAddress(network.TLS, fmt.Sprintf("127.0.0.1:%d", 7770+2*i))
+		list[i] = network.NewServerIdentity(kp.Public, addr)
+	}
+	return onet.NewRoster(list)
+}
+
+// Sign sets the signers of the instruction, with their next counters, and
+// signs it as the only instruction of a transaction, which is what Run
+// expects.
+func (s *State) Sign(instr *byzcoin.Instruction, signers ...darc.Signer) error {
+	instr.SignerIdentities = make([]darc.Identity, len(signers))
+	instr.SignerCounter = make([]uint64, len(signers))
+	for i, signer := range signers {
+		instr.SignerIdentities[i] = signer.Identity()
+		counter, err := byzcoin.GetSignerCounter(s, signer.Identity().String())
+		if err != nil {
+			return xerrors.Errorf("getting counter: %v", err)
+		}
+		instr.SignerCounter[i] = counter + 1
+	}
+	tx := byzcoin.NewClientTransaction(s.Version, *instr)
+	err := tx.SignWith(signers...)
+	if err != nil {
+		return xerrors.Errorf("signing: %v", err)
+	}
+	*instr = tx.Instructions[0]
+	return nil
+}
+
+// Run verifies and executes the instruction like the nodes do, as the only
+// instruction of a transaction. If it is accepted, the state changes are
+// applied to the state. It returns the state changes, including the ones of
+// the counters of the signers, and the coins left over by the contract.
+func (s *State) Run(instr byzcoin.Instruction) (byzcoin.StateChanges, []byzcoin.Coin, error) {
+	return s.RunWithCoins(instr, nil)
+}
+
+// RunWithCoins works like Run, but gives the coins to the contract.
+func (s *State) RunWithCoins(instr byzcoin.Instruction,
+	cin []byzcoin.Coin) (byzcoin.StateChanges, []byzcoin.Coin, error) {
+	tx := byzcoin.NewClientTransaction(s.Version, instr)
+	scs, cout, err := byzcoin.ExecuteInstruction(s, cin, tx.Instructions[0],
+		tx.Digest())
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = s.Apply(scs); err != nil {
+		return nil, nil, err
+	}
+	return scs, cout, nil
+}
+
+// Apply stores the state changes, after checking that they only create
+// missing instances and only update or remove existing ones. Nothing is
+// stored if one of them is refused. It can be used to set up a state without
+// running instructions.
+func (s *State) Apply(scs byzcoin.StateChanges) error {
+	sst := s.sst.Clone()
+	for i := range scs {
+		sc := &scs[i]
+		v, err := sst.Get(sc.InstanceID)
+		if err != nil {
+			return xerrors.Errorf("reading trie: %v", err)
+		}
+		switch {
+		case sc.StateAction == byzcoin.Create && v != nil:
+			return xerrors.Errorf("tried to create existing instanceID %x",
+				sc.InstanceID)
+		case sc.StateAction == byzcoin.Update && v == nil:
+			return xerrors.Errorf("tried to update non-existing instanceID %x",
+				sc.InstanceID)
+		case sc.StateAction == byzcoin.Remove && v == nil:
+			return xerrors.Errorf("tried to remove non-existing instanceID %x",
+				sc.InstanceID)
+		}
+		if err = sst.Batch([]trie.KVPair{sc}); err != nil {
+			return xerrors.Errorf("storing state change: %v", err)
+		}
+	}
+	s.sst = sst
+	return nil
+}
+
+// GetValues returns the value, version, contract ID and darc ID of the
+// instance. byzcoin.ErrKeyNotSet is returned if the instance doesn't exist.
+func (s *State) GetValues(key []byte) (value []byte, version uint64,
+	contractID string, darcID darc.ID, err error) {
+	buf, err := s.sst.Get(key)
+	if err != nil {
+		err = xerrors.Errorf("reading trie: %v", err)
+		return
+	}
+	if buf == nil {
+		err = cothority.WrapError(byzcoin.ErrKeyNotSet)
+		return
+	}
+	var body byzcoin.StateChangeBody
+	if err = protobuf.Decode(buf, &body); err != nil {
+		err = xerrors.Errorf("decoding body: %v", err)
+		return
+	}
+	return body.Value, body.Version, body.ContractID, body.DarcID, nil
+}
+
+// GetProof returns the proof of the key in the state.
+func (s *State) GetProof(key []byte) (*trie.Proof, error) {
+	return s.sst.GetProof(key)
+}
+
+// GetIndex returns the Index of the state.
+func (s *State) GetIndex() int {
+	return s.Index
+}
+
+// GetNonce returns the nonce of the trie.
+func (s *State) GetNonce() ([]byte, error) {
+	return s.sst.GetNonce()
+}
+
+// GetVersion returns the Version of the state.
+func (s *State) GetVersion() byzcoin.Version {
+	return s.Version
+}
+
+// ForEach calls the callback on every key/value pair of the state.
+func (s *State) ForEach(cb func(k, v []byte) error) error {
+	return s.sst.ForEach(cb)
+}
+
+// StoreAllToReplica returns a copy of the state with the state changes
+// applied, the state itself is not modified.
+func (s *State) StoreAllToReplica(scs byzcoin.StateChanges) (byzcoin.ReadOnlyStateTrie, error) {
+	replica := *s
+	replica.sst = s.sst.Clone()
+	pairs := make([]trie.KVPair, len(scs))
+	for i := range scs {
+		pairs[i] = &scs[i]
+	}
+	if err := replica.sst.Batch(pairs); err != nil {
+		return nil, xerrors.Errorf("replica failed to store state changes: %v", err)
+	}
+	return &replica, nil
+}
+
+// GetLatest always fails, as there are no blocks.
+func (s *State) GetLatest() (*skipchain.SkipBlock, error) {
+	return nil, errNoBlocks
+}
+
+// GetGenesisBlock always fails, as there are no blocks.
+func (s *State) GetGenesisBlock() (*skipchain.SkipBlock, error) {
+	return nil, errNoBlocks
+}
+
+// GetBlock always fails, as there are no blocks.
+func (s *State) GetBlock(skipchain.SkipBlockID) (*skipchain.SkipBlock, error) {
+	return nil, errNoBlocks
+}
+
+// GetBlockByIndex always fails, as there are no blocks.
+func (s *State) GetBlockByIndex(idx int) (*skipchain.SkipBlock, error) {
+	return nil, errNoBlocks
+}
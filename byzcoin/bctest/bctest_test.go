@@ -0,0 +1,94 @@
+package bctest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/byzcoin/contracts"
+	"golang.org/x/xerrors"
+)
+
+func TestState_Run(t *testing.T) {
+	s, err := NewState("spawn:value", "invoke:value.update", "delete:value")
+	require.NoError(t, err)
+
+	_, _, cID, _, err := s.GetValues(byzcoin.ConfigInstanceID.Slice())
+	require.NoError(t, err)
+	require.Equal(t, byzcoin.ContractConfigID, cID)
+
+	spawn := Spawn(s.GenesisDarc.GetBaseID(), contracts.ContractValueID,
+		Arg("value", "one"))
+	require.NoError(t, s.Sign(&spawn, s.Admin))
+	scs, _, err := s.Run(spawn)
+	require.NoError(t, err)
+	// The value instance and the counter of the signer.
+	require.Len(t, scs, 2)
+	require.Equal(t, byzcoin.Create, scs[0].StateAction)
+
+	iid := spawn.DeriveID("")
+	v, ver, cID, _, err := s.GetValues(iid.Slice())
+	require.NoError(t, err)
+	require.Equal(t, []byte("one"), v)
+	require.Equal(t, uint64(0), ver)
+	require.Equal(t, contracts.ContractValueID, cID)
+	counter, err := byzcoin.GetSignerCounter(s, s.Admin.Identity().String())
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), counter)
+
+	// An instruction cannot be replayed.
+	_, _, err = s.Run(spawn)
+	require.Error(t, err)
+
+	// Only the admin can update the value.
+	update := Invoke(iid, contracts.ContractValueID, "update",
+		Arg("value", "two"))
+	require.NoError(t, s.Sign(&update, NewSigner()))
+	_, _, err = s.Run(update)
+	require.Error(t, err)
+	require.NoError(t, s.Sign(&update, s.Admin))
+	_, _, err = s.Run(update)
+	require.NoError(t, err)
+	v, ver, _, _, err = s.GetValues(iid.Slice())
+	require.NoError(t, err)
+	require.Equal(t, []byte("two"), v)
+	require.Equal(t, uint64(1), ver)
+
+	del := Delete(iid, contracts.ContractValueID)
+	require.NoError(t, s.Sign(&del, s.Admin))
+	_, _, err = s.Run(del)
+	require.NoError(t, err)
+	_, _, _, _, err = s.GetValues(iid.Slice())
+	require.True(t, xerrors.Is(err, byzcoin.ErrKeyNotSet))
+}
+
+func TestState_Apply(t *testing.T) {
+	s, err := NewState()
+	require.NoError(t, err)
+
+	iid := byzcoin.NewInstanceID([]byte("value"))
+	update := byzcoin.NewStateChange(byzcoin.Update, iid,
+		contracts.ContractValueID, []byte("one"), nil)
+	require.Error(t, s.Apply(byzcoin.StateChanges{update}))
+
+	create := byzcoin.NewStateChange(byzcoin.Create, iid,
+		contracts.ContractValueID, []byte("one"), nil)
+	// Nothing is stored if a state change is refused.
+	require.Error(t, s.Apply(byzcoin.StateChanges{create, create}))
+	_, _, _, _, err = s.GetValues(iid.Slice())
+	require.Error(t, err)
+
+	require.NoError(t, s.Apply(byzcoin.StateChanges{create, update}))
+	v, _, _, _, err := s.GetValues(iid.Slice())
+	require.NoError(t, err)
+	require.Equal(t, []byte("one"), v)
+
+	// A replica doesn't change the state.
+	remove := byzcoin.NewStateChange(byzcoin.Remove, iid, "", nil, nil)
+	replica, err := s.StoreAllToReplica(byzcoin.StateChanges{remove})
+	require.NoError(t, err)
+	_, _, _, _, err = replica.GetValues(iid.Slice())
+	require.Error(t, err)
+	_, _, _, _, err = s.GetValues(iid.Slice())
+	require.NoError(t, err)
+}
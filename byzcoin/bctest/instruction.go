@@ -0,0 +1,51 @@
+package bctest
+
+import (
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+)
+
+// Spawn returns an instruction spawning an instance of the contract, with the
+// rules of the darc.
+func Spawn(darcID darc.ID, contractID string, args ...byzcoin.Argument) byzcoin.Instruction {
+	return byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID(darcID),
+		Spawn: &byzcoin.Spawn{
+			ContractID: contractID,
+			Args:       args,
+		},
+	}
+}
+
+// Invoke returns an instruction invoking the command on the instance.
+func Invoke(iid byzcoin.InstanceID, contractID, command string,
+	args ...byzcoin.Argument) byzcoin.Instruction {
+	return byzcoin.Instruction{
+		InstanceID: iid,
+		Invoke: &byzcoin.Invoke{
+			ContractID: contractID,
+			Command:    command,
+			Args:       args,
+		},
+	}
+}
+
+// Delete returns an instruction deleting the instance.
+func Delete(iid byzcoin.InstanceID, contractID string) byzcoin.Instruction {
+	return byzcoin.Instruction{
+		InstanceID: iid,
+		Delete: &byzcoin.Delete{
+			ContractID: contractID,
+		},
+	}
+}
+
+// Arg returns an argument with a string value.
+func Arg(name, value string) byzcoin.Argument {
+	return byzcoin.Argument{Name: name, Value: []byte(value)}
+}
+
+// NewSigner returns a new ed25519 signer.
+func NewSigner() darc.Signer {
+	return darc.NewSignerEd25519(nil, nil)
+}
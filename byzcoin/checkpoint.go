@@ -0,0 +1,559 @@
+package byzcoin
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/blscosi/protocol"
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	bbolt "go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+const checkpointSubFtCosi = "checkpoint_sub_ftcosi"
+const checkpointFtCosi = "checkpoint_ftcosi"
+
+var bucketCheckpoints = []byte("checkpoints")
+
+// checkpointWait is how long a node waits for the block of a checkpoint to be
+// applied to its state before refusing to sign the checkpoint.
+var checkpointWait = 2 * time.Second
+
+var checkpointMsgID network.MessageTypeID
+
+func init() {
+	network.RegisterMessages(&GetCheckpoint{}, &GetCheckpointResponse{},
+		&CheckpointFile{})
+	checkpointMsgID = network.RegisterMessage(&Checkpoint{})
+}
+
+// Hash returns the digest of the checkpoint that is signed by the roster. The
+// signature is not part of it.
+func (cp Checkpoint) Hash() []byte {
+	h := sha256.New()
+	h.Write(cp.SkipChainID)
+	h.Write(cp.BlockID)
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, uint64(cp.Index))
+	h.Write(buf)
+	h.Write(cp.TrieRoot)
+	h.Write(cp.StateHash)
+	return h.Sum(nil)
+}
+
+// stateWalk calls the callback on every key/value pair of a global state, in
+// the order of its trie.
+type stateWalk func(cb func(k, v []byte) error) error
+
+// checkpointStateHash returns the hash of the content of the global state.
+// The pairs are hashed as they are walked, in the order of the trie, so that
+// the state doesn't need to be held in memory.
+func checkpointStateHash(nonce []byte, version Version, walk stateWalk) ([]byte, error) {
+	h := sha256.New()
+	write := func(b []byte) {
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, uint64(len(b)))
+		h.Write(buf)
+		h.Write(b)
+	}
+	write(nonce)
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, uint64(version))
+	h.Write(buf)
+	err := walk(func(k, v []byte) error {
+		write(k)
+		write(v)
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("reading state: %v", err)
+	}
+	return h.Sum(nil), nil
+}
+
+// checkpointStorage keeps the checkpoints of the skipchains, using the
+// big-endian index of the block as the key, so that the latest checkpoint is
+// the last key. Each skipchain has its own sub-bucket.
+type checkpointStorage struct {
+	db     *bbolt.DB
+	bucket []byte
+}
+
+func newCheckpointStorage(c *onet.Context) *checkpointStorage {
+	db, name := c.GetAdditionalBucket(bucketCheckpoints)
+	return &checkpointStorage{
+		db:     db,
+		bucket: name,
+	}
+}
+
+func checkpointKey(index int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(index))
+	return key
+}
+
+func (s *checkpointStorage) store(cp Checkpoint) error {
+	buf, err := protobuf.Encode(&cp)
+	if err != nil {
+		return xerrors.Errorf("encoding: %v", err)
+	}
+	err = s.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.Bucket(s.bucket).CreateBucketIfNotExists(cp.SkipChainID)
+		if err != nil {
+			return xerrors.Errorf("creating bucket: %v", err)
+		}
+		return b.Put(checkpointKey(cp.Index), buf)
+	})
+	return cothority.ErrorOrNil(err, "tx error")
+}
+
+// get returns the checkpoint of the block at the given index, or the latest
+// checkpoint if the index is 0. It returns nil if there is no such
+// checkpoint.
+func (s *checkpointStorage) get(sid skipchain.SkipBlockID, index int) (*Checkpoint, error) {
+	var cp *Checkpoint
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket).Bucket(sid)
+		if b == nil {
+			return nil
+		}
+
+		var buf []byte
+		if index == 0 {
+			_, buf = b.Cursor().Last()
+		} else {
+			buf = b.Get(checkpointKey(index))
+		}
+		if buf == nil {
+			return nil
+		}
+		cp = &Checkpoint{}
+		return protobuf.Decode(buf, cp)
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("tx error: %v", err)
+	}
+	return cp, nil
+}
+
+// makeCheckpoint returns the checkpoint of the given block, without the
+// signature. The state is read from a snapshot of the database, so that blocks
+// can be applied while it is hashed. If cw is not nil, the global state is also
+// written into it.
+func (s *Service) makeCheckpoint(sb *skipchain.SkipBlock, cw *checkpointWriter) (*Checkpoint, error) {
+	header, err := decodeBlockHeader(sb)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding header: %v", err)
+	}
+
+	snap, err := s.getStateSnapshot(sb)
+	if err != nil {
+		return nil, xerrors.Errorf("getting state: %v", err)
+	}
+	defer snap.Close()
+
+	nonce, err := snap.GetNonce()
+	if err != nil {
+		return nil, xerrors.Errorf("getting nonce: %v", err)
+	}
+	version := snap.GetVersion()
+
+	walk := stateWalk(snap.ForEachInOrder)
+	if cw != nil {
+		if err := cw.writeHeader(nonce, version); err != nil {
+			return nil, xerrors.Errorf("writing file: %v", err)
+		}
+		walk = func(cb func(k, v []byte) error) error {
+			return snap.ForEachInOrder(func(k, v []byte) error {
+				if err := cw.writePair(k, v); err != nil {
+					return xerrors.Errorf("writing file: %v", err)
+				}
+				return cb(k, v)
+			})
+		}
+	}
+	hash, err := checkpointStateHash(nonce, version, walk)
+	if err != nil {
+		return nil, xerrors.Errorf("hashing state: %v", err)
+	}
+
+	return &Checkpoint{
+		SkipChainID: sb.SkipChainID(),
+		BlockID:     sb.Hash,
+		Index:       sb.Index,
+		TrieRoot:    header.TrieRoot,
+		StateHash:   hash,
+	}, nil
+}
+
+// checkpointKeyValuesField is the protobuf field number of the KeyValues of a
+// CheckpointFile.
+const checkpointKeyValuesField = 5
+
+// checkpointWriter writes the file of a checkpoint while the global state is
+// walked, so that the state is never held in memory. The file is the protobuf
+// encoding of a CheckpointFile: the other fields are written first, then the
+// key/value pairs are appended one by one, in the order of the trie.
+type checkpointWriter struct {
+	w  *bufio.Writer
+	cf CheckpointFile
+}
+
+// writeHeader writes all the fields of the file but the key/value pairs.
+func (cw *checkpointWriter) writeHeader(nonce []byte, version Version) error {
+	cw.cf.Nonce = nonce
+	cw.cf.Version = version
+	cw.cf.KeyValues = nil
+	buf, err := protobuf.Encode(&cw.cf)
+	if err != nil {
+		return xerrors.Errorf("encoding: %v", err)
+	}
+	_, err = cw.w.Write(buf)
+	return err
+}
+
+// writePair appends a key/value pair to the file.
+func (cw *checkpointWriter) writePair(k, v []byte) error {
+	buf, err := protobuf.Encode(&DBKeyValue{Key: k, Value: v})
+	if err != nil {
+		return xerrors.Errorf("encoding: %v", err)
+	}
+	prefix := make([]byte, 2*binary.MaxVarintLen64)
+	n := binary.PutUvarint(prefix, checkpointKeyValuesField<<3|2)
+	n += binary.PutUvarint(prefix[n:], uint64(len(buf)))
+	if _, err := cw.w.Write(prefix[:n]); err != nil {
+		return err
+	}
+	_, err = cw.w.Write(buf)
+	return err
+}
+
+// exportCheckpoint writes the file of the signed checkpoint of the given block
+// with the given name. The file is only created if the state of this node
+// matches the checkpoint.
+func (s *Service) exportCheckpoint(sb *skipchain.SkipBlock, cp Checkpoint, name string) error {
+	proof, err := s.db().GetProofForID(sb.Hash)
+	if err != nil {
+		return xerrors.Errorf("getting proof: %v", err)
+	}
+
+	tmp := name + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return xerrors.Errorf("creating file: %v", err)
+	}
+	cw := &checkpointWriter{
+		w:  bufio.NewWriter(f),
+		cf: CheckpointFile{Checkpoint: cp, Proof: proof},
+	}
+	local, err := s.makeCheckpoint(sb, cw)
+	if err == nil {
+		err = cw.w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && !bytes.Equal(local.Hash(), cp.Hash()) {
+		err = xerrors.New("checkpoint doesn't match the state")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return cothority.ErrorOrNil(os.Rename(tmp, name), "renaming file")
+}
+
+// createCheckpoint is called by the leader to get the checkpoint of the given
+// block signed by its roster. The signed checkpoint is then stored and sent
+// to the other nodes.
+func (s *Service) createCheckpoint(sb *skipchain.SkipBlock) error {
+	cp, err := s.makeCheckpoint(sb, nil)
+	if err != nil {
+		return xerrors.Errorf("hashing state: %v", err)
+	}
+	payload, err := protobuf.Encode(cp)
+	if err != nil {
+		return xerrors.Errorf("encoding checkpoint: %v", err)
+	}
+	interval, _, err := s.LoadBlockInfo(sb.SkipChainID())
+	if err != nil {
+		return xerrors.Errorf("loading block info: %v", err)
+	}
+
+	proto, err := s.CreateProtocol(checkpointFtCosi, sb.Roster.GenerateBinaryTree())
+	if err != nil {
+		return xerrors.Errorf("creating protocol: %v", err)
+	}
+	cosiProto := proto.(*protocol.BlsCosi)
+	cosiProto.Msg = cp.Hash()
+	cosiProto.Data = payload
+	cosiProto.CreateProtocol = s.CreateProtocol
+	cosiProto.Timeout = interval * 2
+
+	log.Lvlf2("%s starting checkpoint of block %d", s.ServerIdentity(), sb.Index)
+	if err := cosiProto.Start(); err != nil {
+		return xerrors.Errorf("starting protocol: %v", err)
+	}
+	sig := <-cosiProto.FinalSignature
+	if sig == nil {
+		return xerrors.New("couldn't get the checkpoint signed")
+	}
+	cp.Signature = sig
+
+	if err := s.storeCheckpoint(*cp); err != nil {
+		return xerrors.Errorf("storing checkpoint: %v", err)
+	}
+	for _, si := range sb.Roster.List {
+		if si.Equal(s.ServerIdentity()) {
+			continue
+		}
+		if err := s.SendRaw(si, cp); err != nil {
+			log.Warnf("%s couldn't send checkpoint to %s: %v",
+				s.ServerIdentity(), si, err)
+		}
+	}
+	return nil
+}
+
+// verifyCheckpoint is registered in the checkpoint ftcosi. It accepts the
+// checkpoint only if it corresponds to the state of this node. The state is
+// hashed while walking the trie, and no file is created.
+func (s *Service) verifyCheckpoint(msg []byte, data []byte) bool {
+	var cp Checkpoint
+	if err := protobuf.Decode(data, &cp); err != nil {
+		log.Error(s.ServerIdentity(), err)
+		return false
+	}
+	if !bytes.Equal(msg, cp.Hash()) {
+		log.Error(s.ServerIdentity(), "digest doesn't verify")
+		return false
+	}
+	sb, err := s.waitCheckpointBlock(cp)
+	if err != nil {
+		log.Error(s.ServerIdentity(), err)
+		return false
+	}
+	local, err := s.makeCheckpoint(sb, nil)
+	if err != nil {
+		log.Error(s.ServerIdentity(), err)
+		return false
+	}
+	if !bytes.Equal(msg, local.Hash()) {
+		log.Error(s.ServerIdentity(), "checkpoint doesn't match the state")
+		return false
+	}
+	return true
+}
+
+// waitCheckpointBlock returns the block of the checkpoint once it has been
+// applied to the state, as the leader can start the checkpoint before the
+// other nodes applied the block.
+func (s *Service) waitCheckpointBlock(cp Checkpoint) (*skipchain.SkipBlock, error) {
+	deadline := time.Now().Add(checkpointWait)
+	for {
+		sb := s.db().GetByID(cp.BlockID)
+		if sb != nil {
+			st, err := s.getStateTrie(sb.SkipChainID())
+			if err != nil {
+				return nil, xerrors.Errorf("getting state trie: %v", err)
+			}
+			if st.GetIndex() >= sb.Index {
+				return sb, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return nil, xerrors.Errorf("block %d of checkpoint is not applied",
+				cp.Index)
+		}
+		time.Sleep(checkpointWait / 20)
+	}
+}
+
+// handleCheckpoint stores the signed checkpoint sent by the leader.
+func (s *Service) handleCheckpoint(env *network.Envelope) error {
+	cp, ok := env.Msg.(*Checkpoint)
+	if !ok {
+		return xerrors.Errorf("%v failed to cast to Checkpoint", s.ServerIdentity())
+	}
+	sb := s.db().GetByID(cp.BlockID)
+	if sb == nil {
+		return xerrors.Errorf("%v doesn't know the block of the checkpoint", s.ServerIdentity())
+	}
+	if !sb.SkipChainID().Equal(cp.SkipChainID) || sb.Index != cp.Index {
+		return xerrors.New("checkpoint doesn't match its block")
+	}
+	err := protocol.BlsSignature(cp.Signature).Verify(pairingSuite, cp.Hash(),
+		sb.Roster.ServicePublics(ServiceName))
+	if err != nil {
+		return xerrors.Errorf("invalid signature: %v", err)
+	}
+	return cothority.ErrorOrNil(s.storeCheckpoint(*cp), "storing checkpoint")
+}
+
+// storeCheckpoint stores the signed checkpoint and, if the checkpoint
+// directory of the node is set, writes its file. The file is exported from
+// the state of this node.
+func (s *Service) storeCheckpoint(cp Checkpoint) error {
+	if err := s.checkpoints.store(cp); err != nil {
+		return xerrors.Errorf("storing: %v", err)
+	}
+	log.Lvlf2("%s stored checkpoint of block %d", s.ServerIdentity(), cp.Index)
+
+	dir := s.nodeConfig().CheckpointDir
+	if dir == "" {
+		return nil
+	}
+	sb := s.db().GetByID(cp.BlockID)
+	if sb == nil {
+		return xerrors.New("block of checkpoint does not exist")
+	}
+	name := filepath.Join(dir, fmt.Sprintf("%x-%d.checkpoint", cp.SkipChainID, cp.Index))
+	return cothority.ErrorOrNil(s.exportCheckpoint(sb, cp, name), "exporting state")
+}
+
+// GetCheckpoint returns a checkpoint stored by this node.
+func (s *Service) GetCheckpoint(req *GetCheckpoint) (*GetCheckpointResponse, error) {
+	cp, err := s.checkpoints.get(req.SkipChainID, req.Index)
+	if err != nil {
+		return nil, xerrors.Errorf("getting checkpoint: %v", err)
+	}
+	if cp == nil {
+		return nil, xerrors.New("checkpoint not found")
+	}
+	return &GetCheckpointResponse{Checkpoint: *cp}, nil
+}
+
+// checkpointPair is a key/value pair of a checkpoint file that is stored as
+// it is in a trie.
+type checkpointPair struct {
+	kv DBKeyValue
+}
+
+func (p checkpointPair) Op() trie.OpType {
+	return trie.OpSet
+}
+
+func (p checkpointPair) Key() []byte {
+	return p.kv.Key
+}
+
+func (p checkpointPair) Val() []byte {
+	return p.kv.Value
+}
+
+func (cf *CheckpointFile) pairs() []trie.KVPair {
+	pairs := make([]trie.KVPair, len(cf.KeyValues))
+	for i := range cf.KeyValues {
+		pairs[i] = checkpointPair{cf.KeyValues[i]}
+	}
+	return pairs
+}
+
+// VerifyCheckpointFile checks that the checkpoint file holds the global state
+// of a block of the skipchain with the given ID, and that the checkpoint has
+// been signed by the roster of that block.
+func VerifyCheckpointFile(cf *CheckpointFile, id skipchain.SkipBlockID) error {
+	cp := cf.Checkpoint
+	if !cp.SkipChainID.Equal(id) {
+		return xerrors.New("checkpoint is for another skipchain")
+	}
+	if err := skipchain.Proof(cf.Proof).VerifyFromID(id); err != nil {
+		return xerrors.Errorf("invalid proof: %v", err)
+	}
+	sb := cf.Proof[len(cf.Proof)-1]
+	if !sb.Hash.Equal(cp.BlockID) || sb.Index != cp.Index {
+		return xerrors.New("proof doesn't end at the block of the checkpoint")
+	}
+	header, err := decodeBlockHeader(sb)
+	if err != nil {
+		return xerrors.Errorf("decoding header: %v", err)
+	}
+	if !bytes.Equal(header.TrieRoot, cp.TrieRoot) {
+		return xerrors.New("trie root doesn't match the block")
+	}
+	err = protocol.BlsSignature(cp.Signature).Verify(pairingSuite, cp.Hash(),
+		sb.Roster.ServicePublics(ServiceName))
+	if err != nil {
+		return xerrors.Errorf("invalid signature: %v", err)
+	}
+
+	t, err := trie.NewTrie(trie.NewMemDB(), cf.Nonce)
+	if err != nil {
+		return xerrors.Errorf("creating trie: %v", err)
+	}
+	if err := t.Batch(cf.pairs()); err != nil {
+		return xerrors.Errorf("batch failed: %v", err)
+	}
+	if !bytes.Equal(t.GetRoot(), cp.TrieRoot) {
+		return xerrors.New("state doesn't match the trie root")
+	}
+	// A key given twice is only once in the trie.
+	var count int
+	walk := func(cb func(k, v []byte) error) error {
+		return t.ForEach(func(k, v []byte) error {
+			count++
+			return cb(k, v)
+		})
+	}
+	hash, err := checkpointStateHash(cf.Nonce, cf.Version, walk)
+	if err != nil {
+		return xerrors.Errorf("hashing state: %v", err)
+	}
+	if !bytes.Equal(hash, cp.StateHash) {
+		return xerrors.New("state doesn't match the checkpoint")
+	}
+	if count != len(cf.KeyValues) {
+		return xerrors.New("state has duplicated keys")
+	}
+	return nil
+}
+
+// ImportCheckpointFile stores the global state of a checkpoint file in an
+// empty bucket of the database. The file must have been verified with
+// VerifyCheckpointFile. Once imported, the blocks following the checkpoint
+// can be applied with ReplayStateCont.
+func ImportCheckpointFile(db *bbolt.DB, bucket []byte, cf *CheckpointFile) error {
+	st, err := newStateTrie(db, bucket, cf.Nonce)
+	if err != nil {
+		return xerrors.Errorf("creating state trie: %v", err)
+	}
+	err = st.DB().Update(func(b trie.Bucket) error {
+		if err := st.BatchWithBucket(cf.pairs(), b); err != nil {
+			return xerrors.Errorf("batch failed: %v", err)
+		}
+
+		indexBuf := make([]byte, 4)
+		binary.LittleEndian.PutUint32(indexBuf, uint32(cf.Checkpoint.Index))
+		if err := st.SetMetadataWithBucket([]byte(trieIndexKey), indexBuf, b); err != nil {
+			return xerrors.Errorf("storing index: %v", err)
+		}
+
+		versionBuf := make([]byte, 4)
+		binary.LittleEndian.PutUint32(versionBuf, uint32(cf.Version))
+		if err := st.SetMetadataWithBucket([]byte(trieVersionKey), versionBuf, b); err != nil {
+			return xerrors.Errorf("storing version: %v", err)
+		}
+
+		if !bytes.Equal(st.GetRootWithBucket(b), cf.Checkpoint.TrieRoot) {
+			return xerrors.New("root verfication failed")
+		}
+		return nil
+	})
+	if err != nil {
+		return xerrors.Errorf("storing state: %v", err)
+	}
+	return cothority.ErrorOrNil(st.IsValid(), "invalid state")
+}
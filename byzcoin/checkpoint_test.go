@@ -0,0 +1,154 @@
+package byzcoin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+)
+
+func TestCheckpointStorage(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	storage := s.service().checkpoints
+	scID := s.genesis.SkipChainID()
+	cp, err := storage.get(scID, 0)
+	require.NoError(t, err)
+	require.Nil(t, cp)
+
+	for _, i := range []int{2, 10, 4} {
+		require.NoError(t, storage.store(Checkpoint{SkipChainID: scID, Index: i}))
+	}
+	cp, err = storage.get(scID, 0)
+	require.NoError(t, err)
+	require.Equal(t, 10, cp.Index)
+	cp, err = storage.get(scID, 4)
+	require.NoError(t, err)
+	require.Equal(t, 4, cp.Index)
+	cp, err = storage.get(scID, 6)
+	require.NoError(t, err)
+	require.Nil(t, cp)
+}
+
+// Creates checkpoints on a chain, then verifies and imports the state of one
+// of them.
+func TestService_Checkpoint(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	// The first node writes the files of the checkpoints, once its config
+	// is signed by its key.
+	dir, err := ioutil.TempDir("", "checkpoints")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	nodeConfig := NodeConfig{CheckpointDir: dir}
+	req := &SetNodeConfigRequest{Config: nodeConfig, Sequence: 2}
+	_, err = s.service().SetNodeConfig(req)
+	require.Error(t, err)
+	msg, err := req.Hash()
+	require.NoError(t, err)
+	req.Signature, err = schnorr.Sign(cothority.Suite, s.service().ServerIdentity().GetPrivate(), msg)
+	require.NoError(t, err)
+	_, err = s.service().SetNodeConfig(req)
+	require.NoError(t, err)
+	require.Equal(t, nodeConfig, s.service().nodeConfig())
+
+	// The same request, or one with a lower sequence number, is refused.
+	_, err = s.service().SetNodeConfig(req)
+	require.Error(t, err)
+	old := &SetNodeConfigRequest{Sequence: 1}
+	msg, err = old.Hash()
+	require.NoError(t, err)
+	old.Signature, err = schnorr.Sign(cothority.Suite, s.service().ServerIdentity().GetPrivate(), msg)
+	require.NoError(t, err)
+	_, err = s.service().SetNodeConfig(old)
+	require.Error(t, err)
+	require.Equal(t, nodeConfig, s.service().nodeConfig())
+
+	config, err := s.service().LoadConfig(s.genesis.SkipChainID())
+	require.NoError(t, err)
+	config.CheckpointInterval = 2
+	configBuf, err := protobuf.Encode(config)
+	require.NoError(t, err)
+	tx, err := combineInstrsAndSign(s.signer, Instruction{
+		InstanceID: ConfigInstanceID,
+		Invoke: &Invoke{
+			ContractID: ContractConfigID,
+			Command:    "update_config",
+			Args:       Arguments{{Name: "config", Value: configBuf}},
+		},
+		SignerCounter: []uint64{1},
+	})
+	require.NoError(t, err)
+	s.sendTxAndWait(t, tx, 10)
+	addDummyTxs(t, s, 3, 1, 2)
+
+	// All the nodes get the signed checkpoint.
+	var cp Checkpoint
+	for _, service := range s.services {
+		var resp *GetCheckpointResponse
+		for i := 0; i < 20; i++ {
+			resp, err = service.GetCheckpoint(&GetCheckpoint{
+				SkipChainID: s.genesis.SkipChainID(),
+			})
+			if err == nil {
+				break
+			}
+			time.Sleep(testInterval)
+		}
+		require.NoError(t, err)
+		require.Equal(t, 0, resp.Checkpoint.Index%2)
+		if cp.Index == 0 || resp.Checkpoint.Index < cp.Index {
+			cp = resp.Checkpoint
+		}
+	}
+
+	sb := s.service().db().GetByID(cp.BlockID)
+	require.NotNil(t, sb)
+	local, err := s.service().makeCheckpoint(sb, nil)
+	require.NoError(t, err)
+	require.Equal(t, cp.Hash(), local.Hash())
+
+	// The file written by the first node holds the signed checkpoint.
+	name := filepath.Join(dir, fmt.Sprintf("%x-%d.checkpoint", cp.SkipChainID, cp.Index))
+	var buf []byte
+	for i := 0; i < 20; i++ {
+		buf, err = ioutil.ReadFile(name)
+		if err == nil {
+			break
+		}
+		time.Sleep(testInterval)
+	}
+	require.NoError(t, err)
+	cf := &CheckpointFile{}
+	require.NoError(t, protobuf.DecodeWithConstructors(buf, cf,
+		network.DefaultConstructors(cothority.Suite)))
+	require.Equal(t, cp.Signature, cf.Checkpoint.Signature)
+	require.NoError(t, VerifyCheckpointFile(cf, s.genesis.SkipChainID()))
+	require.Error(t, VerifyCheckpointFile(cf, sb.Hash))
+
+	db, bucket := s.service().GetAdditionalBucket([]byte("checkpoint-import"))
+	require.NoError(t, ImportCheckpointFile(db, bucket, cf))
+	st, err := loadStateTrie(db, bucket)
+	require.NoError(t, err)
+	require.Equal(t, cp.TrieRoot, st.GetRoot())
+	require.Equal(t, cp.Index, st.GetIndex())
+	require.Equal(t, cf.Version, st.GetVersion())
+
+	// A modified state or signature is refused.
+	value := cf.KeyValues[0].Value
+	cf.KeyValues[0].Value = []byte("modified")
+	require.Error(t, VerifyCheckpointFile(cf, s.genesis.SkipChainID()))
+	cf.KeyValues[0].Value = value
+	cf.Checkpoint.Signature[0] ^= 1
+	require.Error(t, VerifyCheckpointFile(cf, s.genesis.SkipChainID()))
+}
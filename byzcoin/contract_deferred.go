@@ -73,6 +73,23 @@ func (dd DeferredData) String() string {
 	return out.String()
 }
 
+// DeferredStatus is the result of the "status" query of the deferred
+// contract.
+type DeferredStatus struct {
+	// Expired is true if the current block index is greater than the
+	// ExpireBlockIndex, so that the proposed transaction cannot be
+	// executed anymore.
+	Expired bool
+	// RemainingExecutions is the number of times the proposed transaction
+	// can still be executed.
+	RemainingExecutions uint64
+	// Signatures holds the number of signatures collected for each
+	// instruction of the proposed transaction.
+	Signatures []int
+	// Executed is true if the proposed transaction has been executed.
+	Executed bool
+}
+
 type contractDeferred struct {
 	BasicContract
 	DeferredData
@@ -420,3 +437,26 @@ func hashDeferred(instr Instruction, instanceID []byte) []byte {
 
 	return h.Sum(nil)
 }
+
+// Query implements ContractQuerier. The "status" method returns the
+// protobuf-encoded DeferredStatus of the instance.
+func (c *contractDeferred) Query(rst ReadOnlyStateTrie, iid InstanceID, method string, args Arguments) ([]byte, error) {
+	switch method {
+	case "status":
+		status := DeferredStatus{
+			Expired:             uint64(rst.GetIndex()) > c.DeferredData.ExpireBlockIndex,
+			RemainingExecutions: c.DeferredData.MaxNumExecution,
+			Executed:            len(c.DeferredData.ExecResult) > 0,
+		}
+		for _, instr := range c.DeferredData.ProposedTransaction.Instructions {
+			status.Signatures = append(status.Signatures, len(instr.Signatures))
+		}
+		buf, err := protobuf.Encode(&status)
+		if err != nil {
+			return nil, xerrors.Errorf("encoding status: %v", err)
+		}
+		return buf, nil
+	default:
+		return nil, xerrors.Errorf("unknown query %s", method)
+	}
+}
@@ -87,17 +87,11 @@ func (c *contractNaming) VerifyInstruction(rst ReadOnlyStateTrie, inst Instructi
 	// controlled by the darc that guards the instance ID in the invoke
 	// argument.
 
-	// Check the number of signers match with the number of signatures.
-	if len(inst.SignerIdentities) != len(inst.Signatures) {
-		return xerrors.New("lengh of identities does not match the length of signatures")
-	}
-	if len(inst.Signatures) == 0 {
-		return xerrors.New("no signatures - nothing to verify")
-	}
-
-	// Check the signature counters.
-	if err := verifySignerCounters(rst, inst.SignerCounter, inst.SignerIdentities); err != nil {
-		return xerrors.Errorf("failed to verify the counters: %v", err)
+	// Check the signatures and the signature counters, and save the
+	// identities that provide good signatures.
+	goodIdentities, err := inst.signerIdentities(rst, msg, true)
+	if err != nil {
+		return xerrors.Errorf("failed to verify the signers: %v", err)
 	}
 
 	// Get the darc, we have to do it differently than the normal
@@ -127,13 +121,6 @@ func (c *contractNaming) VerifyInstruction(rst ReadOnlyStateTrie, inst Instructi
 		return xerrors.Errorf("action '%v' does not exist", action)
 	}
 
-	// Save the identities that provide good signatures.
-	goodIdentities := make([]string, 0)
-	for i := range inst.Signatures {
-		if err := inst.SignerIdentities[i].Verify(msg, inst.Signatures[i]); err == nil {
-			goodIdentities = append(goodIdentities, inst.SignerIdentities[i].String())
-		}
-	}
 	if len(goodIdentities) == 0 {
 		return xerrors.New("all signatures failed to verify")
 	}
@@ -210,7 +197,7 @@ func (c *contractNaming) Invoke(rst ReadOnlyStateTrie, inst Instruction, coins [
 		// Check that we are not overwriting.
 		var oldEntryBuf []byte
 		oldEntryBuf, _, _, _, err = rst.GetValues(key.Slice())
-		if !xerrors.Is(err, errKeyNotSet) {
+		if !xerrors.Is(err, ErrKeyNotSet) {
 			oldEntry := contractNamingEntry{}
 			err = protobuf.Decode(oldEntryBuf, &oldEntry)
 			if err != nil {
@@ -297,3 +284,47 @@ func (c *contractNaming) Invoke(rst ReadOnlyStateTrie, inst Instruction, coins [
 		return nil, nil, xerrors.New("invalid invoke command: " + inst.Invoke.Command)
 	}
 }
+
+// Query implements ContractQuerier. The "resolve" method returns the instance
+// ID named by the arguments "darcID" and "name", like ResolveInstanceID.
+func (c *contractNaming) Query(rst ReadOnlyStateTrie, iid InstanceID, method string, args Arguments) ([]byte, error) {
+	switch method {
+	case "resolve":
+		res, err := resolveName(rst, args.Search("darcID"), string(args.Search("name")))
+		if err != nil {
+			return nil, err
+		}
+		return res.Slice(), nil
+	default:
+		return nil, xerrors.Errorf("unknown query %s", method)
+	}
+}
+
+// resolveName returns the instance ID named by the tuple of the darc ID that
+// guards it and the name.
+func resolveName(rst ReadOnlyStateTrie, darcID darc.ID, name string) (InstanceID, error) {
+	if len(darcID) == 0 {
+		return InstanceID{}, xerrors.New("darc ID must be set")
+	}
+
+	h := sha256.New()
+	h.Write(darcID)
+	h.Write([]byte{'/'})
+	h.Write([]byte(name))
+	key := NewInstanceID(h.Sum(nil))
+	val, _, _, _, err := rst.GetValues(key[:])
+	if err != nil {
+		return InstanceID{}, xerrors.Errorf("reading trie: %v", err)
+	}
+
+	valStruct := contractNamingEntry{}
+	if err := protobuf.Decode(val, &valStruct); err != nil {
+		return InstanceID{}, xerrors.Errorf("decoding contract: %v", err)
+	}
+
+	if valStruct.Removed {
+		return InstanceID{}, cothority.WrapError(ErrKeyNotSet)
+	}
+
+	return valStruct.IID, nil
+}
@@ -292,6 +292,18 @@ func TestService_Naming(t *testing.T) {
 	verifyNameResolution("your genesis darc")
 	verifyNameResolution("everyone's genesis darc")
 
+	// The same resolution using a query of the naming contract.
+	res, err := cl.Query(NamingInstanceID, "resolve", Arguments{
+		{Name: "darcID", Value: gDarc.GetBaseID()},
+		{Name: "name", Value: []byte("my genesis darc")},
+	})
+	require.NoError(t, err)
+	iID, err := cl.ResolveInstanceID(gDarc.GetBaseID(), "my genesis darc")
+	require.NoError(t, err)
+	require.Equal(t, iID.Slice(), res)
+	_, err = cl.Query(NamingInstanceID, "unknown", nil)
+	require.Error(t, err)
+
 	// Tests below are for removal.
 
 	// FAIL - do not allow removal for what does not exist.
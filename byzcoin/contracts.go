@@ -1,9 +1,11 @@
 package byzcoin
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -87,15 +89,38 @@ type ContractWithRegistry interface {
 	SetRegistry(ReadOnlyContractRegistry)
 }
 
+// ContractQuerier is an optional interface for contracts that can answer
+// read-only queries about their instances, so that clients don't need to
+// decode the values of the instances themselves. A query must not have any
+// side effect. It is run on the latest state of a node and its result is not
+// proven.
+type ContractQuerier interface {
+	Query(rst ReadOnlyStateTrie, iid InstanceID, method string, args Arguments) ([]byte, error)
+}
+
 // ContractFn is the type signature of the instance factory functions which can be
 // registered with the ByzCoin service.
 type ContractFn func(in []byte) (Contract, error)
 
+// ContractMigration converts the value of an instance from the format used by
+// the previous version of its contract to the format of the new version.
+type ContractMigration func(value []byte) ([]byte, error)
+
+// contractVersion is a version of a contract after the first one, together
+// with the migration of the values of the previous version.
+type contractVersion struct {
+	fn      ContractFn
+	migrate ContractMigration
+}
+
 // contractRegistry maps a contract ID with its constructor function. As soon
 // as the first cloning happens, the registry will be locked and no new contract
 // can be added for the global call.
 type contractRegistry struct {
 	registry map[string]ContractFn
+	// versions holds the versions of a contract after the first one, so
+	// that the version v is at the index v-1.
+	versions map[string][]contractVersion
 	locked   bool
 	sync.Mutex
 }
@@ -123,8 +148,35 @@ func (cr *contractRegistry) register(contractID string, f ContractFn, ignoreLock
 	return nil
 }
 
+// registerVersion adds the next version of a contract that is already
+// registered. It fails if the registry is locked or if the version doesn't
+// follow the latest one.
+func (cr *contractRegistry) registerVersion(contractID string, version uint64,
+	f ContractFn, migrate ContractMigration) error {
+	cr.Lock()
+	defer cr.Unlock()
+
+	if cr.locked {
+		return xerrors.New("contract registry is locked")
+	}
+	if _, exists := cr.registry[contractID]; !exists {
+		return xerrors.New("first version of the contract is not registered")
+	}
+	if latest := uint64(len(cr.versions[contractID])); version != latest+1 {
+		return xerrors.Errorf("expected version %d but got %d", latest+1, version)
+	}
+	if migrate == nil {
+		return xerrors.New("missing migration")
+	}
+
+	cr.versions[contractID] = append(cr.versions[contractID],
+		contractVersion{fn: f, migrate: migrate})
+	return nil
+}
+
 // Search looks up the contract ID and returns the constructor function
-// if it exists and nil otherwise.
+// if it exists and nil otherwise. It always returns the first version of the
+// contract.
 func (cr *contractRegistry) Search(contractID string) (ContractFn, bool) {
 	cr.Lock()
 	fn, exists := cr.registry[contractID]
@@ -132,6 +184,103 @@ func (cr *contractRegistry) Search(contractID string) (ContractFn, bool) {
 	return fn, exists
 }
 
+// searchVersion returns the constructor function of the given version of the
+// contract, if it exists.
+func (cr *contractRegistry) searchVersion(contractID string, version uint64) (ContractFn, bool) {
+	if version == 0 {
+		return cr.Search(contractID)
+	}
+
+	cr.Lock()
+	defer cr.Unlock()
+	vs := cr.versions[contractID]
+	if version > uint64(len(vs)) {
+		return nil, false
+	}
+	return vs[version-1].fn, true
+}
+
+// migrate converts the value of an instance of the contract from the version
+// from to the version to, applying all the migrations in between.
+func (cr *contractRegistry) migrate(contractID string, from, to uint64, value []byte) ([]byte, error) {
+	cr.Lock()
+	vs := cr.versions[contractID]
+	cr.Unlock()
+
+	if to > uint64(len(vs)) {
+		return nil, xerrors.Errorf("version %d of contract %s is not supported",
+			to, contractID)
+	}
+	for v := from + 1; v <= to; v++ {
+		var err error
+		value, err = vs[v-1].migrate(value)
+		if err != nil {
+			return nil, xerrors.Errorf("migrating to version %d: %v", v, err)
+		}
+	}
+	return value, nil
+}
+
+// hasVersions returns true if any contract has more than one version.
+func (cr *contractRegistry) hasVersions() bool {
+	cr.Lock()
+	defer cr.Unlock()
+	return len(cr.versions) > 0
+}
+
+// supportedVersions returns the latest version of every contract, sorted by
+// contract ID.
+func (cr *contractRegistry) supportedVersions() []ContractVersion {
+	cr.Lock()
+	defer cr.Unlock()
+
+	out := make([]ContractVersion, 0, len(cr.registry))
+	for id := range cr.registry {
+		out = append(out, ContractVersion{
+			ContractID: id,
+			Version:    uint64(len(cr.versions[id])),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].ContractID < out[j].ContractID
+	})
+	return out
+}
+
+// withVersions returns a view of the registry that uses the given versions of
+// the contracts, as activated in the configuration of a chain.
+func (cr *contractRegistry) withVersions(versions []ContractVersion) *versionedRegistry {
+	vr := &versionedRegistry{
+		contractRegistry: cr,
+		versions:         make(map[string]uint64),
+	}
+	for _, v := range versions {
+		vr.versions[v.ContractID] = v.Version
+	}
+	return vr
+}
+
+// contractMigrator is implemented by the registries given to the contracts, so
+// that the config contract can migrate the instances when a new version of a
+// contract is activated.
+type contractMigrator interface {
+	searchVersion(contractID string, version uint64) (ContractFn, bool)
+	migrate(contractID string, from, to uint64, value []byte) ([]byte, error)
+}
+
+// versionedRegistry returns the versions of the contracts that are active on
+// a chain.
+type versionedRegistry struct {
+	*contractRegistry
+	versions map[string]uint64
+}
+
+// Search implements ReadOnlyContractRegistry and returns the constructor
+// function of the active version of the contract.
+func (vr *versionedRegistry) Search(contractID string) (ContractFn, bool) {
+	return vr.searchVersion(contractID, vr.versions[contractID])
+}
+
 // Clone returns a copy of the registry and locks the source so that
 // static registration is not allowed anymore. This is to prevent
 // registration of a contract at runtime and limit it only to the
@@ -147,6 +296,9 @@ func (cr *contractRegistry) clone() *contractRegistry {
 	for key, value := range cr.registry {
 		clone.registry[key] = value
 	}
+	for key, value := range cr.versions {
+		clone.versions[key] = append([]contractVersion{}, value...)
+	}
 	cr.Unlock()
 
 	return clone
@@ -155,6 +307,7 @@ func (cr *contractRegistry) clone() *contractRegistry {
 func newContractRegistry() *contractRegistry {
 	return &contractRegistry{
 		registry: make(map[string]ContractFn),
+		versions: make(map[string][]contractVersion),
 		locked:   false,
 	}
 }
@@ -169,6 +322,18 @@ func RegisterGlobalContract(contractID string, f ContractFn) error {
 	return cothority.ErrorOrNil(err, "registration failed")
 }
 
+// RegisterGlobalContractVersion stores a new version of a contract already in
+// the global registry. The versions must be registered in order, starting at
+// 1, and each one comes with the migration of the values of the previous
+// version. A chain keeps using the previous version until the config contract
+// is invoked with upgrade_contract, which migrates all the instances of the
+// contract in the same block.
+func RegisterGlobalContractVersion(contractID string, version uint64, f ContractFn,
+	migrate ContractMigration) error {
+	err := globalContractRegistry.registerVersion(contractID, version, f, migrate)
+	return cothority.ErrorOrNil(err, "registration failed")
+}
+
 // RegisterContract stores the contract in the service registry which
 // makes it only available to byzcoin.
 //
@@ -291,6 +456,7 @@ var ConfigInstanceID = InstanceID{}
 type contractConfig struct {
 	BasicContract
 	ChainConfig
+	contracts ReadOnlyContractRegistry
 }
 
 var _ Contract = (*contractConfig)(nil)
@@ -309,6 +475,11 @@ type darcContractIDs struct {
 	IDs []string
 }
 
+// SetRegistry keeps the reference of the contract registry.
+func (c *contractConfig) SetRegistry(r ReadOnlyContractRegistry) {
+	c.contracts = r
+}
+
 // We need to override BasicContract.Verify because of the genesis config special case.
 func (c *contractConfig) VerifyInstruction(rst ReadOnlyStateTrie, inst Instruction, msg []byte) error {
 	pr, err := rst.GetProof(ConfigInstanceID.Slice())
@@ -422,7 +593,7 @@ func (c *contractConfig) Spawn(rst ReadOnlyStateTrie, inst Instruction, coins []
 	}
 	c.DarcContractIDs = dcIDs.IDs
 
-	configBuf, err := protobuf.Encode(c)
+	configBuf, err := protobuf.Encode(&c.ChainConfig)
 	if err != nil {
 		return nil, nil, xerrors.Errorf("encoding config: %v", err)
 	}
@@ -437,11 +608,16 @@ func (c *contractConfig) Spawn(rst ReadOnlyStateTrie, inst Instruction, coins []
 
 // Invoke offers the following functions:
 //   - Invoke:update_config
+//   - Invoke:upgrade_contract
 //   - Invoke:view_change
 //
 // Invoke:update_config should have the following input argument:
 //   - config ChainConfig
 //
+// Invoke:upgrade_contract should have the following input arguments:
+//   - contract_id string
+//   - version     uint64, little-endian encoded
+//
 // Invoke:view_change sould have the following input arguments:
 //   - newview viewchange.NewViewReq
 //   - multisig []byte
@@ -518,11 +694,83 @@ func (c *contractConfig) Invoke(rst ReadOnlyStateTrie, inst Instruction, coins [
 
 		sc, err := updateRosterScs(rst, darcID, req.Roster)
 		return sc, coins, cothority.ErrorOrNil(err, "roster scs")
+	case "upgrade_contract":
+		sc, err := c.upgradeContract(rst, inst, darcID)
+		return sc, coins, cothority.ErrorOrNil(err, "upgrading contract")
 	default:
 		return nil, nil, xerrors.New("invalid invoke command: " + inst.Invoke.Command)
 	}
 }
 
+// upgradeContract activates a new version of a contract and migrates the values
+// of all the instances of the contract to it, so that the block holding the
+// instruction is the barrier between the two versions. All the nodes must
+// support the new version, else they will refuse the block.
+func (c *contractConfig) upgradeContract(rst ReadOnlyStateTrie, inst Instruction, darcID darc.ID) (StateChanges, error) {
+	contractID := string(inst.Invoke.Args.Search("contract_id"))
+	if contractID == ContractConfigID {
+		return nil, xerrors.New("cannot upgrade the config contract")
+	}
+	versionBuf := inst.Invoke.Args.Search("version")
+	if len(versionBuf) != 8 {
+		return nil, xerrors.New("version must be 8 bytes")
+	}
+	version := binary.LittleEndian.Uint64(versionBuf)
+
+	m, ok := c.contracts.(contractMigrator)
+	if !ok {
+		return nil, xerrors.New("contracts registry is missing due to bad initialization")
+	}
+	if _, ok := m.searchVersion(contractID, version); !ok {
+		return nil, xerrors.Errorf("version %d of contract %s is not supported",
+			version, contractID)
+	}
+	current := c.contractVersion(contractID)
+	if version <= current {
+		return nil, xerrors.Errorf("contract %s is already at version %d",
+			contractID, current)
+	}
+
+	var scs StateChanges
+	err := rst.ForEach(func(k, v []byte) error {
+		body, err := decodeStateChangeBody(v)
+		if err != nil {
+			return xerrors.Errorf("decoding value: %v", err)
+		}
+		if body.ContractID != contractID {
+			return nil
+		}
+
+		value, err := m.migrate(contractID, current, version, body.Value)
+		if err != nil {
+			return xerrors.Errorf("instance %x: %v", k, err)
+		}
+		scs = append(scs, NewStateChange(Update, NewInstanceID(k), contractID,
+			value, body.DarcID))
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("migrating instances: %v", err)
+	}
+	// The staging trie doesn't iterate in a fixed order, but all the nodes
+	// must return the same state changes.
+	sort.Slice(scs, func(i, j int) bool {
+		return bytes.Compare(scs[i].InstanceID, scs[j].InstanceID) < 0
+	})
+
+	config := c.ChainConfig
+	config.ContractVersions = append([]ContractVersion{}, c.ContractVersions...)
+	config.setContractVersion(contractID, version)
+	configBuf, err := protobuf.Encode(&config)
+	if err != nil {
+		return nil, xerrors.Errorf("encoding config: %v", err)
+	}
+
+	return append(StateChanges{
+		NewStateChange(Update, ConfigInstanceID, ContractConfigID, configBuf, darcID),
+	}, scs...), nil
+}
+
 func updateRosterScs(rst ReadOnlyStateTrie, darcID darc.ID, newRoster onet.Roster) (StateChanges, error) {
 	config, err := LoadConfigFromTrie(rst)
 	if err != nil {
@@ -568,7 +816,7 @@ func GetValueContract(st ReadOnlyStateTrie, key []byte) (value []byte, version u
 		return
 	}
 	if value == nil {
-		err = cothority.WrapError(errKeyNotSet)
+		err = cothority.WrapError(ErrKeyNotSet)
 		return
 	}
 	return
@@ -31,6 +31,7 @@ var CoinName = iid("byzCoin")
 //  - fetch takes "coins" out of the account and returns it as an output
 //    parameter for the next instruction to interpret.
 //  - store puts the coins given to the instance back into the account.
+// The balance of an instance can be read with the "balance" query.
 // You can only delete a contractCoin instance if the account is empty.
 
 func contractCoinFromBytes(in []byte) (byzcoin.Contract, error) {
@@ -220,6 +221,19 @@ func (c *contractCoin) Delete(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instru
 	return
 }
 
+// Query implements byzcoin.ContractQuerier. The "balance" method returns the
+// number of coins in the instance as a 64-bit uint in LittleEndian.
+func (c *contractCoin) Query(rst byzcoin.ReadOnlyStateTrie, iid byzcoin.InstanceID, method string, args byzcoin.Arguments) ([]byte, error) {
+	switch method {
+	case "balance":
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, c.Value)
+		return buf, nil
+	default:
+		return nil, xerrors.Errorf("unknown query %s", method)
+	}
+}
+
 // iid uses sha256(in) in order to manufacture an InstanceID from in
 // thereby handling the case where len(in) != 32.
 //
@@ -259,6 +259,21 @@ func TestCoin_InvokeTransfer(t *testing.T) {
 	require.Equal(t, byzcoin.NewStateChange(byzcoin.Update, coAddr1, ContractCoinID, ciZero, gdarc.GetBaseID()), sc[1])
 }
 
+func TestCoin_QueryBalance(t *testing.T) {
+	ct := newCT()
+	coAddr := byzcoin.InstanceID{}
+	ct.Store(coAddr, ciTwo, ContractCoinID, gdarc.GetBaseID())
+
+	q, ok := ct.getContract(coAddr).(byzcoin.ContractQuerier)
+	require.True(t, ok)
+	res, err := q.Query(ct, coAddr, "balance", nil)
+	require.NoError(t, err)
+	require.Equal(t, coinTwo, res)
+
+	_, err = q.Query(ct, coAddr, "unknown", nil)
+	require.Error(t, err)
+}
+
 type cvTest struct {
 	values      map[string][]byte
 	contractIDs map[string]string
@@ -268,6 +268,17 @@ func TestDeferred_ScenarioSingleInstruction(t *testing.T) {
 	result, err = cl.GetDeferredDataAfter(myID, &atr.Proof.Latest)
 	require.Equal(t, 1, len(result.ExecResult))
 
+	// The status of the instance can be queried.
+	require.NoError(t, cl.WaitPropagation(atr.Proof.Latest.Index))
+	statusBuf, err := cl.Query(myID, "status", nil)
+	require.NoError(t, err)
+	var status byzcoin.DeferredStatus
+	require.NoError(t, protobuf.Decode(statusBuf, &status))
+	require.True(t, status.Executed)
+	require.False(t, status.Expired)
+	require.Equal(t, uint64(0), status.RemainingExecutions)
+	require.Equal(t, []int{2}, status.Signatures)
+
 	time.Sleep(2 * genesisMsg.BlockInterval)
 	pr, err := cl.WaitProof(byzcoin.NewInstanceID(result.ExecResult[0]), 2*genesisMsg.BlockInterval, nil)
 	require.Nil(t, err)
@@ -0,0 +1,516 @@
+package contracts
+
+import (
+	"bytes"
+	"math"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/byzcoin/wasm"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// The wasm contract runs code deployed by the users, written in WebAssembly.
+// The code is stored in an instance of its own, and can then be used by any
+// number of instances, each one with its own data.
+//
+// Spawning with the "code" argument stores the module in a new code
+// instance, after checking that it can be decoded. Spawning with the
+// "code_id" argument creates an instance bound to that code and calls the
+// exported function "spawn" of the module. Invoking any command on such an
+// instance calls the exported function "invoke", which can read the command
+// and the arguments of the instruction. Both functions take no parameters and
+// return an i32, which must be 0 for the instruction to be accepted.
+//
+// The module can import the following functions from the "byzcoin" module.
+// All the parameters and results are i32, the pointers and lengths referring
+// to the memory of the module. The functions that return a value copy as
+// much of it as fits in the buffer and return its full length, or -1 if there
+// is no such value.
+//
+//  - arg(name_ptr, name_len, buf_ptr, buf_len) -> len
+//  - command(buf_ptr, buf_len) -> len
+//  - instance_id(buf_ptr) writes the 32 bytes of the ID of the instance
+//  - get_data(buf_ptr, buf_len) -> len
+//  - set_data(ptr, len) replaces the data of the instance
+//  - get_value(iid_ptr, buf_ptr, buf_len) -> len reads any instance
+//  - get_contract(iid_ptr, buf_ptr, buf_len) -> len reads the contract ID of
+//    any instance
+//  - spawn_instance(seed_ptr, seed_len, data_ptr, data_len, iid_ptr) creates
+//    a new instance of the same code and writes its ID
+//  - update_instance(iid_ptr, data_ptr, data_len) replaces the data of an
+//    instance of the same code, if its darc gives "invoke:wasm.<command>" to
+//    the signers, with the command of the instruction, or "spawn" for a spawn
+//  - remove_instance(iid_ptr) removes an instance of the same code, if its
+//    darc gives "delete:wasm" to the signers
+//  - emit_event(name_ptr, name_len, value_ptr, value_len)
+//  - abort(msg_ptr, msg_len) refuses the instruction with the message
+//
+// The instances are read as they were before the instruction. A run is
+// limited to WasmFuel, so it always stops, and the same instruction uses the
+// same fuel on every node.
+
+// ContractWasmID denotes a contract that runs WebAssembly code stored in
+// another instance.
+const ContractWasmID = "wasm"
+
+// WasmFuel is the fuel given to a run of the code, which is used by every
+// WebAssembly instruction and by the bytes copied by the host functions.
+const WasmFuel = 1e7
+
+// wasmNotFound is returned to the module when a value doesn't exist.
+const wasmNotFound = math.MaxUint32
+
+// WasmInstance is the value of the instances of the wasm contract. A code
+// instance only holds the Code, the others hold the ID of their code
+// instance and their Data.
+type WasmInstance struct {
+	Code   []byte
+	CodeID []byte
+	Data   []byte
+}
+
+func contractWasmFromBytes(in []byte) (byzcoin.Contract, error) {
+	c := &contractWasm{}
+	if in == nil {
+		return c, nil
+	}
+	err := protobuf.Decode(in, &c.WasmInstance)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't unmarshal instance data: %v", err)
+	}
+	return c, nil
+}
+
+type contractWasm struct {
+	byzcoin.BasicContract
+	WasmInstance
+}
+
+func (c *contractWasm) Spawn(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	if code := inst.Spawn.Args.Search("code"); code != nil {
+		if _, err = wasm.Decode(code); err != nil {
+			return nil, nil, xerrors.Errorf("invalid code: %v", err)
+		}
+		var buf []byte
+		buf, err = protobuf.Encode(&WasmInstance{Code: code})
+		if err != nil {
+			return nil, nil, xerrors.Errorf("encoding instance: %v", err)
+		}
+		sc = byzcoin.StateChanges{
+			byzcoin.NewStateChange(byzcoin.Create, inst.DeriveID(""),
+				ContractWasmID, buf, darcID),
+		}
+		return
+	}
+
+	codeID := inst.Spawn.Args.Search("code_id")
+	if codeID == nil {
+		return nil, nil, xerrors.New("need either a code or a code_id argument")
+	}
+	c.WasmInstance = WasmInstance{CodeID: codeID}
+	sc, err = c.run(rst, inst, inst.DeriveID(""), darcID, "spawn", byzcoin.Create)
+	return
+}
+
+func (c *contractWasm) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	if c.Code != nil {
+		return nil, nil, xerrors.New("cannot invoke a code instance")
+	}
+	sc, err = c.run(rst, inst, inst.InstanceID, darcID, "invoke", byzcoin.Update)
+	return
+}
+
+func (c *contractWasm) Delete(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	sc = byzcoin.StateChanges{
+		byzcoin.NewStateChange(byzcoin.Remove, inst.InstanceID, ContractWasmID, nil, darcID),
+	}
+	return
+}
+
+// run calls the exported function of the code and returns the state change
+// of the instance with its new data, followed by the ones of the other
+// instances.
+func (c *contractWasm) run(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction,
+	iid byzcoin.InstanceID, darcID darc.ID, export string,
+	action byzcoin.StateAction) (byzcoin.StateChanges, error) {
+	code, err := getWasmInstance(rst, c.CodeID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting code: %v", err)
+	}
+	if code.Code == nil {
+		return nil, xerrors.New("code_id is not a code instance")
+	}
+	mod, err := wasm.Decode(code.Code)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding code: %v", err)
+	}
+	ft, ok := mod.ExportedFunction(export)
+	if !ok || len(ft.Params) != 0 || len(ft.Results) != 1 || ft.Results[0] != wasm.I32 {
+		return nil, xerrors.Errorf("code must export a function %s() -> i32", export)
+	}
+
+	h := &wasmHost{
+		rst:     rst,
+		inst:    inst,
+		self:    iid,
+		darcID:  darcID,
+		codeID:  c.CodeID,
+		data:    c.Data,
+		touched: map[byzcoin.InstanceID]bool{iid: true},
+	}
+	imports, err := h.imports(mod)
+	if err != nil {
+		return nil, err
+	}
+	in, err := wasm.NewInstance(mod, imports, WasmFuel)
+	if err != nil {
+		return nil, xerrors.Errorf("instantiating code: %v", err)
+	}
+	res, err := in.Call(export)
+	if err != nil {
+		return nil, xerrors.Errorf("running %s: %v", export, err)
+	}
+	if res[0] != 0 {
+		return nil, xerrors.Errorf("%s returned %d", export, int32(res[0]))
+	}
+
+	buf, err := protobuf.Encode(&WasmInstance{CodeID: c.CodeID, Data: h.data})
+	if err != nil {
+		return nil, xerrors.Errorf("encoding instance: %v", err)
+	}
+	return append(byzcoin.StateChanges{
+		byzcoin.NewStateChange(action, iid, ContractWasmID, buf, darcID),
+	}, h.scs...), nil
+}
+
+// getWasmInstance returns the value of an instance of the wasm contract.
+func getWasmInstance(rst byzcoin.ReadOnlyStateTrie, iid []byte) (*WasmInstance, error) {
+	v, _, cid, _, err := rst.GetValues(iid)
+	if err != nil {
+		return nil, xerrors.Errorf("reading instance: %v", err)
+	}
+	if cid != ContractWasmID {
+		return nil, xerrors.Errorf("instance %x is not a wasm instance", iid)
+	}
+	var wi WasmInstance
+	err = protobuf.Decode(v, &wi)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding instance: %v", err)
+	}
+	return &wi, nil
+}
+
+// wasmHost holds the state of the host functions during a run.
+type wasmHost struct {
+	rst    byzcoin.ReadOnlyStateTrie
+	inst   byzcoin.Instruction
+	self   byzcoin.InstanceID
+	darcID darc.ID
+	codeID []byte
+	data   []byte
+	// scs are the state changes of the other instances, which can only be
+	// changed once per run.
+	scs     byzcoin.StateChanges
+	touched map[byzcoin.InstanceID]bool
+}
+
+// wasmHostFunc is a host function with its number of i32 parameters and
+// results.
+type wasmHostFunc struct {
+	params  int
+	results int
+	f       wasm.HostFunc
+}
+
+// imports returns the host functions imported by the module, after checking
+// their signatures.
+func (h *wasmHost) imports(mod *wasm.Module) (wasm.Imports, error) {
+	funcs := map[string]wasmHostFunc{
+		"arg":             {4, 1, h.arg},
+		"command":         {2, 1, h.command},
+		"instance_id":     {1, 0, h.instanceID},
+		"get_data":        {2, 1, h.getData},
+		"set_data":        {2, 0, h.setData},
+		"get_value":       {3, 1, h.getValue},
+		"get_contract":    {3, 1, h.getContract},
+		"spawn_instance":  {5, 0, h.spawnInstance},
+		"update_instance": {3, 0, h.updateInstance},
+		"remove_instance": {1, 0, h.removeInstance},
+		"emit_event":      {4, 0, h.emitEvent},
+		"abort":           {2, 0, h.abort},
+	}
+	imports := wasm.Imports{"byzcoin": {}}
+	for _, imp := range mod.Imports() {
+		hf, ok := funcs[imp.Name]
+		if imp.Module != "byzcoin" || !ok {
+			return nil, xerrors.Errorf("unknown import %s.%s", imp.Module, imp.Name)
+		}
+		valid := len(imp.Type.Params) == hf.params && len(imp.Type.Results) == hf.results
+		for _, vt := range imp.Type.Params {
+			valid = valid && vt == wasm.I32
+		}
+		for _, vt := range imp.Type.Results {
+			valid = valid && vt == wasm.I32
+		}
+		if !valid {
+			return nil, xerrors.Errorf("wrong signature for import %s", imp.Name)
+		}
+		imports["byzcoin"][imp.Name] = hf.f
+	}
+	return imports, nil
+}
+
+// read returns a copy of the memory of the module.
+func (h *wasmHost) read(in *wasm.Instance, ptr, size uint64) ([]byte, error) {
+	if err := in.UseFuel(size); err != nil {
+		return nil, err
+	}
+	return in.Read(uint32(ptr), uint32(size))
+}
+
+// write copies as much of the value as fits in the buffer and returns the
+// length of the value.
+func (h *wasmHost) write(in *wasm.Instance, value []byte, ptr, size uint64) ([]uint64, error) {
+	if uint64(len(value)) < size {
+		size = uint64(len(value))
+	}
+	if err := in.UseFuel(size); err != nil {
+		return nil, err
+	}
+	if err := in.Write(uint32(ptr), value[:size]); err != nil {
+		return nil, err
+	}
+	return []uint64{uint64(len(value))}, nil
+}
+
+func (h *wasmHost) readInstanceID(in *wasm.Instance, ptr uint64) (byzcoin.InstanceID, error) {
+	buf, err := h.read(in, ptr, uint64(len(byzcoin.InstanceID{})))
+	if err != nil {
+		return byzcoin.InstanceID{}, err
+	}
+	return byzcoin.NewInstanceID(buf), nil
+}
+
+// sameCode returns the instance of the instruction if it is bound to the
+// same code as the one being run, and if its darc allows the instruction to
+// the signers of the instruction being run.
+func (h *wasmHost) sameCode(instr byzcoin.Instruction) (*WasmInstance, darc.ID, error) {
+	iid := instr.InstanceID
+	if h.touched[iid] {
+		return nil, nil, xerrors.Errorf("instance %x already changed", iid[:])
+	}
+	wi, err := getWasmInstance(h.rst, iid.Slice())
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(wi.CodeID, h.codeID) {
+		return nil, nil, xerrors.Errorf("instance %x is bound to another code", iid[:])
+	}
+	if err := byzcoin.AuthorizeInstruction(h.rst, instr); err != nil {
+		return nil, nil, xerrors.Errorf("instance %x: %v", iid[:], err)
+	}
+	_, _, _, darcID, err := h.rst.GetValues(iid.Slice())
+	return wi, darcID, err
+}
+
+func (h *wasmHost) arg(in *wasm.Instance, args []uint64) ([]uint64, error) {
+	name, err := h.read(in, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	var value []byte
+	found := false
+	for _, arg := range h.args() {
+		if arg.Name == string(name) {
+			value = arg.Value
+			found = true
+			break
+		}
+	}
+	if !found {
+		return []uint64{wasmNotFound}, nil
+	}
+	return h.write(in, value, args[2], args[3])
+}
+
+func (h *wasmHost) args() byzcoin.Arguments {
+	if h.inst.Spawn != nil {
+		return h.inst.Spawn.Args
+	}
+	return h.inst.Invoke.Args
+}
+
+func (h *wasmHost) command(in *wasm.Instance, args []uint64) ([]uint64, error) {
+	if h.inst.Invoke == nil {
+		return []uint64{wasmNotFound}, nil
+	}
+	return h.write(in, []byte(h.inst.Invoke.Command), args[0], args[1])
+}
+
+func (h *wasmHost) instanceID(in *wasm.Instance, args []uint64) ([]uint64, error) {
+	_, err := h.write(in, h.self[:], args[0], uint64(len(h.self)))
+	return nil, err
+}
+
+func (h *wasmHost) getData(in *wasm.Instance, args []uint64) ([]uint64, error) {
+	return h.write(in, h.data, args[0], args[1])
+}
+
+func (h *wasmHost) setData(in *wasm.Instance, args []uint64) (res []uint64, err error) {
+	h.data, err = h.read(in, args[0], args[1])
+	return
+}
+
+func (h *wasmHost) getValue(in *wasm.Instance, args []uint64) ([]uint64, error) {
+	iid, err := h.readInstanceID(in, args[0])
+	if err != nil {
+		return nil, err
+	}
+	v, _, _, _, err := h.rst.GetValues(iid.Slice())
+	if xerrors.Is(err, byzcoin.ErrKeyNotSet) {
+		return []uint64{wasmNotFound}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return h.write(in, v, args[1], args[2])
+}
+
+func (h *wasmHost) getContract(in *wasm.Instance, args []uint64) ([]uint64, error) {
+	iid, err := h.readInstanceID(in, args[0])
+	if err != nil {
+		return nil, err
+	}
+	_, _, cid, _, err := h.rst.GetValues(iid.Slice())
+	if xerrors.Is(err, byzcoin.ErrKeyNotSet) {
+		return []uint64{wasmNotFound}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return h.write(in, []byte(cid), args[1], args[2])
+}
+
+func (h *wasmHost) spawnInstance(in *wasm.Instance, args []uint64) ([]uint64, error) {
+	seed, err := h.read(in, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	data, err := h.read(in, args[2], args[3])
+	if err != nil {
+		return nil, err
+	}
+	iid := h.inst.DeriveID(string(seed))
+	if h.touched[iid] {
+		return nil, xerrors.Errorf("instance %x already changed", iid[:])
+	}
+	_, _, _, _, err = h.rst.GetValues(iid.Slice())
+	if err == nil {
+		return nil, xerrors.Errorf("instance %x already exists", iid[:])
+	} else if !xerrors.Is(err, byzcoin.ErrKeyNotSet) {
+		return nil, err
+	}
+	buf, err := protobuf.Encode(&WasmInstance{CodeID: h.codeID, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	h.touched[iid] = true
+	h.scs = append(h.scs, byzcoin.NewStateChange(byzcoin.Create, iid,
+		ContractWasmID, buf, h.darcID))
+	_, err = h.write(in, iid[:], args[4], uint64(len(iid)))
+	return nil, err
+}
+
+func (h *wasmHost) updateInstance(in *wasm.Instance, args []uint64) ([]uint64, error) {
+	iid, err := h.readInstanceID(in, args[0])
+	if err != nil {
+		return nil, err
+	}
+	data, err := h.read(in, args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	command := "spawn"
+	if h.inst.Invoke != nil {
+		command = h.inst.Invoke.Command
+	}
+	_, darcID, err := h.sameCode(byzcoin.Instruction{
+		InstanceID: iid,
+		Invoke:     &byzcoin.Invoke{ContractID: ContractWasmID, Command: command},
+	})
+	if err != nil {
+		return nil, err
+	}
+	buf, err := protobuf.Encode(&WasmInstance{CodeID: h.codeID, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	h.touched[iid] = true
+	h.scs = append(h.scs, byzcoin.NewStateChange(byzcoin.Update, iid,
+		ContractWasmID, buf, darcID))
+	return nil, nil
+}
+
+func (h *wasmHost) removeInstance(in *wasm.Instance, args []uint64) ([]uint64, error) {
+	iid, err := h.readInstanceID(in, args[0])
+	if err != nil {
+		return nil, err
+	}
+	_, darcID, err := h.sameCode(byzcoin.Instruction{
+		InstanceID: iid,
+		Delete:     &byzcoin.Delete{ContractID: ContractWasmID},
+	})
+	if err != nil {
+		return nil, err
+	}
+	h.touched[iid] = true
+	h.scs = append(h.scs, byzcoin.NewStateChange(byzcoin.Remove, iid,
+		ContractWasmID, nil, darcID))
+	return nil, nil
+}
+
+func (h *wasmHost) emitEvent(in *wasm.Instance, args []uint64) ([]uint64, error) {
+	name, err := h.read(in, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	value, err := h.read(in, args[2], args[3])
+	if err != nil {
+		return nil, err
+	}
+	byzcoin.EmitEvent(h.rst, string(name), h.self,
+		byzcoin.NewEventAttribute("value", value))
+	return nil, nil
+}
+
+func (h *wasmHost) abort(in *wasm.Instance, args []uint64) ([]uint64, error) {
+	msg, err := h.read(in, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return nil, xerrors.Errorf("aborted: %s", msg)
+}
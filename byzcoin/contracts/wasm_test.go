@@ -0,0 +1,100 @@
+package contracts
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/byzcoin/bctest"
+	"go.dedis.ch/protobuf"
+)
+
+// counterWasm is a counter of 8 bytes that can be incremented up to 3:
+//
+//	(module
+//	  (import "byzcoin" "get_data" (func $get_data (param i32 i32) (result i32)))
+//	  (import "byzcoin" "set_data" (func $set_data (param i32 i32)))
+//	  (import "byzcoin" "abort" (func $abort (param i32 i32)))
+//	  (memory 1)
+//	  (func (export "spawn") (result i32)
+//	    (call $set_data (i32.const 0) (i32.const 8))
+//	    (i32.const 0))
+//	  (func (export "invoke") (result i32)
+//	    (drop (call $get_data (i32.const 0) (i32.const 8)))
+//	    (i64.store (i32.const 0) (i64.add (i64.load (i32.const 0)) (i64.const 1)))
+//	    (if (i64.gt_u (i64.load (i32.const 0)) (i64.const 3))
+//	      (then (call $abort (i32.const 16) (i32.const 7))))
+//	    (call $set_data (i32.const 0) (i32.const 8))
+//	    (i32.const 0))
+//	  (data (i32.const 16) "too big"))
+const counterWasm = "0061736d0100000001100360027f7f017f60027f7f006000017f0237030762" +
+	"797a636f696e086765745f6461746100000762797a636f696e087365745f646174" +
+	"6100010762797a636f696e0561626f727400010303020202050301000107120205" +
+	"737061776e000306696e766f6b6500040a3c020a0041004108100141000b2f0041" +
+	"00410810001a4100410029030042017c370300410029030042035604404110410710" +
+	"020b41004108100141000b0b0d010041100b07746f6f20626967"
+
+func TestWasm(t *testing.T) {
+	s, err := bctest.NewState("spawn:wasm", "invoke:wasm.increment", "delete:wasm")
+	require.NoError(t, err)
+	code, err := hex.DecodeString(counterWasm)
+	require.NoError(t, err)
+
+	// Only valid modules are stored.
+	spawn := bctest.Spawn(s.GenesisDarc.GetBaseID(), ContractWasmID,
+		bctest.Arg("code", "not wasm"))
+	require.NoError(t, s.Sign(&spawn, s.Admin))
+	_, _, err = s.Run(spawn)
+	require.Error(t, err)
+
+	spawn = bctest.Spawn(s.GenesisDarc.GetBaseID(), ContractWasmID,
+		byzcoin.Argument{Name: "code", Value: code})
+	require.NoError(t, s.Sign(&spawn, s.Admin))
+	_, _, err = s.Run(spawn)
+	require.NoError(t, err)
+	codeID := spawn.DeriveID("")
+
+	increment := bctest.Invoke(codeID, ContractWasmID, "increment")
+	require.NoError(t, s.Sign(&increment, s.Admin))
+	_, _, err = s.Run(increment)
+	require.Error(t, err)
+
+	spawn = bctest.Spawn(s.GenesisDarc.GetBaseID(), ContractWasmID,
+		byzcoin.Argument{Name: "code_id", Value: codeID.Slice()})
+	require.NoError(t, s.Sign(&spawn, s.Admin))
+	_, _, err = s.Run(spawn)
+	require.NoError(t, err)
+	iid := spawn.DeriveID("")
+	require.Equal(t, make([]byte, 8), getWasmData(t, s, iid))
+
+	for i := byte(1); i <= 3; i++ {
+		increment = bctest.Invoke(iid, ContractWasmID, "increment")
+		require.NoError(t, s.Sign(&increment, s.Admin))
+		_, _, err = s.Run(increment)
+		require.NoError(t, err)
+		require.Equal(t, []byte{i, 0, 0, 0, 0, 0, 0, 0}, getWasmData(t, s, iid))
+	}
+
+	// The code refuses to go over 3.
+	increment = bctest.Invoke(iid, ContractWasmID, "increment")
+	require.NoError(t, s.Sign(&increment, s.Admin))
+	_, _, err = s.Run(increment)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "too big")
+	require.Equal(t, []byte{3, 0, 0, 0, 0, 0, 0, 0}, getWasmData(t, s, iid))
+
+	del := bctest.Delete(iid, ContractWasmID)
+	require.NoError(t, s.Sign(&del, s.Admin))
+	_, _, err = s.Run(del)
+	require.NoError(t, err)
+}
+
+func getWasmData(t *testing.T, s *bctest.State, iid byzcoin.InstanceID) []byte {
+	v, _, cid, _, err := s.GetValues(iid.Slice())
+	require.NoError(t, err)
+	require.Equal(t, ContractWasmID, cid)
+	var wi WasmInstance
+	require.NoError(t, protobuf.Decode(v, &wi))
+	return wi.Data
+}
@@ -36,3 +36,49 @@ func TestContracts_Registry(t *testing.T) {
 	require.Error(t, r.register("c", testContractFn, false))
 	require.NoError(t, r.register("c", testContractFn, true))
 }
+
+// Test the registration and the migrations of the versions of a contract.
+func TestContracts_RegistryVersions(t *testing.T) {
+	r := newContractRegistry()
+	migrate := func(suffix string) ContractMigration {
+		return func(value []byte) ([]byte, error) {
+			return append(value, suffix...), nil
+		}
+	}
+
+	require.Error(t, r.registerVersion("a", 1, testContractFn, migrate("1")))
+	require.NoError(t, r.register("a", testContractFn, false))
+	require.NoError(t, r.register("b", testContractFn, false))
+	require.False(t, r.hasVersions())
+	require.Error(t, r.registerVersion("a", 2, testContractFn, migrate("2")))
+	require.Error(t, r.registerVersion("a", 1, testContractFn, nil))
+	require.NoError(t, r.registerVersion("a", 1, testContractFn, migrate("1")))
+	require.NoError(t, r.registerVersion("a", 2, testContractFn, migrate("2")))
+	require.True(t, r.hasVersions())
+
+	_, exists := r.searchVersion("a", 2)
+	require.True(t, exists)
+	_, exists = r.searchVersion("a", 3)
+	require.False(t, exists)
+
+	value, err := r.migrate("a", 0, 2, []byte("v"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("v12"), value)
+	value, err = r.migrate("a", 1, 2, []byte("v"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("v2"), value)
+	_, err = r.migrate("a", 0, 3, []byte("v"))
+	require.Error(t, err)
+
+	require.Equal(t, []ContractVersion{{"a", 2}, {"b", 0}}, r.supportedVersions())
+
+	vr := r.withVersions([]ContractVersion{{"a", 1}})
+	_, exists = vr.Search("a")
+	require.True(t, exists)
+	_, exists = vr.withVersions([]ContractVersion{{"a", 3}}).Search("a")
+	require.False(t, exists)
+
+	r2 := r.clone()
+	require.Error(t, r.registerVersion("a", 3, testContractFn, migrate("3")))
+	require.Equal(t, r.supportedVersions(), r2.supportedVersions())
+}
@@ -0,0 +1,485 @@
+package byzcoin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"go.dedis.ch/kyber/v3/util/random"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+// downloadSessionTimeout is the time after which a download session that is
+// not used anymore is closed.
+var downloadSessionTimeout = time.Minute
+
+// downloadSessionLifetime is the time after which a download session is
+// closed even if it is still used. A session holds a read transaction of the
+// database, which prevents the database from reusing the pages freed by the
+// writes, so it must not stay open for long.
+var downloadSessionLifetime = 10 * time.Minute
+
+// maxDownloadSessions is the maximum number of download sessions that can be
+// open at the same time.
+const maxDownloadSessions = 16
+
+// downloadBucketSuffix is appended to the name of the bucket of a state trie
+// to get the bucket where it is downloaded before being verified.
+const downloadBucketSuffix = "-download"
+
+// How many times a chunk is requested again from the same node before its
+// range is given to another node.
+var catchupDownloadRetries = 3
+
+// From how many nodes the state is downloaded at the same time.
+var catchupDownloadParallel = 4
+
+// Hash returns the digest signed by the node asking for a new download: the
+// ID of the chain followed by the timestamp.
+func (req DownloadState) Hash() []byte {
+	h := sha256.New()
+	h.Write(req.ByzCoinID)
+	ts := make([]byte, 8)
+	binary.LittleEndian.PutUint64(ts, uint64(req.Timestamp))
+	h.Write(ts)
+	return h.Sum(nil)
+}
+
+// Sign sets the signer, the timestamp and the signature of a new download.
+// The private key of the node must be in the server identity.
+func (req *DownloadState) Sign(si *network.ServerIdentity) error {
+	req.SignerID = si.ID
+	req.Timestamp = time.Now().UnixNano()
+	sig, err := schnorr.Sign(cothority.Suite, si.GetPrivate(), req.Hash())
+	if err != nil {
+		return xerrors.Errorf("sign error: %v", err)
+	}
+	req.Signature = sig
+	return nil
+}
+
+// verifyDownloader checks that a new download is asked for by a node of the
+// roster of the latest block, and that the request is recent.
+func (s *Service) verifyDownloader(req *DownloadState) error {
+	ts := time.Unix(0, req.Timestamp)
+	if ts.Before(time.Now().Add(-downloadSessionTimeout)) ||
+		ts.After(time.Now().Add(downloadSessionTimeout)) {
+		return xerrors.New("timestamp of the request is too far from now")
+	}
+	latest, err := s.db().GetLatestByID(req.ByzCoinID)
+	if err != nil {
+		return xerrors.Errorf("getting latest block: %v", err)
+	}
+	_, signer := latest.Roster.Search(req.SignerID)
+	if signer == nil {
+		return xerrors.New("signer is not in the roster")
+	}
+	err = schnorr.Verify(cothority.Suite, signer.Public, req.Hash(), req.Signature)
+	return cothority.ErrorOrNil(err, "verifying signature")
+}
+
+// downloadSession serves the state of a skipchain as it was when the session
+// was created. It holds a read transaction of the database, so that the
+// state doesn't change during the download.
+type downloadSession struct {
+	sync.Mutex
+	id     skipchain.SkipBlockID
+	tx     *bbolt.Tx
+	bucket *bbolt.Bucket
+	// next is the key following the last key returned.
+	next  []byte
+	total int
+	index int
+	timer *time.Timer
+	// deadline closes the session at the end of its lifetime.
+	deadline *time.Timer
+	closed   bool
+}
+
+// read returns up to length key/values, starting at start and stopping
+// before end. If start is empty, it continues after the last key returned.
+func (ds *downloadSession) read(start, end []byte, length int) ([]DBKeyValue, error) {
+	ds.Lock()
+	defer ds.Unlock()
+	if ds.closed {
+		return nil, xerrors.New("session is closed")
+	}
+	ds.timer.Reset(downloadSessionTimeout)
+
+	if len(start) == 0 {
+		start = ds.next
+	}
+	c := ds.bucket.Cursor()
+	k, v := c.First()
+	if len(start) > 0 {
+		k, v = c.Seek(start)
+	}
+
+	var kvs []DBKeyValue
+	for ; k != nil && len(kvs) < length; k, v = c.Next() {
+		if len(end) > 0 && bytes.Compare(k, end) >= 0 {
+			break
+		}
+		kvs = append(kvs, DBKeyValue{
+			Key:   append([]byte{}, k...),
+			Value: append([]byte{}, v...),
+		})
+	}
+	if len(kvs) > 0 {
+		ds.next = nextKey(kvs[len(kvs)-1].Key)
+	}
+	return kvs, nil
+}
+
+func (ds *downloadSession) close() {
+	ds.Lock()
+	defer ds.Unlock()
+	if ds.closed {
+		return
+	}
+	ds.closed = true
+	ds.timer.Stop()
+	ds.deadline.Stop()
+	if err := ds.tx.Rollback(); err != nil {
+		log.Error("couldn't close download session:", err)
+	}
+}
+
+// downloadSessions holds the download sessions of a node, indexed by their
+// nonce.
+type downloadSessions struct {
+	sync.Mutex
+	sessions map[uint64]*downloadSession
+}
+
+func newDownloadSessions() downloadSessions {
+	return downloadSessions{
+		sessions: make(map[uint64]*downloadSession),
+	}
+}
+
+// open creates a new session serving the state trie stored in the bucket.
+// The session is closed if it is not used during downloadSessionTimeout, and
+// in any case after downloadSessionLifetime.
+func (m *downloadSessions) open(id skipchain.SkipBlockID, db *bbolt.DB,
+	bucketName []byte) (uint64, *downloadSession, error) {
+	m.Lock()
+	defer m.Unlock()
+	if len(m.sessions) >= maxDownloadSessions {
+		return 0, nil, xerrors.New("too many download sessions")
+	}
+
+	tx, err := db.Begin(false)
+	if err != nil {
+		return 0, nil, xerrors.Errorf("tx error: %v", err)
+	}
+	bucket := tx.Bucket(bucketName)
+	if bucket == nil {
+		tx.Rollback()
+		return 0, nil, xerrors.New("no state for this skipchain")
+	}
+
+	ds := &downloadSession{
+		id:     id,
+		tx:     tx,
+		bucket: bucket,
+		total:  bucket.Stats().KeyN,
+		index:  -1,
+	}
+	if buf := bucket.Get([]byte(trieIndexKey)); buf != nil {
+		ds.index = int(binary.LittleEndian.Uint32(buf))
+	}
+
+	var nonce uint64
+	for nonce == 0 || m.sessions[nonce] != nil {
+		nonce = binary.LittleEndian.Uint64(random.Bits(64, true, random.New()))
+	}
+	ds.timer = time.AfterFunc(downloadSessionTimeout, func() {
+		log.Lvlf2("Closing download session %x", nonce)
+		m.close(nonce)
+	})
+	ds.deadline = time.AfterFunc(downloadSessionLifetime, func() {
+		log.Lvlf2("Download session %x reached its lifetime", nonce)
+		m.close(nonce)
+	})
+	m.sessions[nonce] = ds
+	return nonce, ds, nil
+}
+
+// get returns the session of the nonce, which must serve the given skipchain.
+func (m *downloadSessions) get(id skipchain.SkipBlockID, nonce uint64) (*downloadSession, error) {
+	m.Lock()
+	defer m.Unlock()
+	ds, ok := m.sessions[nonce]
+	if !ok || !ds.id.Equal(id) {
+		return nil, xerrors.New("unknown download session")
+	}
+	return ds, nil
+}
+
+func (m *downloadSessions) close(nonce uint64) {
+	m.Lock()
+	ds, ok := m.sessions[nonce]
+	delete(m.sessions, nonce)
+	m.Unlock()
+	if ok {
+		ds.close()
+	}
+}
+
+func (m *downloadSessions) closeAll() {
+	m.Lock()
+	sessions := m.sessions
+	m.sessions = make(map[uint64]*downloadSession)
+	m.Unlock()
+	for _, ds := range sessions {
+		ds.close()
+	}
+}
+
+// nextKey returns the smallest key that is bigger than key.
+func nextKey(key []byte) []byte {
+	return append(append([]byte{}, key...), 0)
+}
+
+// downloadRange is a range of keys to download. An empty end means that the
+// range goes up to the last key.
+type downloadRange struct {
+	start []byte
+	end   []byte
+}
+
+// splitKeySpace returns n ranges covering all the keys, split using their
+// first byte. As the keys of the trie are hashes, the ranges have about the
+// same number of keys.
+func splitKeySpace(n int) []downloadRange {
+	if n > 256 {
+		n = 256
+	}
+	out := make([]downloadRange, n)
+	for i := range out {
+		// As the keys cannot be empty, all keys are bigger than {0}.
+		out[i].start = []byte{byte(i * 256 / n)}
+		if i < n-1 {
+			out[i].end = []byte{byte((i + 1) * 256 / n)}
+		}
+	}
+	return out
+}
+
+// downloadStartIndex returns the index of the first node of a roster of
+// size l that is asked for the state, so that the leader and the subleaders
+// are not slowed down.
+func downloadStartIndex(l int) int {
+	if l > 3 {
+		// This is the leader plus the subleaders, don't contact them
+		return 1 + int(math.Ceil(math.Pow(float64(l), 1./3.)))
+	}
+	return 0
+}
+
+// downloadNode is a node that serves a session of the state to download.
+type downloadNode struct {
+	si    *network.ServerIdentity
+	nonce uint64
+	index int
+}
+
+// openDownloadSessions opens a session on up to catchupDownloadParallel nodes
+// of the roster. It returns the nodes that serve the state of the same
+// block, using the block with the most nodes.
+func (s *Service) openDownloadSessions(cl *Client, roster *onet.Roster) ([]downloadNode, error) {
+	var candidates []*network.ServerIdentity
+	for _, si := range roster.List[downloadStartIndex(len(roster.List)):] {
+		if !si.Equal(s.ServerIdentity()) {
+			candidates = append(candidates, si)
+		}
+	}
+	if len(candidates) > catchupDownloadParallel {
+		candidates = candidates[:catchupDownloadParallel]
+	}
+
+	nodes := make([]*downloadNode, len(candidates))
+	var wg sync.WaitGroup
+	for i, si := range candidates {
+		wg.Add(1)
+		go func(i int, si *network.ServerIdentity) {
+			defer wg.Done()
+			req := &DownloadState{
+				ByzCoinID: cl.ID,
+				Length:    1,
+			}
+			if err := req.Sign(s.ServerIdentity()); err != nil {
+				log.Error(s.ServerIdentity(), err)
+				return
+			}
+			resp, err := cl.DownloadStateFrom(si, req)
+			if err != nil {
+				log.Warnf("%s: couldn't open download session on %s: %v",
+					s.ServerIdentity(), si, err)
+				return
+			}
+			nodes[i] = &downloadNode{si: si, nonce: resp.Nonce, index: resp.Index}
+		}(i, si)
+	}
+	wg.Wait()
+
+	count := make(map[int]int)
+	best := -1
+	for _, n := range nodes {
+		if n == nil {
+			continue
+		}
+		count[n.index]++
+		if best < 0 || count[n.index] > count[best] ||
+			count[n.index] == count[best] && n.index > best {
+			best = n.index
+		}
+	}
+	if best < 0 {
+		return nil, xerrors.New("couldn't open a download session")
+	}
+
+	var out []downloadNode
+	for _, n := range nodes {
+		if n != nil && n.index == best {
+			out = append(out, *n)
+		}
+	}
+	return out, nil
+}
+
+// downloadState downloads the state from the nodes in parallel, each node
+// downloading ranges of keys. A range that fails on a node is resumed on
+// another node. The key/values are given to store, which must be safe for
+// concurrent use.
+func (s *Service) downloadState(cl *Client, nodes []downloadNode,
+	store func([]DBKeyValue) error) error {
+	ranges := splitKeySpace(len(nodes))
+	pending := make(chan downloadRange, len(ranges))
+	for _, r := range ranges {
+		pending <- r
+	}
+	remaining := int32(len(ranges))
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for _, n := range nodes {
+		wg.Add(1)
+		go func(n downloadNode) {
+			defer wg.Done()
+			for {
+				select {
+				case r := <-pending:
+					next, err := s.downloadRange(cl, n, r, store)
+					if err != nil {
+						log.Warnf("%s: giving up download from %s: %v",
+							s.ServerIdentity(), n.si, err)
+						pending <- downloadRange{start: next, end: r.end}
+						return
+					}
+					if atomic.AddInt32(&remaining, -1) == 0 {
+						close(done)
+					}
+				case <-done:
+					return
+				}
+			}
+		}(n)
+	}
+	wg.Wait()
+
+	if atomic.LoadInt32(&remaining) > 0 {
+		return xerrors.New("none of the nodes could give the whole state")
+	}
+	return nil
+}
+
+// downloadRange downloads all the keys of the range from the node. If it
+// fails, it returns the key where the download must be resumed.
+func (s *Service) downloadRange(cl *Client, n downloadNode, r downloadRange,
+	store func([]DBKeyValue) error) ([]byte, error) {
+	start := r.start
+	retries := 0
+	for {
+		resp, err := cl.DownloadStateFrom(n.si, &DownloadState{
+			ByzCoinID: cl.ID,
+			Nonce:     n.nonce,
+			Length:    catchupFetchDBEntries,
+			Start:     start,
+			End:       r.end,
+		})
+		if err != nil {
+			retries++
+			if retries > catchupDownloadRetries {
+				return start, err
+			}
+			log.Lvlf2("%s: retrying download from %s: %v", s.ServerIdentity(), n.si, err)
+			continue
+		}
+		retries = 0
+
+		if err := store(resp.KeyValues); err != nil {
+			return start, xerrors.Errorf("couldn't store entries: %v", err)
+		}
+		log.Lvlf2("Downloaded %d key/values of %d from %s", len(resp.KeyValues),
+			resp.Total, n.si)
+		if len(resp.KeyValues) < catchupFetchDBEntries {
+			return nil, nil
+		}
+		start = nextKey(resp.KeyValues[len(resp.KeyValues)-1].Key)
+	}
+}
+
+// clearBucket removes all the entries of the bucket.
+func clearBucket(db *bbolt.DB, name []byte) error {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		if tx.Bucket(name) != nil {
+			if err := tx.DeleteBucket(name); err != nil {
+				return xerrors.Errorf("deleting bucket: %v", err)
+			}
+		}
+		_, err := tx.CreateBucket(name)
+		return cothority.ErrorOrNil(err, "creating bucket")
+	})
+	return cothority.ErrorOrNil(err, "tx error")
+}
+
+// moveBucket replaces the content of the bucket dst with the one of src, and
+// removes src.
+func moveBucket(db *bbolt.DB, src, dst []byte) error {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		from := tx.Bucket(src)
+		if from == nil {
+			return xerrors.New("missing bucket")
+		}
+		if tx.Bucket(dst) != nil {
+			if err := tx.DeleteBucket(dst); err != nil {
+				return xerrors.Errorf("deleting bucket: %v", err)
+			}
+		}
+		to, err := tx.CreateBucket(dst)
+		if err != nil {
+			return xerrors.Errorf("creating bucket: %v", err)
+		}
+		err = from.ForEach(func(k, v []byte) error {
+			return to.Put(k, v)
+		})
+		if err != nil {
+			return xerrors.Errorf("copying entries: %v", err)
+		}
+		return cothority.ErrorOrNil(tx.DeleteBucket(src), "deleting bucket")
+	})
+	return cothority.ErrorOrNil(err, "tx error")
+}
@@ -0,0 +1,136 @@
+package byzcoin
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/onet/v3"
+)
+
+func TestSplitKeySpace(t *testing.T) {
+	rs := splitKeySpace(1)
+	require.Equal(t, []downloadRange{{start: []byte{0}}}, rs)
+
+	rs = splitKeySpace(3)
+	require.Equal(t, 3, len(rs))
+	require.Equal(t, []byte{0}, rs[0].start)
+	for i := 1; i < len(rs); i++ {
+		require.Equal(t, rs[i-1].end, rs[i].start)
+	}
+	require.Nil(t, rs[2].end)
+}
+
+func TestDownloadSessions_Timeout(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	dst := downloadSessionTimeout
+	defer func() {
+		downloadSessionTimeout = dst
+	}()
+	downloadSessionTimeout = 100 * time.Millisecond
+
+	resp, err := s.downloadState(DownloadState{
+		ByzCoinID: s.genesis.SkipChainID(),
+		Length:    1,
+	})
+	require.NoError(t, err)
+
+	time.Sleep(3 * downloadSessionTimeout)
+	_, err = s.downloadState(DownloadState{
+		ByzCoinID: s.genesis.SkipChainID(),
+		Nonce:     resp.Nonce,
+		Length:    1,
+	})
+	require.Error(t, err)
+
+	// A session that is used is still closed at the end of its lifetime.
+	dsl := downloadSessionLifetime
+	defer func() {
+		downloadSessionLifetime = dsl
+	}()
+	downloadSessionLifetime = 5 * downloadSessionTimeout
+	resp, err = s.downloadState(DownloadState{
+		ByzCoinID: s.genesis.SkipChainID(),
+		Length:    1,
+	})
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		time.Sleep(downloadSessionTimeout / 2)
+		_, err = s.downloadState(DownloadState{
+			ByzCoinID: s.genesis.SkipChainID(),
+			Nonce:     resp.Nonce,
+			Length:    1,
+		})
+		if err != nil {
+			break
+		}
+	}
+	require.Error(t, err)
+}
+
+// Downloads the state from many nodes in parallel and checks that it is the
+// same as the one of a single node.
+func TestService_DownloadStateParallel(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	cfdb := catchupFetchDBEntries
+	defer func() {
+		catchupFetchDBEntries = cfdb
+	}()
+	catchupFetchDBEntries = 10
+
+	addDummyTxs(t, s, 3, 3, 1)
+	s.waitPropagation(t, -1)
+
+	var all []DBKeyValue
+	var nonce uint64
+	for {
+		resp, err := s.downloadState(DownloadState{
+			ByzCoinID: s.genesis.SkipChainID(),
+			Nonce:     nonce,
+			Length:    10,
+		})
+		require.NoError(t, err)
+		if len(resp.KeyValues) == 0 {
+			break
+		}
+		all = append(all, resp.KeyValues...)
+		nonce = resp.Nonce
+	}
+
+	servers, _, _ := s.local.MakeSRS(cothority.Suite, 1, ByzCoinID)
+	service := s.local.GetServices(servers, ByzCoinID)[0].(*Service)
+	cl := NewClient(s.genesis.SkipChainID(), *s.roster)
+	// With 3 nodes, the leader is not avoided.
+	nodes, err := service.openDownloadSessions(cl, onet.NewRoster(s.roster.List[:3]))
+	require.NoError(t, err)
+	require.Equal(t, 3, len(nodes))
+
+	var lock sync.Mutex
+	var got []DBKeyValue
+	err = service.downloadState(cl, nodes, func(kvs []DBKeyValue) error {
+		lock.Lock()
+		defer lock.Unlock()
+		got = append(got, kvs...)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, len(all), len(got))
+	for _, kv := range all {
+		found := false
+		for _, kv2 := range got {
+			if bytes.Equal(kv.Key, kv2.Key) {
+				require.Equal(t, kv.Value, kv2.Value)
+				found = true
+				break
+			}
+		}
+		require.True(t, found)
+	}
+}
@@ -0,0 +1,166 @@
+package byzcoin
+
+import (
+	"encoding/binary"
+	"hash"
+
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/log"
+	"golang.org/x/xerrors"
+)
+
+// maxSearchEventsBlocks is the maximum number of blocks that are searched by
+// one SearchEvents request. The client can continue the search using the
+// Next field of the response.
+const maxSearchEventsBlocks = 1000
+
+// EventEmitter is implemented by the global state given to the contracts. The
+// events emitted while executing an instruction are stored in the TxResult
+// of the transaction, if it is accepted.
+type EventEmitter interface {
+	EmitEvent(name string, iid InstanceID, attrs ...EventAttribute)
+}
+
+// EmitEvent emits an event if the global state given to the contract supports
+// it. It returns false if the event could not be emitted, e.g. when the
+// contract is called outside of a transaction.
+func EmitEvent(rst ReadOnlyStateTrie, name string, iid InstanceID, attrs ...EventAttribute) bool {
+	e, ok := rst.(EventEmitter)
+	if !ok {
+		return false
+	}
+	e.EmitEvent(name, iid, attrs...)
+	return true
+}
+
+// NewEventAttribute is a convenience function that creates an attribute of an
+// event.
+func NewEventAttribute(key string, value []byte) EventAttribute {
+	return EventAttribute{Key: key, Value: value}
+}
+
+// eventCollector holds the events emitted by the contract of one instruction.
+type eventCollector struct {
+	contractID string
+	events     []Event
+}
+
+func (c *eventCollector) emit(name string, iid InstanceID, attrs []EventAttribute) {
+	c.events = append(c.events, Event{
+		Name:       name,
+		InstanceID: iid,
+		ContractID: c.contractID,
+		Attributes: append([]EventAttribute{}, attrs...),
+	})
+}
+
+// EmitEvent implements EventEmitter. The events are only collected if the
+// global state has been created for the execution of an instruction.
+func (gs globalState) EmitEvent(name string, iid InstanceID, attrs ...EventAttribute) {
+	if gs.events == nil {
+		return
+	}
+	gs.events.emit(name, iid, attrs)
+}
+
+// hashEvents writes the events to the hash, prefixing all the variable
+// length fields with their length.
+func hashEvents(h hash.Hash, events []Event) {
+	writeLen := func(l int) {
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, uint64(l))
+		h.Write(buf)
+	}
+	writeBytes := func(b []byte) {
+		writeLen(len(b))
+		h.Write(b)
+	}
+
+	for _, e := range events {
+		writeBytes([]byte(e.Name))
+		h.Write(e.InstanceID[:])
+		writeBytes([]byte(e.ContractID))
+		writeLen(len(e.Attributes))
+		for _, attr := range e.Attributes {
+			writeBytes([]byte(attr.Key))
+			writeBytes(attr.Value)
+		}
+	}
+}
+
+// match returns true if the event has the name and the contract ID of the
+// request, if they are set.
+func (req *SearchEvents) match(e Event) bool {
+	return (req.Name == "" || req.Name == e.Name) &&
+		(req.ContractID == "" || req.ContractID == e.ContractID)
+}
+
+// SearchEvents returns the events of the accepted transactions in the blocks
+// of the given range that match the name and the contract ID of the request.
+// At most maxSearchEventsBlocks blocks are searched, and the response tells
+// where the search must continue.
+func (s *Service) SearchEvents(req *SearchEvents) (*SearchEventsResponse, error) {
+	if req.From < 0 {
+		return nil, xerrors.New("the first block of the range must be positive")
+	}
+
+	latest, err := s.db().GetLatestByID(req.SkipChainID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting latest block: %v", err)
+	}
+
+	to := req.To
+	if to <= 0 || to > latest.Index {
+		to = latest.Index
+	}
+	if req.From > to {
+		return nil, xerrors.Errorf("invalid range: %d > %d", req.From, to)
+	}
+
+	resp := &SearchEventsResponse{}
+	last := to
+	if last-req.From >= maxSearchEventsBlocks {
+		last = req.From + maxSearchEventsBlocks - 1
+		resp.Next = last + 1
+	}
+
+	for idx := req.From; idx <= last; idx++ {
+		reply, err := s.skService().GetSingleBlockByIndex(
+			&skipchain.GetSingleBlockByIndex{Genesis: req.SkipChainID, Index: idx})
+		if err != nil {
+			return nil, xerrors.Errorf("getting block %d: %v", idx, err)
+		}
+
+		body, err := decodeBlockBody(reply.SkipBlock)
+		if xerrors.Is(err, errBlockPruned) {
+			fwd := &SearchEventsResponse{}
+			err = s.forwardToArchive(req.SkipChainID, req, fwd)
+			if err != nil {
+				return nil, xerrors.Errorf("forwarding request: %v", err)
+			}
+			return fwd, nil
+		}
+		if err != nil {
+			return nil, xerrors.Errorf("decoding body of block %d: %v", idx, err)
+		}
+
+		for i, txr := range body.TxResults {
+			if !txr.Accepted {
+				continue
+			}
+			for _, e := range txr.Events {
+				if req.match(e) {
+					resp.Events = append(resp.Events, BlockEvent{
+						BlockIndex: idx,
+						TxIndex:    i,
+						Event:      e,
+					})
+				}
+			}
+		}
+	}
+
+	log.Lvlf3("%s: found %d events in blocks %d to %d", s.ServerIdentity(),
+		len(resp.Events), req.From, last)
+	return resp, nil
+}
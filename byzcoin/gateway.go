@@ -0,0 +1,806 @@
+package byzcoin
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// gatewayPrefix is the path under which the gateway is served.
+const gatewayPrefix = "/byzcoin/"
+
+// The limits of the connections to the gateway. There is no timeout for
+// reading the whole request or writing the response, as it would also stop
+// the streams of blocks. The requests have no body, as only GET is accepted.
+const (
+	gatewayReadHeaderTimeout = 5 * time.Second
+	gatewayIdleTimeout       = time.Minute
+	gatewayMaxHeaderBytes    = 1 << 16
+)
+
+// The limits of the requests to the gateway, which is open to anyone. A proof
+// against a past state needs the state to be rebuilt, so only the last blocks
+// can be asked for, and a stream holds a listener on the chain.
+const (
+	gatewayProofHistory = 10
+	gatewayReplayBlocks = 100
+	gatewayMaxStreams   = 32
+)
+
+// gateway serves the chains of a node as JSON over HTTP, so that the clients
+// don't need the protobuf definitions. It only reads the chains: the
+// requests go through ProcessClientRequest like the ones of the websockets,
+// and the blocks are streamed as server-sent events.
+//
+// The paths are:
+//   - /byzcoin/ lists the chains
+//   - /byzcoin/$chain/config returns the configuration of the chain
+//   - /byzcoin/$chain/blocks/$block returns the block with this index or ID,
+//     or the latest block for "latest"
+//   - /byzcoin/$chain/instances/$id returns the instance with its contract,
+//     version and darc
+//   - /byzcoin/$chain/proofs/$id returns the proof of the instance
+//   - /byzcoin/$chain/counters?identity=$id returns the counters of the
+//     identities
+//   - /byzcoin/$chain/stream streams the new blocks
+type gateway struct {
+	s *Service
+	// streams holds a token for every open stream.
+	streams chan struct{}
+}
+
+// updateGateway starts the gateway on addr, if it is not running there yet,
+// or stops it if addr is empty.
+func (s *Service) updateGateway(addr string) error {
+	if addr == "" {
+		s.stopGateway()
+		return nil
+	}
+	s.gatewayLock.Lock()
+	running := s.gatewayServer != nil && s.gatewayAddr == addr
+	s.gatewayLock.Unlock()
+	if running {
+		return nil
+	}
+	return s.startGateway(addr)
+}
+
+// startGateway starts the gateway on addr, and then stops the running one.
+// If addr cannot be used, the running gateway is kept.
+func (s *Service) startGateway(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return xerrors.Errorf("listening on %s: %v", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(gatewayPrefix, &gateway{
+		s:       s,
+		streams: make(chan struct{}, gatewayMaxStreams),
+	})
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: gatewayReadHeaderTimeout,
+		IdleTimeout:       gatewayIdleTimeout,
+		MaxHeaderBytes:    gatewayMaxHeaderBytes,
+	}
+	s.stopGateway()
+	s.gatewayLock.Lock()
+	s.gatewayServer = srv
+	s.gatewayAddr = addr
+	s.gatewayLock.Unlock()
+	go func() {
+		err := srv.Serve(l)
+		if err != http.ErrServerClosed {
+			log.Error(s.ServerIdentity(), "gateway stopped:", err)
+		}
+	}()
+	log.Lvl1(s.ServerIdentity(), "serving the gateway on", l.Addr())
+	return nil
+}
+
+// stopGateway closes the gateway and its connections, if it is running.
+func (s *Service) stopGateway() {
+	s.gatewayLock.Lock()
+	defer s.gatewayLock.Unlock()
+	if s.gatewayServer == nil {
+		return
+	}
+	if err := s.gatewayServer.Close(); err != nil {
+		log.Error(s.ServerIdentity(), "couldn't close the gateway:", err)
+	}
+	s.gatewayServer = nil
+	s.gatewayAddr = ""
+}
+
+// gatewayError is an error with the HTTP status to return.
+type gatewayError struct {
+	status int
+	err    error
+}
+
+func (e gatewayError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return gatewayError{http.StatusBadRequest, xerrors.Errorf(format, args...)}
+}
+
+func notFound(format string, args ...interface{}) error {
+	return gatewayError{http.StatusNotFound, xerrors.Errorf(format, args...)}
+}
+
+// ServeHTTP implements http.Handler.
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		g.writeError(w, gatewayError{http.StatusMethodNotAllowed,
+			xerrors.New("the gateway is read-only")})
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var path []string
+	if p := strings.Trim(strings.TrimPrefix(r.URL.Path, gatewayPrefix), "/"); p != "" {
+		path = strings.Split(p, "/")
+	}
+	if len(path) == 0 {
+		g.write(w, r, g.chains)
+		return
+	}
+
+	id, err := g.chainID(path[0])
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+	switch {
+	case len(path) == 2 && path[1] == "config":
+		g.write(w, r, func(r *http.Request) (interface{}, error) {
+			return g.config(r, id)
+		})
+	case len(path) == 3 && path[1] == "blocks":
+		g.write(w, r, func(r *http.Request) (interface{}, error) {
+			return g.block(id, path[2])
+		})
+	case len(path) == 3 && path[1] == "instances":
+		g.write(w, r, func(r *http.Request) (interface{}, error) {
+			return g.instance(r, id, path[2])
+		})
+	case len(path) == 3 && path[1] == "proofs":
+		g.write(w, r, func(r *http.Request) (interface{}, error) {
+			return g.proof(r, id, path[2])
+		})
+	case len(path) == 2 && path[1] == "counters":
+		g.write(w, r, func(r *http.Request) (interface{}, error) {
+			return g.counters(r, id)
+		})
+	case len(path) == 2 && path[1] == "stream":
+		g.stream(w, r, id)
+	default:
+		g.writeError(w, notFound("unknown path %s", r.URL.Path))
+	}
+}
+
+// write writes the result of f as JSON.
+func (g *gateway) write(w http.ResponseWriter, r *http.Request,
+	f func(*http.Request) (interface{}, error)) {
+	res, err := f(r)
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Warn("writing the response:", err)
+	}
+}
+
+func (g *gateway) writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var ge gatewayError
+	if xerrors.As(err, &ge) {
+		status = ge.status
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(GatewayError{Error: err.Error()}); err != nil {
+		log.Warn("writing the error:", err)
+	}
+}
+
+// process sends the request to the handler of the service, in the same way
+// as the requests of the websockets, and decodes the response.
+func (g *gateway) process(r *http.Request, req, resp interface{}) error {
+	buf, err := protobuf.Encode(req)
+	if err != nil {
+		return xerrors.Errorf("encoding request: %v", err)
+	}
+	path := reflect.TypeOf(req).Elem().Name()
+	buf, _, err = g.s.ProcessClientRequest(r, path, buf)
+	if err != nil {
+		return xerrors.Errorf("processing %s: %v", path, err)
+	}
+	err = protobuf.DecodeWithConstructors(buf, resp,
+		network.DefaultConstructors(cothority.Suite))
+	return cothority.ErrorOrNil(err, "decoding response")
+}
+
+// latestIndex returns the index of the latest block applied to the state of
+// the chain.
+func (g *gateway) latestIndex(id skipchain.SkipBlockID) (int, error) {
+	st, err := g.s.getStateTrie(id)
+	if err != nil {
+		return 0, xerrors.Errorf("getting state trie: %v", err)
+	}
+	return st.GetIndex(), nil
+}
+
+// chainID parses the ID of a chain and checks that it is a ByzCoin chain of
+// this node.
+func (g *gateway) chainID(s string) (skipchain.SkipBlockID, error) {
+	id, err := hex.DecodeString(s)
+	if err != nil || len(id) != 32 {
+		return nil, badRequest("invalid chain ID %s", s)
+	}
+	if g.s.db().GetByID(id) == nil || !g.s.hasByzCoinVerification(id) {
+		return nil, notFound("unknown chain %s", s)
+	}
+	return id, nil
+}
+
+// getProof returns the proof of the instance with the given ID. If the
+// "block" parameter of the request is set, the proof is against the state
+// after the block with this index, which must be one of the last
+// gatewayProofHistory blocks.
+func (g *gateway) getProof(r *http.Request, id skipchain.SkipBlockID,
+	key []byte) (*Proof, error) {
+	req := &GetProof{Version: CurrentVersion, Key: key, ID: id}
+	if b := r.URL.Query().Get("block"); b != "" {
+		index, err := strconv.Atoi(b)
+		if err != nil || index < 0 {
+			return nil, badRequest("invalid block index %s", b)
+		}
+		latest, err := g.latestIndex(id)
+		if err != nil {
+			return nil, err
+		}
+		if latest-index > gatewayProofHistory {
+			return nil, badRequest("cannot prove more than %d blocks back",
+				gatewayProofHistory)
+		}
+		req.AtIndex = index
+	}
+	var resp GetProofResponse
+	if err := g.process(r, req, &resp); err != nil {
+		return nil, xerrors.Errorf("getting proof: %v", err)
+	}
+	return &resp.Proof, nil
+}
+
+func (g *gateway) chains(r *http.Request) (interface{}, error) {
+	var resp DebugResponse
+	if err := g.process(r, &DebugRequest{}, &resp); err != nil {
+		return nil, xerrors.Errorf("getting chains: %v", err)
+	}
+	chains := []GatewayChain{}
+	for _, bc := range resp.Byzcoins {
+		chains = append(chains, GatewayChain{
+			ID:          hex.EncodeToString(bc.ByzCoinID),
+			LatestIndex: bc.Latest.Index,
+			LatestID:    hex.EncodeToString(bc.Latest.Hash),
+		})
+	}
+	return chains, nil
+}
+
+func (g *gateway) config(r *http.Request, id skipchain.SkipBlockID) (*GatewayConfig, error) {
+	config, err := g.chainConfig(r, id)
+	if err != nil {
+		return nil, err
+	}
+	gc := &GatewayConfig{
+		BlockInterval:      config.BlockInterval.String(),
+		MaxBlockSize:       config.MaxBlockSize,
+		DarcContractIDs:    config.DarcContractIDs,
+		MeteringBudget:     config.MeteringBudget,
+		MeteringPrice:      config.MeteringPrice,
+		CheckpointInterval: config.CheckpointInterval,
+		Roster:             gatewayNodes(config.Roster.List),
+		ArchiveNodes:       gatewayNodes(config.ArchiveNodes),
+	}
+	if config.MeteringCoin != nil {
+		gc.MeteringCoin = config.MeteringCoin.String()
+	}
+	for _, cv := range config.ContractVersions {
+		gc.ContractVersions = append(gc.ContractVersions,
+			GatewayContractVersion{ContractID: cv.ContractID, Version: cv.Version})
+	}
+	return gc, nil
+}
+
+func (g *gateway) chainConfig(r *http.Request, id skipchain.SkipBlockID) (*ChainConfig, error) {
+	proof, err := g.getProof(r, id, NewInstanceID(nil).Slice())
+	if err != nil {
+		return nil, err
+	}
+	var config ChainConfig
+	if err := proof.VerifyAndDecode(cothority.Suite, ContractConfigID, &config); err != nil {
+		return nil, xerrors.Errorf("decoding config: %v", err)
+	}
+	return &config, nil
+}
+
+func gatewayNodes(sis []*network.ServerIdentity) []GatewayNode {
+	var nodes []GatewayNode
+	for _, si := range sis {
+		nodes = append(nodes, GatewayNode{
+			Address:     si.Address.String(),
+			URL:         si.URL,
+			Public:      si.Public.String(),
+			Description: si.Description,
+		})
+	}
+	return nodes
+}
+
+// block returns the block with the given index or ID.
+func (g *gateway) block(id skipchain.SkipBlockID, b string) (*GatewayBlock, error) {
+	var sb *skipchain.SkipBlock
+	if len(b) == 64 {
+		bid, err := hex.DecodeString(b)
+		if err != nil {
+			return nil, badRequest("invalid block ID %s", b)
+		}
+		sb = g.s.db().GetByID(bid)
+		if sb == nil || !sb.SkipChainID().Equal(id) {
+			return nil, notFound("unknown block %s", b)
+		}
+	} else if b == "latest" {
+		var err error
+		sb, err = g.s.db().GetLatestByID(id)
+		if err != nil {
+			return nil, xerrors.Errorf("getting latest block: %v", err)
+		}
+	} else {
+		index, err := strconv.Atoi(b)
+		if err != nil || index < 0 {
+			return nil, badRequest("invalid block %s", b)
+		}
+		reply, err := g.s.skService().GetSingleBlockByIndex(
+			&skipchain.GetSingleBlockByIndex{Genesis: id, Index: index})
+		if err != nil {
+			return nil, notFound("unknown block %s: %v", b, err)
+		}
+		sb = reply.SkipBlock
+	}
+	return newGatewayBlock(sb)
+}
+
+func newGatewayBlock(sb *skipchain.SkipBlock) (*GatewayBlock, error) {
+	var header DataHeader
+	if err := protobuf.Decode(sb.Data, &header); err != nil {
+		return nil, xerrors.Errorf("decoding header: %v", err)
+	}
+	gb := &GatewayBlock{
+		ID:        hex.EncodeToString(sb.Hash),
+		Index:     sb.Index,
+		Height:    sb.Height,
+		Roster:    gatewayNodes(sb.Roster.List),
+		TrieRoot:  hex.EncodeToString(header.TrieRoot),
+		Timestamp: header.Timestamp,
+		Version:   int(header.Version),
+		Pruned:    true,
+	}
+	for _, bl := range sb.BackLinkIDs {
+		gb.BackLinks = append(gb.BackLinks, hex.EncodeToString(bl))
+	}
+	for _, fl := range sb.ForwardLink {
+		gb.ForwardLinks = append(gb.ForwardLinks, hex.EncodeToString(fl.To))
+	}
+
+	body, err := decodeBlockBody(sb)
+	if xerrors.Is(err, errBlockPruned) {
+		return gb, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("decoding body: %v", err)
+	}
+	gb.Pruned = false
+	for _, txr := range body.TxResults {
+		gb.Transactions = append(gb.Transactions, newGatewayTransaction(txr))
+	}
+	return gb, nil
+}
+
+func newGatewayTransaction(txr TxResult) GatewayTransaction {
+	instrs := txr.ClientTransaction.Instructions
+	gt := GatewayTransaction{
+		ID:       hex.EncodeToString(instrs.Hash()),
+		Accepted: txr.Accepted,
+	}
+	for _, instr := range instrs {
+		gi := GatewayInstruction{
+			InstanceID:    instr.InstanceID.String(),
+			Action:        instr.Action(),
+			ContractID:    instr.ContractID(),
+			SignerCounter: instr.SignerCounter,
+		}
+		var args Arguments
+		switch instr.GetType() {
+		case SpawnType:
+			args = instr.Spawn.Args
+		case InvokeType:
+			gi.Command = instr.Invoke.Command
+			args = instr.Invoke.Args
+		}
+		for _, arg := range args {
+			gi.Args = append(gi.Args, GatewayArgument{Name: arg.Name, Value: arg.Value})
+		}
+		for _, id := range instr.SignerIdentities {
+			gi.Signers = append(gi.Signers, id.String())
+		}
+		gt.Instructions = append(gt.Instructions, gi)
+	}
+	for _, ev := range txr.Events {
+		ge := GatewayEvent{
+			Name:       ev.Name,
+			InstanceID: ev.InstanceID.String(),
+			ContractID: ev.ContractID,
+		}
+		for _, attr := range ev.Attributes {
+			ge.Attributes = append(ge.Attributes,
+				GatewayArgument{Name: attr.Key, Value: attr.Value})
+		}
+		gt.Events = append(gt.Events, ge)
+	}
+	return gt
+}
+
+func parseInstanceID(s string) (InstanceID, error) {
+	buf, err := hex.DecodeString(s)
+	if err != nil || len(buf) != 32 {
+		return InstanceID{}, badRequest("invalid instance ID %s", s)
+	}
+	return NewInstanceID(buf), nil
+}
+
+// instance returns the instance with the given ID. The darcs are decoded.
+func (g *gateway) instance(r *http.Request, id skipchain.SkipBlockID,
+	s string) (*GatewayInstance, error) {
+	iid, err := parseInstanceID(s)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := g.getProof(r, id, iid.Slice())
+	if err != nil {
+		return nil, err
+	}
+	if !proof.InclusionProof.Match(iid.Slice()) {
+		return nil, notFound("unknown instance %s", s)
+	}
+	body, err := decodeStateChangeBody(proof.InclusionProof.Get(iid.Slice()))
+	if err != nil {
+		return nil, xerrors.Errorf("decoding instance: %v", err)
+	}
+	gi := &GatewayInstance{
+		ID:         iid.String(),
+		ContractID: body.ContractID,
+		Version:    body.Version,
+		DarcID:     hex.EncodeToString(body.DarcID),
+		Data:       body.Value,
+		BlockIndex: proof.Latest.Index,
+	}
+
+	config, err := g.chainConfig(r, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, cid := range config.DarcContractIDs {
+		if cid != body.ContractID {
+			continue
+		}
+		d, err := darc.NewFromProtobuf(body.Value)
+		if err != nil {
+			return nil, xerrors.Errorf("decoding darc: %v", err)
+		}
+		gd := &GatewayDarc{
+			BaseID:      hex.EncodeToString(d.GetBaseID()),
+			Version:     d.Version,
+			Description: string(d.Description),
+		}
+		for _, rule := range d.Rules.List {
+			gd.Rules = append(gd.Rules, GatewayRule{
+				Action: string(rule.Action),
+				Expr:   string(rule.Expr),
+			})
+		}
+		gi.Darc = gd
+		break
+	}
+	return gi, nil
+}
+
+// proof returns the proof of the instance with the given ID, which can be
+// verified by the clients that have the genesis block.
+func (g *gateway) proof(r *http.Request, id skipchain.SkipBlockID,
+	s string) (*GatewayProof, error) {
+	iid, err := parseInstanceID(s)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := g.getProof(r, id, iid.Slice())
+	if err != nil {
+		return nil, err
+	}
+	buf, err := protobuf.Encode(proof)
+	if err != nil {
+		return nil, xerrors.Errorf("encoding proof: %v", err)
+	}
+	return &GatewayProof{
+		InstanceID: iid.String(),
+		Exists:     proof.InclusionProof.Match(iid.Slice()),
+		BlockIndex: proof.Latest.Index,
+		Proof:      buf,
+	}, nil
+}
+
+// counters returns the counters of the identities given as "identity"
+// parameters, with the lanes given as "lane" parameters.
+func (g *gateway) counters(r *http.Request, id skipchain.SkipBlockID) (*GatewayCounters, error) {
+	q := r.URL.Query()
+	req := &GetSignerCounters{SignerIDs: q["identity"], SkipchainID: id}
+	if len(req.SignerIDs) == 0 {
+		return nil, badRequest("missing identity")
+	}
+	for _, l := range q["lane"] {
+		lane, err := strconv.ParseUint(l, 10, 64)
+		if err != nil {
+			return nil, badRequest("invalid lane %s", l)
+		}
+		req.SignerLanes = append(req.SignerLanes, lane)
+	}
+	var resp GetSignerCountersResponse
+	if err := g.process(r, req, &resp); err != nil {
+		return nil, xerrors.Errorf("getting counters: %v", err)
+	}
+	return &GatewayCounters{Counters: resp.Counters, BlockIndex: resp.Index}, nil
+}
+
+// stream sends the blocks as server-sent events until the client closes the
+// connection. The blocks can be filtered with the "contract", "instance" and
+// "accepted" parameters, and the "start" parameter replays the blocks from
+// this index first, if it is one of the last gatewayReplayBlocks blocks. At
+// most gatewayMaxStreams streams are open at the same time.
+func (g *gateway) stream(w http.ResponseWriter, r *http.Request, id skipchain.SkipBlockID) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		g.writeError(w, xerrors.New("streaming is not supported"))
+		return
+	}
+	select {
+	case g.streams <- struct{}{}:
+		defer func() { <-g.streams }()
+	default:
+		g.writeError(w, gatewayError{http.StatusServiceUnavailable,
+			xerrors.New("too many streams")})
+		return
+	}
+	q := r.URL.Query()
+	req := &StreamingRequest{
+		ID:           id,
+		ContractIDs:  q["contract"],
+		AcceptedOnly: q.Get("accepted") == "true",
+	}
+	for _, s := range q["instance"] {
+		iid, err := parseInstanceID(s)
+		if err != nil {
+			g.writeError(w, err)
+			return
+		}
+		req.InstanceIDs = append(req.InstanceIDs, iid)
+	}
+	if s := q.Get("start"); s != "" {
+		start, err := strconv.Atoi(s)
+		if err != nil || start < 0 {
+			g.writeError(w, badRequest("invalid start %s", s))
+			return
+		}
+		latest, err := g.latestIndex(id)
+		if err != nil {
+			g.writeError(w, err)
+			return
+		}
+		if latest-start > gatewayReplayBlocks {
+			g.writeError(w, badRequest("cannot replay more than %d blocks",
+				gatewayReplayBlocks))
+			return
+		}
+		req.StartIndex = start
+	}
+
+	blocks, stop, err := g.s.StreamTransactions(req)
+	if err != nil {
+		g.writeError(w, xerrors.Errorf("streaming: %v", err))
+		return
+	}
+	defer close(stop)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case resp, ok := <-blocks:
+			if !ok {
+				return
+			}
+			gb, err := newGatewayBlock(resp.Block)
+			if err != nil {
+				log.Error("streaming block:", err)
+				return
+			}
+			buf, err := json.Marshal(gb)
+			if err != nil {
+				log.Error("encoding block:", err)
+				return
+			}
+			if _, err := w.Write([]byte("event: block\nid: " +
+				strconv.Itoa(gb.Index) + "\ndata: " + string(buf) + "\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// GatewayError is returned by the gateway if the request fails.
+type GatewayError struct {
+	Error string `json:"error"`
+}
+
+// GatewayChain is a chain of the node, returned by the gateway.
+type GatewayChain struct {
+	ID          string `json:"id"`
+	LatestIndex int    `json:"latest_index"`
+	LatestID    string `json:"latest_id"`
+}
+
+// GatewayNode is a node of a roster, returned by the gateway.
+type GatewayNode struct {
+	Address     string `json:"address"`
+	URL         string `json:"url,omitempty"`
+	Public      string `json:"public"`
+	Description string `json:"description,omitempty"`
+}
+
+// GatewayContractVersion is the active version of a contract, returned by
+// the gateway.
+type GatewayContractVersion struct {
+	ContractID string `json:"contract_id"`
+	Version    uint64 `json:"version"`
+}
+
+// GatewayConfig is the configuration of a chain, returned by the gateway.
+type GatewayConfig struct {
+	BlockInterval      string                   `json:"block_interval"`
+	MaxBlockSize       int                      `json:"max_block_size"`
+	Roster             []GatewayNode            `json:"roster"`
+	DarcContractIDs    []string                 `json:"darc_contract_ids"`
+	MeteringBudget     uint64                   `json:"metering_budget,omitempty"`
+	MeteringCoin       string                   `json:"metering_coin,omitempty"`
+	MeteringPrice      uint64                   `json:"metering_price,omitempty"`
+	ContractVersions   []GatewayContractVersion `json:"contract_versions,omitempty"`
+	CheckpointInterval int                      `json:"checkpoint_interval,omitempty"`
+	ArchiveNodes       []GatewayNode            `json:"archive_nodes,omitempty"`
+}
+
+// GatewayBlock is a block, returned by the gateway. The transactions of the
+// blocks pruned by the node are missing.
+type GatewayBlock struct {
+	ID           string               `json:"id"`
+	Index        int                  `json:"index"`
+	Height       int                  `json:"height"`
+	BackLinks    []string             `json:"back_links"`
+	ForwardLinks []string             `json:"forward_links,omitempty"`
+	Roster       []GatewayNode        `json:"roster"`
+	TrieRoot     string               `json:"trie_root"`
+	Timestamp    int64                `json:"timestamp"`
+	Version      int                  `json:"version"`
+	Pruned       bool                 `json:"pruned,omitempty"`
+	Transactions []GatewayTransaction `json:"transactions,omitempty"`
+}
+
+// GatewayTransaction is a transaction of a block, returned by the gateway.
+// Its ID is the hash of its instructions.
+type GatewayTransaction struct {
+	ID           string               `json:"id"`
+	Accepted     bool                 `json:"accepted"`
+	Instructions []GatewayInstruction `json:"instructions"`
+	Events       []GatewayEvent       `json:"events,omitempty"`
+}
+
+// GatewayInstruction is an instruction of a transaction, returned by the
+// gateway.
+type GatewayInstruction struct {
+	InstanceID    string            `json:"instance_id"`
+	Action        string            `json:"action"`
+	ContractID    string            `json:"contract_id"`
+	Command       string            `json:"command,omitempty"`
+	Args          []GatewayArgument `json:"args,omitempty"`
+	Signers       []string          `json:"signers,omitempty"`
+	SignerCounter []uint64          `json:"signer_counter,omitempty"`
+}
+
+// GatewayArgument is an argument of an instruction or an attribute of an
+// event, returned by the gateway.
+type GatewayArgument struct {
+	Name  string `json:"name"`
+	Value []byte `json:"value"`
+}
+
+// GatewayEvent is an event emitted by a transaction, returned by the
+// gateway.
+type GatewayEvent struct {
+	Name       string            `json:"name"`
+	InstanceID string            `json:"instance_id"`
+	ContractID string            `json:"contract_id"`
+	Attributes []GatewayArgument `json:"attributes,omitempty"`
+}
+
+// GatewayInstance is an instance of the global state, returned by the
+// gateway. The darcs are also decoded.
+type GatewayInstance struct {
+	ID         string       `json:"id"`
+	ContractID string       `json:"contract_id"`
+	Version    uint64       `json:"version"`
+	DarcID     string       `json:"darc_id"`
+	Data       []byte       `json:"data"`
+	Darc       *GatewayDarc `json:"darc,omitempty"`
+	BlockIndex int          `json:"block_index"`
+}
+
+// GatewayDarc is a decoded darc, returned by the gateway.
+type GatewayDarc struct {
+	BaseID      string        `json:"base_id"`
+	Version     uint64        `json:"version"`
+	Description string        `json:"description"`
+	Rules       []GatewayRule `json:"rules"`
+}
+
+// GatewayRule is a rule of a darc, returned by the gateway.
+type GatewayRule struct {
+	Action string `json:"action"`
+	Expr   string `json:"expr"`
+}
+
+// GatewayProof is the proof of an instance, returned by the gateway. The
+// proof is protobuf-encoded, so that the clients can verify it.
+type GatewayProof struct {
+	InstanceID string `json:"instance_id"`
+	Exists     bool   `json:"exists"`
+	BlockIndex int    `json:"block_index"`
+	Proof      []byte `json:"proof"`
+}
+
+// GatewayCounters are the counters of the signers, returned by the gateway.
+type GatewayCounters struct {
+	Counters   []uint64 `json:"counters"`
+	BlockIndex uint64   `json:"block_index"`
+}
@@ -0,0 +1,124 @@
+package byzcoin
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+)
+
+func TestGateway(t *testing.T) {
+	s := newSer(t, 2, testInterval)
+	defer s.local.CloseAll()
+
+	srv := httptest.NewServer(&gateway{
+		s:       s.service(),
+		streams: make(chan struct{}, 1),
+	})
+	defer srv.Close()
+	chain := srv.URL + gatewayPrefix + hex.EncodeToString(s.genesis.SkipChainID())
+
+	get := func(path string, status int, res interface{}) {
+		resp, err := http.Get(path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, status, resp.StatusCode)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(res))
+	}
+
+	var chains []GatewayChain
+	get(srv.URL+gatewayPrefix, http.StatusOK, &chains)
+	require.Equal(t, 1, len(chains))
+	require.Equal(t, hex.EncodeToString(s.genesis.SkipChainID()), chains[0].ID)
+	require.Equal(t, 1, chains[0].LatestIndex)
+
+	var config GatewayConfig
+	get(chain+"/config", http.StatusOK, &config)
+	require.Equal(t, s.interval.String(), config.BlockInterval)
+	require.Equal(t, len(s.roster.List), len(config.Roster))
+
+	var block GatewayBlock
+	get(chain+"/blocks/latest", http.StatusOK, &block)
+	require.Equal(t, 1, block.Index)
+	require.Equal(t, 1, len(block.Transactions))
+	require.True(t, block.Transactions[0].Accepted)
+	require.Equal(t, dummyContract, block.Transactions[0].Instructions[0].ContractID)
+	get(chain+"/blocks/"+block.BackLinks[0], http.StatusOK, &block)
+	require.Equal(t, 0, block.Index)
+	var gErr GatewayError
+	get(chain+"/blocks/2", http.StatusNotFound, &gErr)
+
+	var instance GatewayInstance
+	get(chain+"/instances/"+hex.EncodeToString(s.darc.GetBaseID()), http.StatusOK, &instance)
+	require.Equal(t, ContractDarcID, instance.ContractID)
+	require.NotNil(t, instance.Darc)
+	require.Equal(t, hex.EncodeToString(s.darc.GetBaseID()), instance.Darc.BaseID)
+	iid := NewInstanceID(s.tx.Instructions[0].Hash())
+	instance = GatewayInstance{}
+	get(chain+"/instances/"+iid.String(), http.StatusOK, &instance)
+	require.Equal(t, dummyContract, instance.ContractID)
+	require.Equal(t, s.value, instance.Data)
+	require.Nil(t, instance.Darc)
+	get(chain+"/instances/"+iid.String()+"?block=0", http.StatusNotFound, &gErr)
+	get(chain+"/instances/1234", http.StatusBadRequest, &gErr)
+
+	var proof GatewayProof
+	get(chain+"/proofs/"+iid.String(), http.StatusOK, &proof)
+	require.True(t, proof.Exists)
+	var p Proof
+	require.NoError(t, protobuf.DecodeWithConstructors(proof.Proof, &p,
+		network.DefaultConstructors(cothority.Suite)))
+	require.NoError(t, p.Verify(s.genesis.SkipChainID()))
+
+	var counters GatewayCounters
+	get(chain+"/counters?identity="+s.signer.Identity().String(), http.StatusOK, &counters)
+	require.Equal(t, []uint64{1}, counters.Counters)
+
+	get(srv.URL+gatewayPrefix+"1234/config", http.StatusBadRequest, &gErr)
+	get(srv.URL+gatewayPrefix+strings.Repeat("00", 32)+"/config", http.StatusNotFound, &gErr)
+	resp, err := http.Post(chain+"/config", "application/json", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	resp.Body.Close()
+
+	// The missed blocks are replayed before the new ones.
+	resp, err = http.Get(chain + "/stream?start=1")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "data: ") {
+			require.NoError(t, json.Unmarshal([]byte(line[6:]), &block))
+			break
+		}
+	}
+	require.NoError(t, scanner.Err())
+	require.Equal(t, 1, block.Index)
+	// Only one stream can be open with this gateway.
+	get(chain+"/stream", http.StatusServiceUnavailable, &gErr)
+
+	// The gateway of the service follows the address of the node config.
+	service := s.service()
+	require.NoError(t, service.setNodeConfig(NodeConfig{GatewayAddress: "127.0.0.1:0"}))
+	require.NotNil(t, service.gatewayServer)
+	// An address that cannot be used is refused and not stored.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	require.Error(t, service.setNodeConfig(NodeConfig{GatewayAddress: l.Addr().String()}))
+	require.Equal(t, "127.0.0.1:0", service.nodeConfig().GatewayAddress)
+	require.NotNil(t, service.gatewayServer)
+	require.NoError(t, service.setNodeConfig(NodeConfig{}))
+	require.Nil(t, service.gatewayServer)
+}
@@ -0,0 +1,272 @@
+package byzcoin
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/protobuf"
+	bbolt "go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+var (
+	bucketInstanceIndex = []byte("instanceindex")
+	bucketByContract    = []byte("contract")
+	bucketByDarc        = []byte("darc")
+	bucketByInstance    = []byte("instance")
+	keyIndexBlock       = []byte("index")
+)
+
+const (
+	// defaultListLimit is the number of instances returned by ListInstances
+	// if the request doesn't give a limit.
+	defaultListLimit = 100
+	// maxListLimit is the maximum number of instances returned by
+	// ListInstances.
+	maxListLimit = 1000
+)
+
+// instanceIndexEntry is stored for each instance so that the old entries can
+// be removed when an instance changes its darc or is deleted.
+type instanceIndexEntry struct {
+	ContractID string
+	DarcID     darc.ID
+}
+
+// instanceIndexStorage keeps a persistent index of the instances of the
+// global state by contract ID and by darc ID. Each skipchain has its own
+// sub-bucket, which also stores the index of the last block applied to it.
+type instanceIndexStorage struct {
+	db     *bbolt.DB
+	bucket []byte
+}
+
+func newInstanceIndexStorage(c *onet.Context) *instanceIndexStorage {
+	db, name := c.GetAdditionalBucket(bucketInstanceIndex)
+	return &instanceIndexStorage{
+		db:     db,
+		bucket: name,
+	}
+}
+
+// getIndex returns the index of the last block applied to the index of the
+// skipchain, or -1 if there is no index for this skipchain.
+func (s *instanceIndexStorage) getIndex(sid skipchain.SkipBlockID) (idx int, err error) {
+	idx = -1
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket).Bucket(sid)
+		if b == nil {
+			return nil
+		}
+		buf := b.Get(keyIndexBlock)
+		if buf == nil {
+			return nil
+		}
+		idx = int(binary.BigEndian.Uint64(buf))
+		return nil
+	})
+
+	err = cothority.ErrorOrNil(err, "tx error")
+	return
+}
+
+// update applies the state changes of the block at the given index. If the
+// index is not up to date with the previous block, it is rebuilt from the
+// state trie, which must already hold the state changes.
+func (s *instanceIndexStorage) update(sid skipchain.SkipBlockID, scs StateChanges, index int, st ReadOnlyStateTrie) error {
+	last, err := s.getIndex(sid)
+	if err != nil {
+		return xerrors.Errorf("reading index: %v", err)
+	}
+	if last+1 != index {
+		return s.rebuild(sid, st)
+	}
+
+	err = s.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.Bucket(s.bucket).CreateBucketIfNotExists(sid)
+		if err != nil {
+			return xerrors.Errorf("creating bucket: %v", err)
+		}
+
+		for _, sc := range scs {
+			var entry *instanceIndexEntry
+			if sc.StateAction != Remove {
+				entry = &instanceIndexEntry{
+					ContractID: sc.ContractID,
+					DarcID:     sc.DarcID,
+				}
+			}
+			if err := setInstanceEntry(b, NewInstanceID(sc.InstanceID), entry); err != nil {
+				return xerrors.Errorf("updating instance: %v", err)
+			}
+		}
+
+		return putIndexBlock(b, index)
+	})
+	return cothority.ErrorOrNil(err, "tx error")
+}
+
+// rebuild replaces the index of the skipchain with the instances found in the
+// given state trie.
+func (s *instanceIndexStorage) rebuild(sid skipchain.SkipBlockID, st ReadOnlyStateTrie) error {
+	// The trie can be stored in the same db, so it must be read before
+	// the write transaction is opened.
+	entries := make(map[InstanceID]*instanceIndexEntry)
+	err := st.ForEach(func(k, v []byte) error {
+		body, err := decodeStateChangeBody(v)
+		if err != nil {
+			return xerrors.Errorf("decoding value: %v", err)
+		}
+		entries[NewInstanceID(k)] = &instanceIndexEntry{
+			ContractID: body.ContractID,
+			DarcID:     body.DarcID,
+		}
+		return nil
+	})
+	if err != nil {
+		return xerrors.Errorf("reading trie: %v", err)
+	}
+
+	err = s.db.Update(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(s.bucket)
+		if root.Bucket(sid) != nil {
+			if err := root.DeleteBucket(sid); err != nil {
+				return xerrors.Errorf("deleting bucket: %v", err)
+			}
+		}
+		b, err := root.CreateBucket(sid)
+		if err != nil {
+			return xerrors.Errorf("creating bucket: %v", err)
+		}
+
+		for iid, entry := range entries {
+			if err := setInstanceEntry(b, iid, entry); err != nil {
+				return xerrors.Errorf("adding instance: %v", err)
+			}
+		}
+
+		return putIndexBlock(b, st.GetIndex())
+	})
+	return cothority.ErrorOrNil(err, "tx error")
+}
+
+// list returns up to limit instances of the contract, or guarded by the darc,
+// in the order of their IDs and starting at start. It also returns the ID
+// where the next page starts, or nil if there are no more instances, and the
+// index of the last block applied to the index.
+func (s *instanceIndexStorage) list(sid skipchain.SkipBlockID, contractID string, darcID darc.ID,
+	start []byte, limit int) (iids []InstanceID, next []byte, index int, err error) {
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket).Bucket(sid)
+		if b == nil {
+			return xerrors.New("no index for this skipchain")
+		}
+		if buf := b.Get(keyIndexBlock); buf != nil {
+			index = int(binary.BigEndian.Uint64(buf))
+		}
+
+		parent, key := b.Bucket(bucketByDarc), []byte(darcID)
+		if contractID != "" {
+			parent, key = b.Bucket(bucketByContract), []byte(contractID)
+		}
+		if parent == nil || parent.Bucket(key) == nil {
+			return nil
+		}
+		sub := parent.Bucket(key)
+
+		c := sub.Cursor()
+		for k, _ := c.Seek(start); k != nil; k, _ = c.Next() {
+			if len(iids) == limit {
+				next = append([]byte{}, k...)
+				break
+			}
+			iids = append(iids, NewInstanceID(k))
+		}
+		return nil
+	})
+
+	err = cothority.ErrorOrNil(err, "tx error")
+	return
+}
+
+// setInstanceEntry removes the old entries of the instance and adds the new
+// ones. If entry is nil, the instance is only removed.
+func setInstanceEntry(b *bbolt.Bucket, iid InstanceID, entry *instanceIndexEntry) error {
+	instances, err := b.CreateBucketIfNotExists(bucketByInstance)
+	if err != nil {
+		return xerrors.Errorf("creating bucket: %v", err)
+	}
+
+	if buf := instances.Get(iid[:]); buf != nil {
+		var old instanceIndexEntry
+		if err := protobuf.Decode(buf, &old); err != nil {
+			return xerrors.Errorf("decoding: %v", err)
+		}
+		if entry != nil && old.ContractID == entry.ContractID &&
+			bytes.Equal(old.DarcID, entry.DarcID) {
+			return nil
+		}
+		if err := deleteFromSubBucket(b, bucketByContract, []byte(old.ContractID), iid); err != nil {
+			return err
+		}
+		if err := deleteFromSubBucket(b, bucketByDarc, old.DarcID, iid); err != nil {
+			return err
+		}
+	}
+
+	if entry == nil {
+		return instances.Delete(iid[:])
+	}
+
+	buf, err := protobuf.Encode(entry)
+	if err != nil {
+		return xerrors.Errorf("encoding: %v", err)
+	}
+	if err := instances.Put(iid[:], buf); err != nil {
+		return xerrors.Errorf("writing item: %v", err)
+	}
+	if err := putInSubBucket(b, bucketByContract, []byte(entry.ContractID), iid); err != nil {
+		return err
+	}
+	return putInSubBucket(b, bucketByDarc, entry.DarcID, iid)
+}
+
+func putInSubBucket(b *bbolt.Bucket, name, key []byte, iid InstanceID) error {
+	if len(key) == 0 {
+		return nil
+	}
+	parent, err := b.CreateBucketIfNotExists(name)
+	if err != nil {
+		return xerrors.Errorf("creating bucket: %v", err)
+	}
+	sub, err := parent.CreateBucketIfNotExists(key)
+	if err != nil {
+		return xerrors.Errorf("creating bucket: %v", err)
+	}
+	return cothority.ErrorOrNil(sub.Put(iid[:], []byte{}), "writing item")
+}
+
+func deleteFromSubBucket(b *bbolt.Bucket, name, key []byte, iid InstanceID) error {
+	if len(key) == 0 {
+		return nil
+	}
+	parent := b.Bucket(name)
+	if parent == nil {
+		return nil
+	}
+	sub := parent.Bucket(key)
+	if sub == nil {
+		return nil
+	}
+	return cothority.ErrorOrNil(sub.Delete(iid[:]), "deleting item")
+}
+
+func putIndexBlock(b *bbolt.Bucket, index int) error {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(index))
+	return cothority.ErrorOrNil(b.Put(keyIndexBlock, buf), "writing index")
+}
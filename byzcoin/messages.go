@@ -14,6 +14,7 @@ func init() {
 		&GetAllByzCoinIDsRequest{}, &GetAllByzCoinIDsResponse{},
 		&CreateGenesisBlock{}, &CreateGenesisBlockResponse{},
 		&AddTxRequest{}, &AddTxResponse{},
+		&SimulateTxRequest{}, &SimulateTxResponse{},
 		&GetSignerCounters{}, &GetSignerCountersResponse{},
 	)
 }
@@ -24,4 +25,8 @@ func init() {
 type Version int
 
 // CurrentVersion is what we're running now
-const CurrentVersion Version = 3
+const CurrentVersion Version = 4
+
+// VersionSignerLanes is the first version where the instructions can use the
+// lanes of the counters of their signers.
+const VersionSignerLanes Version = 4
@@ -0,0 +1,232 @@
+package byzcoin
+
+import (
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// The cost of the accesses of a contract to the global state. They are the
+// same on all nodes, so that an instruction over its budget is refused by
+// every node.
+const (
+	// meteringReadCost is charged for every read of an instance.
+	meteringReadCost = 10
+	// meteringWriteCost is charged for every state change.
+	meteringWriteCost = 100
+	// meteringByteCost is charged for every byte read or written.
+	meteringByteCost = 1
+)
+
+// meteringCoinContract is the ID of the coin contract, which holds the coins
+// of a fee payer. It is the same as contracts.ContractCoinID.
+const meteringCoinContract = "coin"
+
+var errOverBudget = xerrors.New("instruction is over its budget")
+
+// meter counts the cost of one instruction.
+type meter struct {
+	budget uint64
+	used   uint64
+	// price is the number of coins paid for each unit of cost, using the
+	// coins given to the instruction. If it is 0, nothing is paid.
+	price uint64
+	coin  InstanceID
+}
+
+// newMeter returns the meter for an instruction as defined in the
+// configuration, or nil if the instructions are not metered.
+func newMeter(config *ChainConfig) *meter {
+	if config == nil || config.MeteringBudget == 0 {
+		return nil
+	}
+
+	m := &meter{budget: config.MeteringBudget}
+	if config.MeteringCoin != nil {
+		m.price = config.MeteringPrice
+		m.coin = *config.MeteringCoin
+	}
+	return m
+}
+
+// charge adds the cost and returns an error if the budget is exceeded.
+func (m *meter) charge(cost uint64) error {
+	m.used += cost
+	if m.used > m.budget {
+		return xerrors.Errorf("used %d out of %d: %w", m.used, m.budget,
+			errOverBudget)
+	}
+	return nil
+}
+
+// chargeRead adds the cost of reading a value of the given size.
+func (m *meter) chargeRead(size int) error {
+	return m.charge(meteringReadCost + meteringByteCost*uint64(size))
+}
+
+// chargeStateChanges adds the cost of writing the state changes.
+func (m *meter) chargeStateChanges(scs StateChanges) error {
+	for _, sc := range scs {
+		err := m.charge(meteringWriteCost + meteringByteCost*uint64(len(sc.Value)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exceeded returns true if the budget has been exceeded, even if the contract
+// ignored the error returned by the state trie.
+func (m *meter) exceeded() bool {
+	return m.used > m.budget
+}
+
+// fee returns the number of coins to pay for the cost of the instruction.
+func (m *meter) fee() (uint64, error) {
+	fee := m.used * m.price
+	if m.used != 0 && fee/m.used != m.price {
+		return 0, xerrors.New("fee overflows")
+	}
+	return fee, nil
+}
+
+// pay removes the cost of the instruction from the coins it returned. An
+// error is returned if there are not enough coins of the configured type.
+func (m *meter) pay(coins []Coin) ([]Coin, error) {
+	if m.price == 0 {
+		return coins, nil
+	}
+
+	fee, err := m.fee()
+	if err != nil {
+		return nil, err
+	}
+	out := append([]Coin{}, coins...)
+	for i := range out {
+		if fee == 0 {
+			break
+		}
+		if !out[i].Name.Equal(m.coin) {
+			continue
+		}
+		if out[i].Value >= fee {
+			out[i].Value -= fee
+			fee = 0
+		} else {
+			fee -= out[i].Value
+			out[i].Value = 0
+		}
+	}
+	if fee > 0 {
+		return nil, xerrors.Errorf("missing %d coins of %x to pay for a cost of %d",
+			fee, m.coin[:], m.used)
+	}
+	return out, nil
+}
+
+// payFrom returns the state change removing the cost of the instruction from
+// the coin instance payer, after checking that the signers of the
+// instruction are allowed to fetch coins from it. The signatures are
+// verified against msg, the digest of the transaction.
+func (m *meter) payFrom(st ReadOnlyStateTrie, payer InstanceID,
+	instr Instruction, msg []byte) (StateChanges, error) {
+	if m.price == 0 {
+		return nil, nil
+	}
+
+	fetch := Instruction{
+		InstanceID: payer,
+		Invoke: &Invoke{
+			ContractID: meteringCoinContract,
+			Command:    "fetch",
+		},
+		SignerIdentities: instr.SignerIdentities,
+		Signatures:       instr.Signatures,
+		version:          instr.version,
+	}
+	err := fetch.VerifyWithOption(st, msg, &VerificationOptions{IgnoreCounters: true})
+	if err != nil {
+		return nil, xerrors.Errorf("fee payer refused the signers: %v", err)
+	}
+
+	fee, err := m.fee()
+	if err != nil {
+		return nil, err
+	}
+	value, version, contractID, darcID, err := st.GetValues(payer.Slice())
+	if err != nil {
+		return nil, xerrors.Errorf("reading fee payer: %v", err)
+	}
+	if contractID != meteringCoinContract {
+		return nil, xerrors.Errorf("fee payer is a %s instance", contractID)
+	}
+	var c Coin
+	if err := protobuf.Decode(value, &c); err != nil {
+		return nil, xerrors.Errorf("decoding fee payer: %v", err)
+	}
+	if !c.Name.Equal(m.coin) {
+		return nil, xerrors.Errorf("fee payer holds coins of %x instead of %x",
+			c.Name[:], m.coin[:])
+	}
+	if c.Value < fee {
+		return nil, xerrors.Errorf("missing %d coins of %x to pay for a cost of %d",
+			fee-c.Value, m.coin[:], m.used)
+	}
+	c.Value -= fee
+	buf, err := protobuf.Encode(&c)
+	if err != nil {
+		return nil, xerrors.Errorf("encoding fee payer: %v", err)
+	}
+
+	sc := NewStateChange(Update, payer, contractID, buf, darcID)
+	sc.Version = version + 1
+	return StateChanges{sc}, nil
+}
+
+// meteredStateTrie charges every read of a contract to a meter. Once the
+// budget is exceeded, all the reads return an error.
+type meteredStateTrie struct {
+	ReadOnlyStateTrie
+	meter *meter
+}
+
+// GetValues charges the read of the instance and the size of its value.
+func (t *meteredStateTrie) GetValues(key []byte) (value []byte, version uint64, contractID string, darcID darc.ID, err error) {
+	value, version, contractID, darcID, err = t.ReadOnlyStateTrie.GetValues(key)
+	if errCharge := t.meter.chargeRead(len(value)); errCharge != nil {
+		return nil, 0, "", nil, errCharge
+	}
+	return
+}
+
+// GetProof charges the read of the instance.
+func (t *meteredStateTrie) GetProof(key []byte) (*trie.Proof, error) {
+	if err := t.meter.chargeRead(0); err != nil {
+		return nil, err
+	}
+	return t.ReadOnlyStateTrie.GetProof(key)
+}
+
+// ForEach charges the read of every key/value pair.
+func (t *meteredStateTrie) ForEach(f func(k, v []byte) error) error {
+	return t.ReadOnlyStateTrie.ForEach(func(k, v []byte) error {
+		if err := t.meter.chargeRead(len(k) + len(v)); err != nil {
+			return err
+		}
+		return f(k, v)
+	})
+}
+
+// StoreAllToReplica charges the state changes and returns a replica that is
+// metered with the same meter.
+func (t *meteredStateTrie) StoreAllToReplica(scs StateChanges) (ReadOnlyStateTrie, error) {
+	if err := t.meter.chargeStateChanges(scs); err != nil {
+		return nil, err
+	}
+	replica, err := t.ReadOnlyStateTrie.StoreAllToReplica(scs)
+	if err != nil {
+		return nil, err
+	}
+	return &meteredStateTrie{replica, t.meter}, nil
+}
@@ -0,0 +1,136 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+func TestMeter_Charge(t *testing.T) {
+	require.Nil(t, newMeter(&ChainConfig{}))
+
+	m := newMeter(&ChainConfig{MeteringBudget: 200})
+	require.NoError(t, m.chargeRead(50))
+	require.NoError(t, m.chargeStateChanges(StateChanges{{Value: make([]byte, 40)}}))
+	require.Equal(t, uint64(200), m.used)
+	require.False(t, m.exceeded())
+
+	err := m.chargeRead(0)
+	require.Error(t, err)
+	require.True(t, xerrors.Is(err, errOverBudget))
+	require.True(t, m.exceeded())
+}
+
+func TestMeter_Pay(t *testing.T) {
+	coin := NewInstanceID([]byte("coin"))
+	other := NewInstanceID([]byte("other"))
+
+	m := newMeter(&ChainConfig{MeteringBudget: 100, MeteringCoin: &coin, MeteringPrice: 2})
+	require.NoError(t, m.charge(10))
+
+	coins := []Coin{{Name: coin, Value: 15}, {Name: other, Value: 100}, {Name: coin, Value: 10}}
+	out, err := m.pay(coins)
+	require.NoError(t, err)
+	require.Equal(t, []Coin{{Name: coin, Value: 0}, {Name: other, Value: 100}, {Name: coin, Value: 5}}, out)
+	// The coins given to pay must not be modified.
+	require.Equal(t, uint64(15), coins[0].Value)
+
+	_, err = m.pay([]Coin{{Name: other, Value: 100}})
+	require.Error(t, err)
+
+	// Without a coin, nothing is paid.
+	m = newMeter(&ChainConfig{MeteringBudget: 100})
+	require.NoError(t, m.charge(10))
+	out, err = m.pay(nil)
+	require.NoError(t, err)
+	require.Empty(t, out)
+}
+
+func TestMeter_PayFrom(t *testing.T) {
+	coin := NewInstanceID([]byte("coin"))
+	payer := NewInstanceID([]byte("payer"))
+	signer := darc.NewSignerEd25519(nil, nil)
+	ids := []darc.Identity{signer.Identity()}
+	d := darc.NewDarc(darc.InitRules(ids, ids), []byte("payer darc"))
+	require.NoError(t, d.Rules.AddRule("invoke:coin.fetch", d.Rules.GetSignExpr()))
+
+	sst, err := newMemStagingStateTrie([]byte("my nonce"))
+	require.NoError(t, err)
+	configBuf, err := protobuf.Encode(&ChainConfig{DarcContractIDs: []string{ContractDarcID}})
+	require.NoError(t, err)
+	darcBuf, err := d.ToProto()
+	require.NoError(t, err)
+	coinBuf, err := protobuf.Encode(&Coin{Name: coin, Value: 30})
+	require.NoError(t, err)
+	require.NoError(t, sst.StoreAll(StateChanges{
+		NewStateChange(Create, ConfigInstanceID, ContractConfigID, configBuf, nil),
+		NewStateChange(Create, NewInstanceID(d.GetBaseID()), ContractDarcID, darcBuf, d.GetBaseID()),
+		NewStateChange(Create, payer, meteringCoinContract, coinBuf, d.GetBaseID()),
+	}))
+
+	msg := []byte("digest")
+	instr := Instruction{
+		SignerIdentities: ids,
+		SignerCounter:    []uint64{1},
+	}
+	require.NoError(t, instr.SignWith(msg, signer))
+
+	m := newMeter(&ChainConfig{MeteringBudget: 100, MeteringCoin: &coin, MeteringPrice: 2})
+	require.NoError(t, m.charge(10))
+	scs, err := m.payFrom(sst, payer, instr, msg)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(scs))
+	require.Equal(t, Update, scs[0].StateAction)
+	require.Equal(t, uint64(1), scs[0].Version)
+	var c Coin
+	require.NoError(t, protobuf.Decode(scs[0].Value, &c))
+	require.Equal(t, uint64(10), c.Value)
+
+	// The signature must be on the digest of the transaction.
+	_, err = m.payFrom(sst, payer, instr, []byte("other digest"))
+	require.Error(t, err)
+
+	// The payer must hold enough coins.
+	require.NoError(t, m.charge(10))
+	_, err = m.payFrom(sst, payer, instr, msg)
+	require.Error(t, err)
+
+	// The payer must hold the metering coin.
+	other := NewInstanceID([]byte("other"))
+	m = newMeter(&ChainConfig{MeteringBudget: 100, MeteringCoin: &other, MeteringPrice: 2})
+	require.NoError(t, m.charge(10))
+	_, err = m.payFrom(sst, payer, instr, msg)
+	require.Error(t, err)
+}
+
+func TestMeteredStateTrie(t *testing.T) {
+	sst, err := newMemStagingStateTrie([]byte("my nonce"))
+	require.NoError(t, err)
+	key := []byte("key")
+	require.NoError(t, sst.StoreAll(StateChanges{{
+		StateAction: Create,
+		InstanceID:  key,
+		ContractID:  "contract",
+		Value:       make([]byte, 30),
+	}}))
+
+	m := newMeter(&ChainConfig{MeteringBudget: 100})
+	mst := &meteredStateTrie{sst, m}
+	val, _, cid, _, err := mst.GetValues(key)
+	require.NoError(t, err)
+	require.Equal(t, 30, len(val))
+	require.Equal(t, "contract", cid)
+	require.Equal(t, uint64(meteringReadCost+30), m.used)
+
+	// A missing key is charged too, and the error is kept.
+	_, _, _, _, err = mst.GetValues([]byte("missing"))
+	require.True(t, xerrors.Is(err, ErrKeyNotSet))
+
+	_, err = mst.StoreAllToReplica(StateChanges{{StateAction: Update, InstanceID: key}})
+	require.True(t, xerrors.Is(err, errOverBudget))
+	_, _, _, _, err = mst.GetValues(key)
+	require.True(t, xerrors.Is(err, errOverBudget))
+}
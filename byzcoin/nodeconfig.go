@@ -0,0 +1,98 @@
+package byzcoin
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+func init() {
+	network.RegisterMessages(&SetNodeConfigRequest{}, &SetNodeConfigResponse{})
+}
+
+// Hash returns the digest of the request that is signed by the node: the
+// sequence number followed by the encoding of the settings.
+func (req SetNodeConfigRequest) Hash() ([]byte, error) {
+	buf, err := protobuf.Encode(&req.Config)
+	if err != nil {
+		return nil, xerrors.Errorf("encoding config: %v", err)
+	}
+	h := sha256.New()
+	seq := make([]byte, 8)
+	binary.LittleEndian.PutUint64(seq, req.Sequence)
+	h.Write(seq)
+	h.Write(buf)
+	return h.Sum(nil), nil
+}
+
+// SetNodeConfig stores the settings of the node, replacing the previous ones,
+// and applies them. The sequence number of the request must be above the one
+// of the last request, which is stored even if the settings cannot be applied.
+func (s *Service) SetNodeConfig(req *SetNodeConfigRequest) (*SetNodeConfigResponse, error) {
+	msg, err := req.Hash()
+	if err != nil {
+		return nil, err
+	}
+	err = schnorr.Verify(cothority.Suite, s.ServerIdentity().Public, msg, req.Signature)
+	if err != nil {
+		return nil, xerrors.Errorf("verifying signature: %v", err)
+	}
+
+	if req.Config.PruneBlocks < 0 {
+		return nil, xerrors.New("number of blocks to keep must be positive")
+	}
+
+	s.storage.Lock()
+	if req.Sequence <= s.storage.NodeSequence {
+		s.storage.Unlock()
+		return nil, xerrors.New("sequence number must be above the last one")
+	}
+	s.storage.NodeSequence = req.Sequence
+	s.storage.Unlock()
+	s.save()
+
+	err = s.setNodeConfig(req.Config)
+	if err != nil {
+		return nil, xerrors.Errorf("applying config: %v", err)
+	}
+	log.Lvlf2("%s stored the node config %+v", s.ServerIdentity(), req.Config)
+	return &SetNodeConfigResponse{}, nil
+}
+
+// setNodeConfig stores and applies the settings of the node. The gateway is
+// moved to its new address first, so that an address that cannot be used is
+// refused before it is stored.
+func (s *Service) setNodeConfig(config NodeConfig) error {
+	if err := s.updateGateway(config.GatewayAddress); err != nil {
+		return xerrors.Errorf("starting gateway: %v", err)
+	}
+	s.storage.Lock()
+	s.storage.Node = config
+	s.storage.Unlock()
+	s.save()
+	s.applyNodeConfig()
+	return nil
+}
+
+// applyNodeConfig applies the stored settings of the node to the parts of
+// the service that don't read them when they are used. The failure to start
+// the gateway is only logged, so that the chains still run.
+func (s *Service) applyNodeConfig() {
+	s.stateChangeStorage.setMaxNbrBlock(s.pruneDepth())
+	if err := s.updateGateway(s.nodeConfig().GatewayAddress); err != nil {
+		log.Error(s.ServerIdentity(), "couldn't start the gateway:", err)
+	}
+}
+
+// nodeConfig returns the current settings of the node.
+func (s *Service) nodeConfig() NodeConfig {
+	s.storage.Lock()
+	defer s.storage.Unlock()
+	return s.storage.Node
+}
@@ -0,0 +1,172 @@
+package byzcoin
+
+import (
+	"runtime"
+	"sync"
+
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"golang.org/x/xerrors"
+)
+
+// parallelWorkers is the number of transactions that are executed at the same
+// time when the state changes of a block are created. If it is 1, the
+// transactions are executed one after the other.
+var parallelWorkers = runtime.NumCPU()
+
+// accessRecorder records the keys of the global state read by a transaction,
+// so that it is possible to know if the transaction would give another result
+// when executed after other transactions.
+type accessRecorder struct {
+	keys map[string]bool
+	// all is set if the transaction depends on the whole state, e.g. when
+	// it iterates over it or gets a proof, which holds the root.
+	all bool
+}
+
+func newAccessRecorder() *accessRecorder {
+	return &accessRecorder{keys: make(map[string]bool)}
+}
+
+func (r *accessRecorder) read(key []byte) {
+	if r != nil {
+		r.keys[string(key)] = true
+	}
+}
+
+func (r *accessRecorder) readAll() {
+	if r != nil {
+		r.all = true
+	}
+}
+
+// conflicts returns true if one of the keys read has been written.
+func (r *accessRecorder) conflicts(written map[string]bool) bool {
+	if len(written) == 0 {
+		return false
+	}
+	if r.all {
+		return true
+	}
+	for k := range r.keys {
+		if written[k] {
+			return true
+		}
+	}
+	return false
+}
+
+// Get records the key if the reads of the trie are recorded.
+func (t *stagingStateTrie) Get(key []byte) ([]byte, error) {
+	t.reads.read(key)
+	return t.StagingTrie.Get(key)
+}
+
+// GetProof records the read of the whole trie if the reads are recorded.
+func (t *stagingStateTrie) GetProof(key []byte) (*trie.Proof, error) {
+	t.reads.readAll()
+	return t.StagingTrie.GetProof(key)
+}
+
+// ForEach records the read of the whole trie if the reads are recorded.
+func (t *stagingStateTrie) ForEach(f func(k, v []byte) error) error {
+	t.reads.readAll()
+	return t.StagingTrie.ForEach(f)
+}
+
+// speculativeTx is the result of the execution of a transaction against the
+// state at the beginning of the block.
+type speculativeTx struct {
+	states StateChanges
+	events []Event
+	reads  *accessRecorder
+	err    error
+}
+
+// declaredConflicts returns, for each transaction, whether the instances it
+// declares have been declared by a previous transaction of the block. As the
+// declarations are only hints, these transactions are not executed
+// speculatively, because their result would probably be thrown away.
+func declaredConflicts(txs TxResults) []bool {
+	out := make([]bool, len(txs))
+	seen := make(map[InstanceID]bool)
+	for i, tx := range txs {
+		for _, iid := range tx.ClientTransaction.AccessedInstances {
+			if seen[iid] {
+				out[i] = true
+			}
+		}
+		for _, iid := range tx.ClientTransaction.AccessedInstances {
+			seen[iid] = true
+		}
+	}
+	return out
+}
+
+// runSpeculative executes all the transactions in parallel against the given
+// state, recording the keys they read. It returns nil if the transactions are
+// to be executed one after the other. The given state must not be modified
+// while the transactions are executed.
+func (s *Service) runSpeculative(sst *stagingStateTrie, txs TxResults,
+	scID skipchain.SkipBlockID) []*speculativeTx {
+	if parallelWorkers <= 1 || len(txs) <= 1 {
+		return nil
+	}
+
+	skip := declaredConflicts(txs)
+	out := make([]*speculativeTx, len(txs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parallelWorkers && w < len(txs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				reads := newAccessRecorder()
+				base := sst.Clone()
+				base.reads = reads
+				states, events, _, _, _, err := s.runOneTx(base,
+					txs[i].ClientTransaction, scID)
+				out[i] = &speculativeTx{
+					states: states,
+					events: events,
+					reads:  reads,
+					err:    err,
+				}
+			}
+		}()
+	}
+	for i := range txs {
+		if !skip[i] {
+			jobs <- i
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return out
+}
+
+// applySpeculative returns the result of the speculative execution of the
+// transaction if none of the keys it read has been written by the previous
+// transactions of the block, so that the result is the same as if it was
+// executed on sst. Else, the transaction is executed again on sst.
+func (s *Service) applySpeculative(sst *stagingStateTrie, spec *speculativeTx,
+	written map[string]bool, tx ClientTransaction,
+	scID skipchain.SkipBlockID) (StateChanges, []Event, *stagingStateTrie, error) {
+	if spec == nil || spec.reads.conflicts(written) {
+		return s.processOneTx(sst, tx, scID)
+	}
+
+	if spec.err != nil {
+		s.addError(tx, spec.err)
+		return nil, nil, nil, spec.err
+	}
+	out := sst.Clone()
+	if err := out.StoreAll(spec.states); err != nil {
+		err = xerrors.Errorf("%s StoreAll failed: %v", s.ServerIdentity(), err)
+		s.addError(tx, err)
+		return nil, nil, nil, err
+	}
+	return spec.states, spec.events, out, nil
+}
@@ -0,0 +1,124 @@
+package byzcoin
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestAccessRecorder_Conflicts(t *testing.T) {
+	r := newAccessRecorder()
+	r.read([]byte("a"))
+	require.False(t, r.conflicts(nil))
+	require.False(t, r.conflicts(map[string]bool{"b": true}))
+	require.True(t, r.conflicts(map[string]bool{"a": true}))
+
+	r.readAll()
+	require.False(t, r.conflicts(map[string]bool{}))
+	require.True(t, r.conflicts(map[string]bool{"b": true}))
+
+	// A nil recorder doesn't record anything.
+	var nr *accessRecorder
+	nr.read([]byte("a"))
+	nr.readAll()
+}
+
+func TestDeclaredConflicts(t *testing.T) {
+	a, b := NewInstanceID([]byte("a")), NewInstanceID([]byte("b"))
+	txs := TxResults{
+		{ClientTransaction: ClientTransaction{AccessedInstances: []InstanceID{a}}},
+		{ClientTransaction: ClientTransaction{AccessedInstances: []InstanceID{b}}},
+		{ClientTransaction: ClientTransaction{}},
+		{ClientTransaction: ClientTransaction{AccessedInstances: []InstanceID{b, a}}},
+	}
+	require.Equal(t, []bool{false, false, false, true}, declaredConflicts(txs))
+}
+
+// Checks that the parallel execution of the transactions gives the same
+// result as the sequential one, with transactions that conflict or fail.
+func TestService_ParallelExecution(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	// The contract increments the value of the instance, or fails if the
+	// value reaches 3.
+	f := func(cdb ReadOnlyStateTrie, inst Instruction, c []Coin) ([]StateChange, []Coin, error) {
+		val, _, cid, _, err := cdb.GetValues(inst.InstanceID.Slice())
+		if err != nil {
+			return nil, nil, err
+		}
+		v := binary.LittleEndian.Uint64(val)
+		if v == 3 {
+			return nil, nil, xerrors.New("too big")
+		}
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, v+1)
+		return []StateChange{
+			NewStateChange(Update, inst.InstanceID, cid, buf, nil),
+		}, nil, nil
+	}
+	for _, service := range s.services {
+		service.testRegisterContract("inc", adaptorNoVerify(f))
+	}
+
+	scID := s.genesis.SkipChainID()
+	st, err := s.service().getStateTrie(scID)
+	require.NoError(t, err)
+
+	iids := []InstanceID{genID(), genID(), genID(), genID()}
+	for _, iid := range iids {
+		err = st.StoreAll([]StateChange{
+			NewStateChange(Create, iid, "inc", make([]byte, 8), nil),
+		}, st.GetIndex(), CurrentVersion)
+		require.NoError(t, err)
+	}
+
+	// The first instance is incremented more than 3 times, so some of the
+	// transactions fail.
+	var txs TxResults
+	for i, idx := range []int{0, 1, 0, 2, 0, 3, 0, 0, 1} {
+		txs = append(txs, NewTxResults(ClientTransaction{
+			Instructions: Instructions{{
+				InstanceID: iids[idx],
+				Invoke: &Invoke{
+					Command: "inc",
+					Args:    Arguments{{Name: "tx", Value: []byte{byte(i)}}},
+				},
+			}},
+		})...)
+	}
+	// A wrong declaration must not change the result.
+	txs[4].ClientTransaction.AccessedInstances = []InstanceID{iids[1]}
+
+	pw := parallelWorkers
+	defer func() {
+		parallelWorkers = pw
+	}()
+
+	run := func(workers int) ([]byte, TxResults, StateChanges) {
+		parallelWorkers = workers
+		s.service().stateChangeCache = newStateChangeCache()
+		root, txOut, scs, _ := s.service().createStateChanges(st.MakeStagingStateTrie(),
+			scID, txs, noTimeout, CurrentVersion)
+		return root, txOut, scs
+	}
+
+	root, txOut, scs := run(1)
+	require.Equal(t, len(txs), len(txOut))
+	accepted := 0
+	for _, tx := range txOut {
+		if tx.Accepted {
+			accepted++
+		}
+	}
+	require.Equal(t, len(txs)-2, accepted)
+
+	rootP, txOutP, scsP := run(4)
+	require.Equal(t, root, rootP)
+	require.Equal(t, txOut, txOutP)
+	require.Equal(t, scs, scsP)
+	require.Equal(t, txOut.Hash(), txOutP.Hash())
+	require.Equal(t, scs.Hash(), scsP.Hash())
+}
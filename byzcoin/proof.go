@@ -6,6 +6,7 @@ import (
 	"golang.org/x/xerrors"
 
 	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
 	"go.dedis.ch/cothority/v3/darc"
 	"go.dedis.ch/cothority/v3/skipchain"
 	"go.dedis.ch/kyber/v3/pairing"
@@ -24,16 +25,60 @@ func NewProof(c ReadOnlyStateTrie, s *skipchain.SkipBlockDB, id skipchain.SkipBl
 		return nil, xerrors.Errorf("couldn't get proof: %+v", err)
 	}
 	p.InclusionProof = *pr
+	links, sb, err := newForwardLinks(s, id, c.GetIndex())
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't get forward links: %v", err)
+	}
+	p.Links = links
+	p.Latest = *sb
+	return
+}
+
+// multiProver is implemented by the state tries that can create a proof for
+// many keys at once.
+type multiProver interface {
+	GetMultiProof(keys [][]byte) (*trie.MultiProof, error)
+}
+
+// NewMultiProof creates a proof for all the keys in the skipchain with the
+// given id, like NewProof.
+func NewMultiProof(c ReadOnlyStateTrie, s *skipchain.SkipBlockDB, id skipchain.SkipBlockID,
+	keys [][]byte) (p *MultiProof, err error) {
+	mp, ok := c.(multiProver)
+	if !ok {
+		return nil, xerrors.New("state trie cannot create multi-proofs")
+	}
+	p = &MultiProof{}
+	pr, err := mp.GetMultiProof(keys)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't get proof: %+v", err)
+	}
+	p.InclusionProof = *pr
+	links, sb, err := newForwardLinks(s, id, c.GetIndex())
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't get forward links: %v", err)
+	}
+	p.Links = links
+	p.Latest = *sb
+	return
+}
+
+// newForwardLinks returns the shortest list of forward links from the block
+// with the given id to the block with the given index, together with this
+// block. The first link is a synthetic one that holds the roster of the
+// starting block.
+func newForwardLinks(s *skipchain.SkipBlockDB, id skipchain.SkipBlockID,
+	index int) ([]skipchain.ForwardLink, *skipchain.SkipBlock, error) {
 	sb := s.GetByID(id)
 	if sb == nil {
-		return nil, xerrors.New("didn't find skipchain")
+		return nil, nil, xerrors.New("didn't find skipchain")
 	}
-	p.Links = []skipchain.ForwardLink{{
+	links := []skipchain.ForwardLink{{
 		From:      []byte{},
 		To:        id,
 		NewRoster: sb.Roster,
 	}}
-	for len(sb.ForwardLink) > 0 && sb.Index < c.GetIndex() {
+	for len(sb.ForwardLink) > 0 && sb.Index < index {
 		var link *skipchain.ForwardLink
 		// Corner-case when the database is downloading blocks and a proof is
 		// requested before all blocks are stored - then we need to make sure that
@@ -43,23 +88,22 @@ func NewProof(c ReadOnlyStateTrie, s *skipchain.SkipBlockDB, id skipchain.SkipBl
 			link = sb.ForwardLink[height]
 			sbTemp := s.GetByID(link.To)
 			if sbTemp == nil {
-				return nil, xerrors.New("missing block in chain")
+				return nil, nil, xerrors.New("missing block in chain")
 			}
 			if sbTemp.Index <= sb.Index {
-				return nil, cothority.ErrorOrNil(skipchain.ErrorInconsistentForwardLink, "")
+				return nil, nil, cothority.ErrorOrNil(skipchain.ErrorInconsistentForwardLink, "")
 			}
-			if sbTemp.Index <= c.GetIndex() {
+			if sbTemp.Index <= index {
 				sb = sbTemp
 				break
 			}
 		}
-		p.Links = append(p.Links, *link)
+		links = append(links, *link)
 	}
-	if c.GetIndex() != sb.Index {
-		return nil, xerrors.New("didn't find skipblock with same index as state-trie")
+	if index != sb.Index {
+		return nil, nil, xerrors.New("didn't find skipblock with same index as state-trie")
 	}
-	p.Latest = *sb
-	return
+	return links, sb, nil
 }
 
 // ErrorVerifyTrie is returned if the proof itself is not properly set up.
@@ -118,19 +162,27 @@ func (p Proof) Verify(sbID skipchain.SkipBlockID) error {
 		return cothority.WrapError(err)
 	}
 
-	if len(p.Links) == 0 {
+	return verifyForwardLinks(p.Links, &p.Latest, sbID)
+}
+
+// verifyForwardLinks checks that the links go from the block sbID to the
+// latest block. The roster of the first, synthetic, link must have been
+// verified before by the caller.
+func verifyForwardLinks(links []skipchain.ForwardLink, latest *skipchain.SkipBlock,
+	sbID skipchain.SkipBlockID) error {
+	if len(links) == 0 {
 		return cothority.WrapError(ErrorMissingForwardLinks)
 	}
-	if p.Links[0].NewRoster == nil {
+	if links[0].NewRoster == nil {
 		return cothority.WrapError(ErrorMalformedForwardLink)
 	}
 
 	// Get the first from the synthetic link which is assumed to be verified
 	// before against the block with ID stored in the To field by the caller.
-	publics := p.Links[0].NewRoster.ServicePublics(skipchain.ServiceName)
+	publics := links[0].NewRoster.ServicePublics(skipchain.ServiceName)
 
-	for _, l := range p.Links[1:] {
-		if err = l.VerifyWithScheme(pairing.NewSuiteBn256(), publics, p.Latest.SignatureScheme); err != nil {
+	for _, l := range links[1:] {
+		if err := l.VerifyWithScheme(pairing.NewSuiteBn256(), publics, latest.SignatureScheme); err != nil {
 			return cothority.WrapError(ErrorVerifySkipchain)
 		}
 		if !l.From.Equal(sbID) {
@@ -143,13 +195,52 @@ func (p Proof) Verify(sbID skipchain.SkipBlockID) error {
 	}
 
 	// Check that the given latest block matches the last forward link target
-	if !p.Latest.CalculateHash().Equal(sbID) {
+	if !latest.CalculateHash().Equal(sbID) {
 		return cothority.WrapError(ErrorVerifyHash)
 	}
 
 	return nil
 }
 
+// VerifyFromBlock works like Proof.VerifyFromBlock for all the keys of the
+// proof.
+func (p MultiProof) VerifyFromBlock(verifiedBlock *skipchain.SkipBlock) error {
+	if len(p.Links) > 0 {
+		p.Links[0].NewRoster = verifiedBlock.Roster
+	}
+	err := p.Verify(verifiedBlock.Hash)
+	return cothority.ErrorOrNil(err, "verification failed")
+}
+
+// Verify works like Proof.Verify for all the keys of the proof. It does not
+// verify whether a certain key/value pair exists in the proof.
+func (p MultiProof) Verify(sbID skipchain.SkipBlockID) error {
+	var header DataHeader
+	err := protobuf.Decode(p.Latest.Data, &header)
+	if err != nil {
+		return xerrors.Errorf("decoding header: %v", err)
+	}
+	if !bytes.Equal(p.InclusionProof.GetRoot(), header.TrieRoot) {
+		return cothority.WrapError(ErrorVerifyTrieRoot)
+	}
+
+	return verifyForwardLinks(p.Links, &p.Latest, sbID)
+}
+
+// Proof returns the proof of a single key of the multi-proof, so that it can
+// be used where a Proof is expected.
+func (p MultiProof) Proof(key []byte) (*Proof, error) {
+	pr, err := p.InclusionProof.Proof(key)
+	if err != nil {
+		return nil, xerrors.Errorf("key not in the proof: %v", err)
+	}
+	return &Proof{
+		InclusionProof: *pr,
+		Latest:         p.Latest,
+		Links:          p.Links,
+	}, nil
+}
+
 // VerifyInclusionProof verifies that the inclusion proof matches the skipblock
 // given in parameter.
 func (p Proof) VerifyInclusionProof(latest *skipchain.SkipBlock) error {
@@ -7,6 +7,7 @@ import (
 	"go.dedis.ch/cothority/v3/darc"
 	"go.dedis.ch/cothority/v3/skipchain"
 	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
 )
 
 // PROTOSTART
@@ -19,8 +20,10 @@ import (
 // type :TxResults:[]TxResult
 // type :InstanceID:bytes
 // type :Version:sint32
+// type :network.ServerIdentityID:bytes
 // import "skipchain.proto";
 // import "onet.proto";
+// import "network.proto";
 // import "darc.proto";
 // import "trie.proto";
 //
@@ -115,6 +118,35 @@ type AddTxResponse struct {
 	Proof *Proof `protobuf:"opt"`
 }
 
+// SimulateTxRequest requests to run a transaction against the latest state of
+// the ledger, without adding it to the transaction buffer.
+type SimulateTxRequest struct {
+	// Version of the protocol
+	Version Version
+	// SkipchainID is the hash of the first skipblock
+	SkipchainID skipchain.SkipBlockID
+	// Transaction to be simulated
+	Transaction ClientTransaction
+}
+
+// SimulateTxResponse holds the outcome of a SimulateTxRequest.
+type SimulateTxResponse struct {
+	// Version of the protocol
+	Version Version
+	// StateChanges that the transaction would produce, including the
+	// updates of the signer counters.
+	StateChanges []StateChange
+	// Coins left over after the last instruction.
+	Coins []Coin
+	// Events that the contracts would emit.
+	Events []Event `protobuf:"opt"`
+	// Error message describes why the transaction would fail.
+	Error string `protobuf:"opt"`
+	// ErrorIndex is the index of the instruction that failed. It is only
+	// valid if Error is set.
+	ErrorIndex int `protobuf:"opt"`
+}
+
 // GetProof returns the proof that the given key is in the trie.
 type GetProof struct {
 	// Version of the protocol
@@ -127,6 +159,14 @@ type GetProof struct {
 	// MustContainBlock when provided informs the server that the proof
 	// should include this block.
 	MustContainBlock skipchain.SkipBlockID `protobuf:"opt"`
+	// AtBlockID asks for a proof against the state as it was after this
+	// block has been applied, instead of the latest state.
+	AtBlockID skipchain.SkipBlockID `protobuf:"opt"`
+	// AtIndex asks for a proof against the state as it was after the block
+	// with this index has been applied. It is only used if it is above 0
+	// and AtBlockID is empty. Only the states of the last blocks can be
+	// asked for.
+	AtIndex int `protobuf:"opt"`
 }
 
 // GetProofResponse can be used together with the Genesis block to proof that
@@ -139,6 +179,28 @@ type GetProofResponse struct {
 	Proof Proof
 }
 
+// GetProofs is a request for the proof of many keys against the same state.
+type GetProofs struct {
+	// Version of the protocol
+	Version Version
+	// Keys are the keys we want to look up
+	Keys [][]byte
+	// ID is any block that is known to us in the skipchain, like in
+	// GetProof.
+	ID skipchain.SkipBlockID
+	// AtBlockID and AtIndex ask for the proofs against a past state, like
+	// in GetProof.
+	AtBlockID skipchain.SkipBlockID `protobuf:"opt"`
+	AtIndex   int                   `protobuf:"opt"`
+}
+
+// GetProofsResponse holds the proof of all the keys of the request.
+type GetProofsResponse struct {
+	// Version of the protocol
+	Version Version
+	Proof   MultiProof
+}
+
 // CheckAuthorization returns the list of actions that could be executed if the
 // signatures of the given identities are present and valid
 type CheckAuthorization struct {
@@ -166,6 +228,53 @@ type ChainConfig struct {
 	Roster          onet.Roster
 	MaxBlockSize    int
 	DarcContractIDs []string
+	// MeteringBudget is the maximum cost of an instruction, counted from
+	// the reads, the writes and the bytes of the global state it uses. If
+	// it is 0, the instructions are not metered.
+	MeteringBudget uint64 `protobuf:"opt"`
+	// MeteringCoin is the type of coin used to pay for the cost of the
+	// instructions. The coins are taken from the FeePayer of the
+	// transaction, or else from the coins returned by the instruction,
+	// e.g. when it fetches them from a coin instance.
+	MeteringCoin *InstanceID
+	// MeteringPrice is the number of coins paid per unit of cost.
+	MeteringPrice uint64 `protobuf:"opt"`
+	// ContractVersions holds the active version of the contracts that
+	// have been upgraded. The other contracts use their first version.
+	ContractVersions []ContractVersion `protobuf:"opt"`
+	// CheckpointInterval is the number of blocks between two checkpoints
+	// of the global state. If it is 0, no checkpoint is created.
+	CheckpointInterval int `protobuf:"opt"`
+	// ArchiveNodes are the nodes of the roster that keep the transactions
+	// of all the blocks. The requests that need the transactions of a
+	// block pruned by a node are forwarded to them.
+	ArchiveNodes []*network.ServerIdentity `protobuf:"opt"`
+	// TxQuota limits the transactions received by every node that wait to
+	// be included. If it is nil, only the size of the buffer of the node
+	// is limited.
+	TxQuota *TxQuota `protobuf:"opt"`
+}
+
+// TxQuota holds the limits of the transactions that wait to be included, for
+// the whole chain and for every signer. The limits that are 0 are not
+// checked.
+type TxQuota struct {
+	// MaxPendingTxs is the number of transactions of the chain.
+	MaxPendingTxs int `protobuf:"opt"`
+	// MaxPendingBytes is the size of the transactions of the chain.
+	MaxPendingBytes int `protobuf:"opt"`
+	// MaxSignerTxs is the number of transactions signed by the same
+	// identity.
+	MaxSignerTxs int `protobuf:"opt"`
+	// MaxSignerBytes is the size of the transactions signed by the same
+	// identity.
+	MaxSignerBytes int `protobuf:"opt"`
+}
+
+// ContractVersion is the version of a contract, 0 being the first one.
+type ContractVersion struct {
+	ContractID string
+	Version    uint64
 }
 
 // Proof represents everything necessary to verify a given
@@ -188,6 +297,18 @@ type Proof struct {
 	Links []skipchain.ForwardLink
 }
 
+// MultiProof represents everything necessary to verify many keys stored in
+// the same state. It works like Proof, but the skipblock, the forward links
+// and the interior nodes shared by the keys are only included once.
+type MultiProof struct {
+	// InclusionProof proves the presence or absence of all the keys.
+	InclusionProof trie.MultiProof
+	// Latest is the skipblock holding the Merkle tree root.
+	Latest skipchain.SkipBlock
+	// Links is the path to the latest skipblock, like in Proof.
+	Links []skipchain.ForwardLink
+}
+
 // Instruction holds only one of Spawn, Invoke, or Delete
 type Instruction struct {
 	// InstanceID is either the instance that can spawn a new instance, or the instance
@@ -202,13 +323,20 @@ type Instruction struct {
 	// SignerCounter must be set to a value that is one greater than what
 	// was in the last instruction signed by the same signer. Every counter
 	// must map to the corresponding element in Signature. The initial
-	// counter is 1. Overflow is allowed.
+	// counter is 1. Overflow is allowed. If SignerLanes is set, the
+	// counter is the one of the lane of the signer.
 	SignerCounter []uint64
 	// SignerIdentities are the identities of all the signers.
 	SignerIdentities []darc.Identity
 	// Signatures that are verified using the Darc controlling access to
 	// the instance.
 	Signatures [][]byte
+	// SignerLanes optionally holds the lane of the counter of every
+	// signer. Every lane has its own counter, so that a signer can have
+	// one instruction in flight per lane. If it is empty, all the signers
+	// use the lane 0, which is the counter of the instructions without
+	// lanes.
+	SignerLanes []uint64 `protobuf:"opt"`
 	// version is a private field that can allow an instruction to be passed
 	// around with the context of a block with a specific version.
 	// This field must be the last field of the struct, so that the
@@ -257,12 +385,64 @@ type Argument struct {
 // every instruction must sign for the transaction to be valid.
 type ClientTransaction struct {
 	Instructions Instructions
+	// AccessedInstances optionally declares the instances the transaction
+	// reads or writes. It is only a hint for executing the transactions of
+	// a block in parallel, so it is not signed and doesn't need to be
+	// complete.
+	AccessedInstances []InstanceID `protobuf:"opt"`
+	// Validity optionally restricts the blocks that can include the
+	// transaction. It is signed together with the instructions.
+	Validity *TxValidity `protobuf:"opt"`
+	// FeePayer optionally references a coin instance of the metering coin
+	// that pays for the metered instructions, instead of the coins given
+	// to them. Its darc must allow invoke:coin.fetch to the signers of
+	// every instruction. It is signed together with the instructions.
+	FeePayer *InstanceID `protobuf:"opt"`
+}
+
+// TxValidity is a window outside of which a transaction is refused, so that
+// a transaction that hasn't been included cannot be executed much later. The
+// fields that are 0 are not checked. The timestamps are compared to the one
+// of the latest block when the transaction is executed.
+type TxValidity struct {
+	// NotBefore is the index of the first block that can include the
+	// transaction.
+	NotBefore int `protobuf:"opt"`
+	// NotAfter is the index of the last block that can include the
+	// transaction.
+	NotAfter int `protobuf:"opt"`
+	// NotBeforeTime is a Unix timestamp in nanoseconds.
+	NotBeforeTime int64 `protobuf:"opt"`
+	// NotAfterTime is a Unix timestamp in nanoseconds.
+	NotAfterTime int64 `protobuf:"opt"`
 }
 
 // TxResult holds a transaction and the result of running it.
 type TxResult struct {
 	ClientTransaction ClientTransaction
 	Accepted          bool
+	// Events are emitted by the contracts while executing the transaction.
+	// They are only kept if the transaction is accepted.
+	Events []Event `protobuf:"opt"`
+}
+
+// Event is emitted by a contract to signal that something happened, without
+// having to store it in an instance.
+type Event struct {
+	// Name is chosen by the contract, e.g. "transfer".
+	Name string
+	// InstanceID is the instance the event is about.
+	InstanceID InstanceID
+	// ContractID is the contract that emitted the event. It is set by the
+	// service.
+	ContractID string
+	Attributes []EventAttribute `protobuf:"opt"`
+}
+
+// EventAttribute is a key/value pair attached to an event.
+type EventAttribute struct {
+	Key   string
+	Value []byte `protobuf:"opt"`
 }
 
 // StateChange is one new state that will be applied to the collection.
@@ -291,9 +471,28 @@ type Coin struct {
 }
 
 // StreamingRequest is a request asking the service to start streaming blocks
-// on the chain specified by ID.
+// on the chain specified by ID. If any of the filters is set, the payload of
+// the streamed blocks only holds the instructions that match the filters, and
+// transactions without any matching instruction are removed. An instruction
+// must match all the filters that are set. The blocks themselves are always
+// sent, so that the client can follow the chain.
 type StreamingRequest struct {
 	ID skipchain.SkipBlockID
+	// ContractIDs keeps the instructions for one of these contracts.
+	ContractIDs []string `protobuf:"opt"`
+	// InstanceIDs keeps the instructions sent to one of these instances.
+	InstanceIDs []InstanceID `protobuf:"opt"`
+	// DarcIDs keeps the instructions sent to an instance governed by one
+	// of these darcs before the block of the instruction. A spawn is sent
+	// to the instance it spawns from.
+	DarcIDs []darc.ID `protobuf:"opt"`
+	// AcceptedOnly removes the refused transactions.
+	AcceptedOnly bool `protobuf:"opt"`
+	// StartIndex, if bigger than 0, makes the service first send all the
+	// blocks starting from this index before streaming the new ones. It
+	// is used by clients that reconnect to replay the missed blocks. With
+	// DarcIDs, only the last blocks can be replayed.
+	StartIndex int `protobuf:"opt"`
 }
 
 // StreamingResponse is the reply (block) that is streamed back to the client
@@ -311,12 +510,29 @@ type DownloadState struct {
 	// Nonce is 0 for a new download, else it must be
 	// equal to the nonce returned in DownloadStateResponse.
 	// In case Nonce is non-zero, but doesn't correspond
-	// to the current session, an error is returned,
-	// as only one download-session can be active at
-	// any given moment.
+	// to an active session, an error is returned. Many
+	// download-sessions can be active at the same time.
 	Nonce uint64
 	// Length of the statechanges to download
 	Length int
+	// Start is the first key to return. If it is empty, the download
+	// continues after the last key returned by the session. A client
+	// resumes a download by giving the key following the last key it
+	// received.
+	Start []byte `protobuf:"opt"`
+	// End is the first key that is not returned anymore. If it is empty,
+	// the download goes up to the last key.
+	End []byte `protobuf:"opt"`
+	// SignerID is the node asking for a new download. As a session
+	// holds a snapshot of the database, only the nodes of the roster of
+	// the latest block can open one.
+	SignerID network.ServerIdentityID `protobuf:"opt"`
+	// Timestamp is the time of a new download in nanoseconds. It must be
+	// close to the time of the node.
+	Timestamp int64 `protobuf:"opt"`
+	// Signature is the schnorr signature of the hash of a new download,
+	// by the SignerID node.
+	Signature []byte `protobuf:"opt"`
 }
 
 // DownloadStateResponse is returned by the service. If there are no
@@ -331,6 +547,9 @@ type DownloadStateResponse struct {
 	Nonce uint64
 	// Total key/value pairs.
 	Total int `protobuf:"opt"`
+	// Index of the block of the state that is downloaded. All the chunks
+	// of a session come from the state at this index.
+	Index int `protobuf:"opt"`
 }
 
 // DBKeyValue represents one element in bboltdb
@@ -354,6 +573,10 @@ type StateChangeBody struct {
 type GetSignerCounters struct {
 	SignerIDs   []string
 	SkipchainID skipchain.SkipBlockID
+	// SignerLanes optionally holds the lane of the counter to get for
+	// every signer. If it is empty, the counters of the lane 0 are
+	// returned.
+	SignerLanes []uint64 `protobuf:"opt"`
 }
 
 // GetSignerCountersResponse holds the latest version for the identity in the
@@ -420,6 +643,49 @@ type CheckStateChangeValidityResponse struct {
 	BlockID      skipchain.SkipBlockID
 }
 
+// GetTxStatus is a request to find the block holding a transaction.
+type GetTxStatus struct {
+	SkipChainID skipchain.SkipBlockID
+	// TxHash is the hash of the instructions of the transaction, as
+	// returned by ClientTransaction.Instructions.Hash.
+	TxHash []byte
+}
+
+// GetTxStatusResponse holds the transaction together with the block that
+// holds it and the forward links from the genesis block to this block.
+type GetTxStatusResponse struct {
+	TxResult TxResult
+	// TxIndex is the position of the transaction in the block.
+	TxIndex int
+	Block   skipchain.SkipBlock
+	Links   []skipchain.ForwardLink
+}
+
+// ListInstances is a request to list the instances of a contract or the
+// instances guarded by a darc. Exactly one of ContractID and DarcID must be
+// set.
+type ListInstances struct {
+	SkipChainID skipchain.SkipBlockID
+	ContractID  string  `protobuf:"opt"`
+	DarcID      darc.ID `protobuf:"opt"`
+	// Start is the instance ID the list starts at, as given by Next in the
+	// previous response.
+	Start []byte `protobuf:"opt"`
+	// Limit is the maximum number of instances to return. The service
+	// uses a default value if it is not set and caps it.
+	Limit int `protobuf:"opt"`
+}
+
+// ListInstancesResponse holds a page of instance IDs, sorted by ID.
+type ListInstancesResponse struct {
+	InstanceIDs []InstanceID
+	// Next is the instance ID the next page starts at. It is empty if there
+	// are no more instances.
+	Next []byte `protobuf:"opt"`
+	// Index is the index of the block the list has been created from.
+	Index int
+}
+
 // ResolveInstanceID is the request for resolving the instance ID based on the
 // Darc ID and the name.
 type ResolveInstanceID struct {
@@ -433,6 +699,150 @@ type ResolvedInstanceID struct {
 	InstanceID InstanceID
 }
 
+// QueryInstance is a request to run a read-only query on an instance whose
+// contract implements ContractQuerier.
+type QueryInstance struct {
+	SkipChainID skipchain.SkipBlockID
+	InstanceID  InstanceID
+	// Method is the name of the query, e.g. "balance" for a coin instance.
+	Method string
+	Args   Arguments `protobuf:"opt"`
+}
+
+// QueryInstanceResponse holds the result of a query.
+type QueryInstanceResponse struct {
+	Result []byte
+	// Index is the index of the block of the state the query has been run
+	// on.
+	Index int
+}
+
+// SearchEvents is a request to search the events of a range of blocks. An
+// event matches if it has the name and the contract ID of the request, if
+// they are set.
+type SearchEvents struct {
+	SkipChainID skipchain.SkipBlockID
+	Name        string `protobuf:"opt"`
+	ContractID  string `protobuf:"opt"`
+	// From is the index of the first block to search.
+	From int
+	// To is the index of the last block to search. If it is not set, the
+	// search goes up to the latest block.
+	To int `protobuf:"opt"`
+}
+
+// SearchEventsResponse holds the events found, in the order of the blocks.
+type SearchEventsResponse struct {
+	Events []BlockEvent
+	// Next is the index of the block where the search must continue,
+	// if the range holds too many blocks to be searched at once. It is 0
+	// if the whole range has been searched.
+	Next int `protobuf:"opt"`
+}
+
+// BlockEvent is an event together with its position in the skipchain.
+type BlockEvent struct {
+	BlockIndex int
+	TxIndex    int
+	Event      Event
+}
+
+// GetContractVersions is a request to get the versions of the contracts
+// supported by a node. If SkipChainID is set, the versions active on the chain
+// are returned too.
+type GetContractVersions struct {
+	SkipChainID skipchain.SkipBlockID `protobuf:"opt"`
+}
+
+// GetContractVersionsResponse holds the latest version of every contract known
+// by the node and the versions active on the chain, if it has been requested.
+type GetContractVersionsResponse struct {
+	Supported []ContractVersion
+	Active    []ContractVersion `protobuf:"opt"`
+}
+
+// GetStateDiff is a request for the instances that have been created, updated
+// or removed by the blocks after FromIndex, up to and including ToIndex.
+type GetStateDiff struct {
+	SkipChainID skipchain.SkipBlockID
+	FromIndex   int
+	ToIndex     int
+}
+
+// GetStateDiffResponse holds the differences between the state after the
+// block FromIndex and the state after the block ToIndex, sorted by instance
+// ID.
+type GetStateDiffResponse struct {
+	Diffs []StateDiff
+}
+
+// StateDiff is the change of one instance between two states. The action is
+// Create if the instance didn't exist in the first state, Remove if it doesn't
+// exist in the second one and Update otherwise. The old fields are empty for a
+// created instance, and the new ones for a removed instance.
+type StateDiff struct {
+	InstanceID    InstanceID
+	Action        StateAction
+	OldValue      []byte  `protobuf:"opt"`
+	OldContractID string  `protobuf:"opt"`
+	OldDarcID     darc.ID `protobuf:"opt"`
+	NewValue      []byte  `protobuf:"opt"`
+	NewContractID string  `protobuf:"opt"`
+	NewDarcID     darc.ID `protobuf:"opt"`
+}
+
+// ForwardTxs is sent by a node to the leader of the chain with the
+// transactions it received, so that the leader can include them without
+// waiting to collect them.
+type ForwardTxs struct {
+	SkipChainID skipchain.SkipBlockID
+	Txs         []ClientTransaction
+}
+
+// Checkpoint is a statement, collectively signed by the roster of the block,
+// that ties a block to the global state after the block has been applied.
+type Checkpoint struct {
+	SkipChainID skipchain.SkipBlockID
+	BlockID     skipchain.SkipBlockID
+	Index       int
+	// TrieRoot is the root of the global state, as it is stored in the
+	// header of the block.
+	TrieRoot []byte
+	// StateHash is the hash of the nonce, the version and the key/value
+	// pairs of the global state, in the order of the trie.
+	StateHash []byte
+	// Signature is the BLS signature of the hash of the checkpoint.
+	Signature []byte `protobuf:"opt"`
+}
+
+// CheckpointFile holds a checkpoint together with the global state it
+// refers to, so that a node can import the state without replaying the
+// blocks from the genesis.
+type CheckpointFile struct {
+	Checkpoint Checkpoint
+	// Proof holds the blocks linking the genesis block to the block of the
+	// checkpoint.
+	Proof   []*skipchain.SkipBlock
+	Nonce   []byte
+	Version Version
+	// KeyValues holds the key/value pairs of the global state, in the
+	// order of the trie.
+	KeyValues []DBKeyValue
+}
+
+// GetCheckpoint requests a checkpoint of the global state.
+type GetCheckpoint struct {
+	SkipChainID skipchain.SkipBlockID
+	// Index of the block of the checkpoint. If it is 0, the latest
+	// checkpoint is returned.
+	Index int `protobuf:"opt"`
+}
+
+// GetCheckpointResponse holds the requested checkpoint.
+type GetCheckpointResponse struct {
+	Checkpoint Checkpoint
+}
+
 // DebugRequest returns the list of all byzcoins if byzcoinid is empty, else it returns
 // a dump of all instances if byzcoinid is given and exists.
 type DebugRequest struct {
@@ -467,3 +877,34 @@ type DebugRemoveRequest struct {
 	ByzCoinID []byte
 	Signature []byte
 }
+
+// NodeConfig holds the settings of a node that are not part of the
+// configuration of the chains. They are stored by the node.
+type NodeConfig struct {
+	// PruneBlocks is the number of latest blocks whose transactions and
+	// state changes are kept by the node, or 0 to keep all of them. At
+	// least 100 blocks are kept.
+	PruneBlocks int `protobuf:"opt"`
+	// CheckpointDir is the directory where the node writes the files of the
+	// checkpoints it stores. No file is written if it is empty.
+	CheckpointDir string `protobuf:"opt"`
+	// GatewayAddress is the address on which the node serves its chains as
+	// JSON over HTTP, e.g. "127.0.0.1:7771". The gateway is not started if
+	// it is empty.
+	GatewayAddress string `protobuf:"opt"`
+}
+
+// SetNodeConfigRequest replaces the settings of the conode. It needs to be
+// signed by the private key of the conode, over the sequence number and the
+// encoding of the settings.
+type SetNodeConfigRequest struct {
+	Config NodeConfig
+	// Sequence must be above the one of the last request accepted by the
+	// conode, so that a request cannot be replayed.
+	Sequence  uint64
+	Signature []byte
+}
+
+// SetNodeConfigResponse is returned once the settings are stored.
+type SetNodeConfigResponse struct {
+}
@@ -0,0 +1,115 @@
+package byzcoin
+
+import (
+	"bytes"
+
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+var errBlockPruned = xerrors.New("transactions of the block have been pruned")
+
+// pruneDepth returns the number of latest blocks whose transactions and state
+// changes are kept, as set in the node config, or 0 if the node keeps all of
+// them. The transactions of the latest catchupDownloadAll blocks are always
+// kept, so that the other nodes can catch up from this node.
+func (s *Service) pruneDepth() int {
+	depth := s.nodeConfig().PruneBlocks
+	if depth > 0 && depth < catchupDownloadAll {
+		depth = catchupDownloadAll
+	}
+	return depth
+}
+
+// pruneBlocks removes the transactions of the blocks that are older than the
+// prune depth, going backwards until a block that is already pruned. The
+// headers and the forward links are kept for the proofs. The genesis block is
+// never pruned, and an archive node doesn't prune anything.
+func (s *Service) pruneBlocks(latest *skipchain.SkipBlock, config *ChainConfig) error {
+	depth := s.pruneDepth()
+	if depth <= 0 || config.isArchiveNode(s.ServerIdentity().ID) {
+		return nil
+	}
+	if latest.Index-depth <= 0 {
+		return nil
+	}
+
+	s.pruneLock.Lock()
+	defer s.pruneLock.Unlock()
+
+	reply, err := s.skService().GetSingleBlockByIndex(
+		&skipchain.GetSingleBlockByIndex{
+			Genesis: latest.SkipChainID(),
+			Index:   latest.Index - depth,
+		})
+	if err != nil {
+		return xerrors.Errorf("getting block: %v", err)
+	}
+
+	pruned := 0
+	for sb := reply.SkipBlock; sb != nil && sb.Index > 0; {
+		if _, err := decodeBlockBody(sb); xerrors.Is(err, errBlockPruned) {
+			break
+		}
+		if err := s.db().PrunePayload(sb.Hash); err != nil {
+			return xerrors.Errorf("pruning block %d: %v", sb.Index, err)
+		}
+		pruned++
+		sb = s.db().GetByID(sb.BackLinkIDs[0])
+	}
+	if pruned > 0 {
+		log.Lvlf2("%s pruned %d blocks up to block %d", s.ServerIdentity(),
+			pruned, latest.Index-depth)
+	}
+	return nil
+}
+
+// decodeBlockBody returns the transactions of the block. If the transactions
+// have been pruned, errBlockPruned is returned.
+func decodeBlockBody(sb *skipchain.SkipBlock) (*DataBody, error) {
+	header, err := decodeBlockHeader(sb)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding header: %v", err)
+	}
+	var body DataBody
+	if err := protobuf.Decode(sb.Payload, &body); err != nil {
+		return nil, xerrors.Errorf("decoding body: %v", err)
+	}
+	body.TxResults.SetVersion(header.Version)
+	if !bytes.Equal(body.TxResults.Hash(), header.ClientTransactionHash) {
+		// A block without transactions has an empty payload too, so
+		// only a payload that doesn't match the header means the block
+		// has been pruned.
+		if len(sb.Payload) == 0 {
+			return nil, errBlockPruned
+		}
+		return nil, xerrors.New("transactions don't match the block header")
+	}
+	return &body, nil
+}
+
+// forwardToArchive sends the request to the archive nodes of the chain, one
+// after the other, until one of them replies. It is used when the node
+// pruned the transactions needed to answer the request.
+func (s *Service) forwardToArchive(scID skipchain.SkipBlockID, req, reply interface{}) error {
+	config, err := s.LoadConfig(scID)
+	if err != nil {
+		return xerrors.Errorf("loading config: %v", err)
+	}
+
+	cl := NewClient(scID, config.Roster)
+	for _, si := range config.ArchiveNodes {
+		if si.Equal(s.ServerIdentity()) {
+			continue
+		}
+		err = cl.SendProtobuf(si, req, reply)
+		if err == nil {
+			return nil
+		}
+		log.Warnf("%s couldn't forward request to %s: %v",
+			s.ServerIdentity(), si, err)
+	}
+	return xerrors.New("no archive node could reply")
+}
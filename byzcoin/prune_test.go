@@ -0,0 +1,111 @@
+package byzcoin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+func TestDecodeBlockBody(t *testing.T) {
+	s := newSer(t, 2, testInterval)
+	defer s.local.CloseAll()
+
+	s.waitProof(t, NewInstanceID(s.tx.Instructions[0].Hash()))
+	sb, err := s.service().db().GetLatestByID(s.genesis.SkipChainID())
+	require.NoError(t, err)
+	body, err := decodeBlockBody(sb)
+	require.NoError(t, err)
+	require.NotEmpty(t, body.TxResults)
+
+	pruned := sb.Copy()
+	pruned.Payload = nil
+	_, err = decodeBlockBody(pruned)
+	require.True(t, xerrors.Is(err, errBlockPruned))
+
+	// A payload that doesn't match the header is not a pruned block.
+	txs := append(TxResults{}, body.TxResults...)
+	txs[0].Accepted = !txs[0].Accepted
+	pruned.Payload, err = protobuf.Encode(&DataBody{TxResults: txs})
+	require.NoError(t, err)
+	_, err = decodeBlockBody(pruned)
+	require.Error(t, err)
+	require.False(t, xerrors.Is(err, errBlockPruned))
+}
+
+// Prunes the blocks of all the nodes but one archive node, and checks that
+// the requests for the pruned transactions are forwarded to it.
+func TestService_Prune(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	cda := catchupDownloadAll
+	defer func() {
+		catchupDownloadAll = cda
+	}()
+	catchupDownloadAll = 2
+
+	archive := s.roster.List[3]
+	config, err := s.service().LoadConfig(s.genesis.SkipChainID())
+	require.NoError(t, err)
+	config.ArchiveNodes = []*network.ServerIdentity{archive}
+	configBuf, err := protobuf.Encode(config)
+	require.NoError(t, err)
+	tx, err := combineInstrsAndSign(s.signer, Instruction{
+		InstanceID: ConfigInstanceID,
+		Invoke: &Invoke{
+			ContractID: ContractConfigID,
+			Command:    "update_config",
+			Args:       Arguments{{Name: "config", Value: configBuf}},
+		},
+		SignerCounter: []uint64{1},
+	})
+	require.NoError(t, err)
+	s.sendTxAndWait(t, tx, 10)
+	hash := tx.Instructions.Hash()
+
+	// The depth is raised to catchupDownloadAll, for the state changes too.
+	for _, service := range s.services {
+		require.NoError(t, service.setNodeConfig(NodeConfig{PruneBlocks: 1}))
+		require.Equal(t, 2, service.pruneDepth())
+		require.Equal(t, 2, service.stateChangeStorage.maxNbrBlock)
+	}
+	addDummyTxs(t, s, 3, 1, 2)
+	s.waitPropagation(t, -1)
+
+	isPruned := func(service *Service) bool {
+		resp, err := service.skService().GetSingleBlockByIndex(
+			&skipchain.GetSingleBlockByIndex{Genesis: s.genesis.SkipChainID(), Index: 1})
+		require.NoError(t, err)
+		_, err = decodeBlockBody(resp.SkipBlock)
+		return xerrors.Is(err, errBlockPruned)
+	}
+	for i := 0; i < 20 && !isPruned(s.service()); i++ {
+		time.Sleep(testInterval)
+	}
+	require.True(t, isPruned(s.service()))
+	require.False(t, isPruned(s.services[3]))
+
+	// The genesis block is never pruned.
+	genesis := s.service().db().GetByID(s.genesis.SkipChainID())
+	_, err = decodeBlockBody(genesis)
+	require.NoError(t, err)
+
+	rep, err := s.service().GetTxStatus(&GetTxStatus{
+		SkipChainID: s.genesis.SkipChainID(),
+		TxHash:      hash,
+	})
+	require.NoError(t, err)
+	require.True(t, rep.TxResult.Accepted)
+	require.NoError(t, rep.Verify(s.genesis))
+
+	resp, err := s.service().SearchEvents(&SearchEvents{
+		SkipChainID: s.genesis.SkipChainID(),
+	})
+	require.NoError(t, err)
+	require.Equal(t, 0, resp.Next)
+}
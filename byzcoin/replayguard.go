@@ -8,11 +8,18 @@ import (
 	"golang.org/x/xerrors"
 )
 
-// getSignerCounter returns 0 if the key is not set, otherwise it loads the
-// counter from the Trie.
-func getSignerCounter(st ReadOnlyStateTrie, id string) (uint64, error) {
-	val, _, _, _, err := st.GetValues(publicVersionKey(id))
-	if xerrors.Is(err, errKeyNotSet) {
+// GetSignerCounter returns the counter of the signer, which is 0 if the
+// signer never signed an instruction, so that the next instruction of the
+// signer must use the counter plus one.
+func GetSignerCounter(st ReadOnlyStateTrie, id string) (uint64, error) {
+	return getSignerLaneCounter(st, id, 0)
+}
+
+// getSignerLaneCounter returns the counter of the given lane of the signer,
+// or 0 if it is not set.
+func getSignerLaneCounter(st ReadOnlyStateTrie, id string, lane uint64) (uint64, error) {
+	val, _, _, _, err := st.GetValues(signerLaneKey(id, lane))
+	if xerrors.Is(err, ErrKeyNotSet) {
 		return 0, nil
 	}
 	if err != nil {
@@ -23,12 +30,17 @@ func getSignerCounter(st ReadOnlyStateTrie, id string) (uint64, error) {
 }
 
 // incrementSignerCounters loads the existing counters from sigs and then
-// increments all of them by 1.
-func incrementSignerCounters(st ReadOnlyStateTrie, ids []darc.Identity) (StateChanges, error) {
+// increments all of them by 1. If lanes is not empty, the counter of the lane
+// of each signer is incremented.
+func incrementSignerCounters(st ReadOnlyStateTrie, ids []darc.Identity, lanes []uint64) (StateChanges, error) {
+	if err := checkSignerLanes(lanes, ids); err != nil {
+		return nil, err
+	}
 	var scs StateChanges
-	for _, id := range ids {
+	for i, id := range ids {
 		id := id.String()
-		ver, err := getSignerCounter(st, id)
+		lane := signerLane(lanes, i)
+		ver, err := getSignerLaneCounter(st, id, lane)
 		if err != nil {
 			return scs, xerrors.Errorf("reading counter: %v", err)
 		}
@@ -45,7 +57,7 @@ func incrementSignerCounters(st ReadOnlyStateTrie, ids []darc.Identity) (StateCh
 		}
 		scs = append(scs, StateChange{
 			StateAction: action,
-			InstanceID:  publicVersionKey(id),
+			InstanceID:  signerLaneKey(id, lane),
 			ContractID:  "",
 			Value:       verBuf,
 			Version:     ver + 1,
@@ -56,17 +68,20 @@ func incrementSignerCounters(st ReadOnlyStateTrie, ids []darc.Identity) (StateCh
 }
 
 // verifySignerCounters verifies whether the given counters are valid with
-// respect to the current counters.
-func verifySignerCounters(st ReadOnlyStateTrie, counters []uint64, ids []darc.Identity) error {
+// respect to the current counters of the given lanes.
+func verifySignerCounters(st ReadOnlyStateTrie, counters []uint64, ids []darc.Identity, lanes []uint64) error {
 	if len(counters) != len(ids) {
 		return xerrors.New("lengths of the counters and signatures are not the same")
 	}
+	if err := checkSignerLanes(lanes, ids); err != nil {
+		return err
+	}
 	for i, counter := range counters {
 		if !ids[i].PrimaryIdentity() {
 			return xerrors.New("not a primary identity")
 		}
 		id := ids[i].String()
-		c, err := getSignerCounter(st, id)
+		c, err := getSignerLaneCounter(st, id, signerLane(lanes, i))
 		if err != nil {
 			return xerrors.Errorf("reading counter: %v", err)
 		}
@@ -74,7 +89,8 @@ func verifySignerCounters(st ReadOnlyStateTrie, counters []uint64, ids []darc.Id
 		// to 0, this is the intended behaviour, otherwise the client
 		// will not be able to make more transactions.
 		if counter != c+1 {
-			return xerrors.Errorf("for pk %s, got counter=%v, but need %v", id, counter, c+1)
+			return xerrors.Errorf("for pk %s in lane %d, got counter=%v, but need %v",
+				id, signerLane(lanes, i), counter, c+1)
 		}
 	}
 	return nil
@@ -86,3 +102,45 @@ func publicVersionKey(id string) []byte {
 	h.Write([]byte(id))
 	return h.Sum(nil)
 }
+
+// signerLaneKey returns the key of the counter of a lane of the signer. The
+// lane 0 is the counter used by the instructions without lanes, so that
+// existing signers keep their counter.
+func signerLaneKey(id string, lane uint64) []byte {
+	if lane == 0 {
+		return publicVersionKey(id)
+	}
+	laneBuf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(laneBuf, lane)
+	h := sha256.New()
+	h.Write([]byte("signerlane_"))
+	h.Write(laneBuf)
+	h.Write([]byte(id))
+	return h.Sum(nil)
+}
+
+// signerLane returns the lane of the i-th signer, which is 0 if the lanes are
+// not given.
+func signerLane(lanes []uint64, i int) uint64 {
+	if len(lanes) == 0 {
+		return 0
+	}
+	return lanes[i]
+}
+
+// checkLanesVersion returns an error if the instruction uses lanes in a
+// block of a version that doesn't support them.
+func checkLanesVersion(instr Instruction) error {
+	if len(instr.SignerLanes) > 0 && instr.version < VersionSignerLanes {
+		return xerrors.Errorf("lanes are not supported before version %d",
+			VersionSignerLanes)
+	}
+	return nil
+}
+
+func checkSignerLanes(lanes []uint64, ids []darc.Identity) error {
+	if len(lanes) > 0 && len(lanes) != len(ids) {
+		return xerrors.New("lengths of the lanes and signatures are not the same")
+	}
+	return nil
+}
@@ -16,7 +16,7 @@ func TestReplayGuard(t *testing.T) {
 	for _, signer := range signers {
 		ids = append(ids, signer.Identity())
 	}
-	scs, err := incrementSignerCounters(sst, ids)
+	scs, err := incrementSignerCounters(sst, ids, nil)
 	require.NoError(t, err)
 	require.NoError(t, sst.StoreAll(scs))
 
@@ -26,16 +26,16 @@ func TestReplayGuard(t *testing.T) {
 	}
 
 	// check that they're 1 using getSignerCounter
-	ctr0, err := getSignerCounter(sst, signers[0].Identity().String())
+	ctr0, err := GetSignerCounter(sst, signers[0].Identity().String())
 	require.NoError(t, err)
 	require.Equal(t, uint64(1), ctr0)
 
-	ctr1, err := getSignerCounter(sst, signers[1].Identity().String())
+	ctr1, err := GetSignerCounter(sst, signers[1].Identity().String())
 	require.NoError(t, err)
 	require.Equal(t, uint64(1), ctr1)
 
 	// increment again, now the counter state is at 2
-	scs, err = incrementSignerCounters(sst, ids)
+	scs, err = incrementSignerCounters(sst, ids, nil)
 	require.NoError(t, err)
 	require.NoError(t, sst.StoreAll(scs))
 
@@ -45,6 +45,43 @@ func TestReplayGuard(t *testing.T) {
 	}
 
 	// verify, the new counter state must be 3
-	err = verifySignerCounters(sst, []uint64{3, 3}, ids)
+	err = verifySignerCounters(sst, []uint64{3, 3}, ids, nil)
 	require.NoError(t, err)
 }
+
+func TestReplayGuard_Lanes(t *testing.T) {
+	sst, err := newMemStagingStateTrie([]byte("my nonce"))
+	require.NoError(t, err)
+	signer := darc.NewSignerEd25519(nil, nil)
+	ids := []darc.Identity{signer.Identity()}
+
+	// every lane has its own counter
+	for _, lane := range []uint64{1, 2} {
+		require.NoError(t, verifySignerCounters(sst, []uint64{1}, ids, []uint64{lane}))
+		scs, err := incrementSignerCounters(sst, ids, []uint64{lane})
+		require.NoError(t, err)
+		require.NoError(t, sst.StoreAll(scs))
+	}
+	require.Error(t, verifySignerCounters(sst, []uint64{1}, ids, []uint64{1}))
+	require.NoError(t, verifySignerCounters(sst, []uint64{2}, ids, []uint64{2}))
+
+	// the lane 0 is the counter of the instructions without lanes
+	ctr, err := GetSignerCounter(sst, signer.Identity().String())
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), ctr)
+	require.NoError(t, verifySignerCounters(sst, []uint64{1}, ids, []uint64{0}))
+	require.NoError(t, verifySignerCounters(sst, []uint64{1}, ids, nil))
+
+	require.Error(t, verifySignerCounters(sst, []uint64{1}, ids, []uint64{1, 2}))
+}
+
+func TestCheckLanesVersion(t *testing.T) {
+	instr := Instruction{SignerLanes: []uint64{1}}
+	instr.version = VersionSignerLanes - 1
+	require.Error(t, checkLanesVersion(instr))
+	instr.version = VersionSignerLanes
+	require.NoError(t, checkLanesVersion(instr))
+
+	// The instructions without lanes are accepted in all the versions.
+	require.NoError(t, checkLanesVersion(Instruction{}))
+}
@@ -59,6 +59,10 @@ const defaultRotationWindow time.Duration = 10
 
 const noTimeout time.Duration = 0
 
+// maxProofKeys is the maximum number of keys that can be proven by one
+// GetProofs request.
+const maxProofKeys = 1000
+
 const collectTxProtocol = "CollectTxProtocol"
 
 const viewChangeSubFtCosi = "viewchange_sub_ftcosi"
@@ -116,6 +120,14 @@ type Service struct {
 	// We need to store the state changes for keeping track
 	// of the history of an instance
 	stateChangeStorage *stateChangeStorage
+	// txIndex holds the block and the position of every transaction
+	txIndex *txIndexStorage
+	// instanceIndex holds the instances by contract ID and by darc ID
+	instanceIndex *instanceIndexStorage
+	// checkpoints holds the signed checkpoints of the global state
+	checkpoints *checkpointStorage
+	// pruneLock prevents the blocks from being pruned concurrently
+	pruneLock sync.Mutex
 	// notifications is used for client transaction and block notification
 	notifications bcNotifications
 
@@ -124,6 +136,20 @@ type Service struct {
 	pollChan    map[string]chan bool
 	pollChanMut sync.Mutex
 	pollChanWG  sync.WaitGroup
+	// pushChan holds the channels giving the transactions received by the
+	// leader to its pipeline. It is protected by pollChanMut.
+	pushChan map[string]chan []ClientTransaction
+	// pushedTxs holds the hashes of the latest transactions received by
+	// the leader.
+	pushedTxs recentTxs
+	// querySlots limits the number of queries that run at the same time.
+	querySlots chan bool
+	// forwardSlots limits the number of forwards of transactions to the
+	// leader that are in flight.
+	forwardSlots chan bool
+	// admission counts the transactions received by the node that wait to
+	// be included.
+	admission txAdmission
 
 	// NOTE: If we have a lot of skipchains, then using mutex most likely
 	// will slow down our service, an improvement is to go-routines to
@@ -160,7 +186,13 @@ type Service struct {
 	catchingUpHistory     map[string]time.Time
 	catchingUpHistoryLock sync.Mutex
 
-	downloadState downloadState
+	downloadSessions downloadSessions
+
+	// gatewayServer is the HTTP server of the gateway, if it is running on
+	// gatewayAddr.
+	gatewayServer *http.Server
+	gatewayAddr   string
+	gatewayLock   sync.Mutex
 
 	rotationWindow time.Duration
 
@@ -172,14 +204,6 @@ type Service struct {
 	defaultVersionLock sync.Mutex
 }
 
-type downloadState struct {
-	id    skipchain.SkipBlockID
-	nonce uint64
-	read  chan DBKeyValue
-	stop  chan bool
-	total int
-}
-
 // storageID reflects the data we're storing - we could store more
 // than one structure.
 var storageID = []byte("ByzCoin")
@@ -196,6 +220,11 @@ type bcStorage struct {
 	// PropTimeout is used when sending the request to integrate a new block
 	// to all nodes.
 	PropTimeout time.Duration
+	// Node holds the settings of the node, set with SetNodeConfig.
+	Node NodeConfig
+	// NodeSequence is the sequence number of the last SetNodeConfig
+	// request.
+	NodeSequence uint64
 
 	sync.Mutex
 }
@@ -247,13 +276,42 @@ func (s *Service) CreateGenesisBlock(req *CreateGenesisBlock) (
 		return nil, xerrors.New("must provide a roster")
 	}
 
+	for _, c := range req.DarcContractIDs {
+		if _, ok := s.GetContractConstructor(c); !ok {
+			return nil, xerrors.New("the given contract \"" + c + "\" does not exist")
+		}
+	}
+
+	instr, err := NewGenesisInstruction(req)
+	if err != nil {
+		return nil, err
+	}
+	// Create the genesis-transaction with a special key, it acts as a
+	// reference to the actual genesis transaction.
+	ctx := ClientTransaction{Instructions: []Instruction{instr}}
+
+	sb, err := s.createNewBlock(nil, &req.Roster, NewTxResults(ctx))
+	if err != nil {
+		return nil, xerrors.Errorf("creating block: %v", err)
+	}
+
+	return &CreateGenesisBlockResponse{
+		Version:   CurrentVersion,
+		Skipblock: sb,
+	}, nil
+}
+
+// NewGenesisInstruction returns the instruction of the genesis block, which
+// spawns the configuration and the genesis darc of a new chain, with a new
+// nonce for the trie. The defaults are set in req.
+func NewGenesisInstruction(req *CreateGenesisBlock) (Instruction, error) {
 	darcBuf, err := req.GenesisDarc.ToProto()
 	if err != nil {
-		return nil, xerrors.Errorf("encoding darc: %v", err)
+		return Instruction{}, xerrors.Errorf("encoding darc: %v", err)
 	}
 	if req.GenesisDarc.Verify(true) != nil ||
 		req.GenesisDarc.Rules.Count() == 0 {
-		return nil, xerrors.New("invalid genesis darc")
+		return Instruction{}, xerrors.New("invalid genesis darc")
 	}
 
 	if req.BlockInterval == 0 {
@@ -270,18 +328,13 @@ func (s *Service) CreateGenesisBlock(req *CreateGenesisBlock) (
 
 	rosterBuf, err := protobuf.Encode(&req.Roster)
 	if err != nil {
-		return nil, xerrors.Errorf("encoding roster: %v", err)
+		return Instruction{}, xerrors.Errorf("encoding roster: %v", err)
 	}
 
 	// The user must include at least one contract that can be parsed as a
-	// DARC and it must exist.
+	// DARC.
 	if len(req.DarcContractIDs) == 0 {
-		return nil, xerrors.New("must provide at least one DARC contract")
-	}
-	for _, c := range req.DarcContractIDs {
-		if _, ok := s.GetContractConstructor(c); !ok {
-			return nil, xerrors.New("the given contract \"" + c + "\" does not exist")
-		}
+		return Instruction{}, xerrors.New("must provide at least one DARC contract")
 	}
 
 	dcIDs := darcContractIDs{
@@ -289,7 +342,7 @@ func (s *Service) CreateGenesisBlock(req *CreateGenesisBlock) (
 	}
 	darcContractIDsBuf, err := protobuf.Encode(&dcIDs)
 	if err != nil {
-		return nil, xerrors.Errorf("encoding id: %v", err)
+		return Instruction{}, xerrors.Errorf("encoding id: %v", err)
 	}
 
 	// This is the nonce for the trie.
@@ -308,25 +361,9 @@ func (s *Service) CreateGenesisBlock(req *CreateGenesisBlock) (
 		},
 	}
 
-	// Create the genesis-transaction with a special key, it acts as a
-	// reference to the actual genesis transaction.
-	ctx := ClientTransaction{
-		Instructions: []Instruction{
-			{
-				InstanceID: ConfigInstanceID,
-				Spawn:      spawnGenesis,
-			},
-		},
-	}
-
-	sb, err := s.createNewBlock(nil, &req.Roster, NewTxResults(ctx))
-	if err != nil {
-		return nil, xerrors.Errorf("creating block: %v", err)
-	}
-
-	return &CreateGenesisBlockResponse{
-		Version:   CurrentVersion,
-		Skipblock: sb,
+	return Instruction{
+		InstanceID: ConfigInstanceID,
+		Spawn:      spawnGenesis,
 	}, nil
 }
 
@@ -413,15 +450,54 @@ func (s *Service) AddTransaction(req *AddTxRequest) (*AddTxResponse, error) {
 	// to use the correct hash function.
 	req.Transaction.Instructions.SetVersion(header.Version)
 
-	_, maxsz, err := s.LoadBlockInfo(req.SkipchainID)
+	// A transaction outside of its validity window is not added to the
+	// buffer, so that it cannot be included in a later block.
+	if err := req.Transaction.Validity.check(latest.Index+1, header.Timestamp); err != nil {
+		return &AddTxResponse{
+			Version: CurrentVersion,
+			Error:   fmt.Sprintf("transaction refused: %v", err),
+		}, nil
+	}
+
+	config, err := s.LoadConfig(req.SkipchainID)
 	if err != nil {
-		return nil, xerrors.Errorf("loading block info: %v", err)
+		return nil, xerrors.Errorf("loading config: %v", err)
 	}
 	txsz := txSize(TxResult{ClientTransaction: req.Transaction})
-	if txsz > maxsz {
+	if txsz > config.MaxBlockSize {
 		return nil, xerrors.New("transaction too large")
 	}
 
+	var interval time.Duration
+	if req.InclusionWait > 0 {
+		interval, _, err = s.LoadBlockInfo(req.SkipchainID)
+		if err != nil {
+			return nil, xerrors.Errorf("couldn't get block info: %v", err)
+		}
+	}
+
+	// The transaction is counted until it is included, so that a signer
+	// cannot fill the buffers of the nodes. It is admitted after all the
+	// checks that can fail, so that it is always either queued or released.
+	key := string(req.SkipchainID)
+	err = s.admission.admit(key, req.Transaction, latest.Index, config.TxQuota)
+	if err != nil {
+		return &AddTxResponse{
+			Version: CurrentVersion,
+			Error:   fmt.Sprintf("transaction refused: %v", err),
+		}, nil
+	}
+	queueTx := func() *AddTxResponse {
+		if err := s.queueTx(req.SkipchainID, req.Transaction); err != nil {
+			s.admission.release(key, req.Transaction)
+			return &AddTxResponse{
+				Version: CurrentVersion,
+				Error:   fmt.Sprintf("transaction refused: %v", err),
+			}
+		}
+		return nil
+	}
+
 	for i, instr := range req.Transaction.Instructions {
 		log.Lvlf2("Instruction[%d]: %s on instance ID %s", i, instr.Action(), instr.InstanceID.String())
 	}
@@ -439,16 +515,13 @@ func (s *Service) AddTransaction(req *AddTxRequest) (*AddTxResponse, error) {
 		defer s.working.Done()
 
 		// Wait for InclusionWait new blocks and look if our transaction is in it.
-		interval, _, err := s.LoadBlockInfo(req.SkipchainID)
-		if err != nil {
-			return nil, xerrors.Errorf("couldn't get block info: %v", err)
-		}
-
 		ctxHash := req.Transaction.Instructions.Hash()
 		ch := s.notifications.registerForBlocks()
 		defer s.notifications.unregisterForBlocks(ch)
 
-		s.txBuffer.add(string(req.SkipchainID), req.Transaction)
+		if resp := queueTx(); resp != nil {
+			return resp, nil
+		}
 
 		// In case we don't have any blocks, because there are no transactions,
 		// have a hard timeout in twice the minimal expected time to create the
@@ -467,6 +540,14 @@ func (s *Service) AddTransaction(req *AddTxRequest) (*AddTxResponse, error) {
 
 				if notif.block.SkipChainID().Equal(req.SkipchainID) {
 					blocksLeft--
+
+					// The transaction will be dropped if it expired.
+					if err := checkExpired(req.Transaction, notif.block); err != nil {
+						return &AddTxResponse{
+							Version: CurrentVersion,
+							Error:   fmt.Sprintf("transaction dropped: %v", err),
+						}, nil
+					}
 				}
 				if blocksLeft == 0 {
 					return nil, xerrors.Errorf("did not find transaction after %v blocks", req.InclusionWait)
@@ -475,15 +556,70 @@ func (s *Service) AddTransaction(req *AddTxRequest) (*AddTxResponse, error) {
 				return nil, xerrors.Errorf("transaction didn't get included after %v (2 * t_block * %d)", tooLongDur, req.InclusionWait)
 			}
 		}
-	} else {
-		s.txBuffer.add(string(req.SkipchainID), req.Transaction)
+	} else if resp := queueTx(); resp != nil {
+		return resp, nil
 	}
 
 	return &AddTxResponse{Version: CurrentVersion}, nil
 }
 
+// SimulateTransaction runs the given transaction against the latest state of
+// the ledger, using the same path as a transaction that is included in a
+// block. The transaction is not added to the transaction buffer and the state
+// is not modified. Like for AddTransaction, the caller must check
+// SimulateTxResponse.Error to find out if the transaction would be refused.
+func (s *Service) SimulateTransaction(req *SimulateTxRequest) (*SimulateTxResponse, error) {
+	if len(req.Transaction.Instructions) == 0 {
+		return nil, xerrors.New("no instructions to simulate")
+	}
+
+	gen := s.db().GetByID(req.SkipchainID)
+	if gen == nil || gen.Index != 0 {
+		return nil, xerrors.New("skipchain ID does not exist")
+	}
+
+	latest, err := s.db().GetLatest(gen)
+	if err != nil {
+		return nil, xerrors.Errorf("reading latest block: %v", err)
+	}
+
+	header, err := decodeBlockHeader(latest)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding header: %v", err)
+	}
+
+	if req.Version < 2 && header.Version >= 2 {
+		return nil, xerrors.New("invalid client version below 2")
+	}
+	req.Transaction.Instructions.SetVersion(header.Version)
+
+	st, err := s.getStateTrie(req.SkipchainID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting state trie: %v", err)
+	}
+
+	resp := &SimulateTxResponse{Version: CurrentVersion}
+	scs, events, cout, _, idx, err := s.runOneTx(st.MakeStagingStateTrie(),
+		req.Transaction, req.SkipchainID)
+	if err != nil {
+		// As for AddTransaction, the error is returned in the response so
+		// that its length is not limited.
+		resp.Error = err.Error()
+		resp.ErrorIndex = idx
+		return resp, nil
+	}
+	resp.StateChanges = scs
+	resp.Coins = cout
+	resp.Events = events
+
+	return resp, nil
+}
+
 // GetProof searches for a key and returns a proof of the
-// presence or the absence of this key.
+// presence or the absence of this key. If AtBlockID or AtIndex is given, the
+// proof is made against the state of that past block, as long as the state
+// changes needed to rebuild it are still in the storage and the block is not
+// more than maxHistoryBlocks behind the latest one.
 func (s *Service) GetProof(req *GetProof) (*GetProofResponse, error) {
 	s.catchingLock.Lock()
 	s.updateTrieLock.Lock()
@@ -503,7 +639,7 @@ func (s *Service) GetProof(req *GetProof) (*GetProofResponse, error) {
 	if sb == nil {
 		return nil, xerrors.New("cannot find skipblock while getting proof")
 	}
-	st, err := s.GetReadOnlyStateTrie(sb.SkipChainID())
+	st, err := s.proofStateTrie(sb, req.AtBlockID, req.AtIndex)
 	if err != nil {
 		return nil, xerrors.Errorf("getting state trie: %w", err)
 	}
@@ -531,6 +667,86 @@ func (s *Service) GetProof(req *GetProof) (*GetProofResponse, error) {
 	}, nil
 }
 
+// GetProofs works like GetProof, but returns a single proof for all the keys of
+// the request, which is smaller than one proof per key.
+func (s *Service) GetProofs(req *GetProofs) (*GetProofsResponse, error) {
+	if len(req.Keys) == 0 {
+		return nil, xerrors.New("no keys in the request")
+	}
+	if len(req.Keys) > maxProofKeys {
+		return nil, xerrors.Errorf("cannot prove more than %d keys at once",
+			maxProofKeys)
+	}
+
+	s.catchingLock.Lock()
+	s.updateTrieLock.Lock()
+
+	defer func() {
+		s.updateTrieLock.Unlock()
+		s.catchingLock.Unlock()
+	}()
+
+	s.closedMutex.Lock()
+	defer s.closedMutex.Unlock()
+	if s.closed {
+		return nil, xerrors.New("cannot get proof while in closed state")
+	}
+
+	sb := s.db().GetByID(req.ID)
+	if sb == nil {
+		return nil, xerrors.New("cannot find skipblock while getting proof")
+	}
+	st, err := s.proofStateTrie(sb, req.AtBlockID, req.AtIndex)
+	if err != nil {
+		return nil, xerrors.Errorf("getting state trie: %w", err)
+	}
+	proof, err := NewMultiProof(st, s.db(), req.ID, req.Keys)
+	if err != nil {
+		return nil, xerrors.Errorf("making proof: %w", err)
+	}
+
+	log.Lvlf2("%s: Returning proof for %d keys from chain %x at index %v",
+		s.ServerIdentity(), len(req.Keys), sb.SkipChainID(), proof.Latest.Index)
+	return &GetProofsResponse{
+		Version: CurrentVersion,
+		Proof:   *proof,
+	}, nil
+}
+
+// proofStateTrie returns the state trie of the chain of sb that proofs are
+// made against: the state after the block atBlockID or after the block with
+// index atIndex if one of them is set, else the latest state. The caller must
+// hold the updateTrieLock.
+func (s *Service) proofStateTrie(sb *skipchain.SkipBlock, atBlockID skipchain.SkipBlockID,
+	atIndex int) (ReadOnlyStateTrie, error) {
+	if len(atBlockID) == 0 && atIndex <= 0 {
+		return s.GetReadOnlyStateTrie(sb.SkipChainID())
+	}
+
+	// The proof is requested for a past state, which needs to be
+	// rebuilt from the state changes.
+	at := s.db().GetByID(atBlockID)
+	if len(atBlockID) == 0 {
+		reply, err := s.skService().GetSingleBlockByIndex(
+			&skipchain.GetSingleBlockByIndex{
+				Genesis: sb.SkipChainID(),
+				Index:   atIndex,
+			})
+		if err != nil {
+			return nil, xerrors.Errorf("getting block: %v", err)
+		}
+		at = reply.SkipBlock
+	}
+	if at == nil || !at.SkipChainID().Equal(sb.SkipChainID()) {
+		return nil, xerrors.New("cannot find the requested block in the chain")
+	}
+	st, err := s.getHistoricalStateTrie(at)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
 // CheckAuthorization verifies whether a given combination of identities can
 // fulfill a given rule of a given darc. Because all darcs are now used in
 // an online fashion, we need to offer this check.
@@ -582,12 +798,15 @@ func (s *Service) GetSignerCounters(req *GetSignerCounters) (*GetSignerCountersR
 	if err != nil {
 		return nil, xerrors.Errorf("getting trie: %v", err)
 	}
+	if len(req.SignerLanes) > 0 && len(req.SignerLanes) != len(req.SignerIDs) {
+		return nil, xerrors.New("lengths of the lanes and signers are not the same")
+	}
 	out := make([]uint64, len(req.SignerIDs))
 
 	for i := range req.SignerIDs {
-		key := publicVersionKey(req.SignerIDs[i])
+		key := signerLaneKey(req.SignerIDs[i], signerLane(req.SignerLanes, i))
 		buf, _, _, _, err := st.GetValues(key)
-		if xerrors.Is(err, errKeyNotSet) {
+		if xerrors.Is(err, ErrKeyNotSet) {
 			out[i] = 0
 			continue
 		}
@@ -605,81 +824,54 @@ func (s *Service) GetSignerCounters(req *GetSignerCounters) (*GetSignerCountersR
 }
 
 // DownloadState creates a snapshot of the current state and then returns the
-// instances in small chunks.
+// instances in small chunks. Many downloads can be served at the same time,
+// each one being a session identified by its nonce.
 func (s *Service) DownloadState(req *DownloadState) (resp *DownloadStateResponse, err error) {
-	s.catchingLock.Lock()
-	defer s.catchingLock.Unlock()
 	if req.Length <= 0 {
 		return nil, xerrors.New("length must be bigger than 0")
 	}
 
-	if req.Nonce == 0 {
+	nonce := req.Nonce
+	var ds *downloadSession
+	if nonce == 0 {
 		log.Lvl2(s.ServerIdentity(), "Creating new download")
-		if !s.downloadState.id.IsNull() {
-			log.Lvlf2("Aborting download of nonce %x", s.downloadState.nonce)
-			close(s.downloadState.stop)
-		}
 		sb := s.db().GetByID(req.ByzCoinID)
 		if sb == nil || sb.Index > 0 {
 			return nil, xerrors.New("unknown byzcoinID")
 		}
-		s.downloadState.id = req.ByzCoinID
-		s.downloadState.read = make(chan DBKeyValue)
-		s.downloadState.stop = make(chan bool)
-		nonce := binary.LittleEndian.Uint64(random.Bits(64, true, random.New()))
-		s.downloadState.nonce = nonce
-		total := make(chan int)
-		go func(ds downloadState) {
-			idStr := fmt.Sprintf("%x", ds.id)
-			db, bucketName := s.GetAdditionalBucket([]byte(idStr))
-			err := db.View(func(tx *bbolt.Tx) error {
-				bucket := tx.Bucket(bucketName)
-				total <- bucket.Stats().KeyN
-				return bucket.ForEach(func(k []byte, v []byte) error {
-					key := make([]byte, len(k))
-					copy(key, k)
-					value := make([]byte, len(v))
-					copy(value, v)
-					select {
-					case ds.read <- DBKeyValue{key, value}:
-					case <-ds.stop:
-						return xerrors.New("closed")
-					case <-time.After(time.Minute):
-						return xerrors.New("timed out while waiting for next read")
-					}
-					return nil
-				})
-			})
-			if err != nil {
-				log.Error("while serving current database:", err)
-			}
-			close(ds.read)
-		}(s.downloadState)
-		s.downloadState.total = <-total
-	} else if !s.downloadState.id.Equal(req.ByzCoinID) || req.Nonce != s.downloadState.nonce {
-		return nil, xerrors.New("download has been aborted in favor of another download")
+		if err := s.verifyDownloader(req); err != nil {
+			return nil, xerrors.Errorf("refusing download: %v", err)
+		}
+		// Don't create a snapshot of a state that is being replaced.
+		s.catchingLock.Lock()
+		db, bucketName := s.GetAdditionalBucket([]byte(fmt.Sprintf("%x", req.ByzCoinID)))
+		nonce, ds, err = s.downloadSessions.open(req.ByzCoinID, db, bucketName)
+		s.catchingLock.Unlock()
+		if err != nil {
+			return nil, xerrors.Errorf("creating download: %v", err)
+		}
+	} else {
+		ds, err = s.downloadSessions.get(req.ByzCoinID, nonce)
+		if err != nil {
+			return nil, xerrors.Errorf("getting download: %v", err)
+		}
 	}
 
-	resp = &DownloadStateResponse{
-		Nonce: s.downloadState.nonce,
-		Total: s.downloadState.total,
-	}
-query:
-	for i := 0; i < req.Length; i++ {
-		select {
-		case kv, ok := <-s.downloadState.read:
-			if !ok {
-				break query
-			}
-			resp.KeyValues = append(resp.KeyValues, kv)
-		}
+	kvs, err := ds.read(req.Start, req.End, req.Length)
+	if err != nil {
+		return nil, xerrors.Errorf("reading state: %v", err)
 	}
-	return
+	return &DownloadStateResponse{
+		KeyValues: kvs,
+		Nonce:     nonce,
+		Total:     ds.total,
+		Index:     ds.index,
+	}, nil
 }
 
 func entryToResponse(sce *StateChangeEntry, ok bool, err error) (*GetInstanceVersionResponse, error) {
 	if !ok {
-		err = errKeyNotSet
+		err = ErrKeyNotSet
 	}
 	if err != nil {
 		return nil, cothority.WrapError(err)
@@ -731,7 +923,7 @@ func (s *Service) GetAllInstanceVersion(req *GetAllInstanceVersion) (res *GetAll
 func (s *Service) CheckStateChangeValidity(req *CheckStateChangeValidity) (*CheckStateChangeValidityResponse, error) {
 	sce, ok, err := s.stateChangeStorage.getByVersion(req.InstanceID[:], req.Version, req.SkipChainID)
 	if !ok {
-		err = errKeyNotSet
+		err = ErrKeyNotSet
 	}
 	if err != nil {
 		return nil, cothority.WrapError(err)
@@ -761,6 +953,82 @@ func (s *Service) CheckStateChangeValidity(req *CheckStateChangeValidity) (*Chec
 	}, nil
 }
 
+// GetTxStatus looks up the block holding the transaction with the given hash
+// and returns the transaction, the block and the forward links from the
+// genesis block to this block, so that the client can verify it.
+func (s *Service) GetTxStatus(req *GetTxStatus) (*GetTxStatusResponse, error) {
+	entry, ok, err := s.txIndex.get(req.SkipChainID, req.TxHash)
+	if err != nil {
+		return nil, xerrors.Errorf("reading index: %v", err)
+	}
+	if !ok {
+		return nil, xerrors.New("transaction not found")
+	}
+
+	txs, sb, err := s.getBlockTx(entry.BlockID)
+	if xerrors.Is(err, errBlockPruned) {
+		reply := &GetTxStatusResponse{}
+		err = s.forwardToArchive(req.SkipChainID, req, reply)
+		if err != nil {
+			return nil, xerrors.Errorf("forwarding request: %v", err)
+		}
+		return reply, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("getting block: %v", err)
+	}
+	if entry.TxIndex >= len(txs) {
+		return nil, xerrors.New("index of the transaction is out of range")
+	}
+
+	links, _, err := newForwardLinks(s.db(), req.SkipChainID, sb.Index)
+	if err != nil {
+		return nil, xerrors.Errorf("getting forward links: %v", err)
+	}
+
+	return &GetTxStatusResponse{
+		TxResult: txs[entry.TxIndex],
+		TxIndex:  entry.TxIndex,
+		Block:    *sb,
+		Links:    links,
+	}, nil
+}
+
+// ListInstances returns a page of the instances of a contract, or of the
+// instances guarded by a darc, as found in the instance index of the node.
+func (s *Service) ListInstances(req *ListInstances) (*ListInstancesResponse, error) {
+	if (req.ContractID == "") == (len(req.DarcID) == 0) {
+		return nil, xerrors.New("exactly one of contract ID and darc ID must be set")
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+
+	iids, next, index, err := s.instanceIndex.list(req.SkipChainID, req.ContractID,
+		req.DarcID, req.Start, limit)
+	if err != nil {
+		return nil, xerrors.Errorf("reading index: %v", err)
+	}
+
+	return &ListInstancesResponse{
+		InstanceIDs: iids,
+		Next:        next,
+		Index:       index,
+	}, nil
+}
+
+// RebuildInstanceIndex replaces the instance index of the skipchain with the
+// instances of the given trie. It can be used with the trie returned by
+// ReplayState.
+func (s *Service) RebuildInstanceIndex(scID skipchain.SkipBlockID, st ReadOnlyStateTrie) error {
+	return cothority.ErrorOrNil(s.instanceIndex.rebuild(scID, st), "rebuilding index")
+}
+
 // ResolveInstanceID resolves the instance ID using the given request. The name
 // must be already set by calling the naming contract.
 func (s *Service) ResolveInstanceID(req *ResolveInstanceID) (*ResolvedInstanceID, error) {
@@ -769,30 +1037,100 @@ func (s *Service) ResolveInstanceID(req *ResolveInstanceID) (*ResolvedInstanceID
 		return nil, xerrors.Errorf("getting trie: %v", err)
 	}
 
-	if len(req.DarcID) == 0 {
-		return nil, xerrors.New("darc ID must be set")
+	iid, err := resolveName(st, req.DarcID, req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ResolvedInstanceID{iid}, nil
+}
+
+const (
+	// queryTimeout is the maximum time a query can run.
+	queryTimeout = 5 * time.Second
+	// queryBudget limits the reads of the global state of a query, with the
+	// costs of the metering of the instructions.
+	queryBudget = 1e7
+)
+
+// maxQueriesInFlight is the number of queries that can run at the same time.
+var maxQueriesInFlight = 16
+
+// QueryInstance runs a read-only query on an instance using the latest state.
+// The contract of the instance must implement ContractQuerier. The query is
+// refused if it panics, runs for longer than queryTimeout or reads too much of
+// the global state.
+func (s *Service) QueryInstance(req *QueryInstance) (*QueryInstanceResponse, error) {
+	st, err := s.GetReadOnlyStateTrie(req.SkipChainID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting trie: %v", err)
+	}
+
+	val, _, contractID, _, err := st.GetValues(req.InstanceID.Slice())
+	if err != nil {
+		return nil, xerrors.Errorf("reading trie: %w", err)
 	}
 
-	h := sha256.New()
-	h.Write(req.DarcID)
-	h.Write([]byte{'/'})
-	h.Write([]byte(req.Name))
-	key := NewInstanceID(h.Sum(nil))
-	val, _, _, _, err := st.GetValues(key[:])
+	registry := s.getContractRegistry(st)
+	fn, exists := registry.Search(contractID)
+	if !exists {
+		return nil, xerrors.Errorf("contract %s does not exist", contractID)
+	}
+	c, err := fn(val)
 	if err != nil {
-		return nil, xerrors.Errorf("reading trie: %v", err)
+		return nil, xerrors.Errorf("making contract: %v", err)
+	}
+	if cwr, ok := c.(ContractWithRegistry); ok {
+		cwr.SetRegistry(registry)
+	}
+	q, ok := c.(ContractQuerier)
+	if !ok {
+		return nil, xerrors.Errorf("contract %s doesn't support queries", contractID)
+	}
+
+	select {
+	case s.querySlots <- true:
+	default:
+		return nil, xerrors.New("too many queries are running")
 	}
 
-	valStruct := contractNamingEntry{}
-	if err := protobuf.Decode(val, &valStruct); err != nil {
-		return nil, xerrors.Errorf("decoding contract: %v", err)
+	// The contract cannot be interrupted, so a query over the timeout
+	// keeps its slot until it returns, and its reads of the global state
+	// are limited by the meter.
+	type queryResult struct {
+		res []byte
+		err error
 	}
+	done := make(chan queryResult, 1)
+	go func() {
+		defer func() { <-s.querySlots }()
+		var qr queryResult
+		defer func() {
+			if re := recover(); re != nil {
+				qr.err = xerrors.Errorf("query panicked: %v", re)
+			}
+			done <- qr
+		}()
+		mst := &meteredStateTrie{st, &meter{budget: queryBudget}}
+		gs := globalState{mst, newROSkipChain(s.skService(), req.SkipChainID), nil, nil}
+		qr.res, qr.err = q.Query(gs, req.InstanceID, req.Method, req.Args)
+	}()
 
-	if valStruct.Removed {
-		return nil, cothority.WrapError(errKeyNotSet)
+	var res []byte
+	select {
+	case qr := <-done:
+		if qr.err != nil {
+			return nil, xerrors.Errorf("query %s.%s failed: %v", contractID, req.Method, qr.err)
+		}
+		res = qr.res
+	case <-time.After(queryTimeout):
+		return nil, xerrors.Errorf("query %s.%s timed out", contractID, req.Method)
 	}
 
-	return &ResolvedInstanceID{valStruct.IID}, nil
+	return &QueryInstanceResponse{
+		Result: res,
+		Index:  st.GetIndex(),
+	}, nil
 }
 
 type leafNode struct {
@@ -1136,7 +1474,9 @@ func (s *Service) createUpgradeVersionBlock(scID skipchain.SkipBlockID, version
 
 // downloadDB downloads the full database over the network from a remote block.
 // It does so by copying the bboltDB database entry by entry over the network,
-// and recreating it on the remote side.
+// from many nodes in parallel, and recreating it on the remote side. The
+// database is only used once its root has been verified against the one of
+// the corresponding block.
 // sb is a block in the byzcoin instance that we want
 // to download.
 func (s *Service) downloadDB(sb *skipchain.SkipBlock) error {
@@ -1144,69 +1484,46 @@ func (s *Service) downloadDB(sb *skipchain.SkipBlock) error {
 	idStr := fmt.Sprintf("%x", sb.SkipChainID())
 
 	err := func() error {
-		// First delete an existing stateTrie. There
-		// cannot be another write-access to the
-		// database because of catchingLock.
-		_, err := s.getStateTrie(sb.SkipChainID())
-		if err == nil {
-			// Suppose we _do_ have a statetrie
-			db, stBucket := s.GetAdditionalBucket(sb.SkipChainID())
-			err := db.Update(func(tx *bbolt.Tx) error {
-				return cothority.ErrorOrNil(tx.DeleteBucket(stBucket), "deleting bucket")
-			})
-			if err != nil {
-				return xerrors.Errorf("Cannot delete existing trie while trying to download: %v", err)
-			}
-			s.stateTriesLock.Lock()
-			delete(s.stateTries, idStr)
-			s.stateTriesLock.Unlock()
+		// The state is downloaded in a temporary bucket, so that the
+		// current state is kept if the download fails.
+		db, tmpName := s.GetAdditionalBucket([]byte(idStr + downloadBucketSuffix))
+		if err := clearBucket(db, tmpName); err != nil {
+			return xerrors.Errorf("couldn't prepare download: %v", err)
 		}
 
 		// Then start downloading the stateTrie over the network.
 		cl := NewClient(sb.SkipChainID(), *sb.Roster)
-		cl.DontContact(s.ServerIdentity())
-		var db *bbolt.DB
-		var bucketName []byte
-		var nonce uint64
-		var cursor int
-		for {
-			// Note: we trust the chain therefore even if the reply is corrupted,
-			// it will be detected by difference in the root hash
-			resp, err := cl.DownloadState(sb.SkipChainID(), nonce, catchupFetchDBEntries)
-			if err != nil {
-				return xerrors.Errorf("cannot download trie: %v", err)
-			}
-			log.Lvlf1("Downloaded key/values %d..%d of %d from %s", cursor, cursor+len(resp.KeyValues), resp.Total,
-				cl.noncesSI[resp.Nonce])
-			cursor += len(resp.KeyValues)
-			if db == nil {
-				db, bucketName = s.GetAdditionalBucket([]byte(idStr))
-				nonce = resp.Nonce
-			}
-			// And store all entries in our local database.
-			err = db.Update(func(tx *bbolt.Tx) error {
-				bucket := tx.Bucket(bucketName)
-				for _, kv := range resp.KeyValues {
-					err := bucket.Put(kv.Key, kv.Value)
-					if err != nil {
+		nodes, err := s.openDownloadSessions(cl, sb.Roster)
+		if err != nil {
+			return xerrors.Errorf("cannot download trie: %v", err)
+		}
+		log.Lvlf1("%s: downloading state of block %d from %d nodes",
+			s.ServerIdentity(), nodes[0].index, len(nodes))
+		err = s.downloadState(cl, nodes, func(kvs []DBKeyValue) error {
+			err := db.Update(func(tx *bbolt.Tx) error {
+				bucket := tx.Bucket(tmpName)
+				for _, kv := range kvs {
+					if err := bucket.Put(kv.Key, kv.Value); err != nil {
 						return err
 					}
 				}
 				return nil
 			})
-			if err != nil {
-				return xerrors.Errorf("couldn't store entries: %v", err)
-			}
-			if len(resp.KeyValues) < catchupFetchDBEntries {
-				break
-			}
+			return cothority.ErrorOrNil(err, "tx error")
+		})
+		if err != nil {
+			return xerrors.Errorf("cannot download trie: %v", err)
 		}
 
 		// Check the new trie is correct
-		st, err := loadStateTrie(db, bucketName)
+		st, err := loadStateTrie(db, tmpName)
 		if err != nil {
 			return xerrors.Errorf("couldn't load state trie: %v", err)
 		}
+		if st.GetIndex() != nodes[0].index {
+			return xerrors.Errorf("got state of block %d instead of %d",
+				st.GetIndex(), nodes[0].index)
+		}
 		skCl := skipchain.NewClient()
 		skCl.DontContact(s.ServerIdentity())
 		if sb.Index != st.GetIndex() {
@@ -1226,11 +1543,30 @@ func (s *Service) downloadDB(sb *skipchain.SkipBlock) error {
 		if !bytes.Equal(st.GetRoot(), header.TrieRoot) {
 			return xerrors.New("got wrong database, merkle roots don't work out")
 		}
+		// The root is only stored in the database, so all the nodes
+		// must be checked against it.
+		if err := st.IsValid(); err != nil {
+			return xerrors.Errorf("got invalid database: %v", err)
+		}
 
-		// Finally initialize the stateTrie using the new database.
+		// Finally replace the stateTrie with the new database. There
+		// cannot be another write-access to the database because of
+		// catchingLock.
+		_, stName := s.GetAdditionalBucket([]byte(idStr))
 		s.stateTriesLock.Lock()
-		s.stateTries[idStr] = st
+		delete(s.stateTries, idStr)
+		err = moveBucket(db, tmpName, stName)
+		if err == nil {
+			st, err = loadStateTrie(db, stName)
+		}
+		if err == nil {
+			s.stateTries[idStr] = st
+		}
 		s.stateTriesLock.Unlock()
+		if err != nil {
+			return xerrors.Errorf("couldn't store state trie: %v", err)
+		}
+
 		chain, err := skCl.GetUpdateChain(sb.Roster, sb.SkipChainID())
 		if err != nil {
 			return xerrors.Errorf("getting chain: %v", err)
@@ -1595,6 +1931,23 @@ func (s *Service) updateTrieCallback(sbID skipchain.SkipBlockID) error {
 			"mean that the db is broken.")
 	}
 
+	// createStateChanges already set the version of the transactions, so
+	// that the hashes used as keys are correct.
+	err = s.txIndex.add(sb, body.TxResults)
+	if err != nil {
+		log.Error(err)
+		panic("Couldn't add the transactions to the index - this might " +
+			"mean that the db is broken.")
+	}
+	s.admission.included(string(sb.SkipChainID()), sb.Index, body.TxResults)
+
+	// The instance index is rebuilt from the trie on the next block if
+	// the update fails, so the error is not fatal.
+	err = s.instanceIndex.update(sb.SkipChainID(), scs, sb.Index, st)
+	if err != nil {
+		log.Error(s.ServerIdentity(), "couldn't update the instance index:", err)
+	}
+
 	// If we are adding a genesis block, then look into it for the darc ID
 	// and add it to the darcToSc hash map.
 	if sb.Index == 0 {
@@ -1643,6 +1996,15 @@ func (s *Service) updateTrieCallback(sbID skipchain.SkipBlockID) error {
 	}
 	s.pollChanMut.Unlock()
 
+	// After a view change, the transactions that were waiting for the
+	// previous leader are sent to the new one.
+	if nodeInNew && !nodeIsLeader && !s.catchingUp && sb.Index > 0 {
+		prev := s.db().GetByID(sb.BackLinkIDs[0])
+		if prev != nil && !prev.Roster.List[0].Equal(bcConfig.Roster.List[0]) {
+			s.flushTxs(sb.SkipChainID())
+		}
+	}
+
 	// Check if viewchange needs to be started/stopped
 	// Check whether the heartbeat monitor exists, if it doesn't we start a
 	// new one
@@ -1693,7 +2055,33 @@ func (s *Service) updateTrieCallback(sbID skipchain.SkipBlockID) error {
 	s.notifications.informBlock(sb, body.TxResults)
 
 	// At this point everything should be stored.
-	s.streamingMan.notify(string(sb.SkipChainID()), sb)
+	s.streamingMan.notify(string(sb.SkipChainID()), sb,
+		s.blockDarcLookup(sb.SkipChainID(), sb.Index, st))
+
+	// The leader of the block gets the state signed by the roster every
+	// CheckpointInterval blocks.
+	cpi := bcConfig.CheckpointInterval
+	if cpi > 0 && sb.Index > 0 && sb.Index%cpi == 0 && !s.catchingUp &&
+		sb.Roster.List[0].Equal(s.ServerIdentity()) {
+		s.working.Add(1)
+		go func() {
+			defer s.working.Done()
+			if err := s.createCheckpoint(sb); err != nil {
+				log.Errorf("%s couldn't create checkpoint of block %d: %v",
+					s.ServerIdentity(), sb.Index, err)
+			}
+		}()
+	}
+
+	if s.pruneDepth() > 0 && !s.catchingUp {
+		s.working.Add(1)
+		go func() {
+			defer s.working.Done()
+			if err := s.pruneBlocks(sb, bcConfig); err != nil {
+				log.Errorf("%s couldn't prune blocks: %v", s.ServerIdentity(), err)
+			}
+		}()
+	}
 
 	log.Lvlf2("%s updated trie for %x with root %x", s.ServerIdentity(), sb.SkipChainID(), st.GetRoot())
 	return nil
@@ -1845,7 +2233,7 @@ func (s *Service) LoadBlockInfo(scID skipchain.SkipBlockID) (time.Duration, int,
 func loadBlockInfo(st ReadOnlyStateTrie) (time.Duration, int, error) {
 	config, err := LoadConfigFromTrie(st)
 	if err != nil {
-		if xerrors.Is(err, errKeyNotSet) {
+		if xerrors.Is(err, ErrKeyNotSet) {
 			err = nil
 		}
 		return defaultInterval, defaultMaxBlockSize, err
@@ -1853,10 +2241,15 @@ func loadBlockInfo(st ReadOnlyStateTrie) (time.Duration, int, error) {
 	return config.BlockInterval, config.MaxBlockSize, nil
 }
 
+// startPolling starts the pipeline creating the blocks of the chain. The
+// caller must hold pollChanMut.
 func (s *Service) startPolling(scID skipchain.SkipBlockID) chan bool {
+	pushed := make(chan []ClientTransaction, pushChanSize)
+	s.pushChan[string(scID)] = pushed
 	pipeline := txPipeline{
 		processor: &defaultTxProcessor{
 			stopCollect: make(chan bool),
+			pushed:      pushed,
 			scID:        scID,
 			Service:     s,
 		},
@@ -2002,6 +2395,12 @@ func (s *Service) verifySkipBlock(newID []byte, newSB *skipchain.SkipBlock) bool
 		log.Lvl2(s.ServerIdentity(), "Client Transaction Hash doesn't verify")
 		return false
 	}
+	// The body stored in the block must hold the same events as the ones
+	// computed here, else the stored events would not match the header.
+	if bytes.Compare(header.ClientTransactionHash, body.TxResults.Hash()) != 0 {
+		log.Lvl2(s.ServerIdentity(), "Client Transaction Hash doesn't match the stored results")
+		return false
+	}
 
 	if bytes.Compare(header.TrieRoot, mtr) != 0 {
 		log.Lvl2(s.ServerIdentity(), "Trie root doesn't verify")
@@ -2104,14 +2503,28 @@ func (s *Service) createStateChanges(sst *stagingStateTrie, scID skipchain.SkipB
 
 	sstTemp = sst.Clone()
 
-	for _, tx := range txIn {
+	// The transactions are first executed in parallel. Their results are
+	// then applied in order, and a transaction is executed again if it read
+	// an instance written by a previous one, so that the result is the same
+	// as if they were executed one after the other.
+	spec := s.runSpeculative(sstTemp, txIn, scID)
+	written := make(map[string]bool)
+
+	for i, tx := range txIn {
 		txsz := txSize(tx)
 
 		var sstTempC *stagingStateTrie
 		var statesTemp StateChanges
-		statesTemp, sstTempC, err = s.processOneTx(sstTemp, tx.ClientTransaction, scID)
+		var events []Event
+		if spec != nil {
+			statesTemp, events, sstTempC, err = s.applySpeculative(sstTemp, spec[i],
+				written, tx.ClientTransaction, scID)
+		} else {
+			statesTemp, events, sstTempC, err = s.processOneTx(sstTemp, tx.ClientTransaction, scID)
+		}
 		if err != nil {
 			tx.Accepted = false
+			tx.Events = nil
 			txOut = append(txOut, tx)
 			log.Error(s.ServerIdentity(), err)
 		} else {
@@ -2145,9 +2558,13 @@ func (s *Service) createStateChanges(sst *stagingStateTrie, scID skipchain.SkipB
 			}
 
 			tx.Accepted = true
+			tx.Events = events
 			sstTemp = sstTempC
 			blocksz += txsz
 			states = append(states, statesTemp...)
+			for _, sc := range statesTemp {
+				written[string(sc.InstanceID)] = true
+			}
 			txOut = append(txOut, tx)
 		}
 	}
@@ -2168,21 +2585,63 @@ func (s *Service) addError(tx ClientTransaction, err error) {
 	s.txErrorBuf.add(tx.Instructions.HashWithSignatures(), err.Error())
 }
 
-// processOneTx takes one transaction and creates a set of StateChanges. It
-// also returns the temporary StateTrie with the StateChanges applied. Any data
-// from the trie should be read from sst and not the service.
+// processOneTx takes one transaction and creates a set of StateChanges and
+// the events emitted by the contracts. It also returns the temporary
+// StateTrie with the StateChanges applied. Any data from the trie should be
+// read from sst and not the service.
 func (s *Service) processOneTx(sst *stagingStateTrie, tx ClientTransaction,
-	scID skipchain.SkipBlockID) (StateChanges, *stagingStateTrie, error) {
+	scID skipchain.SkipBlockID) (StateChanges, []Event, *stagingStateTrie, error) {
+	states, events, cout, sst, _, err := s.runOneTx(sst, tx, scID)
+	if err != nil {
+		s.addError(tx, err)
+		return nil, nil, nil, err
+	}
+	if len(cout) != 0 {
+		log.Lvl2(s.ServerIdentity(), "Leftover coins detected, discarding.")
+	}
+	return states, events, sst, nil
+}
+
+// runOneTx applies all instructions of one transaction to a clone of sst. It
+// returns the StateChanges, the events emitted by the contracts, the coins
+// left over after the last instruction and the temporary StateTrie with the
+// StateChanges applied. If an instruction
+// fails, its index is returned together with the error. Contrary to
+// processOneTx, it does not store the error, so it can be used for
+// simulations.
+func (s *Service) runOneTx(sst *stagingStateTrie, tx ClientTransaction,
+	scID skipchain.SkipBlockID) (StateChanges, []Event, []Coin, *stagingStateTrie, int, error) {
+
+	if err := s.checkValidity(sst, tx, scID); err != nil {
+		return nil, nil, nil, nil, 0, xerrors.Errorf("%s refused transaction: %v",
+			s.ServerIdentity(), err)
+	}
 
 	// Make a new trie for each instruction. If the instruction is
 	// sucessfully implemented and changes applied, then keep it
 	// otherwise dump it.
 	sst = sst.Clone()
-	h := tx.Instructions.Hash()
+	h := tx.Digest()
 	var statesTemp StateChanges
+	var eventsTemp []Event
 	var cin []Coin
-	for _, instr := range tx.Instructions {
-		scs, cout, err := s.executeInstruction(sst, cin, instr, h, scID)
+	// The genesis transaction is executed before the configuration
+	// exists, so it is never metered.
+	config, _ := LoadConfigFromTrie(sst)
+	for i, instr := range tx.Instructions {
+		var m *meter
+		// The instructions on the configuration are not metered so that
+		// a budget too small can always be fixed.
+		if !ConfigInstanceID.Equal(instr.InstanceID) {
+			m = newMeter(config)
+		}
+		if err := checkLanesVersion(instr); err != nil {
+			err = xerrors.Errorf("%s refused instruction %x: %v",
+				s.ServerIdentity(), instr.Hash(), err)
+			return nil, nil, nil, nil, i, err
+		}
+		ec := &eventCollector{}
+		scs, cout, err := s.executeInstruction(sst, cin, instr, h, scID, m, ec)
 		if err != nil {
 			_, _, cid, _, err2 := sst.GetValues(instr.InstanceID.Slice())
 			if err2 != nil {
@@ -2190,16 +2649,14 @@ func (s *Service) processOneTx(sst *stagingStateTrie, tx ClientTransaction,
 			}
 			err = xerrors.Errorf("%s Contract %s got %x and returned error: %v",
 				s.ServerIdentity(), cid, instr.Hash(), err)
-			s.addError(tx, err)
-			return nil, nil, err
+			return nil, nil, nil, nil, i, err
 		}
 
-		counterScs, err := incrementSignerCounters(sst, instr.SignerIdentities)
+		counterScs, err := incrementSignerCounters(sst, instr.SignerIdentities, instr.SignerLanes)
 		if err != nil {
 			err = xerrors.Errorf("%s failed to update signature counters: %v",
 				s.ServerIdentity(), err)
-			s.addError(tx, err)
-			return nil, nil, err
+			return nil, nil, nil, nil, i, err
 		}
 
 		// Verify the validity of the state-changes:
@@ -2229,38 +2686,89 @@ func (s *Service) processOneTx(sst *stagingStateTrie, tx ClientTransaction,
 					err = xerrors.Errorf("%s couldn't get contractID from the "+
 						"following instruction: %x (with instanceID %x)",
 						s.ServerIdentity(), instr.Hash(), instr.InstanceID.Slice())
-					s.addError(tx, err)
-					return nil, nil, err
+					return nil, nil, nil, nil, i, err
 				}
 				err = xerrors.Errorf("%s: contract %s %s %x", s.ServerIdentity(),
 					contractID, reason, sc.InstanceID)
-				s.addError(tx, err)
-				return nil, nil, err
+				return nil, nil, nil, nil, i, err
 			}
 			log.Lvlf2("StateChange %s for id %x - contract: %s", sc.StateAction,
 				sc.InstanceID, sc.ContractID)
 			err = sst.StoreAll(StateChanges{sc})
 			if err != nil {
 				err = xerrors.Errorf("%s StoreAll failed: %v", s.ServerIdentity(), err)
-				s.addError(tx, err)
-				return nil, nil, err
+				return nil, nil, nil, nil, i, err
 			}
 		}
 		if err = sst.StoreAll(counterScs); err != nil {
 			err = xerrors.Errorf("%s StoreAll failed to add counter changes: %v",
 				s.ServerIdentity(), err)
-			s.addError(tx, err)
-			return nil, nil, err
+			return nil, nil, nil, nil, i, err
+		}
+		var feeScs StateChanges
+		if m != nil && tx.FeePayer != nil {
+			feeScs, err = m.payFrom(sst, *tx.FeePayer, instr, h)
+			if err == nil {
+				err = sst.StoreAll(feeScs)
+			}
+		} else if m != nil {
+			cout, err = m.pay(cout)
+		}
+		if err != nil {
+			err = xerrors.Errorf("%s couldn't pay for instruction: %v",
+				s.ServerIdentity(), err)
+			return nil, nil, nil, nil, i, err
 		}
 		statesTemp = append(statesTemp, scs...)
 		statesTemp = append(statesTemp, counterScs...)
+		statesTemp = append(statesTemp, feeScs...)
+		eventsTemp = append(eventsTemp, ec.events...)
 		cin = cout
 	}
-	if len(cin) != 0 {
-		log.Lvl2(s.ServerIdentity(), "Leftover coins detected, discarding.")
+
+	return statesTemp, eventsTemp, cin, sst, -1, nil
+}
+
+// getContractRegistry returns the contract registry that uses the versions of
+// the contracts active in the given state.
+func (s *Service) getContractRegistry(st ReadOnlyStateTrie) ReadOnlyContractRegistry {
+	return contractRegistryAt(s.contracts, st)
+}
+
+// contractRegistryAt returns the registry with the versions of the contracts
+// that are active in the state.
+func contractRegistryAt(contracts *contractRegistry, st ReadOnlyStateTrie) ReadOnlyContractRegistry {
+	if !contracts.hasVersions() {
+		return contracts
+	}
+
+	// Before the genesis block, there is no config and all the contracts
+	// are at their first version.
+	config, err := LoadConfigFromTrie(st)
+	if err != nil {
+		return contracts
+	}
+	return contracts.withVersions(config.ContractVersions)
+}
+
+// GetContractVersions returns the latest version of the contracts supported
+// by the node, so that the administrators know when all the nodes are ready
+// for an upgrade. If a skipchain is given, the versions active on it are
+// returned too.
+func (s *Service) GetContractVersions(req *GetContractVersions) (*GetContractVersionsResponse, error) {
+	resp := &GetContractVersionsResponse{
+		Supported: s.contracts.supportedVersions(),
+	}
+	if len(req.SkipChainID) == 0 {
+		return resp, nil
 	}
 
-	return statesTemp, sst, nil
+	config, err := s.LoadConfig(req.SkipChainID)
+	if err != nil {
+		return nil, xerrors.Errorf("loading config: %v", err)
+	}
+	resp.Active = config.ContractVersions
+	return resp, nil
 }
 
 // GetContractConstructor gets the contract constructor of the contract
@@ -2270,10 +2778,37 @@ func (s *Service) GetContractConstructor(contractName string) (ContractFn, bool)
 	return fn, exists
 }
 
+// GetActiveContractConstructor gets the contract constructor of the contract
+// contractName, in the version active in the latest state of the skipchain.
+func (s *Service) GetActiveContractConstructor(scID skipchain.SkipBlockID, contractName string) (ContractFn, bool) {
+	st, err := s.GetReadOnlyStateTrie(scID)
+	if err != nil {
+		return nil, false
+	}
+	return s.getContractRegistry(st).Search(contractName)
+}
+
 // GetContractInstance creates a contract given the ID and the bytes input if the contract
 // exists or nil otherwise. It also sets the contract registry if needed.
 func (s *Service) GetContractInstance(contractName string, in []byte) (Contract, error) {
-	fn, exists := s.contracts.Search(contractName)
+	return newContractInstance(s.contracts, contractName, in)
+}
+
+// GetActiveContractInstance is the same as GetContractInstance, but it uses
+// the version of the contract active in the latest state of the skipchain.
+func (s *Service) GetActiveContractInstance(scID skipchain.SkipBlockID, contractName string, in []byte) (Contract, error) {
+	st, err := s.GetReadOnlyStateTrie(scID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting trie: %v", err)
+	}
+	return newContractInstance(s.getContractRegistry(st), contractName, in)
+}
+
+// newContractInstance creates the contract with the constructor found in the
+// registry, and gives it the registry if it needs it.
+func newContractInstance(registry ReadOnlyContractRegistry, contractName string,
+	in []byte) (Contract, error) {
+	fn, exists := registry.Search(contractName)
 	if !exists {
 		return nil, xerrors.New("contract does not exist")
 	}
@@ -2287,40 +2822,83 @@ func (s *Service) GetContractInstance(contractName string, in []byte) (Contract,
 	// that need the registry.
 	cwr, ok := c.(ContractWithRegistry)
 	if ok {
-		cwr.SetRegistry(s.contracts)
+		cwr.SetRegistry(registry)
 	}
 
 	return c, nil
 }
 
-func (s *Service) executeInstruction(st ReadOnlyStateTrie, cin []Coin, instr Instruction, ctxHash []byte, scID skipchain.SkipBlockID) (scs StateChanges, cout []Coin, err error) {
+// executeInstruction calls the contract of the instruction on the state of
+// the chain scID, see the function executeInstruction.
+func (s *Service) executeInstruction(st ReadOnlyStateTrie, cin []Coin, instr Instruction, ctxHash []byte,
+	scID skipchain.SkipBlockID, m *meter, ec *eventCollector) (StateChanges, []Coin, error) {
+	// convert ReadOnlyStateTrie to a GlobalState so that contracts may cast it if they wish
+	roSC := newROSkipChain(s.skService(), scID)
+	return executeInstruction(globalState{st, roSC, nil, nil}, s.contracts, cin,
+		instr, ctxHash, m, ec)
+}
+
+// ExecuteInstruction verifies and executes the instruction on the global
+// state with the contracts of the global registry, the same way the nodes do.
+// The state changes incrementing the counters of the signers are appended to
+// the ones of the contract. The global state itself is not modified. It
+// allows to test contracts without running nodes, see the bctest package.
+func ExecuteInstruction(gs GlobalState, cin []Coin, instr Instruction,
+	ctxHash []byte) (StateChanges, []Coin, error) {
+	scs, cout, err := executeInstruction(globalState{gs, gs, nil, nil},
+		globalContractRegistry.clone(), cin, instr, ctxHash, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	counterScs, err := incrementSignerCounters(gs, instr.SignerIdentities,
+		instr.SignerLanes)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("updating signer counters: %v", err)
+	}
+	return append(scs, counterScs...), cout, nil
+}
+
+// executeInstruction calls the contract of the instruction, taken from the
+// registry of contracts. If m is not nil, the accesses of the contract to the
+// global state are charged to it. If ec is not nil, the events emitted by the
+// contract are added to it. If gs holds the context of sub-instructions, the
+// instruction is one of them and its contract verifies it with the identities
+// of the instruction running it.
+func executeInstruction(gs globalState, contracts *contractRegistry, cin []Coin,
+	instr Instruction, ctxHash []byte, m *meter, ec *eventCollector) (scs StateChanges, cout []Coin, err error) {
 	defer func() {
 		if re := recover(); re != nil {
 			err = xerrors.Errorf("executing instr: %v", re)
 		}
 	}()
 
-	// convert ReadOnlyStateTrie to a GlobalState so that contracts may cast it if they wish
-	roSC := newROSkipChain(s.skService(), scID)
-	gs := globalState{st, roSC}
+	// The contract only sees the metered state, so that the lookups done
+	// here are not charged.
+	cgs := gs
+	if m != nil {
+		cgs.ReadOnlyStateTrie = &meteredStateTrie{gs.ReadOnlyStateTrie, m}
+	}
+	parent := gs.subs
+	cgs.subs = newSubInstructions(gs, contracts, instr, ctxHash, m, ec, parent)
 
 	contents, _, contractID, _, err := gs.GetValues(instr.InstanceID.Slice())
-	if !xerrors.Is(err, errKeyNotSet) && err != nil {
+	if !xerrors.Is(err, ErrKeyNotSet) && err != nil {
 		err = xerrors.Errorf("Couldn't get contract type of instruction: %v", err)
 		return
 	}
 
-	contractFactory, exists := s.GetContractConstructor(contractID)
+	registry := contractRegistryAt(contracts, gs)
+	contractFactory, exists := registry.Search(contractID)
 	if !exists {
 		if ConfigInstanceID.Equal(instr.InstanceID) {
 			// Special case 1: first time call to
 			// genesis-configuration must return correct contract
 			// type.
-			contractFactory, exists = s.GetContractConstructor(ContractConfigID)
+			contractFactory, exists = contracts.Search(ContractConfigID)
 		} else if NamingInstanceID.Equal(instr.InstanceID) {
 			// Special case 2: first time call to the naming
 			// contract must return the correct type too.
-			contractFactory, exists = s.GetContractConstructor(ContractNamingID)
+			contractFactory, exists = contracts.Search(ContractNamingID)
 		} else {
 			// If the leader does not have a verifier for this
 			// contract, it drops the transaction.
@@ -2331,7 +2909,7 @@ func (s *Service) executeInstruction(st ReadOnlyStateTrie, cin []Coin, instr Ins
 	}
 
 	// Now we call the contract function with the data of the key.
-	log.Lvlf3("%s Calling contract '%s'", s.ServerIdentity(), contractID)
+	log.Lvlf3("Calling contract '%s'", contractID)
 
 	var c Contract
 	c, err = contractFactory(contents)
@@ -2344,10 +2922,15 @@ func (s *Service) executeInstruction(st ReadOnlyStateTrie, cin []Coin, instr Ins
 		return
 	}
 	if sc, ok := c.(ContractWithRegistry); ok {
-		sc.SetRegistry(s.contracts)
+		sc.SetRegistry(registry)
 	}
 
-	err = c.VerifyInstruction(gs, instr, ctxHash)
+	if ec != nil {
+		ec.contractID = contractID
+		cgs.events = ec
+	}
+
+	err = c.VerifyInstruction(cgs, instr, ctxHash)
 	if err != nil {
 		err = xerrors.Errorf("instruction verification failed: %v", err)
 		return
@@ -2355,21 +2938,40 @@ func (s *Service) executeInstruction(st ReadOnlyStateTrie, cin []Coin, instr Ins
 
 	switch instr.GetType() {
 	case SpawnType:
-		scs, cout, err = c.Spawn(gs, instr, cin)
+		scs, cout, err = c.Spawn(cgs, instr, cin)
 	case InvokeType:
-		scs, cout, err = c.Invoke(gs, instr, cin)
+		scs, cout, err = c.Invoke(cgs, instr, cin)
 	case DeleteType:
-		scs, cout, err = c.Delete(gs, instr, cin)
+		scs, cout, err = c.Delete(cgs, instr, cin)
 	default:
 		return nil, nil, xerrors.New("unexpected contract type")
 	}
 
+	if m != nil && err == nil {
+		// The budget is checked even if the contract ignored the errors
+		// of the metered state.
+		if m.exceeded() {
+			err = xerrors.Errorf("contract: %w", errOverBudget)
+		} else {
+			err = m.chargeStateChanges(scs)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// The state changes of the sub-instructions are applied with the ones
+	// of the contract, before them.
+	if err == nil && len(cgs.subs.scs) > 0 {
+		scs = append(append(StateChanges{}, cgs.subs.scs...), scs...)
+	}
+
 	// As the InstanceID of each sc is not necessarily the same as the
 	// instruction, we need to get the version from the trie
 	vv := make(map[string]uint64)
 	for i, sc := range scs {
 		// Make sure that the contract either exists or is empty.
-		if _, ok := s.contracts.Search(sc.ContractID); !ok && sc.ContractID != "" {
+		if _, ok := contracts.Search(sc.ContractID); !ok && sc.ContractID != "" {
 			log.Errorf("Found unknown contract ID \"%s\"", sc.ContractID)
 			return nil, nil, xerrors.New("unknown contract ID")
 		}
@@ -2381,7 +2983,7 @@ func (s *Service) executeInstruction(st ReadOnlyStateTrie, cin []Coin, instr Ins
 
 		// this is done at this scope because we must increase
 		// the version only when it's not the first one
-		if xerrors.Is(err, errKeyNotSet) {
+		if xerrors.Is(err, ErrKeyNotSet) {
 			ver = 0
 			err = nil
 		} else if err != nil {
@@ -2451,6 +3053,19 @@ func (s *Service) getTxs(leader *network.ServerIdentity, roster *onet.Roster, sc
 
 	s.heartbeats.beat(string(scID))
 
+	if latest := s.db().GetByID(latestID); latest != nil {
+		header, err := decodeBlockHeader(latest)
+		if err != nil {
+			log.Error(s.ServerIdentity(), err)
+		} else {
+			txs, errs := s.txBuffer.dropExpired(string(scID), latest.Index+1, header.Timestamp)
+			for i := range txs {
+				log.Lvlf2("%v: dropping transaction: %v", s.ServerIdentity(), errs[i])
+				s.addError(txs[i], errs[i])
+			}
+		}
+	}
+
 	return s.txBuffer.take(string(scID), maxNumTxs)
 }
 
@@ -2494,6 +3109,8 @@ func (s *Service) cleanupGoroutines() {
 	s.closeLeaderMonitorChan <- true
 	s.viewChangeMan.closeAll()
 	s.streamingMan.stopAll()
+	s.downloadSessions.closeAll()
+	s.stopGateway()
 
 	s.pollChanMut.Lock()
 	for k, c := range s.pollChan {
@@ -2577,6 +3194,7 @@ func (s *Service) startAllChains() error {
 			return xerrors.New("data of wrong type")
 		}
 	}
+	s.applyNodeConfig()
 	s.stateTries = make(map[string]*stateTrie)
 	s.notifications = bcNotifications{}
 	s.closedMutex.Lock()
@@ -2586,6 +3204,7 @@ func (s *Service) startAllChains() error {
 	// Recreate the polling channles.
 	s.pollChanMut.Lock()
 	s.pollChan = make(map[string]chan bool)
+	s.pushChan = make(map[string]chan []ClientTransaction)
 	s.pollChanMut.Unlock()
 
 	s.skService().RegisterStoreSkipblockCallback(s.updateTrieCallback)
@@ -2637,6 +3256,24 @@ func (s *Service) startChain(genesisID skipchain.SkipBlockID) error {
 		return xerrors.Errorf("fixing inconsistency: %v", err)
 	}
 
+	// nodes upgrading from a version without the instance index need to
+	// build it once
+	idx, err := s.instanceIndex.getIndex(genesisID)
+	if err != nil {
+		return xerrors.Errorf("reading instance index: %v", err)
+	}
+	if idx != st.GetIndex() {
+		log.Lvlf2("%s rebuilding instance index for %x", s.ServerIdentity(), genesisID)
+		if err := s.RebuildInstanceIndex(genesisID, st); err != nil {
+			return xerrors.Errorf("rebuilding instance index: %v", err)
+		}
+	}
+
+	// the same for the transaction index
+	if err := s.indexTxs(genesisID); err != nil {
+		return xerrors.Errorf("indexing transactions: %v", err)
+	}
+
 	// load the metadata to prepare for starting the managers (heartbeat, viewchange)
 	interval, _, err := s.LoadBlockInfo(genesisID)
 	if err != nil {
@@ -2727,8 +3364,7 @@ func (s *Service) getBlockTx(sid skipchain.SkipBlockID) (TxResults, *skipchain.S
 		return nil, nil, err
 	}
 
-	var body DataBody
-	err = protobuf.Decode(sb.Payload, &body)
+	body, err := decodeBlockBody(sb)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -2851,10 +3487,16 @@ func newService(c *onet.Context) (onet.Service, error) {
 		ServiceProcessor:       onet.NewServiceProcessor(c),
 		contracts:              globalContractRegistry.clone(),
 		txBuffer:               newTxBuffer(),
+		forwardSlots:           make(chan bool, maxForwardsInFlight),
+		querySlots:             make(chan bool, maxQueriesInFlight),
+		downloadSessions:       newDownloadSessions(),
 		storage:                &bcStorage{},
 		darcToSc:               make(map[string]skipchain.SkipBlockID),
 		stateChangeCache:       newStateChangeCache(),
 		stateChangeStorage:     newStateChangeStorage(c),
+		txIndex:                newTxIndexStorage(c),
+		instanceIndex:          newInstanceIndexStorage(c),
+		checkpoints:            newCheckpointStorage(c),
 		heartbeatsTimeout:      make(chan string, 1),
 		closeLeaderMonitorChan: make(chan bool, 1),
 		heartbeats:             newHeartbeats(),
@@ -2873,7 +3515,9 @@ func newService(c *onet.Context) (onet.Service, error) {
 		s.GetAllByzCoinIDs,
 		s.CreateGenesisBlock,
 		s.AddTransaction,
+		s.SimulateTransaction,
 		s.GetProof,
+		s.GetProofs,
 		s.CheckAuthorization,
 		s.GetSignerCounters,
 		s.DownloadState,
@@ -2881,9 +3525,17 @@ func newService(c *onet.Context) (onet.Service, error) {
 		s.GetLastInstanceVersion,
 		s.GetAllInstanceVersion,
 		s.CheckStateChangeValidity,
+		s.GetStateDiff,
+		s.GetTxStatus,
+		s.ListInstances,
+		s.QueryInstance,
+		s.SearchEvents,
+		s.GetContractVersions,
 		s.ResolveInstanceID,
+		s.GetCheckpoint,
 		s.Debug,
-		s.DebugRemove)
+		s.DebugRemove,
+		s.SetNodeConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -2892,6 +3544,9 @@ func newService(c *onet.Context) (onet.Service, error) {
 		return nil, xerrors.Errorf("registering handlers: %v", err)
 	}
 	s.RegisterProcessorFunc(viewChangeMsgID, s.handleViewChangeReq)
+	s.RegisterProcessorFunc(checkpointMsgID, s.handleCheckpoint)
+	s.RegisterProcessorFunc(forwardTxsMsgID, s.handleForwardTxs)
+	s.RegisterStatusReporter("ByzCoin", &s.admission)
 
 	if err := skipchain.RegisterVerification(c, Verify, s.verifySkipBlock); err != nil {
 		log.ErrFatal(err)
@@ -2915,6 +3570,20 @@ func newService(c *onet.Context) (onet.Service, error) {
 		return nil, xerrors.Errorf("registering protocol: %v", err)
 	}
 
+	// Register the checkpoint cosi protocols.
+	_, err = s.ProtocolRegister(checkpointSubFtCosi, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return protocol.NewSubBlsCosi(n, s.verifyCheckpoint, pairingSuite)
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("registering protocol: %v", err)
+	}
+	_, err = s.ProtocolRegister(checkpointFtCosi, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return protocol.NewBlsCosi(n, s.verifyCheckpoint, checkpointSubFtCosi, pairingSuite)
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("registering protocol: %v", err)
+	}
+
 	ver, err := s.LoadVersion()
 	if err != nil {
 		return nil, xerrors.Errorf("loading version: %v", err)
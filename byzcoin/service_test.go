@@ -22,6 +22,7 @@ import (
 	"go.dedis.ch/cothority/v3/darc/expression"
 	"go.dedis.ch/cothority/v3/skipchain"
 	"go.dedis.ch/kyber/v3/sign/eddsa"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
 	"go.dedis.ch/kyber/v3/suites"
 	"go.dedis.ch/kyber/v3/util/random"
 	"go.dedis.ch/onet/v3"
@@ -41,7 +42,9 @@ const slowContract = "slow"
 const panicContract = "panic"
 const invalidContract = "invalid"
 const versionContract = "testVersionContract"
+const eventContract = "testEventContract"
 const stateChangeCacheContract = "stateChangeCacheTest"
+const subInstrContract = "testSubInstrContract"
 
 func TestMain(m *testing.M) {
 	log.SetShowTime(true)
@@ -586,6 +589,104 @@ func TestService_AutomaticVersionUpgrade(t *testing.T) {
 	t.Fail()
 }
 
+func TestService_SimulateTransaction(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	tx, err := createOneClientTx(s.darc.GetBaseID(), dummyContract, s.value, s.signer)
+	require.NoError(t, err)
+	resp, err := s.service().SimulateTransaction(&SimulateTxRequest{
+		Version:     CurrentVersion,
+		SkipchainID: s.genesis.SkipChainID(),
+		Transaction: tx,
+	})
+	require.NoError(t, err)
+	require.Empty(t, resp.Error)
+	// One state change for the instance and one for the counter.
+	require.Equal(t, 2, len(resp.StateChanges))
+	require.Equal(t, Create, resp.StateChanges[0].StateAction)
+	require.Equal(t, dummyContract, resp.StateChanges[0].ContractID)
+	require.Equal(t, s.value, resp.StateChanges[0].Value)
+
+	// Nothing must have been stored in the trie nor in the buffer.
+	pr, err := s.service().GetProof(&GetProof{
+		Version: CurrentVersion,
+		ID:      s.genesis.SkipChainID(),
+		Key:     resp.StateChanges[0].InstanceID,
+	})
+	require.NoError(t, err)
+	require.False(t, pr.Proof.InclusionProof.Match(resp.StateChanges[0].InstanceID))
+	require.Empty(t, s.service().txBuffer.take(string(s.genesis.SkipChainID()), 1))
+
+	// A wrong counter must be reported with the failing instruction.
+	tx, err = createClientTxWithTwoInstrWithCounter(s.darc.GetBaseID(), dummyContract, s.value, s.signer, 1)
+	require.NoError(t, err)
+	tx.Instructions[1].SignerCounter = []uint64{1}
+	require.NoError(t, tx.SignWith(s.signer))
+	resp, err = s.service().SimulateTransaction(&SimulateTxRequest{
+		Version:     CurrentVersion,
+		SkipchainID: s.genesis.SkipChainID(),
+		Transaction: tx,
+	})
+	require.NoError(t, err)
+	require.Contains(t, resp.Error, "counter")
+	require.Equal(t, 1, resp.ErrorIndex)
+	require.Empty(t, resp.StateChanges)
+
+	// The transaction must still be accepted afterwards.
+	tx, err = createOneClientTx(s.darc.GetBaseID(), dummyContract, s.value, s.signer)
+	require.NoError(t, err)
+	s.sendTxAndWait(t, tx, 10)
+}
+
+// Checks that a transaction is only accepted inside of its validity window.
+func TestService_TxValidity(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	scID := s.genesis.SkipChainID()
+	newTx := func(counter uint64, v *TxValidity) ClientTransaction {
+		tx, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, s.value, s.signer, counter)
+		require.NoError(t, err)
+		tx.Validity = v
+		require.NoError(t, tx.SignWith(s.signer))
+		return tx
+	}
+	addTx := func(tx ClientTransaction) *AddTxResponse {
+		resp, err := s.service().AddTransaction(&AddTxRequest{
+			Version:     CurrentVersion,
+			SkipchainID: scID,
+			Transaction: tx,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	// Expired and not valid yet transactions are refused by the buffer.
+	resp := addTx(newTx(1, &TxValidity{NotAfterTime: 1}))
+	require.Contains(t, resp.Error, "transaction expired")
+	resp = addTx(newTx(1, &TxValidity{NotBefore: 100}))
+	require.Contains(t, resp.Error, "not valid yet")
+	require.Empty(t, s.service().txBuffer.take(string(scID), -1))
+
+	// The window is also checked when the transaction is executed.
+	st, err := s.service().getStateTrie(scID)
+	require.NoError(t, err)
+	_, _, _, err = s.service().processOneTx(st.MakeStagingStateTrie(),
+		newTx(1, &TxValidity{NotAfterTime: 1}), scID)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "transaction expired")
+
+	// The window is signed.
+	tx := newTx(1, &TxValidity{NotAfter: 100})
+	tx.Validity.NotAfter = 1000
+	_, _, _, err = s.service().processOneTx(st.MakeStagingStateTrie(), tx, scID)
+	require.Error(t, err)
+
+	s.sendTxAndWait(t, newTx(1, &TxValidity{NotBefore: 1, NotAfter: 100,
+		NotAfterTime: time.Now().Add(time.Hour).UnixNano()}), 10)
+}
+
 func TestService_GetProof(t *testing.T) {
 	s := newSer(t, 2, testInterval)
 	defer s.local.CloseAll()
@@ -628,6 +729,545 @@ func TestService_GetProof(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestService_GetProofAtIndex(t *testing.T) {
+	s := newSer(t, 2, testInterval)
+	defer s.local.CloseAll()
+
+	serKey := s.tx.Instructions[0].Hash()
+	pr := s.waitProof(t, NewInstanceID(serKey))
+	spawnIndex := pr.Latest.Index
+
+	// Remove the instance again in a later block.
+	del := Instruction{
+		InstanceID: NewInstanceID(serKey),
+		Delete: &Delete{
+			ContractID: dummyContract,
+		},
+		SignerCounter: []uint64{2},
+	}
+	tx, err := combineInstrsAndSign(s.signer, del)
+	require.NoError(t, err)
+	s.sendTxAndWait(t, tx, 10)
+
+	rep, err := s.service().GetProof(&GetProof{
+		Version: CurrentVersion,
+		ID:      s.genesis.SkipChainID(),
+		Key:     serKey,
+	})
+	require.NoError(t, err)
+	require.False(t, rep.Proof.InclusionProof.Match(serKey))
+
+	// The instance must still be provable at the block it was created in.
+	rep, err = s.service().GetProof(&GetProof{
+		Version: CurrentVersion,
+		ID:      s.genesis.SkipChainID(),
+		Key:     serKey,
+		AtIndex: spawnIndex,
+	})
+	require.NoError(t, err)
+	require.NoError(t, rep.Proof.Verify(s.genesis.SkipChainID()))
+	require.Equal(t, spawnIndex, rep.Proof.Latest.Index)
+	require.True(t, rep.Proof.InclusionProof.Match(serKey))
+	_, v0, _, _, err := rep.Proof.KeyValue()
+	require.NoError(t, err)
+	require.Equal(t, s.value, v0)
+
+	// And it didn't exist in the genesis block.
+	rep, err = s.service().GetProof(&GetProof{
+		Version:   CurrentVersion,
+		ID:        s.genesis.SkipChainID(),
+		Key:       serKey,
+		AtBlockID: s.genesis.SkipChainID(),
+	})
+	require.NoError(t, err)
+	require.NoError(t, rep.Proof.Verify(s.genesis.SkipChainID()))
+	require.Equal(t, 0, rep.Proof.Latest.Index)
+	require.False(t, rep.Proof.InclusionProof.Match(serKey))
+
+	// States too far back are refused before being rebuilt.
+	mhb := maxHistoryBlocks
+	defer func() {
+		maxHistoryBlocks = mhb
+	}()
+	maxHistoryBlocks = 1
+	_, err = s.service().GetProof(&GetProof{
+		Version:   CurrentVersion,
+		ID:        s.genesis.SkipChainID(),
+		Key:       serKey,
+		AtBlockID: s.genesis.SkipChainID(),
+	})
+	require.Error(t, err)
+	maxHistoryBlocks = mhb
+
+	// Once the storage is cleaned, the history cannot be rebuilt.
+	s.service().stateChangeStorage.setMaxNbrBlock(1)
+	tx, err = createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, s.value, s.signer, 3)
+	require.NoError(t, err)
+	s.sendTxAndWait(t, tx, 10)
+	_, err = s.service().GetProof(&GetProof{
+		Version: CurrentVersion,
+		ID:      s.genesis.SkipChainID(),
+		Key:     serKey,
+		AtIndex: spawnIndex,
+	})
+	require.Error(t, err)
+}
+
+func TestService_GetProofs(t *testing.T) {
+	s := newSer(t, 2, testInterval)
+	defer s.local.CloseAll()
+
+	serKey := s.tx.Instructions[0].Hash()
+	s.waitProof(t, NewInstanceID(serKey))
+	missing := make([]byte, 32)
+	keys := [][]byte{serKey, s.darc.GetBaseID(), ConfigInstanceID[:], missing}
+
+	rep, err := s.service().GetProofs(&GetProofs{
+		Version: CurrentVersion,
+		ID:      s.genesis.SkipChainID(),
+		Keys:    keys,
+	})
+	require.NoError(t, err)
+	require.NoError(t, rep.Proof.Verify(s.genesis.SkipChainID()))
+	for _, key := range keys[:3] {
+		require.True(t, rep.Proof.InclusionProof.Match(key))
+	}
+	ok, err := rep.Proof.InclusionProof.Exists(missing)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// The proof of one key can be used like the one of GetProof.
+	p, err := rep.Proof.Proof(serKey)
+	require.NoError(t, err)
+	require.NoError(t, p.Verify(s.genesis.SkipChainID()))
+	_, v0, _, _, err := p.KeyValue()
+	require.NoError(t, err)
+	require.Equal(t, s.value, v0)
+
+	// The proofs can be made against a past state.
+	rep, err = s.service().GetProofs(&GetProofs{
+		Version:   CurrentVersion,
+		ID:        s.genesis.SkipChainID(),
+		Keys:      keys,
+		AtBlockID: s.genesis.SkipChainID(),
+	})
+	require.NoError(t, err)
+	require.NoError(t, rep.Proof.Verify(s.genesis.SkipChainID()))
+	require.Equal(t, 0, rep.Proof.Latest.Index)
+	require.False(t, rep.Proof.InclusionProof.Match(serKey))
+	require.True(t, rep.Proof.InclusionProof.Match(s.darc.GetBaseID()))
+
+	_, err = s.service().GetProofs(&GetProofs{
+		Version: CurrentVersion,
+		ID:      s.genesis.SkipChainID(),
+	})
+	require.Error(t, err)
+}
+
+func TestService_GetTxStatus(t *testing.T) {
+	s := newSer(t, 2, testInterval)
+	defer s.local.CloseAll()
+
+	s.waitProof(t, NewInstanceID(s.tx.Instructions[0].Hash()))
+
+	hash := s.tx.Instructions.Hash()
+	rep, err := s.service().GetTxStatus(&GetTxStatus{
+		SkipChainID: s.genesis.SkipChainID(),
+		TxHash:      hash,
+	})
+	require.NoError(t, err)
+	require.True(t, rep.TxResult.Accepted)
+	require.Equal(t, hash, rep.TxResult.ClientTransaction.Instructions.Hash())
+	require.NoError(t, rep.Verify(s.genesis))
+
+	// A response pointing to another transaction must be refused.
+	rep.TxIndex++
+	require.Error(t, rep.Verify(s.genesis))
+
+	_, err = s.service().GetTxStatus(&GetTxStatus{
+		SkipChainID: s.genesis.SkipChainID(),
+		TxHash:      make([]byte, 32),
+	})
+	require.Error(t, err)
+
+	// The blocks stored before the index existed are added at startup.
+	scID := s.genesis.SkipChainID()
+	txIndex := s.service().txIndex
+	require.NoError(t, txIndex.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(txIndex.bucket).DeleteBucket(scID)
+	}))
+	_, err = s.service().GetTxStatus(&GetTxStatus{SkipChainID: scID, TxHash: hash})
+	require.Error(t, err)
+	require.NoError(t, s.service().indexTxs(scID))
+	rep, err = s.service().GetTxStatus(&GetTxStatus{SkipChainID: scID, TxHash: hash})
+	require.NoError(t, err)
+	require.True(t, rep.TxResult.Accepted)
+	idx, err := txIndex.getIndex(scID)
+	require.NoError(t, err)
+	latest, err := s.service().db().GetLatestByID(scID)
+	require.NoError(t, err)
+	require.Equal(t, latest.Index, idx)
+}
+
+type badQuerier struct {
+	BasicContract
+}
+
+func (badQuerier) Query(rst ReadOnlyStateTrie, iid InstanceID, method string,
+	args Arguments) ([]byte, error) {
+	if method == "panic" {
+		panic("query panic")
+	}
+	for {
+		if err := rst.ForEach(func(k, v []byte) error { return nil }); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// A query that panics or reads too much of the global state must fail
+// without stopping the node.
+func TestService_QueryInstance(t *testing.T) {
+	s := newSer(t, 2, testInterval)
+	defer s.local.CloseAll()
+
+	iid := NewInstanceID(s.tx.Instructions[0].Hash())
+	s.waitProof(t, iid)
+	for _, ser := range s.services {
+		require.NoError(t, ser.testRegisterContract(dummyContract,
+			func([]byte) (Contract, error) { return badQuerier{}, nil }))
+	}
+
+	_, err := s.service().QueryInstance(&QueryInstance{
+		SkipChainID: s.genesis.SkipChainID(),
+		InstanceID:  iid,
+		Method:      "panic",
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "query panic")
+
+	_, err = s.service().QueryInstance(&QueryInstance{
+		SkipChainID: s.genesis.SkipChainID(),
+		InstanceID:  iid,
+		Method:      "scan",
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), errOverBudget.Error())
+}
+
+// The replayed blocks must be filtered with the darcs the instances had before
+// the block, so that an instance deleted since then, or by the block, is still
+// found.
+func TestService_StreamingReplayDeleted(t *testing.T) {
+	s := newSer(t, 2, testInterval)
+	defer s.local.CloseAll()
+
+	iid := NewInstanceID(s.tx.Instructions[0].Hash())
+	s.waitProof(t, iid)
+
+	invoke := createInvokeInstr(iid, dummyContract, "update", "data", []byte("new"))
+	invoke.SignerCounter = []uint64{2}
+	tx, err := combineInstrsAndSign(s.signer, invoke)
+	require.NoError(t, err)
+	s.sendTxAndWait(t, tx, 10)
+	status, err := s.service().GetTxStatus(&GetTxStatus{
+		SkipChainID: s.genesis.SkipChainID(),
+		TxHash:      tx.Instructions.Hash(),
+	})
+	require.NoError(t, err)
+
+	tx, err = combineInstrsAndSign(s.signer, Instruction{
+		InstanceID:    iid,
+		Delete:        &Delete{ContractID: dummyContract},
+		SignerCounter: []uint64{3},
+	})
+	require.NoError(t, err)
+	s.sendTxAndWait(t, tx, 10)
+
+	out, stop, err := s.service().StreamTransactions(&StreamingRequest{
+		ID:         s.genesis.SkipChainID(),
+		DarcIDs:    []darc.ID{s.darc.GetBaseID()},
+		StartIndex: status.Block.Index,
+	})
+	require.NoError(t, err)
+	defer close(stop)
+
+	// Blocks too far back cannot be filtered by darc.
+	mhb := maxHistoryBlocks
+	maxHistoryBlocks = 0
+	_, _, err = s.service().StreamTransactions(&StreamingRequest{
+		ID:         s.genesis.SkipChainID(),
+		DarcIDs:    []darc.ID{s.darc.GetBaseID()},
+		StartIndex: status.Block.Index,
+	})
+	maxHistoryBlocks = mhb
+	require.Error(t, err)
+
+	select {
+	case resp := <-out:
+		require.Equal(t, status.Block.Index, resp.Block.Index)
+		var body DataBody
+		require.NoError(t, protobuf.Decode(resp.Block.Payload, &body))
+		require.Equal(t, 1, len(body.TxResults))
+		require.Equal(t, InvokeType,
+			body.TxResults[0].ClientTransaction.Instructions[0].GetType())
+	case <-time.After(10 * testInterval):
+		require.Fail(t, "didn't get the replayed block")
+	}
+
+	// The delete is sent to the darc of the instance it removes.
+	for {
+		select {
+		case resp := <-out:
+			var body DataBody
+			require.NoError(t, protobuf.Decode(resp.Block.Payload, &body))
+			if len(body.TxResults) == 0 {
+				continue
+			}
+			require.Equal(t, DeleteType,
+				body.TxResults[0].ClientTransaction.Instructions[0].GetType())
+			return
+		case <-time.After(10 * testInterval):
+			require.Fail(t, "didn't get the block of the delete")
+			return
+		}
+	}
+}
+
+func TestService_ListInstances(t *testing.T) {
+	s := newSer(t, 2, testInterval)
+	defer s.local.CloseAll()
+
+	iid := NewInstanceID(s.tx.Instructions[0].Hash())
+	s.waitProof(t, iid)
+
+	rep, err := s.service().ListInstances(&ListInstances{
+		SkipChainID: s.genesis.SkipChainID(),
+		ContractID:  dummyContract,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []InstanceID{iid}, rep.InstanceIDs)
+	require.Empty(t, rep.Next)
+
+	// The genesis darc guards the config, itself and the dummy instance,
+	// so two pages are needed with a limit of 2.
+	req := &ListInstances{
+		SkipChainID: s.genesis.SkipChainID(),
+		DarcID:      s.darc.GetBaseID(),
+		Limit:       2,
+	}
+	rep, err = s.service().ListInstances(req)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(rep.InstanceIDs))
+	require.NotEmpty(t, rep.Next)
+	req.Start = rep.Next
+	rep2, err := s.service().ListInstances(req)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(rep2.InstanceIDs))
+	require.Empty(t, rep2.Next)
+	require.ElementsMatch(t, []InstanceID{ConfigInstanceID, iid,
+		NewInstanceID(s.darc.GetBaseID())},
+		append(rep.InstanceIDs, rep2.InstanceIDs...))
+
+	_, err = s.service().ListInstances(&ListInstances{
+		SkipChainID: s.genesis.SkipChainID(),
+	})
+	require.Error(t, err)
+
+	// Deleting the instance removes it from the index.
+	tx, err := combineInstrsAndSign(s.signer, Instruction{
+		InstanceID: iid,
+		Delete: &Delete{
+			ContractID: dummyContract,
+		},
+		SignerCounter: []uint64{2},
+	})
+	require.NoError(t, err)
+	s.sendTxAndWait(t, tx, 10)
+
+	rep, err = s.service().ListInstances(&ListInstances{
+		SkipChainID: s.genesis.SkipChainID(),
+		ContractID:  dummyContract,
+	})
+	require.NoError(t, err)
+	require.Empty(t, rep.InstanceIDs)
+
+	// Rebuilding the index from the trie gives the same result.
+	st, err := s.service().getStateTrie(s.genesis.SkipChainID())
+	require.NoError(t, err)
+	require.NoError(t, s.service().RebuildInstanceIndex(s.genesis.SkipChainID(), st))
+	rep, err = s.service().ListInstances(&ListInstances{
+		SkipChainID: s.genesis.SkipChainID(),
+		DarcID:      s.darc.GetBaseID(),
+	})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []InstanceID{ConfigInstanceID,
+		NewInstanceID(s.darc.GetBaseID())}, rep.InstanceIDs)
+	require.Equal(t, st.GetIndex(), rep.Index)
+}
+
+func TestService_Metering(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	config, err := s.service().LoadConfig(s.genesis.SkipChainID())
+	require.NoError(t, err)
+	config.MeteringBudget = 2000
+	configBuf, err := protobuf.Encode(config)
+	require.NoError(t, err)
+	tx, err := combineInstrsAndSign(s.signer, Instruction{
+		InstanceID: ConfigInstanceID,
+		Invoke: &Invoke{
+			ContractID: ContractConfigID,
+			Command:    "update_config",
+			Args:       Arguments{{Name: "config", Value: configBuf}},
+		},
+		SignerCounter: []uint64{1},
+	})
+	require.NoError(t, err)
+	s.sendTxAndWait(t, tx, 10)
+
+	simulate := func(value []byte) *SimulateTxResponse {
+		tx, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract,
+			value, s.signer, 2)
+		require.NoError(t, err)
+		resp, err := s.service().SimulateTransaction(&SimulateTxRequest{
+			Version:     CurrentVersion,
+			SkipchainID: s.genesis.SkipChainID(),
+			Transaction: tx,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	require.Empty(t, simulate(s.value).Error)
+	require.Contains(t, simulate(make([]byte, 4000)).Error, errOverBudget.Error())
+}
+
+func TestService_UpgradeContract(t *testing.T) {
+	s := newSer(t, 2, testInterval)
+	defer s.local.CloseAll()
+
+	iid := NewInstanceID(s.tx.Instructions[0].Hash())
+	s.waitProof(t, iid)
+
+	for _, service := range s.services {
+		service.testRegisterContractVersion(dummyContract, adaptorNoVerify(dummyContractFunc),
+			func(value []byte) ([]byte, error) {
+				return append(value, []byte("-v1")...), nil
+			})
+	}
+	c, err := s.service().GetActiveContractInstance(s.genesis.SkipChainID(), dummyContract, nil)
+	require.NoError(t, err)
+	require.IsType(t, &contractAdaptor{}, c)
+
+	resp, err := s.service().GetContractVersions(&GetContractVersions{
+		SkipChainID: s.genesis.SkipChainID(),
+	})
+	require.NoError(t, err)
+	require.Contains(t, resp.Supported, ContractVersion{dummyContract, 1})
+	require.Empty(t, resp.Active)
+
+	upgrade := func(counter uint64) ClientTransaction {
+		version := make([]byte, 8)
+		binary.LittleEndian.PutUint64(version, 1)
+		tx, err := combineInstrsAndSign(s.signer, Instruction{
+			InstanceID: ConfigInstanceID,
+			Invoke: &Invoke{
+				ContractID: ContractConfigID,
+				Command:    "upgrade_contract",
+				Args: Arguments{
+					{Name: "contract_id", Value: []byte(dummyContract)},
+					{Name: "version", Value: version},
+				},
+			},
+			SignerCounter: []uint64{counter},
+		})
+		require.NoError(t, err)
+		return tx
+	}
+	s.sendTxAndWait(t, upgrade(2), 10)
+
+	// The instance has been migrated in the upgrade block.
+	st, err := s.service().GetReadOnlyStateTrie(s.genesis.SkipChainID())
+	require.NoError(t, err)
+	val, _, _, _, err := st.GetValues(iid.Slice())
+	require.NoError(t, err)
+	require.Equal(t, append(append([]byte{}, s.value...), []byte("-v1")...), val)
+
+	resp, err = s.service().GetContractVersions(&GetContractVersions{
+		SkipChainID: s.genesis.SkipChainID(),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []ContractVersion{{dummyContract, 1}}, resp.Active)
+	c, err = s.service().GetActiveContractInstance(s.genesis.SkipChainID(), dummyContract, nil)
+	require.NoError(t, err)
+	require.IsType(t, &contractAdaptorNV{}, c)
+	// The first version is still given without a skipchain.
+	c, err = s.service().GetContractInstance(dummyContract, nil)
+	require.NoError(t, err)
+	require.IsType(t, &contractAdaptor{}, c)
+
+	// The same version cannot be activated twice.
+	sim, err := s.service().SimulateTransaction(&SimulateTxRequest{
+		Version:     CurrentVersion,
+		SkipchainID: s.genesis.SkipChainID(),
+		Transaction: upgrade(3),
+	})
+	require.NoError(t, err)
+	require.Contains(t, sim.Error, "already at version 1")
+}
+
+func TestService_SearchEvents(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	tx, err := createOneClientTxWithCounter(s.darc.GetBaseID(), eventContract,
+		s.value, s.signer, 1)
+	require.NoError(t, err)
+	iid := NewInstanceID(tx.Instructions[0].Hash())
+
+	sim, err := s.service().SimulateTransaction(&SimulateTxRequest{
+		Version:     CurrentVersion,
+		SkipchainID: s.genesis.SkipChainID(),
+		Transaction: tx,
+	})
+	require.NoError(t, err)
+	require.Empty(t, sim.Error)
+	require.Equal(t, 1, len(sim.Events))
+
+	s.sendTxAndWait(t, tx, 10)
+	s.waitProof(t, iid)
+
+	search := func(name, contractID string) []BlockEvent {
+		resp, err := s.service().SearchEvents(&SearchEvents{
+			SkipChainID: s.genesis.SkipChainID(),
+			Name:        name,
+			ContractID:  contractID,
+		})
+		require.NoError(t, err)
+		require.Equal(t, 0, resp.Next)
+		return resp.Events
+	}
+
+	events := search("spawned", "")
+	require.Equal(t, 1, len(events))
+	require.Equal(t, 1, events[0].BlockIndex)
+	require.Equal(t, eventContract, events[0].Event.ContractID)
+	require.True(t, iid.Equal(events[0].Event.InstanceID))
+	require.Equal(t, s.value, events[0].Event.Attributes[0].Value)
+	require.Equal(t, 1, len(search("", eventContract)))
+	require.Empty(t, search("spawned", dummyContract))
+	require.Empty(t, search("unknown", ""))
+
+	// The range must end at an existing block.
+	_, err = s.service().SearchEvents(&SearchEvents{
+		SkipChainID: s.genesis.SkipChainID(),
+		From:        10,
+	})
+	require.Error(t, err)
+}
+
 func TestService_DarcProxy(t *testing.T) {
 	s := newSer(t, 1, testInterval)
 	defer s.local.CloseAll()
@@ -750,7 +1390,7 @@ func TestService_Depending(t *testing.T) {
 	require.NoError(t, err)
 	_, _, _, _, err = cdb.GetValues(in1.Hash())
 	require.Error(t, err)
-	require.True(t, xerrors.Is(err, errKeyNotSet))
+	require.True(t, xerrors.Is(err, ErrKeyNotSet))
 
 	// We need to wait a bit for the propagation to finish because the
 	// skipchain service might decide to update forward links by adding
@@ -822,6 +1462,37 @@ func TestService_BadDataHeader(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestService_BadBodyEvents(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	ser := s.services[0]
+	c := ser.Context
+	err := skipchain.RegisterVerification(c, Verify, func(newID []byte, newSB *skipchain.SkipBlock) bool {
+		// Forge an event in the body, which doesn't match the header.
+		var body DataBody
+		err := protobuf.DecodeWithConstructors(newSB.Payload, &body, network.DefaultConstructors(cothority.Suite))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body.TxResults[0].Events = append(body.TxResults[0].Events, Event{Name: "forged"})
+		newSB.Payload, _ = protobuf.Encode(&body)
+
+		return ser.verifySkipBlock(newID, newSB)
+	})
+	require.NoError(t, err)
+
+	tx, err := createOneClientTx(s.darc.GetBaseID(), dummyContract, s.value, s.signer)
+	require.NoError(t, err)
+	_, err = ser.AddTransaction(&AddTxRequest{
+		Version:       CurrentVersion,
+		SkipchainID:   s.genesis.SkipChainID(),
+		Transaction:   tx,
+		InclusionWait: 5,
+	})
+	require.Error(t, err)
+}
+
 func txResultsFromBlock(sb *skipchain.SkipBlock) (TxResults, error) {
 	var body DataBody
 	err := protobuf.DecodeWithConstructors(sb.Payload, &body, network.DefaultConstructors(cothority.Suite))
@@ -939,6 +1610,46 @@ func waitInclusion(t *testing.T, client int) {
 
 // Sends too many transactions to the ledger and waits for all blocks to be
 // done.
+// Sends transactions of the same signer that are included in the same block,
+// each one using its own counter lane.
+func TestService_SignerLanes(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	lanes := []uint64{1, 2, 3}
+	var txs []ClientTransaction
+	for _, lane := range lanes {
+		instr := createSpawnInstr(s.darc.GetBaseID(), dummyContract, "data", []byte{byte(lane)})
+		instr.SignerLanes = []uint64{lane}
+		tx, err := combineInstrsAndSign(s.signer, instr)
+		require.NoError(t, err)
+		txs = append(txs, tx)
+		s.sendTx(t, tx)
+	}
+	for _, tx := range txs {
+		s.waitProof(t, NewInstanceID(tx.Instructions[0].Hash()))
+	}
+
+	id := s.signer.Identity().String()
+	resp, err := s.service().GetSignerCounters(&GetSignerCounters{
+		SignerIDs:   []string{id, id, id, id},
+		SignerLanes: append([]uint64{0}, lanes...),
+		SkipchainID: s.genesis.SkipChainID(),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0, 1, 1, 1}, resp.Counters)
+
+	// The counter of a lane cannot be replayed.
+	resp2, err := s.service().AddTransaction(&AddTxRequest{
+		Version:       CurrentVersion,
+		SkipchainID:   s.genesis.SkipChainID(),
+		Transaction:   txs[0],
+		InclusionWait: 5,
+	})
+	require.NoError(t, err)
+	require.Contains(t, resp2.Error, "in lane 1")
+}
+
 func TestService_FloodLedger(t *testing.T) {
 	s := newSer(t, 2, testInterval)
 	defer s.local.CloseAll()
@@ -1980,35 +2691,58 @@ func TestService_DownloadState(t *testing.T) {
 
 	// Wrong parameters
 	log.Lvl1("Testing wrong parameters")
-	resp, err := s.service().DownloadState(&DownloadState{
+	resp, err := s.downloadState(DownloadState{
 		ByzCoinID: skipchain.SkipBlockID{},
 	})
 	require.Error(t, err)
-	resp, err = s.service().DownloadState(&DownloadState{
+	resp, err = s.downloadState(DownloadState{
 		ByzCoinID: skipchain.SkipBlockID{},
 		Nonce:     0,
 		Length:    1,
 	})
 	require.Error(t, err)
-	resp, err = s.service().DownloadState(&DownloadState{
+	resp, err = s.downloadState(DownloadState{
 		ByzCoinID: s.genesis.SkipChainID(),
 	})
 	require.Error(t, err)
-	resp, err = s.service().DownloadState(&DownloadState{
+	resp, err = s.downloadState(DownloadState{
 		ByzCoinID: s.genesis.SkipChainID(),
 		Nonce:     1,
 	})
 	require.Error(t, err)
-	resp, err = s.service().DownloadState(&DownloadState{
+	resp, err = s.downloadState(DownloadState{
 		ByzCoinID: s.genesis.SkipChainID(),
 		Nonce:     0,
 	})
 	require.Error(t, err)
 
-	// Start one download and check it is aborted
+	// Only a node of the roster can open a download, with a recent
+	// request.
+	_, err = s.service().DownloadState(&DownloadState{
+		ByzCoinID: s.genesis.SkipChainID(),
+		Length:    1,
+	})
+	require.Error(t, err)
+	servers, _, _ := s.local.MakeSRS(cothority.Suite, 1, ByzCoinID)
+	req := &DownloadState{
+		ByzCoinID: s.genesis.SkipChainID(),
+		Length:    1,
+	}
+	require.NoError(t, req.Sign(servers[0].ServerIdentity))
+	_, err = s.service().DownloadState(req)
+	require.Error(t, err)
+	require.NoError(t, req.Sign(s.service().ServerIdentity()))
+	req.Timestamp -= 2 * downloadSessionTimeout.Nanoseconds()
+	req.Signature, err = schnorr.Sign(cothority.Suite,
+		s.service().ServerIdentity().GetPrivate(), req.Hash())
+	require.NoError(t, err)
+	_, err = s.service().DownloadState(req)
+	require.Error(t, err)
+
+	// Start one download and check it is not aborted
 	// if we start a second download.
-	log.Lvl1("Check aborting of download and resuming")
-	resp, err = s.service().DownloadState(&DownloadState{
+	log.Lvl1("Check concurrent downloads and resuming")
+	resp, err = s.downloadState(DownloadState{
 		ByzCoinID: s.genesis.SkipChainID(),
 		Nonce:     0,
 		Length:    1,
@@ -2016,14 +2750,14 @@ func TestService_DownloadState(t *testing.T) {
 	require.NoError(t, err)
 	nonce1 := resp.Nonce
 	// Continue 1st download
-	resp, err = s.service().DownloadState(&DownloadState{
+	resp, err = s.downloadState(DownloadState{
 		ByzCoinID: s.genesis.SkipChainID(),
 		Nonce:     nonce1,
 		Length:    1,
 	})
 	require.NoError(t, err)
 	// Start 2nd download
-	resp, err = s.service().DownloadState(&DownloadState{
+	resp, err = s.downloadState(DownloadState{
 		ByzCoinID: s.genesis.SkipChainID(),
 		Nonce:     0,
 		Length:    1,
@@ -2031,24 +2765,54 @@ func TestService_DownloadState(t *testing.T) {
 	require.NoError(t, err)
 	nonce2 := resp.Nonce
 	require.NotEqual(t, nonce1, nonce2)
-	// Now 1st download should fail
-	resp, err = s.service().DownloadState(&DownloadState{
+	// Now 1st download should continue
+	resp, err = s.downloadState(DownloadState{
 		ByzCoinID: s.genesis.SkipChainID(),
 		Nonce:     nonce1,
 		Length:    1,
 	})
-	require.Error(t, err)
+	require.NoError(t, err)
+	third := resp.KeyValues[0].Key
 	// And 2nd download should still continue
-	resp, err = s.service().DownloadState(&DownloadState{
+	resp, err = s.downloadState(DownloadState{
 		ByzCoinID: s.genesis.SkipChainID(),
 		Nonce:     nonce2,
 		Length:    1,
 	})
 	require.NoError(t, err)
+	second := resp.KeyValues[0].Key
+	require.Equal(t, stateTrie.GetIndex(), resp.Index)
+	// Resume the 1st download after the second key.
+	resp, err = s.downloadState(DownloadState{
+		ByzCoinID: s.genesis.SkipChainID(),
+		Nonce:     nonce1,
+		Length:    1,
+		Start:     nextKey(second),
+	})
+	require.NoError(t, err)
+	require.Equal(t, third, resp.KeyValues[0].Key)
+	// A range stops before its end.
+	resp, err = s.downloadState(DownloadState{
+		ByzCoinID: s.genesis.SkipChainID(),
+		Nonce:     nonce1,
+		Length:    10,
+		Start:     second,
+		End:       third,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(resp.KeyValues))
+	require.Equal(t, second, resp.KeyValues[0].Key)
+	// An unknown session is refused.
+	resp, err = s.downloadState(DownloadState{
+		ByzCoinID: s.genesis.SkipChainID(),
+		Nonce:     nonce1 + nonce2,
+		Length:    1,
+	})
+	require.Error(t, err)
 
 	// Start downloading
 	log.Lvl1("Partial download")
-	resp, err = s.service().DownloadState(&DownloadState{
+	resp, err = s.downloadState(DownloadState{
 		ByzCoinID: s.genesis.SkipChainID(),
 		Nonce:     0,
 		Length:    10,
@@ -2062,7 +2826,7 @@ func TestService_DownloadState(t *testing.T) {
 	length := 0
 	var nonce uint64
 	for {
-		resp, err = s.service().DownloadState(&DownloadState{
+		resp, err = s.downloadState(DownloadState{
 			ByzCoinID: s.genesis.SkipChainID(),
 			Nonce:     nonce,
 			Length:    10,
@@ -2291,7 +3055,7 @@ func TestService_StateChangeStorage(t *testing.T) {
 	contract := func(cdb ReadOnlyStateTrie, inst Instruction, c []Coin) ([]StateChange, []Coin, error) {
 		// Check the version is correctly increased for multiple state changes
 		var scs []StateChange
-		if _, _, _, _, err := cdb.GetValues(iid.Slice()); xerrors.Is(err, errKeyNotSet) {
+		if _, _, _, _, err := cdb.GetValues(iid.Slice()); xerrors.Is(err, ErrKeyNotSet) {
 			scs = []StateChange{{
 				StateAction: Create,
 				InstanceID:  iid[:],
@@ -2610,6 +3374,17 @@ func (s *ser) service() *Service {
 	return s.services[0]
 }
 
+// downloadState sends the request to the first service, after signing it
+// with the first node if it asks for a new download.
+func (s *ser) downloadState(req DownloadState) (*DownloadStateResponse, error) {
+	if req.Nonce == 0 {
+		if err := req.Sign(s.service().ServerIdentity()); err != nil {
+			return nil, err
+		}
+	}
+	return s.service().DownloadState(&req)
+}
+
 func (s *ser) waitProof(t *testing.T, id InstanceID) Proof {
 	return s.waitProofWithIdx(t, id.Slice(), 0)
 }
@@ -2760,8 +3535,12 @@ func newSerWithVersion(t *testing.T, step int, interval time.Duration, n int, rw
 			"spawn:" + panicContract,
 			"spawn:" + slowContract,
 			"spawn:" + versionContract,
+			"spawn:" + eventContract,
 			"spawn:" + stateChangeCacheContract,
+			"spawn:" + subInstrContract,
 			"delete:" + dummyContract,
+			"invoke:" + dummyContract + ".update",
+			"invoke:" + ContractConfigID + ".upgrade_contract",
 		}, s.signer.Identity())
 	require.NoError(t, err)
 	s.darc = &genesisMsg.GenesisDarc
@@ -2908,6 +3687,21 @@ func versionContractFunc(rst ReadOnlyStateTrie, inst Instruction, c []Coin) ([]S
 	return []StateChange{sc}, c, nil
 }
 
+// Contract that spawns like the dummy contract and emits an event with the
+// value of the new instance.
+func eventContractFunc(rst ReadOnlyStateTrie, inst Instruction, c []Coin) ([]StateChange, []Coin, error) {
+	scs, cout, err := dummyContractFunc(rst, inst, c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !EmitEvent(rst, "spawned", NewInstanceID(scs[0].InstanceID),
+		NewEventAttribute("value", scs[0].Value)) {
+		return nil, nil, xerrors.New("couldn't emit event")
+	}
+	return scs, cout, nil
+}
+
 func registerDummy(servers []*onet.Server) {
 	// For testing - there must be a better way to do that. But putting
 	// services []skipchain.Service in the method signature doesn't work :(
@@ -2921,6 +3715,8 @@ func registerDummy(servers []*onet.Server) {
 		err = service.testRegisterContract(invalidContract, adaptor(invalidContractFunc))
 		log.ErrFatal(err)
 		err = service.testRegisterContract(versionContract, adaptor(versionContractFunc))
+		log.ErrFatal(err)
+		err = service.testRegisterContract(eventContract, adaptor(eventContractFunc))
 	}
 }
 
@@ -2935,3 +3731,10 @@ func (s *Service) testRegisterContract(contractID string, c ContractFn) error {
 	s.contracts.registry[contractID] = c
 	return nil
 }
+
+// testRegisterContractVersion adds the next version of the contract, ignoring
+// the lock of the registry.
+func (s *Service) testRegisterContractVersion(contractID string, c ContractFn, m ContractMigration) {
+	s.contracts.versions[contractID] = append(s.contracts.versions[contractID],
+		contractVersion{fn: c, migrate: m})
+}
@@ -0,0 +1,348 @@
+package byzcoin
+
+import (
+	"bytes"
+	"sort"
+
+	"go.dedis.ch/cothority/v3/skipchain"
+	"golang.org/x/xerrors"
+)
+
+// maxStateDiffBlocks is the maximum number of blocks that can be compared by
+// one GetStateDiff request.
+const maxStateDiffBlocks = 1000
+
+// errStateHistory is returned when the state change storage doesn't hold the
+// state changes of a block anymore.
+var errStateHistory = xerrors.New("state changes are not kept anymore")
+
+// GetStateDiff returns the instances that have been created, updated or
+// removed by the blocks after FromIndex, up to and including ToIndex. The
+// differences are taken from the state change storage, so the blocks must be
+// within the history kept by the node. A pruned node forwards the older
+// requests to the archive nodes, and the other ones refuse them: the
+// differences can still be computed offline with ReplayStateDiff.
+func (s *Service) GetStateDiff(req *GetStateDiff) (*GetStateDiffResponse, error) {
+	if req.FromIndex < 0 || req.ToIndex <= req.FromIndex {
+		return nil, xerrors.Errorf("invalid range: %d - %d", req.FromIndex,
+			req.ToIndex)
+	}
+	if req.ToIndex-req.FromIndex > maxStateDiffBlocks {
+		return nil, xerrors.Errorf("cannot compare more than %d blocks",
+			maxStateDiffBlocks)
+	}
+
+	latest, err := s.db().GetLatestByID(req.SkipChainID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting latest block: %v", err)
+	}
+	if req.ToIndex > latest.Index {
+		return nil, xerrors.Errorf("block %d doesn't exist yet", req.ToIndex)
+	}
+
+	resp, err := s.stateDiff(req)
+	if xerrors.Is(err, errStateHistory) && s.pruneDepth() > 0 {
+		reply := &GetStateDiffResponse{}
+		err = s.forwardToArchive(req.SkipChainID, req, reply)
+		if err != nil {
+			return nil, xerrors.Errorf("forwarding request: %v", err)
+		}
+		return reply, nil
+	}
+	return resp, err
+}
+
+// stateDiff compares, for every instance changed by the blocks of the range,
+// its last state change in the range with the version it had before the
+// range. Both are read from the state change storage, so neither the state
+// trie nor the transactions of the blocks are needed.
+func (s *Service) stateDiff(req *GetStateDiff) (*GetStateDiffResponse, error) {
+	sb, err := s.getBlockByIndex(req.SkipChainID, req.FromIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	first := make(map[string]StateChangeEntry)
+	last := make(map[string]StateChange)
+	for sb.Index < req.ToIndex {
+		sb, err = nextBlock(sb, s.getBlock)
+		if err != nil {
+			return nil, err
+		}
+		scs, err := s.storedStateChanges(sb)
+		if err != nil {
+			return nil, xerrors.Errorf("getting state changes of block %d: %w",
+				sb.Index, err)
+		}
+		for _, sc := range scs {
+			key := string(sc.InstanceID)
+			if _, ok := first[key]; !ok {
+				first[key] = StateChangeEntry{StateChange: sc, BlockIndex: sb.Index}
+			}
+			last[key] = sc
+		}
+	}
+
+	var diffs []StateDiff
+	for key, e := range first {
+		// A state change of the first version reverts to a Remove.
+		prev, err := s.revertStateChange(req.SkipChainID, e.StateChange,
+			e.BlockIndex)
+		if err != nil {
+			return nil, xerrors.Errorf("%w: %v", errStateHistory, err)
+		}
+		diff := StateDiff{InstanceID: NewInstanceID(e.StateChange.InstanceID)}
+		existed := prev.StateAction != Remove
+		if existed {
+			diff.OldValue, diff.OldContractID, diff.OldDarcID =
+				prev.Value, prev.ContractID, prev.DarcID
+		}
+		sc := last[key]
+		exists := sc.StateAction != Remove
+		if exists {
+			diff.NewValue, diff.NewContractID, diff.NewDarcID =
+				sc.Value, sc.ContractID, sc.DarcID
+		}
+		switch {
+		case existed && exists:
+			diff.Action = Update
+		case exists:
+			diff.Action = Create
+		case existed:
+			diff.Action = Remove
+		default:
+			// Created and removed in the range.
+			continue
+		}
+		diffs = append(diffs, diff)
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return bytes.Compare(diffs[i].InstanceID[:], diffs[j].InstanceID[:]) < 0
+	})
+	return &GetStateDiffResponse{Diffs: diffs}, nil
+}
+
+// ReplayStateDiff works like GetStateDiff, but replays the chain from the
+// genesis block using the blocks returned by the fetcher, so that it doesn't
+// need the blocks or the state of the service.
+func (s *Service) ReplayStateDiff(id skipchain.SkipBlockID, from, to int,
+	bf BlockFetcherFunc) (*GetStateDiffResponse, error) {
+	if from < 0 || to <= from {
+		return nil, xerrors.Errorf("invalid range: %d - %d", from, to)
+	}
+	genesis, err := bf(id)
+	if err != nil {
+		return nil, xerrors.Errorf("getting genesis block: %v", err)
+	}
+	if genesis == nil {
+		return nil, xerrors.New("genesis block is not available")
+	}
+	sst, err := newGenesisStateTrie(genesis)
+	if err != nil {
+		return nil, err
+	}
+	last, err := s.applyBlocks(sst, genesis, from, bf, nil)
+	if err != nil {
+		return nil, xerrors.Errorf("replaying blocks: %v", err)
+	}
+	first, err := nextBlock(last, bf)
+	if err != nil {
+		return nil, err
+	}
+	diffs := make(stateDiffs)
+	if _, err = s.applyBlocks(sst, first, to, bf, diffs); err != nil {
+		return nil, xerrors.Errorf("replaying blocks: %v", err)
+	}
+	return &GetStateDiffResponse{Diffs: diffs.result(sst)}, nil
+}
+
+// applyBlocks applies to sst the state changes of the block sb and of the
+// following ones, up to and including the block with index last, which is
+// returned. If diffs is not nil, it collects the instances changed by the
+// blocks.
+func (s *Service) applyBlocks(sst *stagingStateTrie, sb *skipchain.SkipBlock,
+	last int, bf BlockFetcherFunc, diffs stateDiffs) (*skipchain.SkipBlock, error) {
+	for {
+		scs, err := s.blockStateChanges(sst, sb)
+		if err != nil {
+			return nil, xerrors.Errorf("getting state changes of block %d: %w",
+				sb.Index, err)
+		}
+		if diffs != nil {
+			diffs.add(sst, scs)
+		}
+		if err = sst.StoreAll(scs); err != nil {
+			return nil, xerrors.Errorf("storing state changes: %v", err)
+		}
+		if sb.Index >= last {
+			break
+		}
+		sb, err = nextBlock(sb, bf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	header, err := decodeBlockHeader(sb)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding header: %v", err)
+	}
+	if !bytes.Equal(sst.GetRoot(), header.TrieRoot) {
+		return nil, xerrors.Errorf("state doesn't match block %d", sb.Index)
+	}
+	return sb, nil
+}
+
+// storedStateChanges returns the state changes of the block from the state
+// change storage. It returns errStateHistory if the storage doesn't hold all
+// of them anymore.
+func (s *Service) storedStateChanges(sb *skipchain.SkipBlock) (StateChanges, error) {
+	header, err := decodeBlockHeader(sb)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding header: %v", err)
+	}
+
+	entries, err := s.stateChangeStorage.getByBlock(sb.SkipChainID(), sb.Index)
+	if err != nil {
+		return nil, xerrors.Errorf("reading state changes: %v", err)
+	}
+	scs := make(StateChanges, len(entries))
+	for i, e := range entries {
+		scs[i] = e.StateChange
+	}
+	if !bytes.Equal(scs.Hash(), header.StateChangesHash) {
+		return nil, errStateHistory
+	}
+	return scs, nil
+}
+
+// blockStateChanges returns the state changes of the block. They are taken
+// from the state change storage if it still holds all of them, else the
+// transactions of the block are replayed on sst, which must hold the state
+// before the block. sst itself is not modified.
+func (s *Service) blockStateChanges(sst *stagingStateTrie,
+	sb *skipchain.SkipBlock) (StateChanges, error) {
+	scs, err := s.storedStateChanges(sb)
+	if !xerrors.Is(err, errStateHistory) {
+		return scs, err
+	}
+
+	header, err := decodeBlockHeader(sb)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding header: %v", err)
+	}
+	body, err := decodeBlockBody(sb)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding body: %w", err)
+	}
+	_, _, scs, _ = s.createStateChanges(sst.Clone(), sb.SkipChainID(),
+		body.TxResults, noTimeout, header.Version)
+	if !bytes.Equal(scs.Hash(), header.StateChangesHash) {
+		return nil, xerrors.New("replayed state changes don't match the block")
+	}
+	return scs, nil
+}
+
+// getBlock returns the block with the given ID from the database of the
+// service.
+func (s *Service) getBlock(id skipchain.SkipBlockID) (*skipchain.SkipBlock, error) {
+	sb := s.db().GetByID(id)
+	if sb == nil {
+		return nil, xerrors.Errorf("couldn't find block %x", id)
+	}
+	return sb, nil
+}
+
+// getBlockByIndex returns the block of the chain with the given index.
+func (s *Service) getBlockByIndex(scID skipchain.SkipBlockID,
+	idx int) (*skipchain.SkipBlock, error) {
+	reply, err := s.skService().GetSingleBlockByIndex(
+		&skipchain.GetSingleBlockByIndex{Genesis: scID, Index: idx})
+	if err != nil {
+		return nil, xerrors.Errorf("getting block %d: %v", idx, err)
+	}
+	return reply.SkipBlock, nil
+}
+
+// nextBlock returns the block following sb using its level 0 forward link.
+func nextBlock(sb *skipchain.SkipBlock, bf BlockFetcherFunc) (*skipchain.SkipBlock, error) {
+	if len(sb.ForwardLink) == 0 {
+		return nil, xerrors.Errorf("block %d has no forward link", sb.Index)
+	}
+	next, err := bf(sb.ForwardLink[0].To)
+	if err != nil {
+		return nil, xerrors.Errorf("getting block %d: %v", sb.Index+1, err)
+	}
+	if next == nil {
+		return nil, xerrors.Errorf("block %d is not available", sb.Index+1)
+	}
+	return next, nil
+}
+
+// newGenesisStateTrie returns an empty in-memory state with the nonce of the
+// chain of the genesis block.
+func newGenesisStateTrie(genesis *skipchain.SkipBlock) (*stagingStateTrie, error) {
+	body, err := decodeBlockBody(genesis)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding genesis block: %v", err)
+	}
+	nonce, err := loadNonceFromTxs(body.TxResults)
+	if err != nil {
+		return nil, xerrors.Errorf("getting nonce: %v", err)
+	}
+	sst, err := newMemStagingStateTrie(nonce)
+	if err != nil {
+		return nil, xerrors.Errorf("creating trie: %v", err)
+	}
+	return sst, nil
+}
+
+// stateDiffs collects the instances changed by a range of blocks, together
+// with their values before the first change in the range.
+type stateDiffs map[string]*StateDiff
+
+// add remembers the values of the instances changed by scs, if they are
+// changed for the first time. It must be called before scs are applied to
+// sst.
+func (d stateDiffs) add(sst *stagingStateTrie, scs StateChanges) {
+	for _, sc := range scs {
+		key := string(sc.InstanceID)
+		if _, ok := d[key]; ok {
+			continue
+		}
+		diff := &StateDiff{InstanceID: NewInstanceID(sc.InstanceID)}
+		// The existing instance is removed, unless it is found in
+		// the state at the end of the range.
+		v, _, cID, dID, err := sst.GetValues(sc.InstanceID)
+		if err == nil {
+			diff.OldValue, diff.OldContractID, diff.OldDarcID = v, cID, dID
+			diff.Action = Remove
+		}
+		d[key] = diff
+	}
+}
+
+// result returns the differences between the remembered values and the ones
+// in sst, sorted by instance ID. The instances that have been created and
+// removed in the range are left out.
+func (d stateDiffs) result(sst *stagingStateTrie) []StateDiff {
+	var diffs []StateDiff
+	for _, diff := range d {
+		v, _, cID, dID, err := sst.GetValues(diff.InstanceID[:])
+		if err == nil {
+			diff.NewValue, diff.NewContractID, diff.NewDarcID = v, cID, dID
+			if diff.Action == Remove {
+				diff.Action = Update
+			} else {
+				diff.Action = Create
+			}
+		} else if diff.Action != Remove {
+			continue
+		}
+		diffs = append(diffs, *diff)
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return bytes.Compare(diffs[i].InstanceID[:], diffs[j].InstanceID[:]) < 0
+	})
+	return diffs
+}
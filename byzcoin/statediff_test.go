@@ -0,0 +1,110 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestService_GetStateDiff(t *testing.T) {
+	s := newSer(t, 2, testInterval)
+	defer s.local.CloseAll()
+	scID := s.genesis.SkipChainID()
+
+	serKey := s.tx.Instructions[0].Hash()
+	pr := s.waitProof(t, NewInstanceID(serKey))
+	spawnIndex := pr.Latest.Index
+
+	del := Instruction{
+		InstanceID: NewInstanceID(serKey),
+		Delete: &Delete{
+			ContractID: dummyContract,
+		},
+		SignerCounter: []uint64{2},
+	}
+	tx, err := combineInstrsAndSign(s.signer, del)
+	require.NoError(t, err)
+	s.sendTxAndWait(t, tx, 10)
+	latest, err := s.service().db().GetLatestByID(scID)
+	require.NoError(t, err)
+
+	findDiff := func(resp *GetStateDiffResponse) *StateDiff {
+		for _, d := range resp.Diffs {
+			if d.InstanceID.Equal(NewInstanceID(serKey)) {
+				return &d
+			}
+		}
+		return nil
+	}
+
+	created, err := s.service().GetStateDiff(&GetStateDiff{
+		SkipChainID: scID,
+		FromIndex:   0,
+		ToIndex:     spawnIndex,
+	})
+	require.NoError(t, err)
+	d := findDiff(created)
+	require.NotNil(t, d)
+	require.Equal(t, Create, d.Action)
+	require.Equal(t, s.value, d.NewValue)
+	require.Equal(t, dummyContract, d.NewContractID)
+	require.Equal(t, s.darc.GetBaseID(), d.NewDarcID)
+	require.Empty(t, d.OldValue)
+
+	resp, err := s.service().GetStateDiff(&GetStateDiff{
+		SkipChainID: scID,
+		FromIndex:   spawnIndex,
+		ToIndex:     latest.Index,
+	})
+	require.NoError(t, err)
+	d = findDiff(resp)
+	require.NotNil(t, d)
+	require.Equal(t, Remove, d.Action)
+	require.Equal(t, s.value, d.OldValue)
+	require.Empty(t, d.NewValue)
+
+	// An instance created and removed in the range is not a difference.
+	resp, err = s.service().GetStateDiff(&GetStateDiff{
+		SkipChainID: scID,
+		FromIndex:   0,
+		ToIndex:     latest.Index,
+	})
+	require.NoError(t, err)
+	require.Nil(t, findDiff(resp))
+
+	// The same diff is computed by replaying the chain.
+	resp, err = s.service().ReplayStateDiff(scID, 0, spawnIndex, s.service().getBlock)
+	require.NoError(t, err)
+	require.Equal(t, created, resp)
+
+	// Once the storage is cleaned, the older blocks are refused instead of
+	// being replayed, but the replay from the blocks still works.
+	s.service().stateChangeStorage.setMaxNbrBlock(1)
+	tx, err = createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, s.value, s.signer, 3)
+	require.NoError(t, err)
+	s.sendTxAndWait(t, tx, 10)
+	_, err = s.service().GetStateDiff(&GetStateDiff{
+		SkipChainID: scID,
+		FromIndex:   0,
+		ToIndex:     spawnIndex,
+	})
+	require.Error(t, err)
+	require.True(t, xerrors.Is(err, errStateHistory))
+	resp, err = s.service().ReplayStateDiff(scID, 0, spawnIndex, s.service().getBlock)
+	require.NoError(t, err)
+	require.Equal(t, created, resp)
+
+	_, err = s.service().GetStateDiff(&GetStateDiff{
+		SkipChainID: scID,
+		FromIndex:   spawnIndex,
+		ToIndex:     spawnIndex,
+	})
+	require.Error(t, err)
+	_, err = s.service().GetStateDiff(&GetStateDiff{
+		SkipChainID: scID,
+		FromIndex:   0,
+		ToIndex:     latest.Index + 10,
+	})
+	require.Error(t, err)
+}
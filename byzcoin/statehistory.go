@@ -0,0 +1,210 @@
+package byzcoin
+
+import (
+	"bytes"
+	"fmt"
+
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"golang.org/x/xerrors"
+)
+
+// maxHistoryBlocks is the maximum number of blocks whose state changes are
+// reverted to rebuild a past state. The history can be shorter if the state
+// changes are cleaned earlier.
+var maxHistoryBlocks = 100
+
+// snapshotAttempts is how many times getStateSnapshot tries to take a snapshot
+// of a state that doesn't change while the state changes to revert are read.
+const snapshotAttempts = 3
+
+// historicalStateTrie is a read-only view of the global state as it was after
+// a past block has been applied. It reports the index of that block, so that
+// proofs created from it end at the past block and not at the latest one.
+type historicalStateTrie struct {
+	*stagingStateTrie
+	index int
+}
+
+// GetIndex returns the index of the block the trie belongs to.
+func (t *historicalStateTrie) GetIndex() int {
+	return t.index
+}
+
+// getHistoricalStateTrie rebuilds the global state as it was after the given
+// block has been applied. It starts from the current state trie and reverts
+// the state changes of all later blocks using the state change storage. The
+// resulting root is checked against the one stored in the header of the
+// block, so an error is returned if the storage has already been cleaned of
+// the needed state changes. The state cannot be rebuilt for a block that is
+// more than historyDepth blocks back.
+//
+// The caller must hold the updateTrieLock so that the state trie cannot change
+// while the history is rebuilt.
+func (s *Service) getHistoricalStateTrie(sb *skipchain.SkipBlock) (*historicalStateTrie, error) {
+	header, err := decodeBlockHeader(sb)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding header: %v", err)
+	}
+
+	scID := sb.SkipChainID()
+	st, err := s.getStateTrie(scID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting state trie: %v", err)
+	}
+	if sb.Index > st.GetIndex() {
+		return nil, xerrors.Errorf("block %d is not yet in the state trie",
+			sb.Index)
+	}
+
+	reverts, err := s.historyReverts(scID, st.GetIndex(), sb.Index)
+	if err != nil {
+		return nil, err
+	}
+	return makeHistoricalStateTrie(st, reverts, sb.Index, header.TrieRoot)
+}
+
+// stateSnapshot is a historicalStateTrie rebuilt on a read-only snapshot of
+// the database, so that it doesn't change when new blocks are applied. It
+// must be closed once it is not used anymore, and no other transaction on the
+// database can be started by the goroutine using it until then.
+type stateSnapshot struct {
+	*historicalStateTrie
+	db trie.DB
+}
+
+// Close releases the snapshot of the database.
+func (ss *stateSnapshot) Close() error {
+	return ss.db.Close()
+}
+
+// getStateSnapshot works like getHistoricalStateTrie, but rebuilds the state
+// on a snapshot of the database, so that the caller doesn't need to hold the
+// updateTrieLock. The state changes to revert are read before the snapshot is
+// taken, and the snapshot is taken again if a block has been applied in the
+// meantime.
+func (s *Service) getStateSnapshot(sb *skipchain.SkipBlock) (*stateSnapshot, error) {
+	header, err := decodeBlockHeader(sb)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding header: %v", err)
+	}
+
+	scID := sb.SkipChainID()
+	st, err := s.getStateTrie(scID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting state trie: %v", err)
+	}
+	db, bucket := s.GetAdditionalBucket([]byte(fmt.Sprintf("%x", scID)))
+
+	for i := 0; i < snapshotAttempts; i++ {
+		index := st.GetIndex()
+		if sb.Index > index {
+			return nil, xerrors.Errorf("block %d is not yet in the state trie",
+				sb.Index)
+		}
+		reverts, err := s.historyReverts(scID, index, sb.Index)
+		if err != nil {
+			return nil, err
+		}
+
+		snap, err := trie.NewSnapshotDB(db, bucket)
+		if err != nil {
+			return nil, xerrors.Errorf("taking snapshot: %v", err)
+		}
+		t, err := trie.LoadTrie(snap)
+		if err != nil {
+			snap.Close()
+			return nil, xerrors.Errorf("loading trie: %v", err)
+		}
+		sst := &stateTrie{Trie: *t}
+		if sst.GetIndex() != index {
+			// A block has been applied since the state changes
+			// have been read.
+			snap.Close()
+			continue
+		}
+
+		hst, err := makeHistoricalStateTrie(sst, reverts, sb.Index, header.TrieRoot)
+		if err != nil {
+			snap.Close()
+			return nil, err
+		}
+		return &stateSnapshot{historicalStateTrie: hst, db: snap}, nil
+	}
+	return nil, xerrors.New("state changed too often to take a snapshot")
+}
+
+// historyReverts returns the state changes that set the state after the block
+// at index from back to the state after the block at index to, in the order
+// they must be applied.
+func (s *Service) historyReverts(scID skipchain.SkipBlockID, from, to int) (StateChanges, error) {
+	if depth := s.stateChangeStorage.historyDepth(); from-to > depth {
+		return nil, xerrors.Errorf("cannot rebuild the state more than %d "+
+			"blocks back", depth)
+	}
+
+	var reverts StateChanges
+	for idx := from; idx > to; idx-- {
+		entries, err := s.stateChangeStorage.getByBlock(scID, idx)
+		if err != nil {
+			return nil, xerrors.Errorf("getting state changes of block %d: %v",
+				idx, err)
+		}
+
+		// The state changes must be reverted in the opposite order
+		// they have been applied.
+		for i := len(entries) - 1; i >= 0; i-- {
+			sc, err := s.revertStateChange(scID, entries[i].StateChange, idx)
+			if err != nil {
+				return nil, xerrors.Errorf("reverting block %d: %v", idx, err)
+			}
+			reverts = append(reverts, sc)
+		}
+	}
+	return reverts, nil
+}
+
+// makeHistoricalStateTrie applies the reverting state changes on a staging
+// copy of st and checks that the result has the root of the block at the
+// given index.
+func makeHistoricalStateTrie(st *stateTrie, reverts StateChanges, index int,
+	root []byte) (*historicalStateTrie, error) {
+	sst := st.MakeStagingStateTrie()
+	if err := sst.StoreAll(reverts); err != nil {
+		return nil, xerrors.Errorf("storing state changes: %v", err)
+	}
+	if !bytes.Equal(sst.GetRoot(), root) {
+		return nil, xerrors.Errorf("history of the state is not available "+
+			"for block %d", index)
+	}
+
+	return &historicalStateTrie{
+		stagingStateTrie: sst,
+		index:            index,
+	}, nil
+}
+
+// revertStateChange returns the state change that sets the instance of sc
+// back to the version it had before sc has been applied in block idx.
+func (s *Service) revertStateChange(scID skipchain.SkipBlockID, sc StateChange,
+	idx int) (StateChange, error) {
+	if sc.Version == 0 {
+		// The instance has been created by sc.
+		return StateChange{
+			StateAction: Remove,
+			InstanceID:  sc.InstanceID,
+		}, nil
+	}
+
+	prev, ok, err := s.stateChangeStorage.getPrevious(sc.InstanceID,
+		sc.Version-1, idx, scID)
+	if err != nil {
+		return StateChange{}, xerrors.Errorf("getting previous version: %v", err)
+	}
+	if !ok {
+		return StateChange{}, xerrors.Errorf("version %d of instance %x is "+
+			"not stored anymore", sc.Version-1, sc.InstanceID)
+	}
+
+	return prev.StateChange, nil
+}
@@ -146,7 +146,7 @@ func (s *Service) ReplayStateContLog(id skipchain.SkipBlockID,
 				if tx.Accepted {
 					txAccepted++
 					var scsTmp StateChanges
-					scsTmp, sst, err = s.processOneTx(sst, tx.ClientTransaction,
+					scsTmp, _, sst, err = s.processOneTx(sst, tx.ClientTransaction,
 						id)
 					if err != nil {
 						return nil, replayError(sb, err)
@@ -154,7 +154,7 @@ func (s *Service) ReplayStateContLog(id skipchain.SkipBlockID,
 
 					scs = append(scs, scsTmp...)
 				} else {
-					_, _, err = s.processOneTx(sst, tx.ClientTransaction, id)
+					_, _, _, err = s.processOneTx(sst, tx.ClientTransaction, id)
 					if err == nil {
 						return nil, replayError(sb, xerrors.New("refused transaction passes"))
 					}
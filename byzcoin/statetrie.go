@@ -12,7 +12,10 @@ import (
 	"golang.org/x/xerrors"
 )
 
-var errKeyNotSet = xerrors.New("key not set")
+// ErrKeyNotSet is returned by GetValues when the key is not in the state.
+// Other implementations of ReadOnlyStateTrie must wrap it too, as the
+// counters of the new signers rely on it.
+var ErrKeyNotSet = xerrors.New("key not set")
 
 // GlobalState is used to query for any data in byzcoin.
 type GlobalState interface {
@@ -53,6 +56,11 @@ type ReadOnlySkipChain interface {
 type globalState struct {
 	ReadOnlyStateTrie
 	ReadOnlySkipChain
+	// events is only set during the execution of an instruction.
+	events *eventCollector
+	// subs is only set during the execution of an instruction, to run its
+	// sub-instructions.
+	subs *subInstructions
 }
 
 var _ GlobalState = (*globalState)(nil)
@@ -61,6 +69,9 @@ var _ GlobalState = (*globalState)(nil)
 // byzcoin.
 type stagingStateTrie struct {
 	trie.StagingTrie
+	// reads is only set when the trie is used for a speculative execution,
+	// and is shared by the clones of the trie.
+	reads *accessRecorder
 }
 
 // Clone makes a copy of the staged data of the structure, the source Trie is
@@ -68,6 +79,7 @@ type stagingStateTrie struct {
 func (t *stagingStateTrie) Clone() *stagingStateTrie {
 	return &stagingStateTrie{
 		StagingTrie: *t.StagingTrie.Clone(),
+		reads:       t.reads,
 	}
 }
 
@@ -93,7 +105,7 @@ func (t *stagingStateTrie) GetValues(key []byte) (value []byte, version uint64,
 		return
 	}
 	if buf == nil {
-		err = cothority.WrapError(errKeyNotSet)
+		err = cothority.WrapError(ErrKeyNotSet)
 		return
 	}
 
@@ -213,7 +225,7 @@ func (t *stateTrie) GetValues(key []byte) (value []byte, version uint64, contrac
 		return
 	}
 	if buf == nil {
-		err = cothority.WrapError(errKeyNotSet)
+		err = cothority.WrapError(ErrKeyNotSet)
 		return
 	}
 
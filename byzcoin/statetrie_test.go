@@ -36,7 +36,7 @@ func TestStateTrie(t *testing.T) {
 	// store with bad expected root hash should fail, value should not be inside
 	require.Error(t, st.VerifiedStoreAll([]StateChange{sc}, 5, CurrentVersion, []byte("badhash")))
 	_, _, _, _, err = st.GetValues(key)
-	require.True(t, xerrors.Is(err, errKeyNotSet))
+	require.True(t, xerrors.Is(err, ErrKeyNotSet))
 
 	// store the state changes normally using StoreAll and it should work
 	require.NoError(t, st.StoreAll([]StateChange{sc}, 5, CurrentVersion))
@@ -46,7 +46,7 @@ func TestStateTrie(t *testing.T) {
 	require.Equal(t, st.GetIndex(), 6)
 
 	_, _, _, _, err = st.GetValues(append(key, byte(0)))
-	require.True(t, xerrors.Is(err, errKeyNotSet))
+	require.True(t, xerrors.Is(err, ErrKeyNotSet))
 
 	val, ver, cid, did, err := st.GetValues(key)
 	require.Equal(t, value, val)
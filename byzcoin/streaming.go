@@ -3,21 +3,201 @@ package byzcoin
 import (
 	"sync"
 
+	"go.dedis.ch/cothority/v3/darc"
 	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
 )
 
 func init() {
 	network.RegisterMessages(&StreamingRequest{}, &StreamingResponse{})
 }
 
+// streamingBufferSize is the number of blocks that can wait to be sent to a
+// listener. A listener that is too slow to keep up is disconnected so that it
+// doesn't block the others, and can reconnect using StartIndex.
+const streamingBufferSize = 100
+
+// streamingFilter holds the filters of a StreamingRequest. An instruction
+// matches if it matches all the filters that are set.
+type streamingFilter struct {
+	contractIDs  map[string]bool
+	instanceIDs  map[InstanceID]bool
+	darcIDs      map[string]bool
+	acceptedOnly bool
+}
+
+// newStreamingFilter returns nil if the request doesn't have any filter.
+func newStreamingFilter(req *StreamingRequest) *streamingFilter {
+	if len(req.ContractIDs) == 0 && len(req.InstanceIDs) == 0 &&
+		len(req.DarcIDs) == 0 && !req.AcceptedOnly {
+		return nil
+	}
+
+	f := &streamingFilter{acceptedOnly: req.AcceptedOnly}
+	if len(req.ContractIDs) > 0 {
+		f.contractIDs = make(map[string]bool)
+		for _, id := range req.ContractIDs {
+			f.contractIDs[id] = true
+		}
+	}
+	if len(req.InstanceIDs) > 0 {
+		f.instanceIDs = make(map[InstanceID]bool)
+		for _, id := range req.InstanceIDs {
+			f.instanceIDs[id] = true
+		}
+	}
+	if len(req.DarcIDs) > 0 {
+		f.darcIDs = make(map[string]bool)
+		for _, id := range req.DarcIDs {
+			f.darcIDs[string(id)] = true
+		}
+	}
+	return f
+}
+
+// darcLookup returns the darc that guarded an instance before the block being
+// filtered has been applied, or false if it is not known.
+type darcLookup func(id InstanceID) (darc.ID, bool)
+
+func (f *streamingFilter) matchInstruction(instr Instruction, darcOf darcLookup) bool {
+	if f.contractIDs != nil && !f.contractIDs[instr.ContractID()] {
+		return false
+	}
+	if f.instanceIDs != nil && !f.instanceIDs[instr.InstanceID] {
+		return false
+	}
+	if f.darcIDs != nil {
+		// The darc is the one of the instance the instruction is sent
+		// to, before the block, so that a spawn goes to the darc
+		// guarding the spawning instance and a deleted instance is
+		// still found.
+		if darcOf == nil {
+			return false
+		}
+		darcID, ok := darcOf(instr.InstanceID)
+		if !ok || !f.darcIDs[string(darcID)] {
+			return false
+		}
+	}
+	return true
+}
+
+// needsDarcs returns true if the filter needs to look up the darcs of the
+// instances.
+func (f *streamingFilter) needsDarcs() bool {
+	return f != nil && f.darcIDs != nil
+}
+
+// apply returns the transactions with only the matching instructions. The
+// darc of an instance is looked up with darcOf.
+func (f *streamingFilter) apply(txs TxResults, darcOf darcLookup) TxResults {
+	out := TxResults{}
+	for _, txr := range txs {
+		if f.acceptedOnly && !txr.Accepted {
+			continue
+		}
+
+		var instrs Instructions
+		for _, instr := range txr.ClientTransaction.Instructions {
+			if f.matchInstruction(instr, darcOf) {
+				instrs = append(instrs, instr)
+			}
+		}
+		if len(instrs) == 0 {
+			continue
+		}
+
+		txr.ClientTransaction.Instructions = instrs
+		out = append(out, txr)
+	}
+	return out
+}
+
+// filterBlock returns a copy of the block whose payload only holds the
+// matching transactions. As the payload is not part of the hash of the block,
+// the block stays valid, but the transactions cannot be verified against the
+// header anymore.
+func (f *streamingFilter) filterBlock(block *skipchain.SkipBlock, darcOf darcLookup) (*skipchain.SkipBlock, error) {
+	var body DataBody
+	if err := protobuf.Decode(block.Payload, &body); err != nil {
+		return nil, xerrors.Errorf("decoding body: %v", err)
+	}
+	body.TxResults = f.apply(body.TxResults, darcOf)
+
+	buf, err := protobuf.Encode(&body)
+	if err != nil {
+		return nil, xerrors.Errorf("encoding body: %v", err)
+	}
+
+	sb := *block
+	sb.Payload = buf
+	return &sb, nil
+}
+
+type streamingListener struct {
+	// The mutex makes sure the replayed blocks are not sent while the
+	// channel is closed.
+	sync.Mutex
+	out    chan *StreamingResponse
+	stop   chan struct{}
+	closed bool
+	filter *streamingFilter
+	// replaying is true as long as the missed blocks are sent by the
+	// replay, during which notify doesn't send the new blocks.
+	replaying bool
+	// next is the index of the next block to send, so that a block is not
+	// sent twice when the replay and notify overlap.
+	next int
+}
+
+func (l *streamingListener) response(block *skipchain.SkipBlock, darcOf darcLookup) (*StreamingResponse, error) {
+	if l.filter == nil {
+		return &StreamingResponse{Block: block}, nil
+	}
+
+	sb, err := l.filter.filterBlock(block, darcOf)
+	if err != nil {
+		return nil, xerrors.Errorf("filtering block: %v", err)
+	}
+	return &StreamingResponse{Block: sb}, nil
+}
+
+// send blocks until the response is sent or the listener is stopped. It must
+// only be used for the replay. It returns false if the listener is stopped.
+func (l *streamingListener) send(resp *StreamingResponse) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.closed {
+		return false
+	}
+	select {
+	case l.out <- resp:
+		return true
+	case <-l.stop:
+		return false
+	}
+}
+
+func (l *streamingListener) close() {
+	close(l.stop)
+
+	l.Lock()
+	defer l.Unlock()
+	l.closed = true
+	close(l.out)
+}
+
 type streamingManager struct {
 	sync.Mutex
 	// key: skipchain ID, value: slice of listeners
-	listeners map[string][]chan *StreamingResponse
+	listeners map[string][]*streamingListener
 }
 
-func (s *streamingManager) notify(scID string, block *skipchain.SkipBlock) {
+func (s *streamingManager) notify(scID string, block *skipchain.SkipBlock, darcOf darcLookup) {
 	s.Lock()
 	defer s.Unlock()
 
@@ -26,40 +206,81 @@ func (s *streamingManager) notify(scID string, block *skipchain.SkipBlock) {
 		return
 	}
 
-	for _, c := range ls {
-		c <- &StreamingResponse{
-			Block: block,
+	var slow []*streamingListener
+	for _, l := range ls {
+		if l.replaying || block.Index < l.next {
+			continue
+		}
+
+		resp, err := l.response(block, darcOf)
+		if err != nil {
+			log.Error("couldn't create streaming response:", err)
+			continue
+		}
+		l.next = block.Index + 1
+
+		select {
+		case l.out <- resp:
+		default:
+			slow = append(slow, l)
 		}
 	}
+
+	for _, l := range slow {
+		log.Warn("streaming listener is too slow - closing it")
+		s.removeListener(scID, l)
+	}
 }
 
-func (s *streamingManager) newListener(scID string) chan *StreamingResponse {
+// newListener adds a listener for the skipchain. If replaying is true, the new
+// blocks are not sent to the listener before endReplay has been called.
+func (s *streamingManager) newListener(scID string, filter *streamingFilter, replaying bool) *streamingListener {
 	s.Lock()
 	defer s.Unlock()
 
 	if s.listeners == nil {
-		s.listeners = make(map[string][]chan *StreamingResponse)
+		s.listeners = make(map[string][]*streamingListener)
 	}
 
-	ls := s.listeners[scID]
-	outChan := make(chan *StreamingResponse)
-	ls = append(ls, outChan)
-	s.listeners[scID] = ls
-	return outChan
+	l := &streamingListener{
+		out:       make(chan *StreamingResponse, streamingBufferSize),
+		stop:      make(chan struct{}),
+		filter:    filter,
+		replaying: replaying,
+	}
+	s.listeners[scID] = append(s.listeners[scID], l)
+	return l
 }
 
-func (s *streamingManager) stopListener(scID string, outChan chan *StreamingResponse) {
+// endReplay is called once the replay sent the block before index. If the
+// block at index is stored in the meantime, as reported by exists, it returns
+// false and the replay must continue. Else the new blocks will be sent by
+// notify from now on.
+func (s *streamingManager) endReplay(l *streamingListener, index int, exists func(int) bool) bool {
 	s.Lock()
 	defer s.Unlock()
 
-	ls := s.listeners[scID]
-	if ls == nil {
-		return
+	l.next = index
+	if exists(index) {
+		return false
 	}
+	l.replaying = false
+	return true
+}
+
+func (s *streamingManager) stopListener(scID string, l *streamingListener) {
+	s.Lock()
+	defer s.Unlock()
 
+	s.removeListener(scID, l)
+}
+
+// removeListener must be called with the lock held.
+func (s *streamingManager) removeListener(scID string, l *streamingListener) {
+	ls := s.listeners[scID]
 	for i, listener := range ls {
-		if listener == outChan {
-			close(listener)
+		if listener == l {
+			listener.close()
 			s.listeners[scID] = append(ls[:i], ls[i+1:]...)
 			return
 		}
@@ -70,10 +291,10 @@ func (s *streamingManager) stopAll() {
 	s.Lock()
 	defer s.Unlock()
 
-	for key, l := range s.listeners {
-		for _, c := range l {
+	for key, ls := range s.listeners {
+		for _, l := range ls {
 			// Force the streaming connection in Onet to close.
-			close(c)
+			l.close()
 		}
 
 		delete(s.listeners, key)
@@ -81,11 +302,27 @@ func (s *streamingManager) stopAll() {
 }
 
 // StreamTransactions will stream all transactions IDs to the client until the
-// client closes the connection.
+// client closes the connection. The blocks can be filtered and the missed
+// blocks can be replayed using the fields of the request.
 func (s *Service) StreamTransactions(msg *StreamingRequest) (chan *StreamingResponse, chan bool, error) {
 	stopChan := make(chan bool)
 	key := string(msg.ID)
-	outChan := s.streamingMan.newListener(key)
+	filter := newStreamingFilter(msg)
+
+	// The darcs of the replayed blocks are only known as far back as the
+	// state changes are kept.
+	if msg.StartIndex > 0 && filter.needsDarcs() {
+		st, err := s.getStateTrie(msg.ID)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("getting state trie: %v", err)
+		}
+		depth := s.stateChangeStorage.historyDepth()
+		if st.GetIndex()-msg.StartIndex > depth {
+			return nil, nil, xerrors.Errorf("cannot replay more than %d "+
+				"blocks with a darc filter", depth)
+		}
+	}
+	l := s.streamingMan.newListener(key, filter, msg.StartIndex > 0)
 
 	go func() {
 		s.closedMutex.Lock()
@@ -97,11 +334,123 @@ func (s *Service) StreamTransactions(msg *StreamingRequest) (chan *StreamingResp
 		defer s.working.Done()
 		s.closedMutex.Unlock()
 
+		if msg.StartIndex > 0 {
+			go s.replayBlocks(msg.ID, l, msg.StartIndex)
+		}
+
 		// Either the service is closing and we force the connection to stop or
 		// the streaming connection is closed upfront.
 		<-stopChan
 		// In both cases we clean the listener.
-		s.streamingMan.stopListener(key, outChan)
+		s.streamingMan.stopListener(key, l)
 	}()
-	return outChan, stopChan, nil
+	return l.out, stopChan, nil
+}
+
+// blockDarcLookup returns the darcLookup of the block at index. The darc of an
+// instance is the one of its last state change stored before the block, or of
+// the state change of the block creating it. An instance without any stored
+// state change didn't change for as long as the history goes, so its darc is
+// read from st, the latest state. The darcs are cached, as the lookup is
+// shared by the listeners.
+func (s *Service) blockDarcLookup(scID skipchain.SkipBlockID, index int,
+	st ReadOnlyStateTrie) darcLookup {
+	cache := make(map[InstanceID]darc.ID)
+	return func(id InstanceID) (darc.ID, bool) {
+		darcID, ok := cache[id]
+		if !ok {
+			darcID = s.instanceDarcBefore(scID, id, index, st)
+			cache[id] = darcID
+		}
+		return darcID, darcID != nil
+	}
+}
+
+// instanceDarcBefore returns the darc of the instance before the block at
+// index, or nil if it is not known.
+func (s *Service) instanceDarcBefore(scID skipchain.SkipBlockID, id InstanceID,
+	index int, st ReadOnlyStateTrie) darc.ID {
+	entries, err := s.stateChangeStorage.getAll(id.Slice(), scID)
+	if err != nil {
+		log.Error("couldn't get the state changes of the instance:", err)
+		return nil
+	}
+	if len(entries) == 0 {
+		_, _, _, darcID, err := st.GetValues(id.Slice())
+		if err != nil {
+			return nil
+		}
+		return darcID
+	}
+
+	// The entries are sorted by version, so also by block.
+	var prev *StateChangeEntry
+	for i := range entries {
+		if entries[i].BlockIndex >= index {
+			break
+		}
+		prev = &entries[i]
+	}
+	if prev != nil {
+		if prev.StateChange.StateAction == Remove {
+			return nil
+		}
+		return prev.StateChange.DarcID
+	}
+	first := entries[0].StateChange
+	if first.Version == 0 && entries[0].BlockIndex == index {
+		return first.DarcID
+	}
+	return nil
+}
+
+// replayBlocks sends the blocks starting at index to the listener until it
+// reaches the latest block, then lets notify send the new blocks. If the
+// replay fails, the listener is stopped so that the client can reconnect.
+func (s *Service) replayBlocks(scID skipchain.SkipBlockID, l *streamingListener, index int) {
+	key := string(scID)
+	// A block is replayed once it is in the state trie, because notify
+	// is only called after that.
+	stored := func(idx int) bool {
+		trie, err := s.getStateTrie(scID)
+		return err == nil && trie.GetIndex() >= idx
+	}
+
+	for {
+		if !stored(index) {
+			if s.streamingMan.endReplay(l, index, stored) {
+				return
+			}
+			continue
+		}
+
+		reply, err := s.skService().GetSingleBlockByIndex(
+			&skipchain.GetSingleBlockByIndex{Genesis: scID, Index: index})
+		if err != nil {
+			log.Error("couldn't get block to replay:", err)
+			s.streamingMan.stopListener(key, l)
+			return
+		}
+
+		var darcOf darcLookup
+		if l.filter.needsDarcs() {
+			st, err := s.GetReadOnlyStateTrie(scID)
+			if err != nil {
+				log.Error("couldn't get the state trie:", err)
+				s.streamingMan.stopListener(key, l)
+				return
+			}
+			darcOf = s.blockDarcLookup(scID, index, st)
+		}
+		resp, err := l.response(reply.SkipBlock, darcOf)
+		if err != nil {
+			log.Error("couldn't create streaming response:", err)
+			s.streamingMan.stopListener(key, l)
+			return
+		}
+		if !l.send(resp) {
+			return
+		}
+		index++
+	}
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"reflect"
 	"sort"
 	"strings"
 	"sync"
@@ -12,6 +13,7 @@ import (
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/skipchain"
 	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
 	"go.dedis.ch/protobuf"
 	bbolt "go.etcd.io/bbolt"
 	"golang.org/x/xerrors"
@@ -119,7 +121,20 @@ func (s *stateChangeStorage) setMaxSize(size int) {
 // setMaxNbrBlock enables the cleaning of state changes belonging
 // to blocks with an old index.
 func (s *stateChangeStorage) setMaxNbrBlock(nbr int) {
+	s.Lock()
 	s.maxNbrBlock = nbr
+	s.Unlock()
+}
+
+// historyDepth returns how many blocks back the state can be rebuilt: the
+// number of blocks the state changes are kept for, up to maxHistoryBlocks.
+func (s *stateChangeStorage) historyDepth() int {
+	s.Lock()
+	defer s.Unlock()
+	if s.maxNbrBlock > 0 && s.maxNbrBlock < maxHistoryBlocks {
+		return s.maxNbrBlock
+	}
+	return maxHistoryBlocks
 }
 
 // calculateSize reads the entries in the database and sums up their
@@ -468,6 +483,57 @@ func (s *stateChangeStorage) getByVersion(iid []byte,
 	return
 }
 
+// getPrevious returns the entry of the given version of an instance that was
+// stored in the newest block not after idx. Contrary to getByVersion, this
+// also works for instances that have been removed and created again. Use the
+// bool returned value to check if the version exists.
+func (s *stateChangeStorage) getPrevious(iid []byte, ver uint64, idx int,
+	sid skipchain.SkipBlockID) (sce StateChangeEntry, ok bool, err error) {
+	s.Lock()
+	defer s.Unlock()
+	if len(iid) != prefixLength {
+		err = cothority.WrapError(errLengthInstanceID)
+		return
+	}
+
+	from, err := s.key(iid, ver, int64(0))
+	if err != nil {
+		err = xerrors.Errorf("key: %v", err)
+		return
+	}
+	to, err := s.key(iid, ver, int64(idx))
+	if err != nil {
+		err = xerrors.Errorf("key: %v", err)
+		return
+	}
+
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		b := s.getBucket(tx, sid)
+		if b == nil {
+			return nil
+		}
+
+		var last []byte
+		c := b.Cursor()
+		for k, v := c.Seek(from); k != nil && bytes.Compare(k, to) <= 0; k, v = c.Next() {
+			last = v
+		}
+		if last != nil {
+			err := protobuf.Decode(last, &sce)
+			if err != nil {
+				return xerrors.Errorf("decoding: %v", err)
+			}
+
+			ok = true
+		}
+
+		return nil
+	})
+
+	err = cothority.ErrorOrNil(err, "tx error")
+	return
+}
+
 // getByBlock looks for the state changes associated with a given
 // skipblock
 func (s *stateChangeStorage) getByBlock(sid skipchain.SkipBlockID, idx int) (entries StateChangeEntries, err error) {
@@ -645,12 +711,77 @@ func (c ChainConfig) sanityCheck(old *ChainConfig) error {
 	if len(c.Roster.List) < 3 {
 		return xerrors.New("need at least 3 nodes to have a majority")
 	}
+	if c.MeteringCoin != nil && c.MeteringBudget == 0 {
+		return xerrors.New("metering coin is set without a metering budget")
+	}
+	if c.CheckpointInterval < 0 {
+		return xerrors.New("checkpoint interval is negative")
+	}
+	for _, si := range c.ArchiveNodes {
+		if i, _ := c.Roster.Search(si.ID); i < 0 {
+			return xerrors.Errorf("archive node %s is not in the roster", si)
+		}
+	}
+	if q := c.TxQuota; q != nil {
+		if q.MaxPendingTxs < 0 || q.MaxPendingBytes < 0 ||
+			q.MaxSignerTxs < 0 || q.MaxSignerBytes < 0 {
+			return xerrors.New("transaction quota is negative")
+		}
+	}
 	if old != nil {
+		if !reflect.DeepEqual(c.activeContractVersions(), old.activeContractVersions()) {
+			return xerrors.New("contract versions can only be changed with upgrade_contract")
+		}
 		return cothority.ErrorOrNil(old.checkNewRoster(c.Roster), "roster check: %v")
 	}
 	return nil
 }
 
+// isArchiveNode returns true if the node keeps the transactions of all the
+// blocks.
+func (c ChainConfig) isArchiveNode(id network.ServerIdentityID) bool {
+	for _, si := range c.ArchiveNodes {
+		if si.ID.Equal(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// contractVersion returns the active version of the contract.
+func (c ChainConfig) contractVersion(contractID string) uint64 {
+	for _, v := range c.ContractVersions {
+		if v.ContractID == contractID {
+			return v.Version
+		}
+	}
+	return 0
+}
+
+// setContractVersion changes the active version of the contract.
+func (c *ChainConfig) setContractVersion(contractID string, version uint64) {
+	for i := range c.ContractVersions {
+		if c.ContractVersions[i].ContractID == contractID {
+			c.ContractVersions[i].Version = version
+			return
+		}
+	}
+	c.ContractVersions = append(c.ContractVersions,
+		ContractVersion{ContractID: contractID, Version: version})
+}
+
+// activeContractVersions returns the versions of the contracts that are not
+// at their first version.
+func (c ChainConfig) activeContractVersions() map[string]uint64 {
+	vs := make(map[string]uint64)
+	for _, v := range c.ContractVersions {
+		if v.Version > 0 {
+			vs[v.ContractID] = v.Version
+		}
+	}
+	return vs
+}
+
 // checkNewRoster makes sure that the new roster follows the rules we need
 // in byzcoin:
 //   - no new node can join as leader
@@ -701,5 +832,34 @@ func (c ChainConfig) String() string {
 	for i, darcID := range c.DarcContractIDs {
 		fmt.Fprintf(res, "--- darc contract ID %d: %s\n", i, darcID)
 	}
+	if c.MeteringBudget > 0 {
+		fmt.Fprintf(res, "-- MeteringBudget: %d\n", c.MeteringBudget)
+	}
+	if c.MeteringCoin != nil {
+		fmt.Fprintf(res, "-- MeteringCoin: %x\n", c.MeteringCoin[:])
+		fmt.Fprintf(res, "-- MeteringPrice: %d\n", c.MeteringPrice)
+	}
+	if len(c.ContractVersions) > 0 {
+		res.WriteString("-- ContractVersions:\n")
+		for _, v := range c.ContractVersions {
+			fmt.Fprintf(res, "--- %s: %d\n", v.ContractID, v.Version)
+		}
+	}
+	if c.CheckpointInterval > 0 {
+		fmt.Fprintf(res, "-- CheckpointInterval: %d\n", c.CheckpointInterval)
+	}
+	if len(c.ArchiveNodes) > 0 {
+		res.WriteString("-- ArchiveNodes:\n")
+		for _, si := range c.ArchiveNodes {
+			fmt.Fprintf(res, "--- %s\n", si)
+		}
+	}
+	if q := c.TxQuota; q != nil {
+		res.WriteString("-- TxQuota:\n")
+		fmt.Fprintf(res, "--- MaxPendingTxs: %d\n", q.MaxPendingTxs)
+		fmt.Fprintf(res, "--- MaxPendingBytes: %d\n", q.MaxPendingBytes)
+		fmt.Fprintf(res, "--- MaxSignerTxs: %d\n", q.MaxSignerTxs)
+		fmt.Fprintf(res, "--- MaxSignerBytes: %d\n", q.MaxSignerBytes)
+	}
 	return res.String()
 }
@@ -0,0 +1,187 @@
+package byzcoin
+
+import (
+	"bytes"
+
+	"go.dedis.ch/cothority/v3/darc"
+	"golang.org/x/xerrors"
+)
+
+// maxInstructionDepth is the maximum depth of the sub-instructions: the
+// instruction of a transaction can run sub-instructions, which can run their
+// own, down to this depth.
+const maxInstructionDepth = 4
+
+// InstructionRunner is implemented by the global state given to the
+// contracts. It allows a contract to run sub-instructions on other
+// instances, see RunInstruction.
+type InstructionRunner interface {
+	RunInstruction(instr Instruction, coins []Coin) (StateChanges, []Coin, error)
+}
+
+// RunInstruction runs a sub-instruction from a contract, if the global state
+// given to the contract supports it. The sub-instruction is not signed: it
+// must be authorized by the darc of its instance with the identities that
+// signed the instruction of the transaction, and its counters are ignored.
+// The VerifyInstruction method of its contract is called, and the
+// verification of the signatures and the counters by Instruction.Verify is
+// replaced by these identities.
+//
+// The sub-instruction sees the global state as it was before the instruction
+// of the contract, with the changes of the previous sub-instructions. Its
+// state changes are returned for information, the contract must not return
+// them: they are stored before the ones of the contract if the contract
+// succeeds, and are dropped otherwise. The coins returned by the
+// sub-instruction are given back to the contract.
+func RunInstruction(rst ReadOnlyStateTrie, instr Instruction, coins []Coin) (StateChanges, []Coin, error) {
+	r, ok := rst.(InstructionRunner)
+	if !ok {
+		return nil, nil, xerrors.New("this global state cannot run sub-instructions")
+	}
+	return r.RunInstruction(instr, coins)
+}
+
+// InstructionAuthorizer is implemented by the global state given to the
+// contracts. It allows a contract to check the darc of another instance, see
+// AuthorizeInstruction.
+type InstructionAuthorizer interface {
+	AuthorizeInstruction(instr Instruction) error
+}
+
+// AuthorizeInstruction checks that the action of instr is allowed by the darc
+// of its instance to the identities that signed the instruction of the
+// transaction, the same way as for a sub-instruction. It allows a contract
+// to change another instance directly, only if the signers control it. The
+// signatures and the counters of instr are ignored.
+func AuthorizeInstruction(rst ReadOnlyStateTrie, instr Instruction) error {
+	a, ok := rst.(InstructionAuthorizer)
+	if !ok {
+		return xerrors.New("this global state cannot authorize instructions")
+	}
+	return a.AuthorizeInstruction(instr)
+}
+
+// AuthorizeInstruction implements InstructionAuthorizer. The instructions
+// can only be authorized during the execution of an instruction.
+func (gs globalState) AuthorizeInstruction(instr Instruction) error {
+	if gs.subs == nil {
+		return xerrors.New("instructions can only be authorized for a contract")
+	}
+	return instr.verifyWithIdentities(gs, gs.subs.signers())
+}
+
+// RunInstruction implements InstructionRunner. The sub-instructions can
+// only be run during the execution of an instruction.
+func (gs globalState) RunInstruction(instr Instruction, coins []Coin) (StateChanges, []Coin, error) {
+	if gs.subs == nil {
+		return nil, nil, xerrors.New("sub-instructions can only be run by a contract")
+	}
+	return gs.subs.run(instr, coins)
+}
+
+// subInstructions holds what is needed to run the sub-instructions of an
+// instruction, and collects their state changes.
+type subInstructions struct {
+	// gs is the global state of the instruction, without the meter.
+	gs        globalState
+	contracts *contractRegistry
+	instr     Instruction
+	ctxHash   []byte
+	// identities are the identities that authorize the sub-instructions.
+	// For the instruction of a transaction, they are the signers with a
+	// valid signature and are only computed if needed.
+	identities []darc.Identity
+	verified   bool
+	depth      int
+	m          *meter
+	ec         *eventCollector
+	scs        StateChanges
+}
+
+// newSubInstructions returns the context of the sub-instructions of an
+// instruction, which is itself a sub-instruction if parent is not nil.
+func newSubInstructions(gs globalState, contracts *contractRegistry,
+	instr Instruction, ctxHash []byte, m *meter, ec *eventCollector,
+	parent *subInstructions) *subInstructions {
+	s := &subInstructions{
+		gs:        gs,
+		contracts: contracts,
+		instr:     instr,
+		ctxHash:   ctxHash,
+		depth:     1,
+		m:         m,
+		ec:        ec,
+	}
+	if parent != nil {
+		s.identities = instr.SignerIdentities
+		s.verified = true
+		s.depth = parent.depth + 1
+	}
+	return s
+}
+
+// isSubInstruction returns true if instr is the sub-instruction run by the
+// contract given the global state. It is not signed, and its identities are
+// the ones that authorized the instruction running it.
+func isSubInstruction(st ReadOnlyStateTrie, instr Instruction) bool {
+	gs, ok := st.(globalState)
+	return ok && gs.subs != nil && gs.subs.depth > 1 &&
+		len(instr.Signatures) == 0 &&
+		bytes.Equal(instr.Hash(), gs.subs.instr.Hash())
+}
+
+// signers returns the identities that authorize the sub-instructions.
+func (s *subInstructions) signers() []darc.Identity {
+	if !s.verified {
+		for i, id := range s.instr.SignerIdentities {
+			if i < len(s.instr.Signatures) &&
+				id.Verify(s.ctxHash, s.instr.Signatures[i]) == nil {
+				s.identities = append(s.identities, id)
+			}
+		}
+		s.verified = true
+	}
+	return s.identities
+}
+
+func (s *subInstructions) run(instr Instruction, cin []Coin) (StateChanges, []Coin, error) {
+	if s.depth > maxInstructionDepth {
+		return nil, nil, xerrors.Errorf("sub-instructions are limited to a depth of %d",
+			maxInstructionDepth)
+	}
+	st, err := s.gs.ReadOnlyStateTrie.StoreAllToReplica(s.scs)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("applying previous sub-instructions: %v", err)
+	}
+	gs := s.gs
+	gs.ReadOnlyStateTrie = st
+	gs.subs = s
+
+	sub := instr
+	sub.SignerIdentities = s.signers()
+	sub.SignerCounter = nil
+	sub.SignerLanes = nil
+	sub.Signatures = nil
+	sub.version = s.instr.version
+
+	// The events of the sub-instruction are kept with its contract ID, and
+	// are dropped if it fails.
+	var contractID string
+	var events int
+	if s.ec != nil {
+		contractID, events = s.ec.contractID, len(s.ec.events)
+	}
+	scs, cout, err := executeInstruction(gs, s.contracts, cin, sub, s.ctxHash,
+		s.m, s.ec)
+	if s.ec != nil {
+		s.ec.contractID = contractID
+		if err != nil {
+			s.ec.events = s.ec.events[:events]
+		}
+	}
+	if err != nil {
+		return nil, nil, xerrors.Errorf("sub-instruction: %w", err)
+	}
+	s.scs = append(s.scs, scs...)
+	return scs, cout, nil
+}
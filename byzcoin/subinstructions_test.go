@@ -0,0 +1,132 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/darc/expression"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+func TestService_SubInstructions(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	// The contract spawns a dummy instance with a sub-instruction for the
+	// "data" argument, runs itself again for the "depth" argument, and
+	// spawns an instance not allowed by the darc for any other argument.
+	contract := func(rst ReadOnlyStateTrie, inst Instruction, c []Coin) ([]StateChange, []Coin, error) {
+		arg := inst.Spawn.Args[0]
+		sub := inst
+		switch arg.Name {
+		case "data":
+			sub = createSpawnInstr(inst.InstanceID.Slice(), dummyContract, "data", arg.Value)
+		case "depth":
+		default:
+			sub = createSpawnInstr(inst.InstanceID.Slice(), invalidContract+"x", "data", arg.Value)
+		}
+		scs, cout, err := RunInstruction(rst, sub, c)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(scs) != 1 {
+			return nil, nil, xerrors.New("expected one state change")
+		}
+		return nil, cout, nil
+	}
+	require.NoError(t, s.service().testRegisterContract(subInstrContract, adaptor(contract)))
+
+	scID := s.genesis.SkipChainID()
+	st, err := s.service().getStateTrie(scID)
+	require.NoError(t, err)
+	sst := st.MakeStagingStateTrie()
+
+	tx1, err := createOneClientTxWithCounter(s.darc.GetBaseID(), subInstrContract, s.value, s.signer, 2)
+	require.NoError(t, err)
+	instr := createSpawnInstr(s.darc.GetBaseID(), subInstrContract, "depth", s.value)
+	instr.SignerCounter = []uint64{3}
+	tx2, err := combineInstrsAndSign(s.signer, instr)
+	require.NoError(t, err)
+	instr = createSpawnInstr(s.darc.GetBaseID(), subInstrContract, "other", s.value)
+	instr.SignerCounter = []uint64{3}
+	tx3, err := combineInstrsAndSign(s.signer, instr)
+	require.NoError(t, err)
+
+	_, txOut, states, _ := s.service().createStateChanges(sst, scID,
+		NewTxResults(tx1, tx2, tx3), noTimeout, CurrentVersion)
+	require.Equal(t, 3, len(txOut))
+	require.True(t, txOut[0].Accepted)
+	// The depth of the sub-instructions is limited.
+	require.False(t, txOut[1].Accepted)
+	// The sub-instructions are checked against the darc.
+	require.False(t, txOut[2].Accepted)
+
+	// The dummy instance and the counter of the signer.
+	require.Equal(t, 2, len(states))
+	require.Equal(t, Create, states[0].StateAction)
+	require.Equal(t, dummyContract, states[0].ContractID)
+	require.Equal(t, s.value, states[0].Value)
+	require.Equal(t, "", states[1].ContractID)
+
+	// Outside of a contract, no sub-instruction can be run.
+	_, _, err = RunInstruction(sst, tx1.Instructions[0], nil)
+	require.Error(t, err)
+}
+
+// TestService_SubInstructionsVerify checks that a sub-instruction is verified
+// by its contract, here the naming contract, which checks the darc of the
+// named instance instead of its own.
+func TestService_SubInstructionsVerify(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	// The contract names the instance given in its argument.
+	contract := func(rst ReadOnlyStateTrie, inst Instruction, c []Coin) ([]StateChange, []Coin, error) {
+		sub := createInvokeInstr(NamingInstanceID, ContractNamingID, "add",
+			"instanceID", inst.Spawn.Args[0].Value)
+		sub.Invoke.Args = append(sub.Invoke.Args, Argument{Name: "name", Value: []byte("sub")})
+		_, cout, err := RunInstruction(rst, sub, c)
+		return nil, cout, err
+	}
+	require.NoError(t, s.service().testRegisterContract(subInstrContract, adaptor(contract)))
+
+	scID := s.genesis.SkipChainID()
+	st, err := s.service().getStateTrie(scID)
+	require.NoError(t, err)
+	sst := st.MakeStagingStateTrie()
+
+	// The naming instance, and two darcs allowing the naming to another
+	// signer and to the signer of the transactions.
+	namingBuf, err := protobuf.Encode(&ContractNamingBody{Latest: InstanceID{}})
+	require.NoError(t, err)
+	scs := StateChanges{NewStateChange(Create, NamingInstanceID, ContractNamingID, namingBuf, nil)}
+	var darcIDs []darc.ID
+	for _, id := range []darc.Identity{darc.NewSignerEd25519(nil, nil).Identity(), s.signer.Identity()} {
+		rules := darc.InitRules([]darc.Identity{id}, []darc.Identity{id})
+		require.NoError(t, rules.AddRule(darc.Action("_name:"+ContractDarcID), expression.Expr(id.String())))
+		d := darc.NewDarc(rules, []byte("named"))
+		dBuf, err := d.ToProto()
+		require.NoError(t, err)
+		scs = append(scs, NewStateChange(Create, NewInstanceID(d.GetBaseID()), ContractDarcID, dBuf, d.GetBaseID()))
+		darcIDs = append(darcIDs, d.GetBaseID())
+	}
+	require.NoError(t, sst.StoreAll(scs))
+
+	tx1, err := createOneClientTxWithCounter(s.darc.GetBaseID(), subInstrContract, darcIDs[0], s.signer, 2)
+	require.NoError(t, err)
+	tx2, err := createOneClientTxWithCounter(s.darc.GetBaseID(), subInstrContract, darcIDs[1], s.signer, 2)
+	require.NoError(t, err)
+
+	_, txOut, states, _ := s.service().createStateChanges(sst, scID,
+		NewTxResults(tx1, tx2), noTimeout, CurrentVersion)
+	require.Equal(t, 2, len(txOut))
+	// The signer doesn't control the darc of the first instance.
+	require.False(t, txOut[0].Accepted)
+	require.True(t, txOut[1].Accepted)
+
+	// The name, the naming instance and the counter of the signer.
+	require.Equal(t, 3, len(states))
+	require.Equal(t, ContractNamingID, states[1].ContractID)
+}
@@ -109,7 +109,7 @@ func (ctx *ClientTransaction) FillSignersAndSignWith(signers ...darc.Signer) err
 // SignWith signs all the instructions with the same signers. If some instructions need to be signed by different sets
 // of signers, then use the SignWith method of Instruction.
 func (ctx *ClientTransaction) SignWith(signers ...darc.Signer) error {
-	digest := ctx.Instructions.Hash()
+	digest := ctx.Digest()
 	for i := range ctx.Instructions {
 		if err := ctx.Instructions[i].SignWith(digest, signers...); err != nil {
 			return err
@@ -181,6 +181,13 @@ func (instr Instruction) hashSigners(h hash.Hash) {
 		h.Write(lenBuf)
 		h.Write(buf)
 	}
+	// The lanes are only hashed when they are set, so that the hash of the
+	// instructions without lanes doesn't change.
+	for _, lane := range instr.SignerLanes {
+		laneBuf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(laneBuf, lane)
+		h.Write(laneBuf)
+	}
 }
 
 // DeriveID derives a new InstanceID from the hash of the instruction, its signatures,
@@ -281,6 +288,9 @@ func (instr Instruction) String() string {
 	fmt.Fprintf(&out, "-- action: %s\n", instr.Action())
 	fmt.Fprintf(&out, "-- identities: %v\n", instr.SignerIdentities)
 	fmt.Fprintf(&out, "-- counters: %v\n", instr.SignerCounter)
+	if len(instr.SignerLanes) > 0 {
+		fmt.Fprintf(&out, "-- lanes: %v\n", instr.SignerLanes)
+	}
 	fmt.Fprintf(&out, "-- signatures: %d\n", len(instr.Signatures))
 	out.WriteString(eachLine.ReplaceAllString(methodStr, "-$1"))
 
@@ -298,6 +308,9 @@ func (instr *Instruction) SignWith(msg []byte, signers ...darc.Signer) error {
 	if len(signers) != len(instr.SignerCounter) {
 		return xerrors.New("the number of signers does not match the number of counters")
 	}
+	if len(instr.SignerLanes) > 0 && len(signers) != len(instr.SignerLanes) {
+		return xerrors.New("the number of signers does not match the number of lanes")
+	}
 	instr.Signatures = make([][]byte, len(signers))
 	for i := range signers {
 		signerID := signers[i].Identity()
@@ -345,16 +358,11 @@ func (instr Instruction) VerifyWithOption(st ReadOnlyStateTrie, msg []byte, ops
 		ops = &VerificationOptions{}
 	}
 
-	// check the number of signers match with the number of signatures
-	if len(instr.SignerIdentities) != len(instr.Signatures) {
-		return xerrors.New("lengh of identities does not match the length of signatures")
-	}
-
-	// check the signature counters
-	if !ops.IgnoreCounters {
-		if err := verifySignerCounters(st, instr.SignerCounter, instr.SignerIdentities); err != nil {
-			return xerrors.Errorf("signer counter: %v", err)
-		}
+	// check the signatures and the signature counters, and save the
+	// identities that provide good signatures
+	goodIdentities, err := instr.signerIdentities(st, msg, !ops.IgnoreCounters)
+	if err != nil {
+		return err
 	}
 
 	// get the valid DARC contract IDs from the configuration
@@ -368,26 +376,87 @@ func (instr Instruction) VerifyWithOption(st ReadOnlyStateTrie, msg []byte, ops
 	if err != nil {
 		return xerrors.Errorf("darc not found: %v", err)
 	}
-	if len(instr.Signatures) == 0 {
-		return xerrors.New("no signatures - nothing to verify")
-	}
 
 	// check the action
 	if !d.Rules.Contains(darc.Action(instr.Action())) {
 		return xerrors.Errorf("action '%v' does not exist", instr.Action())
 	}
 
-	// check the signature
-	// Save the identities that provide good signatures
+	// check the expression
+	getDarc := darcGetter(st)
+	if ops.EvalAttr != nil {
+		err := darc.EvalExprAttr(d.Rules.Get(darc.Action(instr.Action())), getDarc, ops.EvalAttr, goodIdentities...)
+		return cothority.ErrorOrNil(err, "evaluating darc")
+	}
+	err = darc.EvalExpr(d.Rules.Get(darc.Action(instr.Action())), getDarc, goodIdentities...)
+	return cothority.ErrorOrNil(err, "evaluating darc")
+}
+
+// signerIdentities returns the identities of the signers with a good
+// signature on msg, after checking the counters if checkCounters is true. A
+// sub-instruction is not signed and its counters are ignored: its identities
+// are the ones that authorized the instruction running it.
+func (instr Instruction) signerIdentities(st ReadOnlyStateTrie, msg []byte,
+	checkCounters bool) ([]string, error) {
+	if isSubInstruction(st, instr) {
+		ids := make([]string, len(instr.SignerIdentities))
+		for i, id := range instr.SignerIdentities {
+			ids[i] = id.String()
+		}
+		return ids, nil
+	}
+
+	if len(instr.SignerIdentities) != len(instr.Signatures) {
+		return nil, xerrors.New("lengh of identities does not match the length of signatures")
+	}
+	if checkCounters {
+		if err := verifySignerCounters(st, instr.SignerCounter, instr.SignerIdentities, instr.SignerLanes); err != nil {
+			return nil, xerrors.Errorf("signer counter: %v", err)
+		}
+	}
+	if len(instr.Signatures) == 0 {
+		return nil, xerrors.New("no signatures - nothing to verify")
+	}
+
 	goodIdentities := make([]string, 0)
 	for i := range instr.Signatures {
 		if err := instr.SignerIdentities[i].Verify(msg, instr.Signatures[i]); err == nil {
 			goodIdentities = append(goodIdentities, instr.SignerIdentities[i].String())
 		}
 	}
+	return goodIdentities, nil
+}
 
-	// check the expression
-	getDarc := func(str string, latest bool) *darc.Darc {
+// verifyWithIdentities checks that the identities satisfy the rule of the
+// darc of the instance for the action of the instruction. It is used by
+// AuthorizeInstruction, which has no signatures: the identities are the ones
+// that signed the instruction of the contract.
+func (instr Instruction) verifyWithIdentities(st ReadOnlyStateTrie, ids []darc.Identity) error {
+	config, err := LoadConfigFromTrie(st)
+	if err != nil {
+		return xerrors.Errorf("reading trie: %v", err)
+	}
+	d, err := getInstanceDarc(st, instr.InstanceID, config.DarcContractIDs)
+	if err != nil {
+		return xerrors.Errorf("darc not found: %v", err)
+	}
+	if !d.Rules.Contains(darc.Action(instr.Action())) {
+		return xerrors.Errorf("action '%v' does not exist", instr.Action())
+	}
+
+	goodIdentities := make([]string, len(ids))
+	for i, id := range ids {
+		goodIdentities[i] = id.String()
+	}
+	err = darc.EvalExpr(d.Rules.Get(darc.Action(instr.Action())), darcGetter(st),
+		goodIdentities...)
+	return cothority.ErrorOrNil(err, "evaluating darc")
+}
+
+// darcGetter returns the function used by the darc expressions to get the
+// darcs they refer to from the global state.
+func darcGetter(st ReadOnlyStateTrie) func(string, bool) *darc.Darc {
+	return func(str string, latest bool) *darc.Darc {
 		if len(str) < 5 || string(str[0:5]) != "darc:" {
 			return nil
 		}
@@ -401,13 +470,6 @@ func (instr Instruction) VerifyWithOption(st ReadOnlyStateTrie, msg []byte, ops
 		}
 		return d
 	}
-
-	if ops.EvalAttr != nil {
-		err := darc.EvalExprAttr(d.Rules.Get(darc.Action(instr.Action())), getDarc, ops.EvalAttr, goodIdentities...)
-		return cothority.ErrorOrNil(err, "evaluating darc")
-	}
-	err = darc.EvalExpr(d.Rules.Get(darc.Action(instr.Action())), getDarc, goodIdentities...)
-	return cothority.ErrorOrNil(err, "evaluating darc")
 }
 
 // InstrType is the instruction type, which can be spawn, invoke or delete.
@@ -483,19 +545,24 @@ func NewTxResults(ct ...ClientTransaction) TxResults {
 	return out
 }
 
-// Hash returns the sha256 hash of all of the transactions.
+// Hash returns the sha256 hash of all of the transactions and their events.
 func (txr TxResults) Hash() []byte {
 	one := []byte{1}
 	zero := []byte{0}
 
 	h := sha256.New()
 	for _, tx := range txr {
-		h.Write(tx.ClientTransaction.Instructions.Hash())
+		h.Write(tx.ClientTransaction.Digest())
 		if tx.Accepted {
 			h.Write(one[:])
 		} else {
 			h.Write(zero[:])
 		}
+		// The events are only hashed if there are any, so that the hash
+		// of the blocks created before the events stays the same.
+		if len(tx.Events) > 0 {
+			hashEvents(h, tx.Events)
+		}
 	}
 	return h.Sum(nil)
 }
@@ -676,7 +743,9 @@ func (r *txBuffer) take(key string, max int) []ClientTransaction {
 	return ret
 }
 
-func (r *txBuffer) add(key string, newTx ClientTransaction) {
+var errBufferFull = xerrors.New("transaction buffer is full")
+
+func (r *txBuffer) add(key string, newTx ClientTransaction) error {
 	r.Lock()
 	defer r.Unlock()
 
@@ -687,10 +756,43 @@ func (r *txBuffer) add(key string, newTx ClientTransaction) {
 			// Drop transactions if the buffer is full. We cannot drop earlier
 			// transactions because an attacker could send multiple ones to
 			// replace legit transactions.
-			return
+			return errBufferFull
 		}
 
 		txs = append(txs, newTx)
 		r.txsMap[key] = txs
 	}
+	return nil
+}
+
+// dropExpired removes the transactions that cannot be included anymore in
+// the block at the given index, when the latest block has the given
+// timestamp. The dropped transactions are returned together with the reason.
+func (r *txBuffer) dropExpired(key string, index int, timestamp int64) (
+	dropped []ClientTransaction, errs []error) {
+	r.Lock()
+	defer r.Unlock()
+
+	txs, ok := r.txsMap[key]
+	if !ok {
+		return
+	}
+
+	kept := txs[:0]
+	for _, tx := range txs {
+		err := tx.Validity.check(index, timestamp)
+		if xerrors.Is(err, errTxExpired) {
+			dropped = append(dropped, tx)
+			errs = append(errs, err)
+			continue
+		}
+		kept = append(kept, tx)
+	}
+
+	if len(kept) == 0 {
+		delete(r.txsMap, key)
+	} else {
+		r.txsMap[key] = kept
+	}
+	return
 }
@@ -8,6 +8,7 @@ import (
 	"go.dedis.ch/cothority/v3/byzcoin/trie"
 	"go.dedis.ch/cothority/v3/darc"
 	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
 )
 
 func TestTransaction_Signing(t *testing.T) {
@@ -26,7 +27,7 @@ func TestTransaction_Signing(t *testing.T) {
 	mdb := trie.NewMemDB()
 	tr, err := trie.NewTrie(mdb, []byte("my nonce"))
 	require.NoError(t, err)
-	sst := &stagingStateTrie{*tr.MakeStagingTrie()}
+	sst := &stagingStateTrie{StagingTrie: *tr.MakeStagingTrie()}
 
 	// verification should fail because trie is empty
 	ctxHash := ctx.Instructions.Hash()
@@ -87,6 +88,7 @@ func TestTransactionBuffer_Add(t *testing.T) {
 
 	require.Equal(t, defaultMaxBufferSize, len(b.txsMap[key]))
 	require.Equal(t, defaultMaxBufferSize, len(b.txsMap[key2]))
+	require.Equal(t, errBufferFull, b.add(key, ClientTransaction{}))
 }
 
 func TestTransactionBuffer_Take(t *testing.T) {
@@ -124,6 +126,68 @@ func TestTransactionBuffer_TakeDisabled(t *testing.T) {
 	require.False(t, ok)
 }
 
+func TestTransactionBuffer_DropExpired(t *testing.T) {
+	b := newTxBuffer()
+	key := "abc"
+
+	b.add(key, ClientTransaction{})
+	b.add(key, ClientTransaction{Validity: &TxValidity{NotAfter: 2}})
+	b.add(key, ClientTransaction{Validity: &TxValidity{NotAfterTime: 100}})
+	b.add(key, ClientTransaction{Validity: &TxValidity{NotBefore: 5}})
+
+	txs, errs := b.dropExpired(key, 2, 100)
+	require.Empty(t, txs)
+	require.Empty(t, errs)
+	require.Equal(t, 4, len(b.txsMap[key]))
+
+	txs, errs = b.dropExpired(key, 3, 101)
+	require.Equal(t, 2, len(txs))
+	require.Equal(t, 2, len(errs))
+	require.True(t, xerrors.Is(errs[0], errTxExpired))
+	require.Equal(t, 2, len(b.txsMap[key]))
+
+	// A transaction that is not valid yet is kept.
+	txs = b.take(key, -1)
+	require.Equal(t, 2, len(txs))
+	require.Equal(t, 5, txs[1].Validity.NotBefore)
+}
+
+func TestTxValidity_Check(t *testing.T) {
+	var v *TxValidity
+	require.NoError(t, v.check(10, 10))
+
+	v = &TxValidity{NotBefore: 2, NotAfter: 4, NotBeforeTime: 20, NotAfterTime: 40}
+	require.NoError(t, v.check(2, 20))
+	require.NoError(t, v.check(4, 40))
+	require.True(t, xerrors.Is(v.check(1, 30), errTxNotValidYet))
+	require.True(t, xerrors.Is(v.check(3, 19), errTxNotValidYet))
+	require.True(t, xerrors.Is(v.check(5, 30), errTxExpired))
+	require.True(t, xerrors.Is(v.check(3, 41), errTxExpired))
+}
+
+func TestClientTransaction_Digest(t *testing.T) {
+	signer := darc.NewSignerEd25519(nil, nil)
+	ctx, err := createOneClientTx(darc.ID{}, "dummy_kind", []byte("dummy_value"), signer)
+	require.NoError(t, err)
+
+	// Without a window, the signatures don't change.
+	require.Equal(t, ctx.Instructions.Hash(), ctx.Digest())
+
+	ctx.Validity = &TxValidity{NotAfter: 10}
+	d := ctx.Digest()
+	require.NotEqual(t, ctx.Instructions.Hash(), d)
+	ctx.Validity.NotAfter = 11
+	require.NotEqual(t, d, ctx.Digest())
+
+	// The fee payer is signed too.
+	d = ctx.Digest()
+	payer := NewInstanceID([]byte("payer"))
+	ctx.FeePayer = &payer
+	require.NotEqual(t, d, ctx.Digest())
+	ctx.Validity = nil
+	require.NotEqual(t, ctx.Instructions.Hash(), ctx.Digest())
+}
+
 func setSignerCounter(sst *stagingStateTrie, id string, v uint64) error {
 	key := publicVersionKey(id)
 	verBuf := make([]byte, 8)
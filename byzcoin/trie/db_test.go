@@ -2,9 +2,11 @@ package trie
 
 import (
 	"bytes"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	bbolt "go.etcd.io/bbolt"
 	"golang.org/x/xerrors"
 )
 
@@ -139,6 +141,85 @@ func testDBDryRun(t *testing.T, db DB) {
 	require.NoError(t, err)
 }
 
+func TestSnapshotDB(t *testing.T) {
+	// The initial mmap is big enough so that the updates below never
+	// remap the database while the snapshot is open.
+	bdb, err := bbolt.Open(testDBName, 0600, &bbolt.Options{InitialMmapSize: 1 << 20})
+	require.NoError(t, err)
+	defer os.Remove(testDBName)
+	defer bdb.Close()
+	err = bdb.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(bucketName))
+		return err
+	})
+	require.NoError(t, err)
+	db := NewDiskDB(bdb, []byte(bucketName))
+
+	err = db.Update(func(b Bucket) error {
+		return b.Put([]byte("a"), []byte("1"))
+	})
+	require.NoError(t, err)
+
+	snap, err := NewSnapshotDB(bdb, []byte(bucketName))
+	require.NoError(t, err)
+
+	// Changes made after the snapshot has been taken are not visible.
+	err = db.Update(func(b Bucket) error {
+		if err := b.Put([]byte("a"), []byte("2")); err != nil {
+			return err
+		}
+		return b.Put([]byte("b"), []byte("2"))
+	})
+	require.NoError(t, err)
+	err = snap.View(func(b Bucket) error {
+		if v := b.Get([]byte("a")); !bytes.Equal(v, []byte("1")) {
+			return xerrors.New("snapshot has been modified")
+		}
+		if b.Get([]byte("b")) != nil {
+			return xerrors.New("snapshot has a new key")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.Error(t, snap.Update(func(b Bucket) error { return nil }))
+	require.Error(t, snap.View(func(b Bucket) error {
+		return b.Put([]byte("c"), []byte("3"))
+	}))
+
+	// A dry-run sees its own changes, which are then discarded.
+	err = snap.UpdateDryRun(func(b Bucket) error {
+		if err := b.Delete([]byte("a")); err != nil {
+			return err
+		}
+		if err := b.Put([]byte("c"), []byte("3")); err != nil {
+			return err
+		}
+		var cnt int
+		err := b.ForEach(func(k, v []byte) error {
+			cnt++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if b.Get([]byte("a")) != nil || cnt != 1 {
+			return xerrors.New("dry-run changes are missing")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	err = snap.View(func(b Bucket) error {
+		if b.Get([]byte("a")) == nil || b.Get([]byte("c")) != nil {
+			return xerrors.New("dry-run changes have been kept")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, snap.Close())
+}
+
 func testMemAndDisk(t *testing.T, f func(*testing.T, DB)) {
 	mem := NewMemDB()
 	defer mem.Close()
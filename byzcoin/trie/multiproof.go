@@ -0,0 +1,229 @@
+package trie
+
+import (
+	"bytes"
+	"fmt"
+
+	"golang.org/x/xerrors"
+)
+
+func (p *MultiProof) String() string {
+	var out string
+	out += fmt.Sprintf("Nonce: %x", p.Nonce)
+	out += "\nInteriors:"
+	for _, interior := range p.Interiors {
+		out += fmt.Sprintf("\n\t%x -> [%x, %x]", interior.hash(), interior.Left, interior.Right)
+	}
+	out += "\nLeaves:"
+	for _, leaf := range p.Leaves {
+		out += fmt.Sprintf("\n\t%x", leaf.hash(p.Nonce))
+	}
+	out += "\nEmpties:"
+	for _, empty := range p.Empties {
+		out += fmt.Sprintf("\n\t%x", empty.hash(p.Nonce))
+	}
+	return out
+}
+
+// Exists checks the proof for inclusion/absence of the key. An error is
+// returned if the proof doesn't hold the path to the key.
+func (p *MultiProof) Exists(key []byte) (bool, error) {
+	leaf, err := p.find(key)
+	if err != nil {
+		return false, err
+	}
+	return leaf != nil, nil
+}
+
+// Match returns true if the proof is an existence proof for the given key, any
+// error during the process of verifying the proof or if the key is absent then
+// it returns false.
+func (p *MultiProof) Match(key []byte) bool {
+	ok, err := p.Exists(key)
+	if err != nil {
+		return false
+	}
+	return ok
+}
+
+// GetRoot returns the Merkle root.
+func (p *MultiProof) GetRoot() []byte {
+	if len(p.Interiors) == 0 {
+		return nil
+	}
+	return p.Interiors[0].hash()
+}
+
+// Get returns the value associated with the given key in the proof. If the key
+// does not exist, or if the proof doesn't hold the path to the key, nil is
+// returned.
+func (p *MultiProof) Get(key []byte) []byte {
+	leaf, err := p.find(key)
+	if err != nil || leaf == nil {
+		return nil
+	}
+	return leaf.Value
+}
+
+// Proof extracts the proof of a single key, so that it can be used where a
+// Proof is expected.
+func (p *MultiProof) Proof(key []byte) (*Proof, error) {
+	proof := &Proof{
+		Nonce:     clone(p.Nonce),
+		noHashKey: p.noHashKey,
+	}
+	err := p.walk(key, func(n interiorNode) {
+		proof.Interiors = append(proof.Interiors, n)
+	}, func(n leafNode) {
+		proof.Leaf = n
+	}, func(n emptyNode) {
+		proof.Empty = n
+	})
+	if err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// find returns the leaf of the key, or nil if the proof shows that the key is
+// absent.
+func (p *MultiProof) find(key []byte) (*leafNode, error) {
+	var found *leafNode
+	err := p.walk(key, func(interiorNode) {}, func(n leafNode) {
+		if bytes.Equal(n.Key, key) {
+			found = &n
+		}
+	}, func(emptyNode) {})
+	return found, err
+}
+
+// walk follows the hash chain from the root to the leaf or the empty node of
+// the key, verifying the prefix of the last node, and calls the callbacks on
+// the nodes of the path.
+func (p *MultiProof) walk(key []byte, onInterior func(interiorNode),
+	onLeaf func(leafNode), onEmpty func(emptyNode)) error {
+	if key == nil {
+		return xerrors.New("key is nil")
+	}
+	if len(p.Interiors) == 0 {
+		return xerrors.New("no interior nodes")
+	}
+
+	interiors := make(map[string]interiorNode)
+	for _, n := range p.Interiors {
+		interiors[string(n.hash())] = n
+	}
+
+	bits := p.binSlice(key)
+	expectedHash := p.Interiors[0].hash() // first one is the root hash
+	for i := 0; ; i++ {
+		n, ok := interiors[string(expectedHash)]
+		if !ok {
+			for _, leaf := range p.Leaves {
+				if bytes.Equal(expectedHash, leaf.hash(p.Nonce)) {
+					if !equal(bits[:i], leaf.Prefix) {
+						return xerrors.New("invalid prefix in leaf node")
+					}
+					onLeaf(leaf)
+					return nil
+				}
+			}
+			for _, empty := range p.Empties {
+				if bytes.Equal(expectedHash, empty.hash(p.Nonce)) {
+					if !equal(bits[:i], empty.Prefix) {
+						return xerrors.New("invalid prefix in empty node")
+					}
+					onEmpty(empty)
+					return nil
+				}
+			}
+			return xerrors.New("missing node in the proof")
+		}
+		if i >= len(bits) {
+			return xerrors.New("path is too long")
+		}
+		onInterior(n)
+		if bits[i] {
+			expectedHash = n.Left
+		} else {
+			expectedHash = n.Right
+		}
+	}
+}
+
+func (p *MultiProof) binSlice(buf []byte) []bool {
+	proof := Proof{noHashKey: p.noHashKey}
+	return proof.binSlice(buf)
+}
+
+// GetMultiProof gets the inclusion/absence proofs of all the given keys.
+func (t *Trie) GetMultiProof(keys [][]byte) (*MultiProof, error) {
+	p := &MultiProof{}
+	err := t.db.View(func(b Bucket) error {
+		rootKey := t.GetRootWithBucket(b)
+		if rootKey == nil {
+			return xerrors.New("no root key")
+		}
+		p.Nonce = clone(t.nonce)
+		p.noHashKey = t.noHashKey
+		return t.getMultiProof(0, rootKey, t.binSlices(keys), p, b)
+	})
+	return p, err
+}
+
+// getMultiProof updates MultiProof p as it traverses the tree. An interior
+// node is only visited once, whatever the number of keys going through it.
+func (t *Trie) getMultiProof(depth int, nodeKey []byte, bits [][]bool, p *MultiProof, b Bucket) error {
+	nodeVal := clone(b.Get(nodeKey))
+	if len(nodeVal) == 0 {
+		return xerrors.New("invalid node key")
+	}
+	switch nodeType(nodeVal[0]) {
+	case typeEmpty:
+		node, err := decodeEmptyNode(nodeVal)
+		if err != nil {
+			return err
+		}
+		p.Empties = append(p.Empties, node)
+		return nil
+	case typeLeaf:
+		node, err := decodeLeafNode(nodeVal)
+		if err != nil {
+			return err
+		}
+		p.Leaves = append(p.Leaves, node)
+		return nil
+	case typeInterior:
+		node, err := decodeInteriorNode(nodeVal)
+		if err != nil {
+			return err
+		}
+		p.Interiors = append(p.Interiors, node)
+		var left, right [][]bool
+		for _, kb := range bits {
+			if kb[depth] {
+				left = append(left, kb)
+			} else {
+				right = append(right, kb)
+			}
+		}
+		if len(left) > 0 {
+			if err := t.getMultiProof(depth+1, node.Left, left, p, b); err != nil {
+				return err
+			}
+		}
+		if len(right) > 0 {
+			return t.getMultiProof(depth+1, node.Right, right, p, b)
+		}
+		return nil
+	}
+	return xerrors.New("invalid node type")
+}
+
+func (t *Trie) binSlices(keys [][]byte) [][]bool {
+	bits := make([][]bool, len(keys))
+	for i, key := range keys {
+		bits[i] = t.binSlice(key)
+	}
+	return bits
+}
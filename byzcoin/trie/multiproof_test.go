@@ -0,0 +1,90 @@
+package trie
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiProof(t *testing.T) {
+	testMemAndDisk(t, testMultiProof)
+}
+
+func testMultiProof(t *testing.T, db DB) {
+	testTrie, err := NewTrie(db, genNonce())
+	require.NoError(t, err)
+
+	var keys [][]byte
+	for i := 0; i < 20; i++ {
+		k := []byte{byte(i)}
+		keys = append(keys, k)
+		if i >= 10 {
+			require.NoError(t, testTrie.Set(k, k))
+		}
+	}
+
+	p, err := testTrie.GetMultiProof(keys)
+	require.NoError(t, err)
+	require.Equal(t, testTrie.GetRoot(), p.GetRoot())
+	for i, k := range keys {
+		ok, err := p.Exists(k)
+		require.NoError(t, err)
+		require.Equal(t, i >= 10, ok)
+		if ok {
+			require.Equal(t, k, p.Get(k))
+		} else {
+			require.Nil(t, p.Get(k))
+		}
+
+		// The proof of a single key must be the same as the one of the
+		// trie.
+		single, err := p.Proof(k)
+		require.NoError(t, err)
+		expected, err := testTrie.GetProof(k)
+		require.NoError(t, err)
+		require.Equal(t, expected, single)
+	}
+
+	// The shared interior nodes are only included once.
+	var total int
+	for _, k := range keys {
+		single, err := testTrie.GetProof(k)
+		require.NoError(t, err)
+		total += len(single.Interiors)
+	}
+	require.True(t, len(p.Interiors) < total)
+
+	// A key whose path isn't in the proof cannot be verified.
+	p, err = testTrie.GetMultiProof(keys[10:11])
+	require.NoError(t, err)
+	require.True(t, p.Match(keys[10]))
+	for _, k := range keys[11:] {
+		if testTrie.binSlice(k)[0] != testTrie.binSlice(keys[10])[0] {
+			_, err := p.Exists(k)
+			require.Error(t, err)
+		}
+	}
+
+	// A modified node breaks the hash chain.
+	p, err = testTrie.GetMultiProof(keys)
+	require.NoError(t, err)
+	p.Leaves[0].Value = []byte("tampered")
+	_, err = p.Exists(p.Leaves[0].Key)
+	require.Error(t, err)
+}
+
+func TestMultiProofStaging(t *testing.T) {
+	testTrie, err := NewTrie(NewMemDB(), genNonce())
+	require.NoError(t, err)
+	require.NoError(t, testTrie.Set([]byte{1}, []byte{1}))
+
+	sTrie := testTrie.MakeStagingTrie()
+	require.NoError(t, sTrie.Set([]byte{2}, []byte{2}))
+	require.NoError(t, sTrie.Delete([]byte{1}))
+
+	p, err := sTrie.GetMultiProof([][]byte{{1}, {2}})
+	require.NoError(t, err)
+	require.Equal(t, sTrie.GetRoot(), p.GetRoot())
+	require.False(t, p.Match([]byte{1}))
+	require.True(t, p.Match([]byte{2}))
+}
@@ -28,3 +28,15 @@ type Proof struct {
 	Nonce     []byte
 	noHashKey bool
 }
+
+// MultiProof contains inclusion/absence proofs for many keys. The interior
+// nodes shared by the paths of the keys are only included once.
+type MultiProof struct {
+	// Interiors are the interior nodes of the paths in depth-first order,
+	// starting with the root.
+	Interiors []interiorNode
+	Leaves    []leafNode
+	Empties   []emptyNode
+	Nonce     []byte
+	noHashKey bool
+}
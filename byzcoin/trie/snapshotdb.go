@@ -0,0 +1,120 @@
+package trie
+
+import (
+	bbolt "go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+// snapshotDB is a read-only DB implementation that keeps a boltdb read
+// transaction open, so that every read sees the bucket as it was when the
+// snapshot has been taken, even if the bucket is updated in the meantime.
+//
+// As long as the snapshot is open, the goroutine using it must not start
+// another transaction on the same boltdb database: a writer remapping the
+// database would wait for the snapshot to be closed, and the new transaction
+// would wait for the writer.
+type snapshotDB struct {
+	tx     *bbolt.Tx
+	bucket *bbolt.Bucket
+}
+
+// NewSnapshotDB creates a read-only snapshot of the given bucket. The
+// snapshot must be closed with Close, which doesn't close the boltdb
+// database.
+func NewSnapshotDB(db *bbolt.DB, bucket []byte) (DB, error) {
+	tx, err := db.Begin(false)
+	if err != nil {
+		return nil, xerrors.Errorf("starting transaction: %v", err)
+	}
+	b := tx.Bucket(bucket)
+	if b == nil {
+		tx.Rollback()
+		return nil, xerrors.New("bucket does not exist")
+	}
+	return &snapshotDB{
+		tx:     tx,
+		bucket: b,
+	}, nil
+}
+
+// Update always fails as the snapshot is read-only.
+func (r *snapshotDB) Update(f func(Bucket) error) error {
+	return xerrors.New("cannot update a snapshot")
+}
+
+func (r *snapshotDB) View(f func(Bucket) error) error {
+	return f(&snapshotBucket{ro: r.bucket})
+}
+
+// UpdateDryRun executes the given function on a bucket that keeps the
+// modifications in memory, on top of the snapshot. They are discarded when
+// the function returns.
+func (r *snapshotDB) UpdateDryRun(f func(Bucket) error) error {
+	return f(&snapshotBucket{
+		ro:       r.bucket,
+		writable: true,
+		puts:     make(map[string][]byte),
+		deleted:  make(map[string]bool),
+	})
+}
+
+// Close releases the read transaction of the snapshot.
+func (r *snapshotDB) Close() error {
+	return r.tx.Rollback()
+}
+
+type snapshotBucket struct {
+	ro       *bbolt.Bucket
+	writable bool
+	puts     map[string][]byte
+	deleted  map[string]bool
+}
+
+func (r *snapshotBucket) Delete(k []byte) error {
+	if !r.writable {
+		return xerrors.New("trying to use Delete in a read-only transaction")
+	}
+	delete(r.puts, string(k))
+	r.deleted[string(k)] = true
+	return nil
+}
+
+func (r *snapshotBucket) Put(k, v []byte) error {
+	if !r.writable {
+		return xerrors.New("trying to use Put in a read-only transaction")
+	}
+	delete(r.deleted, string(k))
+	r.puts[string(k)] = clone(v)
+	return nil
+}
+
+func (r *snapshotBucket) Get(k []byte) []byte {
+	if r.deleted[string(k)] {
+		return nil
+	}
+	if v, ok := r.puts[string(k)]; ok {
+		return v
+	}
+	return r.ro.Get(k)
+}
+
+func (r *snapshotBucket) ForEach(f func(k, v []byte) error) error {
+	err := r.ro.ForEach(func(k, v []byte) error {
+		if r.deleted[string(k)] {
+			return nil
+		}
+		if _, ok := r.puts[string(k)]; ok {
+			return nil
+		}
+		return f(k, v)
+	})
+	if err != nil {
+		return err
+	}
+	for k, v := range r.puts {
+		if err := f([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
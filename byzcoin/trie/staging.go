@@ -212,19 +212,8 @@ func (t *StagingTrie) GetProof(key []byte) (*Proof, error) {
 	p := &Proof{}
 	err := t.source.db.UpdateDryRun(func(b Bucket) error {
 		// run the pending instructions
-		for _, instr := range t.instrList {
-			switch instr.ty {
-			case OpSet:
-				if err := t.source.SetWithBucket(instr.k, instr.v, b); err != nil {
-					return err
-				}
-			case OpDel:
-				if err := t.source.DeleteWithBucket(instr.k, b); err != nil {
-					return err
-				}
-			default:
-				return xerrors.New("invalid instruction during get proof")
-			}
+		if err := t.applyInstrs(b); err != nil {
+			return err
 		}
 		// create the proof
 		rootKey := t.source.GetRootWithBucket(b)
@@ -237,6 +226,47 @@ func (t *StagingTrie) GetProof(key []byte) (*Proof, error) {
 	return p, err
 }
 
+// GetMultiProof gets the inclusion/absence proofs of all the given keys.
+func (t *StagingTrie) GetMultiProof(keys [][]byte) (*MultiProof, error) {
+	t.Lock()
+	defer t.Unlock()
+	p := &MultiProof{}
+	err := t.source.db.UpdateDryRun(func(b Bucket) error {
+		// run the pending instructions
+		if err := t.applyInstrs(b); err != nil {
+			return err
+		}
+		// create the proof
+		rootKey := t.source.GetRootWithBucket(b)
+		if rootKey == nil {
+			return xerrors.New("no root key")
+		}
+		p.Nonce = clone(t.source.nonce)
+		p.noHashKey = t.source.noHashKey
+		return t.source.getMultiProof(0, rootKey, t.source.binSlices(keys), p, b)
+	})
+	return p, err
+}
+
+// applyInstrs runs the pending instructions on the bucket.
+func (t *StagingTrie) applyInstrs(b Bucket) error {
+	for _, instr := range t.instrList {
+		switch instr.ty {
+		case OpSet:
+			if err := t.source.SetWithBucket(instr.k, instr.v, b); err != nil {
+				return err
+			}
+		case OpDel:
+			if err := t.source.DeleteWithBucket(instr.k, b); err != nil {
+				return err
+			}
+		default:
+			return xerrors.New("invalid instruction during get proof")
+		}
+	}
+	return nil
+}
+
 func (t *StagingTrie) isDeleted(k []byte) bool {
 	if _, ok := t.deleteList[string(k)]; ok {
 		return true
@@ -270,6 +300,20 @@ func (t *StagingTrie) ForEach(cb func(k, v []byte) error) error {
 	})
 }
 
+// ForEachInOrder is similar to ForEach, but visits the pairs in the order of
+// the trie with the pending instructions applied, so that the order only
+// depends on the content of the trie. The source trie is not modified.
+func (t *StagingTrie) ForEachInOrder(cb func(k, v []byte) error) error {
+	t.Lock()
+	defer t.Unlock()
+	return t.source.db.UpdateDryRun(func(b Bucket) error {
+		if err := t.applyInstrs(b); err != nil {
+			return err
+		}
+		return t.source.ForEachWithBucket(cb, b)
+	})
+}
+
 // sanityCheck checks the invariant: the deleted values does not appear in the
 // overlay.
 func (t *StagingTrie) sanityCheck() error {
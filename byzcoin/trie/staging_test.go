@@ -223,6 +223,52 @@ func TestStagingForEach(t *testing.T) {
 	testMemAndDisk(t, testStagingForEach)
 }
 
+func testStagingForEachInOrder(t *testing.T, db DB) {
+	nonce := genNonce()
+	testTrie, err := NewTrie(db, nonce)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, testTrie.Set([]byte{byte(i)}, []byte{byte(i)}))
+	}
+	root := testTrie.GetRoot()
+
+	sTrie := testTrie.MakeStagingTrie()
+	require.NoError(t, sTrie.Delete([]byte{3}))
+	require.NoError(t, sTrie.Set([]byte{5}, []byte("five")))
+	require.NoError(t, sTrie.Set([]byte{20}, []byte{20}))
+
+	// The same content in another trie is visited in the same order.
+	expected, err := NewTrie(NewMemDB(), nonce)
+	require.NoError(t, err)
+	require.NoError(t, expected.Set([]byte{20}, []byte{20}))
+	for i := 0; i < 10; i++ {
+		v := []byte{byte(i)}
+		if i == 5 {
+			v = []byte("five")
+		}
+		if i != 3 {
+			require.NoError(t, expected.Set([]byte{byte(i)}, v))
+		}
+	}
+
+	var pairs, expectedPairs [][]byte
+	require.NoError(t, sTrie.ForEachInOrder(func(k, v []byte) error {
+		pairs = append(pairs, append(append([]byte{}, k...), v...))
+		return nil
+	}))
+	require.NoError(t, expected.ForEach(func(k, v []byte) error {
+		expectedPairs = append(expectedPairs, append(append([]byte{}, k...), v...))
+		return nil
+	}))
+	require.Equal(t, 10, len(pairs))
+	require.Equal(t, expectedPairs, pairs)
+	require.Equal(t, root, testTrie.GetRoot())
+}
+
+func TestStagingForEachInOrder(t *testing.T) {
+	testMemAndDisk(t, testStagingForEachInOrder)
+}
+
 func testStagingCommit(t *testing.T, db DB) {
 	// Initialise a trie.
 	testTrie, err := NewTrie(db, genNonce())
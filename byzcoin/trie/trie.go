@@ -571,16 +571,23 @@ func (t *Trie) del(depth int, nodeKey []byte, bits []bool, key []byte, b Bucket)
 // iteration stops and the function returns an error when the callback returns
 // an error.
 func (t *Trie) ForEach(cb func(k, v []byte) error) error {
-	p := leafCallbackProcessor{cb}
 	return t.db.View(func(b Bucket) error {
-		rootKey := t.GetRootWithBucket(b)
-		if rootKey == nil {
-			return xerrors.New("no root key")
-		}
-		return t.dfs(&p, rootKey, b)
+		return t.ForEachWithBucket(cb, b)
 	})
 }
 
+// ForEachWithBucket is similar to ForEach, but uses an existing transaction
+// and bucket. The pairs are visited in the order of the trie, that only
+// depends on its content.
+func (t *Trie) ForEachWithBucket(cb func(k, v []byte) error, b Bucket) error {
+	rootKey := t.GetRootWithBucket(b)
+	if rootKey == nil {
+		return xerrors.New("no root key")
+	}
+	p := leafCallbackProcessor{cb}
+	return t.dfs(&p, rootKey, b)
+}
+
 // IsValid checks whether the trie is valid.
 func (t *Trie) IsValid() error {
 	p := countNodeProcessor{}
@@ -46,6 +46,9 @@ type txProcessor interface {
 	GetLatestGoodState() *txProcessorState
 	// GetBlockSize should return the maximum block size.
 	GetBlockSize() int
+	// PushedTx should return the channel of the transactions that are sent
+	// to the leader between two collections. It can be nil.
+	PushedTx() <-chan []ClientTransaction
 	// GetInterval should return the block interval.
 	GetInterval() time.Duration
 	// Stop stops the txProcessor. Once it is called, the caller should not
@@ -95,6 +98,7 @@ func (s *txProcessorState) copy() *txProcessorState {
 type defaultTxProcessor struct {
 	*Service
 	stopCollect chan bool
+	pushed      chan []ClientTransaction
 	scID        skipchain.SkipBlockID
 	latest      *skipchain.SkipBlock
 	sync.Mutex
@@ -212,7 +216,7 @@ func (s *defaultTxProcessor) ProcessTx(tx ClientTransaction, inState *txProcesso
 
 	tx.Instructions.SetVersion(header.Version)
 
-	scsOut, sstOut, err := s.processOneTx(inState.sst, tx, s.scID)
+	scsOut, _, sstOut, err := s.processOneTx(inState.sst, tx, s.scID)
 
 	// try to create a new state
 	newState := func() *txProcessorState {
@@ -220,14 +224,14 @@ func (s *defaultTxProcessor) ProcessTx(tx ClientTransaction, inState *txProcesso
 			return &txProcessorState{
 				inState.sst,
 				inState.scs,
-				append(inState.txs, TxResult{tx, false}),
+				append(inState.txs, TxResult{ClientTransaction: tx}),
 				0,
 			}
 		}
 		return &txProcessorState{
 			sstOut,
 			append(inState.scs, scsOut...),
-			append(inState.txs, TxResult{tx, true}),
+			append(inState.txs, TxResult{ClientTransaction: tx, Accepted: true}),
 			0,
 		}
 	}()
@@ -243,14 +247,14 @@ func (s *defaultTxProcessor) ProcessTx(tx ClientTransaction, inState *txProcesso
 		newStates = append(newStates, &txProcessorState{
 			inState.sst,
 			inState.scs,
-			[]TxResult{{tx, false}},
+			[]TxResult{{ClientTransaction: tx}},
 			0,
 		})
 	} else {
 		newStates = append(newStates, &txProcessorState{
 			sstOut,
 			scsOut,
-			[]TxResult{{tx, true}},
+			[]TxResult{{ClientTransaction: tx, Accepted: true}},
 			0,
 		})
 	}
@@ -307,6 +311,10 @@ func (s *defaultTxProcessor) GetBlockSize() int {
 	return bcConfig.MaxBlockSize
 }
 
+func (s *defaultTxProcessor) PushedTx() <-chan []ClientTransaction {
+	return s.pushed
+}
+
 func (s *defaultTxProcessor) Stop() {
 	close(s.stopCollect)
 }
@@ -339,6 +347,9 @@ func (p *txPipeline) collectTx() {
 	// set the polling interval to half of the block interval
 	go func() {
 		defer p.wg.Done()
+		// The pushed transactions are only processed once the first
+		// collection gave the latest block to the processor.
+		var pushed <-chan []ClientTransaction
 		for {
 			interval := p.processor.GetInterval()
 			select {
@@ -346,6 +357,8 @@ func (p *txPipeline) collectTx() {
 				log.Lvl3("stopping tx collector")
 				close(p.ctxChan)
 				return
+			case txs := <-pushed:
+				p.queueTxs(txs)
 			case <-time.After(interval / 2):
 				res, err := p.processor.CollectTx()
 				if err != nil {
@@ -356,19 +369,24 @@ func (p *txPipeline) collectTx() {
 				// will check if it is necessary to upgrade.
 				p.needUpgrade <- res.CommonVersion
 
-				for _, tx := range res.Txs {
-					select {
-					case p.ctxChan <- tx:
-						// channel not full, do nothing
-					default:
-						log.Warn("dropping transactions because there are too many")
-					}
-				}
+				p.queueTxs(res.Txs)
+				pushed = p.processor.PushedTx()
 			}
 		}
 	}()
 }
 
+func (p *txPipeline) queueTxs(txs []ClientTransaction) {
+	for _, tx := range txs {
+		select {
+		case p.ctxChan <- tx:
+			// channel not full, do nothing
+		default:
+			log.Warn("dropping transactions because there are too many")
+		}
+	}
+}
+
 var maxTxHashes = 1000
 
 // processTxs consumes transactions and computes the new txResults
@@ -67,7 +67,7 @@ func (p *defaultMockTxProc) ProcessTx(tx ClientTransaction, inState *txProcessor
 	return []*txProcessorState{{
 		sst: inState.sst,
 		scs: append(inState.scs, sc),
-		txs: append(inState.txs, TxResult{tx, true}),
+		txs: append(inState.txs, TxResult{ClientTransaction: tx, Accepted: true}),
 	}}, nil
 }
 
@@ -143,6 +143,10 @@ func (p *defaultMockTxProc) GetBlockSize() int {
 	return 1e3
 }
 
+func (p *defaultMockTxProc) PushedTx() <-chan []ClientTransaction {
+	return nil
+}
+
 func (p *defaultMockTxProc) Done() chan bool {
 	return p.done
 }
@@ -338,7 +342,7 @@ func (p *bigMockTxProc) ProcessTx(tx ClientTransaction, inState *txProcessorStat
 			{
 				newState,
 				[]StateChange{sc},
-				[]TxResult{{tx, true}},
+				[]TxResult{{ClientTransaction: tx, Accepted: true}},
 				0,
 			},
 		}, nil
@@ -346,7 +350,7 @@ func (p *bigMockTxProc) ProcessTx(tx ClientTransaction, inState *txProcessorStat
 	return []*txProcessorState{{
 		newState,
 		append(inState.scs, sc),
-		append(inState.txs, TxResult{tx, true}),
+		append(inState.txs, TxResult{ClientTransaction: tx, Accepted: true}),
 		0,
 	}}, nil
 }
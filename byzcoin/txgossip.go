@@ -0,0 +1,202 @@
+package byzcoin
+
+import (
+	"sync"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"golang.org/x/xerrors"
+)
+
+// maxForwardsInFlight is the number of forwards of transactions to the leader
+// that can be in flight at the same time. Once it is reached, the new
+// transactions are kept in the buffer until the leader collects them.
+var maxForwardsInFlight = 100
+
+// pushChanSize is the number of batches of transactions that can wait to be
+// processed by the pipeline of the leader. Once it is reached, the new
+// transactions are kept in the buffer until the next collection.
+const pushChanSize = 100
+
+var forwardTxsMsgID network.MessageTypeID
+
+func init() {
+	forwardTxsMsgID = network.RegisterMessage(&ForwardTxs{})
+}
+
+// recentTxs remembers the hashes of the latest transactions received by the
+// leader, so that a transaction sent to many nodes is only processed once.
+type recentTxs struct {
+	sync.Mutex
+	hashes map[string]bool
+	order  []string
+}
+
+// addNew returns true if the hash is not known yet, and remembers it.
+func (r *recentTxs) addNew(hash []byte) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.hashes == nil {
+		r.hashes = make(map[string]bool)
+	}
+	key := string(hash)
+	if r.hashes[key] {
+		return false
+	}
+	r.hashes[key] = true
+	r.order = append(r.order, key)
+	if len(r.order) > maxTxHashes {
+		delete(r.hashes, r.order[0])
+		r.order = r.order[1:]
+	}
+	return true
+}
+
+// queueTx sends a new transaction to the leader of the chain, so that it
+// doesn't wait for the next collection of the leader. If the node is the
+// leader, the transaction goes directly to its pipeline. An error is returned
+// if the transaction had to be kept in the buffer, which is full.
+func (s *Service) queueTx(scID skipchain.SkipBlockID, tx ClientTransaction) error {
+	txs := []ClientTransaction{tx}
+	leader, err := s.getLeader(scID)
+	if err != nil {
+		log.Lvlf2("%s couldn't get the leader: %v", s.ServerIdentity(), err)
+		return s.bufferTxs(scID, txs)
+	}
+	if leader.Equal(s.ServerIdentity()) {
+		return s.receiveTxs(scID, txs)
+	}
+	return s.forwardTxs(scID, leader, txs)
+}
+
+// receiveTxs gives the transactions that are not duplicates to the pipeline
+// of the chain. If the node is not the leader, or if the pipeline is busy,
+// they are kept in the buffer.
+func (s *Service) receiveTxs(scID skipchain.SkipBlockID, txs []ClientTransaction) error {
+	var fresh []ClientTransaction
+	for _, tx := range txs {
+		if s.pushedTxs.addNew(tx.Instructions.HashWithSignatures()) {
+			fresh = append(fresh, tx)
+		}
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+
+	if !s.pushTxs(scID, fresh) {
+		return s.bufferTxs(scID, fresh)
+	}
+	return nil
+}
+
+// pushTxs returns false if the node doesn't run the pipeline of the chain,
+// or if too many transactions are waiting to be processed.
+func (s *Service) pushTxs(scID skipchain.SkipBlockID, txs []ClientTransaction) bool {
+	s.pollChanMut.Lock()
+	defer s.pollChanMut.Unlock()
+
+	key := string(scID)
+	if _, ok := s.pollChan[key]; !ok {
+		return false
+	}
+	c, ok := s.pushChan[key]
+	if !ok {
+		return false
+	}
+	select {
+	case c <- txs:
+		return true
+	default:
+		return false
+	}
+}
+
+// forwardTxs sends the transactions to the leader in the background. If too
+// many forwards are in flight, or if the leader cannot be reached, the
+// transactions are kept in the buffer so that the leader can still collect
+// them.
+func (s *Service) forwardTxs(scID skipchain.SkipBlockID, leader *network.ServerIdentity,
+	txs []ClientTransaction) error {
+	select {
+	case s.forwardSlots <- true:
+	default:
+		return s.bufferTxs(scID, txs)
+	}
+
+	s.working.Add(1)
+	go func() {
+		defer s.working.Done()
+		defer func() { <-s.forwardSlots }()
+
+		err := s.SendRaw(leader, &ForwardTxs{SkipChainID: scID, Txs: txs})
+		if err != nil {
+			log.Lvlf2("%s couldn't forward transactions to %s: %v",
+				s.ServerIdentity(), leader, err)
+			if err := s.bufferTxs(scID, txs); err != nil {
+				log.Warn(s.ServerIdentity(), "dropping forwarded transactions:", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// flushTxs forwards the transactions of the buffer to the leader. It is used
+// after a view change, so that the transactions that were waiting for the
+// previous leader are sent to the new one.
+func (s *Service) flushTxs(scID skipchain.SkipBlockID) {
+	leader, err := s.getLeader(scID)
+	if err != nil || leader.Equal(s.ServerIdentity()) {
+		return
+	}
+	txs := s.txBuffer.take(string(scID), -1)
+	if len(txs) > 0 {
+		log.Lvlf2("%s forwards %d transactions to the new leader %s",
+			s.ServerIdentity(), len(txs), leader)
+		if err := s.forwardTxs(scID, leader, txs); err != nil {
+			log.Warn(s.ServerIdentity(), "dropping transactions:", err)
+		}
+	}
+}
+
+// bufferTxs adds the transactions to the buffer, returning an error if some
+// of them have been dropped.
+func (s *Service) bufferTxs(scID skipchain.SkipBlockID, txs []ClientTransaction) error {
+	var err error
+	for _, tx := range txs {
+		if errAdd := s.txBuffer.add(string(scID), tx); errAdd != nil {
+			err = errAdd
+		}
+	}
+	return err
+}
+
+// handleForwardTxs receives the transactions forwarded by the other nodes of
+// the roster.
+func (s *Service) handleForwardTxs(env *network.Envelope) error {
+	req, ok := env.Msg.(*ForwardTxs)
+	if !ok {
+		return xerrors.Errorf("%v failed to cast to ForwardTxs", s.ServerIdentity())
+	}
+
+	config, err := s.LoadConfig(req.SkipChainID)
+	if err != nil {
+		return xerrors.Errorf("loading config: %v", err)
+	}
+	if i, _ := config.Roster.Search(env.ServerIdentity.ID); i < 0 {
+		return xerrors.Errorf("%v got transactions from %v which is not in the roster",
+			s.ServerIdentity(), env.ServerIdentity)
+	}
+
+	var txs []ClientTransaction
+	for _, tx := range req.Txs {
+		if txSize(TxResult{ClientTransaction: tx}) > config.MaxBlockSize {
+			log.Lvl2(s.ServerIdentity(), "dropping forwarded transaction that is too large")
+			continue
+		}
+		txs = append(txs, tx)
+	}
+	return cothority.ErrorOrNil(s.receiveTxs(req.SkipChainID, txs), "receiving transactions")
+}
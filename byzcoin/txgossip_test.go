@@ -0,0 +1,60 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/onet/v3/network"
+)
+
+func TestRecentTxs(t *testing.T) {
+	mth := maxTxHashes
+	defer func() {
+		maxTxHashes = mth
+	}()
+	maxTxHashes = 2
+
+	var r recentTxs
+	require.True(t, r.addNew([]byte{1}))
+	require.False(t, r.addNew([]byte{1}))
+	require.True(t, r.addNew([]byte{2}))
+	require.True(t, r.addNew([]byte{3}))
+	// The oldest hash is forgotten.
+	require.True(t, r.addNew([]byte{1}))
+	require.False(t, r.addNew([]byte{3}))
+}
+
+// Sends transactions to a follower, which must forward them to the leader
+// instead of keeping them for the next collection.
+func TestService_ForwardTxs(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	key := string(s.genesis.SkipChainID())
+
+	tx, err := createOneClientTx(s.darc.GetBaseID(), dummyContract, s.value, s.signer)
+	require.NoError(t, err)
+	s.sendTxTo(t, tx, 1)
+	s.waitProof(t, NewInstanceID(tx.Instructions[0].Hash()))
+	require.Empty(t, s.services[1].txBuffer.take(key, -1))
+
+	// After a view change, the buffer moves to the new leader.
+	tx, err = createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, s.value, s.signer, 2)
+	require.NoError(t, err)
+	s.services[2].bufferTxs(s.genesis.SkipChainID(), []ClientTransaction{tx})
+	s.services[2].flushTxs(s.genesis.SkipChainID())
+	require.Empty(t, s.services[2].txBuffer.take(key, -1))
+	s.waitProof(t, NewInstanceID(tx.Instructions[0].Hash()))
+
+	// Only the nodes of the roster can forward transactions.
+	si := network.NewServerIdentity(cothority.Suite.Point().Pick(cothority.Suite.RandomStream()),
+		s.roster.List[1].Address)
+	err = s.service().handleForwardTxs(&network.Envelope{
+		ServerIdentity: si,
+		Msg: &ForwardTxs{
+			SkipChainID: s.genesis.SkipChainID(),
+			Txs:         []ClientTransaction{tx},
+		},
+	})
+	require.Error(t, err)
+}
@@ -0,0 +1,212 @@
+package byzcoin
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/protobuf"
+	bbolt "go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+var bucketTxIndex = []byte("txindex")
+
+// txIndexEntry is the value stored in the transaction index. It points to the
+// block that holds the transaction.
+type txIndexEntry struct {
+	BlockID  skipchain.SkipBlockID
+	TxIndex  int
+	Accepted bool
+}
+
+// txIndexStorage keeps a persistent index of all the transactions of a
+// skipchain, using the hash of their instructions as the key. Each skipchain
+// has its own sub-bucket, which also stores the index of the last block
+// added without a gap.
+type txIndexStorage struct {
+	db     *bbolt.DB
+	bucket []byte
+}
+
+func newTxIndexStorage(c *onet.Context) *txIndexStorage {
+	db, name := c.GetAdditionalBucket(bucketTxIndex)
+	return &txIndexStorage{
+		db:     db,
+		bucket: name,
+	}
+}
+
+// getIndex returns the index of the last block added to the index of the
+// skipchain after all the previous ones, or -1 if no block has been added.
+func (s *txIndexStorage) getIndex(sid skipchain.SkipBlockID) (idx int, err error) {
+	idx = -1
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket).Bucket(sid)
+		if b == nil {
+			return nil
+		}
+		if buf := b.Get(keyIndexBlock); buf != nil {
+			idx = int(binary.BigEndian.Uint64(buf))
+		}
+		return nil
+	})
+
+	err = cothority.ErrorOrNil(err, "tx error")
+	return
+}
+
+// add stores the position of all the transactions of the block. A
+// transaction that has been refused can be sent again and end up in a later
+// block, so an entry is only overwritten if it is not accepted.
+func (s *txIndexStorage) add(sb *skipchain.SkipBlock, txs TxResults) error {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.Bucket(s.bucket).CreateBucketIfNotExists(sb.SkipChainID())
+		if err != nil {
+			return xerrors.Errorf("creating bucket: %v", err)
+		}
+
+		last := -1
+		if buf := b.Get(keyIndexBlock); buf != nil {
+			last = int(binary.BigEndian.Uint64(buf))
+		}
+		if last+1 == sb.Index {
+			if err := putIndexBlock(b, sb.Index); err != nil {
+				return xerrors.Errorf("writing index: %v", err)
+			}
+		}
+
+		for i, txr := range txs {
+			key := txr.ClientTransaction.Instructions.Hash()
+			if buf := b.Get(key); buf != nil {
+				var old txIndexEntry
+				if err := protobuf.Decode(buf, &old); err != nil {
+					return xerrors.Errorf("decoding: %v", err)
+				}
+				if old.Accepted {
+					continue
+				}
+			}
+
+			buf, err := protobuf.Encode(&txIndexEntry{
+				BlockID:  sb.Hash,
+				TxIndex:  i,
+				Accepted: txr.Accepted,
+			})
+			if err != nil {
+				return xerrors.Errorf("encoding: %v", err)
+			}
+			if err := b.Put(key, buf); err != nil {
+				return xerrors.Errorf("writing item: %v", err)
+			}
+		}
+		return nil
+	})
+	return cothority.ErrorOrNil(err, "tx error")
+}
+
+// indexTxs adds to the transaction index the stored blocks that are missing
+// from it, e.g. the blocks of a chain created before the node had the index.
+// The blocks whose transactions have been pruned are skipped.
+func (s *Service) indexTxs(scID skipchain.SkipBlockID) error {
+	last, err := s.txIndex.getIndex(scID)
+	if err != nil {
+		return xerrors.Errorf("reading index: %v", err)
+	}
+	sb := s.db().GetByID(scID)
+	if last > 0 {
+		reply, err := s.skService().GetSingleBlockByIndex(
+			&skipchain.GetSingleBlockByIndex{Genesis: scID, Index: last})
+		if err != nil {
+			return xerrors.Errorf("getting block: %v", err)
+		}
+		sb = reply.SkipBlock
+	}
+
+	for sb != nil {
+		if sb.Index > last {
+			var txs TxResults
+			body, err := decodeBlockBody(sb)
+			if err == nil {
+				txs = body.TxResults
+			} else if !xerrors.Is(err, errBlockPruned) {
+				return xerrors.Errorf("decoding block %d: %v", sb.Index, err)
+			}
+			if err := s.txIndex.add(sb, txs); err != nil {
+				return xerrors.Errorf("adding block %d: %v", sb.Index, err)
+			}
+		}
+		if len(sb.ForwardLink) == 0 {
+			break
+		}
+		sb = s.db().GetByID(sb.ForwardLink[0].To)
+	}
+	return nil
+}
+
+// get returns the entry of the transaction with the given hash. Use the bool
+// value to know if the transaction has been found.
+func (s *txIndexStorage) get(sid skipchain.SkipBlockID, hash []byte) (entry txIndexEntry, ok bool, err error) {
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket).Bucket(sid)
+		if b == nil {
+			return nil
+		}
+
+		buf := b.Get(hash)
+		if buf == nil {
+			return nil
+		}
+		if err := protobuf.Decode(buf, &entry); err != nil {
+			return xerrors.Errorf("decoding: %v", err)
+		}
+		ok = true
+		return nil
+	})
+
+	err = cothority.ErrorOrNil(err, "tx error")
+	return
+}
+
+// Verify checks that the block of the response is part of the skipchain
+// starting with the given genesis block and that it holds the transaction at
+// the given position.
+func (r GetTxStatusResponse) Verify(genesis *skipchain.SkipBlock) error {
+	links := append([]skipchain.ForwardLink{}, r.Links...)
+	if len(links) > 0 {
+		// The genesis block has been verified by the caller, so its
+		// roster can be trusted.
+		links[0].NewRoster = genesis.Roster
+	}
+	if err := verifyForwardLinks(links, &r.Block, genesis.Hash); err != nil {
+		return xerrors.Errorf("verifying forward links: %v", err)
+	}
+
+	header, err := decodeBlockHeader(&r.Block)
+	if err != nil {
+		return xerrors.Errorf("decoding header: %v", err)
+	}
+	var body DataBody
+	if err := protobuf.Decode(r.Block.Payload, &body); err != nil {
+		return xerrors.Errorf("decoding body: %v", err)
+	}
+	body.TxResults.SetVersion(header.Version)
+	if !bytes.Equal(body.TxResults.Hash(), header.ClientTransactionHash) {
+		return xerrors.New("transactions don't match the block header")
+	}
+
+	if r.TxIndex < 0 || r.TxIndex >= len(body.TxResults) {
+		return xerrors.New("index of the transaction is out of range")
+	}
+	txr := body.TxResults[r.TxIndex]
+	r.TxResult.ClientTransaction.Instructions.SetVersion(header.Version)
+	if !bytes.Equal(txr.ClientTransaction.Instructions.Hash(),
+		r.TxResult.ClientTransaction.Instructions.Hash()) ||
+		txr.Accepted != r.TxResult.Accepted {
+		return xerrors.New("transaction is not in the block")
+	}
+
+	return nil
+}
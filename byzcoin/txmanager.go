@@ -0,0 +1,237 @@
+package byzcoin
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/onet/v3/log"
+	"golang.org/x/xerrors"
+)
+
+// TxManager signs and sends the transactions of a set of signers. It keeps a
+// cache of the counters of the signers and sends a transaction again when it
+// is refused because of the counters, when the node doesn't reply, or when the
+// transaction got lost, e.g. during a view change. On failure, the next node
+// of the roster is contacted, using Client.UseNode.
+//
+// A transaction is only sent again with the same counters, or with newer
+// counters once it is known not to be in the ledger, so that it can never be
+// executed twice.
+type TxManager struct {
+	// Wait is the number of blocks to wait for a transaction to be
+	// included.
+	Wait int
+	// Retries is the number of times a transaction is sent again before
+	// giving up.
+	Retries int
+	// RetryDelay is the time to wait before sending a transaction again.
+	RetryDelay time.Duration
+	// Lane is the counter lane used by the signers. If it is 0, the
+	// instructions don't set the lanes. Two managers with the same signers
+	// must use different lanes.
+	Lane uint64
+
+	client   *Client
+	signers  []darc.Signer
+	counters []uint64
+	node     int
+	sync.Mutex
+}
+
+// NewTxManager returns a manager sending the transactions through the given
+// client, signed by all the signers. The manager changes the node contacted by
+// the client.
+func NewTxManager(c *Client, signers ...darc.Signer) *TxManager {
+	return &TxManager{
+		Wait:       10,
+		Retries:    5,
+		RetryDelay: time.Second,
+		client:     c,
+		signers:    signers,
+	}
+}
+
+// RefreshCounters fetches the counters of the signers from the ledger. It is
+// only needed if the signers are used outside of the manager, as the counters
+// are fetched again when a transaction is refused because of them.
+func (m *TxManager) RefreshCounters() error {
+	m.Lock()
+	defer m.Unlock()
+	return m.refreshCounters()
+}
+
+// Send signs a transaction holding the instructions, sends it and waits for
+// it to be included. The counters, the identities and the lanes of the
+// signers are set by the manager. The returned transaction can be used to
+// derive the IDs of the new instances.
+func (m *TxManager) Send(instrs ...Instruction) (ClientTransaction, *AddTxResponse, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	// sent holds the transactions that might have been included.
+	var sent []ClientTransaction
+	var tx ClientTransaction
+	var err error
+	for i := 0; i <= m.Retries; i++ {
+		if i > 0 {
+			log.Lvlf2("sending transaction again after error: %v", err)
+			time.Sleep(m.RetryDelay)
+		}
+
+		if m.counters == nil {
+			if err = m.refreshCounters(); err != nil {
+				m.nextNode()
+				continue
+			}
+			// A transaction that is lost is only known to be
+			// refused once the counters moved, as it can still be
+			// in the buffer of the leader.
+			if len(sent) > 0 {
+				var resp *AddTxResponse
+				if resp, err = m.findIncluded(sent, len(instrs)); err != nil {
+					m.nextNode()
+					continue
+				}
+				if resp != nil {
+					return sent[len(sent)-1], resp, nil
+				}
+			}
+		}
+
+		tx, err = m.sign(instrs)
+		if err != nil {
+			return tx, nil, xerrors.Errorf("signing transaction: %v", err)
+		}
+
+		var resp *AddTxResponse
+		if err = m.client.UseNode(m.node); err != nil {
+			return tx, nil, xerrors.Errorf("setting node: %v", err)
+		}
+		resp, err = m.client.AddTransactionAndWait(tx, m.Wait)
+		switch {
+		case err == nil:
+			m.increment(len(instrs))
+			return tx, resp, nil
+		case resp != nil && resp.Error != "":
+			// The counters of a refused transaction are not
+			// updated.
+			m.counters = nil
+			if !isCounterError(resp.Error) {
+				return tx, resp, xerrors.Errorf("transaction refused: %v", err)
+			}
+		default:
+			// The node didn't reply or the transaction didn't get
+			// included in time, but it can still be included.
+			sent = append(sent, tx)
+			m.counters = nil
+			m.nextNode()
+		}
+	}
+	return tx, nil, xerrors.Errorf("giving up after %d retries: %v", m.Retries, err)
+}
+
+func (m *TxManager) refreshCounters() error {
+	ids := make([]string, len(m.signers))
+	for i, signer := range m.signers {
+		ids[i] = signer.Identity().String()
+	}
+
+	if err := m.client.UseNode(m.node); err != nil {
+		return xerrors.Errorf("setting node: %v", err)
+	}
+	var resp *GetSignerCountersResponse
+	var err error
+	if m.Lane == 0 {
+		resp, err = m.client.GetSignerCounters(ids...)
+	} else {
+		resp, err = m.client.GetSignerLaneCounters(m.Lane, ids...)
+	}
+	if err != nil {
+		return xerrors.Errorf("getting counters: %v", err)
+	}
+	if len(resp.Counters) != len(ids) {
+		return xerrors.New("got the wrong number of counters")
+	}
+	m.counters = resp.Counters
+	return nil
+}
+
+// findIncluded returns the response for the first of the transactions that
+// has been included, or nil if none of them has been. The counters must be
+// up-to-date.
+func (m *TxManager) findIncluded(txs []ClientTransaction, nbrInstrs int) (*AddTxResponse, error) {
+	for _, tx := range txs {
+		// The transaction can only have been included if the counters
+		// are past the ones of the transaction.
+		if tx.Instructions[nbrInstrs-1].SignerCounter[0] > m.counters[0] {
+			continue
+		}
+		if err := m.client.UseNode(m.node); err != nil {
+			return nil, xerrors.Errorf("setting node: %v", err)
+		}
+		reply, err := m.client.GetTransaction(tx.Instructions.Hash())
+		if err != nil {
+			if strings.Contains(err.Error(), "transaction not found") {
+				continue
+			}
+			return nil, xerrors.Errorf("getting transaction: %v", err)
+		}
+		if reply.TxResult.Accepted {
+			return &AddTxResponse{Version: CurrentVersion}, nil
+		}
+	}
+	return nil, nil
+}
+
+// sign sets the counters of the signers in a copy of the instructions and
+// signs them. Every instruction increments the counters.
+func (m *TxManager) sign(instrs []Instruction) (ClientTransaction, error) {
+	ids := make([]darc.Identity, len(m.signers))
+	for i, signer := range m.signers {
+		ids[i] = signer.Identity()
+	}
+
+	instrs = append([]Instruction{}, instrs...)
+	for i := range instrs {
+		instrs[i].SignerIdentities = ids
+		instrs[i].SignerCounter = make([]uint64, len(m.signers))
+		for j := range m.signers {
+			instrs[i].SignerCounter[j] = m.counters[j] + uint64(i) + 1
+		}
+		instrs[i].SignerLanes = nil
+		if m.Lane > 0 {
+			instrs[i].SignerLanes = make([]uint64, len(m.signers))
+			for j := range m.signers {
+				instrs[i].SignerLanes[j] = m.Lane
+			}
+		}
+	}
+
+	tx, err := m.client.CreateTransaction(instrs...)
+	if err != nil {
+		return tx, xerrors.Errorf("creating transaction: %v", err)
+	}
+	if err := tx.SignWith(m.signers...); err != nil {
+		return tx, xerrors.Errorf("signing: %v", err)
+	}
+	return tx, nil
+}
+
+func (m *TxManager) increment(nbrInstrs int) {
+	for i := range m.counters {
+		m.counters[i] += uint64(nbrInstrs)
+	}
+}
+
+// nextNode makes the manager contact the next node of the roster.
+func (m *TxManager) nextNode() {
+	m.node = (m.node + 1) % len(m.client.Roster.List)
+}
+
+// isCounterError returns true if the error returned by the ledger comes from
+// the verification of the counters.
+func isCounterError(msg string) bool {
+	return strings.Contains(msg, "got counter=")
+}
@@ -0,0 +1,71 @@
+package byzcoin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/onet/v3"
+)
+
+func TestTxManager(t *testing.T) {
+	l := onet.NewTCPTest(cothority.Suite)
+	servers, roster, _ := l.GenTree(4, true)
+	registerDummy(servers)
+	defer l.CloseAll()
+
+	signer := darc.NewSignerEd25519(nil, nil)
+	msg, err := DefaultGenesisMsg(CurrentVersion, roster,
+		[]string{"spawn:" + dummyContract, "spawn:" + invalidContract}, signer.Identity())
+	require.NoError(t, err)
+	msg.BlockInterval = 100 * time.Millisecond
+	c, _, err := NewLedger(msg, false)
+	require.NoError(t, err)
+
+	m := NewTxManager(c, signer)
+	m.RetryDelay = 10 * time.Millisecond
+	spawn := func(contractID string) Instruction {
+		return Instruction{
+			InstanceID: NewInstanceID(msg.GenesisDarc.GetBaseID()),
+			Spawn: &Spawn{
+				ContractID: contractID,
+				Args:       Arguments{{Name: "data", Value: []byte("value")}},
+			},
+		}
+	}
+
+	tx, resp, err := m.Send(spawn(dummyContract), spawn(dummyContract))
+	require.NoError(t, err)
+	require.NotNil(t, resp.Proof)
+	require.Equal(t, []uint64{2}, m.counters)
+	require.Equal(t, []uint64{2}, tx.Instructions[1].SignerCounter)
+
+	// The counters are fetched again if they are wrong.
+	m.counters = []uint64{10}
+	_, _, err = m.Send(spawn(dummyContract))
+	require.NoError(t, err)
+	require.Equal(t, []uint64{3}, m.counters)
+
+	// The other errors are returned.
+	_, resp, err = m.Send(spawn(invalidContract))
+	require.Error(t, err)
+	require.NotEmpty(t, resp.Error)
+
+	// The manager fails over to the next node.
+	require.NoError(t, servers[3].Close())
+	m.node = 3
+	_, _, err = m.Send(spawn(dummyContract))
+	require.NoError(t, err)
+	require.Equal(t, 0, m.node)
+	require.Equal(t, []uint64{4}, m.counters)
+
+	// With a lane, the counters are independent.
+	m.Lane = 1
+	m.counters = nil
+	tx, _, err = m.Send(spawn(dummyContract))
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1}, tx.Instructions[0].SignerCounter)
+	require.Equal(t, []uint64{1}, tx.Instructions[0].SignerLanes)
+}
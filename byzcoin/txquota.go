@@ -0,0 +1,219 @@
+package byzcoin
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"go.dedis.ch/onet/v3"
+	"golang.org/x/xerrors"
+)
+
+// admissionBlocks is the number of blocks after which an admitted
+// transaction that has not been included is not counted anymore in the
+// quotas, e.g. because it has been dropped.
+var admissionBlocks = 10
+
+var errQuotaExceeded = xerrors.New("quota exceeded")
+
+// txAdmission counts, for every chain and every signer, the transactions
+// received by the node that are waiting to be included, and refuses the new
+// ones that go over the quotas of the chain configuration.
+type txAdmission struct {
+	sync.Mutex
+	chains map[string]*chainAdmission
+}
+
+type chainAdmission struct {
+	pending map[string]pendingTx
+	total   admissionCounter
+	signers map[string]*admissionCounter
+	// admitted and refused count all the transactions since the start of
+	// the node.
+	admitted uint64
+	refused  uint64
+}
+
+type admissionCounter struct {
+	txs   int
+	bytes int
+}
+
+type pendingTx struct {
+	signers []string
+	size    int
+	index   int
+}
+
+// admit counts the transaction for the chain and its signers if it fits
+// within the quota. Only the signers with a valid signature are counted. The
+// transaction is expected in one of the blocks following the given index. A
+// nil quota accepts all the transactions.
+func (a *txAdmission) admit(key string, tx ClientTransaction, index int, quota *TxQuota) error {
+	// The signatures are verified before locking, as it is the slow part.
+	signers := txSigners(tx)
+
+	a.Lock()
+	defer a.Unlock()
+
+	if a.chains == nil {
+		a.chains = make(map[string]*chainAdmission)
+	}
+	ca, ok := a.chains[key]
+	if !ok {
+		ca = &chainAdmission{
+			pending: make(map[string]pendingTx),
+			signers: make(map[string]*admissionCounter),
+		}
+		a.chains[key] = ca
+	}
+
+	hash := string(tx.Instructions.HashWithSignatures())
+	if _, ok := ca.pending[hash]; ok {
+		// The same transaction is only counted once.
+		return nil
+	}
+
+	ptx := pendingTx{
+		signers: signers,
+		size:    txSize(TxResult{ClientTransaction: tx}),
+		index:   index,
+	}
+	if err := ca.check(ptx, quota); err != nil {
+		ca.refused++
+		return err
+	}
+
+	ca.admitted++
+	ca.pending[hash] = ptx
+	ca.total.add(ptx.size, 1)
+	for _, id := range ptx.signers {
+		c, ok := ca.signers[id]
+		if !ok {
+			c = &admissionCounter{}
+			ca.signers[id] = c
+		}
+		c.add(ptx.size, 1)
+	}
+	return nil
+}
+
+// release stops counting the transaction, e.g. because it couldn't be
+// buffered.
+func (a *txAdmission) release(key string, tx ClientTransaction) {
+	a.Lock()
+	defer a.Unlock()
+
+	if ca, ok := a.chains[key]; ok {
+		ca.remove(string(tx.Instructions.HashWithSignatures()))
+	}
+}
+
+// included stops counting the transactions of the block at the given index,
+// together with the ones that have been admitted more than admissionBlocks
+// before it.
+func (a *txAdmission) included(key string, index int, txs TxResults) {
+	a.Lock()
+	defer a.Unlock()
+
+	ca, ok := a.chains[key]
+	if !ok {
+		return
+	}
+	for _, tx := range txs {
+		ca.remove(string(tx.ClientTransaction.Instructions.HashWithSignatures()))
+	}
+	for hash, ptx := range ca.pending {
+		if ptx.index+admissionBlocks < index {
+			ca.remove(hash)
+		}
+	}
+}
+
+// GetStatus implements the onet.StatusReporter interface and returns the
+// admission counters of every chain.
+func (a *txAdmission) GetStatus() *onet.Status {
+	a.Lock()
+	defer a.Unlock()
+
+	out := make(map[string]string)
+	for key, ca := range a.chains {
+		prefix := fmt.Sprintf("%x.", []byte(key))
+		out[prefix+"PendingTxs"] = strconv.Itoa(ca.total.txs)
+		out[prefix+"PendingBytes"] = strconv.Itoa(ca.total.bytes)
+		out[prefix+"PendingSigners"] = strconv.Itoa(len(ca.signers))
+		out[prefix+"Admitted"] = strconv.FormatUint(ca.admitted, 10)
+		out[prefix+"Refused"] = strconv.FormatUint(ca.refused, 10)
+	}
+	return &onet.Status{Field: out}
+}
+
+func (ca *chainAdmission) check(ptx pendingTx, quota *TxQuota) error {
+	if quota == nil {
+		return nil
+	}
+	if quota.MaxPendingTxs > 0 && ca.total.txs+1 > quota.MaxPendingTxs {
+		return xerrors.Errorf("%w: too many pending transactions for the chain", errQuotaExceeded)
+	}
+	if quota.MaxPendingBytes > 0 && ca.total.bytes+ptx.size > quota.MaxPendingBytes {
+		return xerrors.Errorf("%w: too many pending bytes for the chain", errQuotaExceeded)
+	}
+	for _, id := range ptx.signers {
+		c, ok := ca.signers[id]
+		if !ok {
+			c = &admissionCounter{}
+		}
+		if quota.MaxSignerTxs > 0 && c.txs+1 > quota.MaxSignerTxs {
+			return xerrors.Errorf("%w: too many pending transactions for %s", errQuotaExceeded, id)
+		}
+		if quota.MaxSignerBytes > 0 && c.bytes+ptx.size > quota.MaxSignerBytes {
+			return xerrors.Errorf("%w: too many pending bytes for %s", errQuotaExceeded, id)
+		}
+	}
+	return nil
+}
+
+func (ca *chainAdmission) remove(hash string) {
+	ptx, ok := ca.pending[hash]
+	if !ok {
+		return
+	}
+	delete(ca.pending, hash)
+	ca.total.add(-ptx.size, -1)
+	for _, id := range ptx.signers {
+		if c, ok := ca.signers[id]; ok {
+			c.add(-ptx.size, -1)
+			if c.txs == 0 {
+				delete(ca.signers, id)
+			}
+		}
+	}
+}
+
+func (c *admissionCounter) add(bytes int, txs int) {
+	c.bytes += bytes
+	c.txs += txs
+}
+
+// txSigners returns the identities with a valid signature on the
+// instructions of the transaction, each one only once. The other identities
+// can be claimed by anyone, so that the transaction is only counted in the
+// quota of the chain for them.
+func txSigners(tx ClientTransaction) []string {
+	var ids []string
+	msg := tx.Digest()
+	seen := make(map[string]bool)
+	for _, instr := range tx.Instructions {
+		for i, id := range instr.SignerIdentities {
+			s := id.String()
+			if seen[s] || i >= len(instr.Signatures) {
+				continue
+			}
+			if id.Verify(msg, instr.Signatures[i]) == nil {
+				seen[s] = true
+				ids = append(ids, s)
+			}
+		}
+	}
+	return ids
+}
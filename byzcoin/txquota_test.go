@@ -0,0 +1,68 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/darc"
+	"golang.org/x/xerrors"
+)
+
+func TestTxAdmission(t *testing.T) {
+	signer1 := darc.NewSignerEd25519(nil, nil)
+	signer2 := darc.NewSignerEd25519(nil, nil)
+	newTx := func(i byte, signers ...darc.Signer) ClientTransaction {
+		tx := NewClientTransaction(CurrentVersion,
+			Instruction{InstanceID: NewInstanceID([]byte{i})})
+		require.NoError(t, tx.FillSignersAndSignWith(signers...))
+		return tx
+	}
+
+	var a txAdmission
+	key := "abc"
+	quota := &TxQuota{MaxPendingTxs: 3, MaxSignerTxs: 2}
+
+	tx1 := newTx(1, signer1)
+	require.NoError(t, a.admit(key, tx1, 0, quota))
+	// The same transaction is only counted once.
+	require.NoError(t, a.admit(key, tx1, 0, quota))
+	require.NoError(t, a.admit(key, newTx(2, signer1, signer2), 0, quota))
+	err := a.admit(key, newTx(3, signer1), 0, quota)
+	require.Error(t, err)
+	require.True(t, xerrors.Is(err, errQuotaExceeded))
+	require.NoError(t, a.admit(key, newTx(4, signer2), 0, quota))
+	err = a.admit(key, newTx(5), 0, quota)
+	require.True(t, xerrors.Is(err, errQuotaExceeded))
+	// Without a quota, everything is accepted.
+	require.NoError(t, a.admit(key, newTx(5), 0, nil))
+
+	status := a.GetStatus().Field
+	require.Equal(t, "4", status["616263.PendingTxs"])
+	require.Equal(t, "2", status["616263.PendingSigners"])
+	require.Equal(t, "4", status["616263.Admitted"])
+	require.Equal(t, "2", status["616263.Refused"])
+
+	// The released and the included transactions are not counted anymore.
+	a.release(key, newTx(5))
+	a.included(key, 1, TxResults{{ClientTransaction: tx1}})
+	require.NoError(t, a.admit(key, newTx(3, signer1), 1, quota))
+	require.Equal(t, "3", a.GetStatus().Field["616263.PendingTxs"])
+
+	// The old transactions are dropped after admissionBlocks.
+	a.included(key, admissionBlocks+1, nil)
+	require.Equal(t, "1", a.GetStatus().Field["616263.PendingTxs"])
+	a.included(key, admissionBlocks+2, nil)
+	require.Equal(t, "0", a.GetStatus().Field["616263.PendingTxs"])
+	require.Equal(t, "0", a.GetStatus().Field["616263.PendingBytes"])
+
+	// A transaction claiming the identity of signer1 without its signature
+	// doesn't use the quota of signer1.
+	forged := newTx(10, signer2)
+	forged.Instructions[0].SignerIdentities = []darc.Identity{signer1.Identity()}
+	require.Empty(t, txSigners(forged))
+	require.NoError(t, a.admit(key, forged, admissionBlocks+2, quota))
+	require.Equal(t, "0", a.GetStatus().Field["616263.PendingSigners"])
+	require.NoError(t, a.admit(key, newTx(11, signer1), admissionBlocks+2, quota))
+	require.NoError(t, a.admit(key, newTx(12, signer1), admissionBlocks+2, quota))
+	require.Equal(t, "3", a.GetStatus().Field["616263.PendingTxs"])
+}
@@ -0,0 +1,122 @@
+package byzcoin
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+
+	"go.dedis.ch/cothority/v3/skipchain"
+	"golang.org/x/xerrors"
+)
+
+var (
+	errTxExpired     = xerrors.New("transaction expired")
+	errTxNotValidYet = xerrors.New("transaction is not valid yet")
+)
+
+// check returns an error if the transaction cannot be included in the block
+// at the given index, when the latest block has the given timestamp. A nil
+// window accepts every block.
+func (v *TxValidity) check(index int, timestamp int64) error {
+	if v == nil {
+		return nil
+	}
+	if v.NotAfter > 0 && index > v.NotAfter {
+		return xerrors.Errorf("block %d is after block %d: %w", index,
+			v.NotAfter, errTxExpired)
+	}
+	if v.NotAfterTime > 0 && timestamp > v.NotAfterTime {
+		return xerrors.Errorf("timestamp %d is after %d: %w", timestamp,
+			v.NotAfterTime, errTxExpired)
+	}
+	if index < v.NotBefore {
+		return xerrors.Errorf("block %d is before block %d: %w", index,
+			v.NotBefore, errTxNotValidYet)
+	}
+	if timestamp < v.NotBeforeTime {
+		return xerrors.Errorf("timestamp %d is before %d: %w", timestamp,
+			v.NotBeforeTime, errTxNotValidYet)
+	}
+	return nil
+}
+
+// hasTimestamps returns true if the window needs the timestamp of the latest
+// block to be checked.
+func (v *TxValidity) hasTimestamps() bool {
+	return v != nil && (v.NotBeforeTime != 0 || v.NotAfterTime != 0)
+}
+
+func (v *TxValidity) hash(h hash.Hash) {
+	for _, x := range []uint64{uint64(v.NotBefore), uint64(v.NotAfter),
+		uint64(v.NotBeforeTime), uint64(v.NotAfterTime)} {
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, x)
+		h.Write(buf)
+	}
+}
+
+// Digest returns the digest that the signers of the instructions sign. If
+// the transaction has a validity window or a fee payer, they are included in
+// the digest, otherwise the digest is the hash of the instructions, so that
+// the signatures of the transactions without them are unchanged.
+func (ctx ClientTransaction) Digest() []byte {
+	if ctx.Validity == nil && ctx.FeePayer == nil {
+		return ctx.Instructions.Hash()
+	}
+
+	h := sha256.New()
+	h.Write(ctx.Instructions.Hash())
+	if ctx.Validity != nil {
+		ctx.Validity.hash(h)
+	}
+	if ctx.FeePayer != nil {
+		h.Write([]byte("feepayer"))
+		h.Write(ctx.FeePayer[:])
+	}
+	return h.Sum(nil)
+}
+
+// checkExpired returns an error if the transaction cannot be included in the
+// blocks following the given one because its validity window expired.
+func checkExpired(tx ClientTransaction, sb *skipchain.SkipBlock) error {
+	if tx.Validity == nil {
+		return nil
+	}
+	header, err := decodeBlockHeader(sb)
+	if err != nil {
+		// The transaction will be refused when the next block is
+		// created, if it is not valid anymore.
+		return nil
+	}
+	err = tx.Validity.check(sb.Index+1, header.Timestamp)
+	if xerrors.Is(err, errTxExpired) {
+		return err
+	}
+	return nil
+}
+
+// checkValidity returns an error if the transaction cannot be included in the
+// block following the state of sst.
+func (s *Service) checkValidity(sst ReadOnlyStateTrie, tx ClientTransaction,
+	scID skipchain.SkipBlockID) error {
+	if tx.Validity == nil {
+		return nil
+	}
+
+	index := sst.GetIndex()
+	var timestamp int64
+	if tx.Validity.hasTimestamps() {
+		reply, err := s.skService().GetSingleBlockByIndex(
+			&skipchain.GetSingleBlockByIndex{Genesis: scID, Index: index})
+		if err != nil {
+			return xerrors.Errorf("getting block %d: %v", index, err)
+		}
+		header, err := decodeBlockHeader(reply.SkipBlock)
+		if err != nil {
+			return xerrors.Errorf("decoding header: %v", err)
+		}
+		timestamp = header.Timestamp
+	}
+
+	return tx.Validity.check(index+1, timestamp)
+}
@@ -406,7 +406,7 @@ func (s *Service) createViewChangeBlock(req viewchange.NewViewReq, multisig []by
 	if err != nil {
 		return xerrors.Errorf("getting trie: %v", err)
 	}
-	ctr, err := getSignerCounter(st, signer.Identity().String())
+	ctr, err := GetSignerCounter(st, signer.Identity().String())
 	if err != nil {
 		return xerrors.Errorf("getting counter: %v", err)
 	}
@@ -444,7 +444,7 @@ func (s *Service) createViewChangeBlock(req viewchange.NewViewReq, multisig []by
 		return xerrors.Errorf("signing tx: %v", err)
 	}
 
-	_, err = s.createNewBlock(req.GetGen(), rotateRoster(sb.Roster, req.GetView().LeaderIndex), []TxResult{TxResult{ctx, false}})
+	_, err = s.createNewBlock(req.GetGen(), rotateRoster(sb.Roster, req.GetView().LeaderIndex), []TxResult{TxResult{ClientTransaction: ctx}})
 	return cothority.ErrorOrNil(err, "creating block")
 }
 
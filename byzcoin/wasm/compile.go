@@ -0,0 +1,295 @@
+package wasm
+
+import (
+	"golang.org/x/xerrors"
+)
+
+// The opcodes of the supported instructions. The instructions prefixed by
+// 0xfc are stored with the prefix in the high byte.
+const (
+	opUnreachable  = 0x00
+	opNop          = 0x01
+	opBlock        = 0x02
+	opLoop         = 0x03
+	opIf           = 0x04
+	opElse         = 0x05
+	opEnd          = 0x0b
+	opBr           = 0x0c
+	opBrIf         = 0x0d
+	opBrTable      = 0x0e
+	opReturn       = 0x0f
+	opCall         = 0x10
+	opCallIndirect = 0x11
+	opDrop         = 0x1a
+	opSelect       = 0x1b
+	opSelectT      = 0x1c
+	opLocalGet     = 0x20
+	opLocalSet     = 0x21
+	opLocalTee     = 0x22
+	opGlobalGet    = 0x23
+	opGlobalSet    = 0x24
+
+	opI32Load    = 0x28
+	opI64Load    = 0x29
+	opI32Load8S  = 0x2c
+	opI32Load8U  = 0x2d
+	opI32Load16S = 0x2e
+	opI32Load16U = 0x2f
+	opI64Load8S  = 0x30
+	opI64Load8U  = 0x31
+	opI64Load16S = 0x32
+	opI64Load16U = 0x33
+	opI64Load32S = 0x34
+	opI64Load32U = 0x35
+	opI32Store   = 0x36
+	opI64Store   = 0x37
+	opI32Store8  = 0x3a
+	opI32Store16 = 0x3b
+	opI64Store8  = 0x3c
+	opI64Store16 = 0x3d
+	opI64Store32 = 0x3e
+	opMemorySize = 0x3f
+	opMemoryGrow = 0x40
+
+	opI32Const = 0x41
+	opI64Const = 0x42
+
+	opI32Eqz = 0x45
+	opI32GeU = 0x4f
+	opI64Eqz = 0x50
+	opI64GeU = 0x5a
+
+	opI32Clz    = 0x67
+	opI32Popcnt = 0x69
+	opI32Add    = 0x6a
+	opI32Rotr   = 0x78
+	opI64Clz    = 0x79
+	opI64Popcnt = 0x7b
+	opI64Add    = 0x7c
+	opI64Rotr   = 0x8a
+
+	opI32WrapI64    = 0xa7
+	opI64ExtendI32S = 0xac
+	opI64ExtendI32U = 0xad
+	opI32Extend8S   = 0xc0
+	opI32Extend16S  = 0xc1
+	opI64Extend8S   = 0xc2
+	opI64Extend16S  = 0xc3
+	opI64Extend32S  = 0xc4
+	opPrefix        = 0xfc
+	opMemoryCopy    = 0xfc0a
+	opMemoryFill    = 0xfc0b
+)
+
+// blockTypeEmpty is the type of a block without parameters and results.
+const blockTypeEmpty = 0x40
+
+// maxFunctionInstr limits the number of instructions of a function.
+const maxFunctionInstr = 1 << 20
+
+// instr is a decoded instruction, with the position of the matching else and
+// end for the blocks.
+type instr struct {
+	op uint16
+	// imm is the index, the label or the offset of the instruction.
+	imm uint32
+	// value is the constant of i32.const and i64.const.
+	value uint64
+	// labels are the labels of br_table, the last one being the default.
+	labels []uint32
+	// params and results are the arity of the block.
+	params  int
+	results int
+	// els and end are the positions of the else and the end of the block.
+	els int
+	end int
+}
+
+// compile decodes the instructions of a function body and matches the
+// blocks with their end.
+func (m *Module) compile(r *reader) ([]instr, error) {
+	var code []instr
+	// blocks are the positions of the open blocks.
+	var blocks []int
+	for {
+		if len(code) >= maxFunctionInstr {
+			return nil, xerrors.New("function is too long")
+		}
+		b, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		in := instr{op: uint16(b), els: -1}
+		switch {
+		case b == opBlock || b == opLoop || b == opIf:
+			if in.params, in.results, err = m.blockType(r); err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, len(code))
+		case b == opElse:
+			if len(blocks) == 0 {
+				return nil, xerrors.New("else outside of a block")
+			}
+			top := &code[blocks[len(blocks)-1]]
+			if top.op != opIf || top.els >= 0 {
+				return nil, xerrors.New("else without if")
+			}
+			top.els = len(code)
+		case b == opEnd:
+			if len(blocks) == 0 {
+				code = append(code, in)
+				if r.pos != len(r.buf) {
+					return nil, xerrors.New("instructions after the end of the function")
+				}
+				return code, nil
+			}
+			top := &code[blocks[len(blocks)-1]]
+			top.end = len(code)
+			if top.els >= 0 {
+				code[top.els].end = len(code)
+			}
+			blocks = blocks[:len(blocks)-1]
+		case b == opBr || b == opBrIf:
+			if in.imm, err = r.u32(); err != nil {
+				return nil, err
+			}
+			if int(in.imm) > len(blocks) {
+				return nil, xerrors.Errorf("invalid label %d", in.imm)
+			}
+		case b == opBrTable:
+			n, err := r.u32()
+			if err != nil {
+				return nil, err
+			}
+			if int(n) >= len(r.buf) {
+				return nil, xerrors.New("too many labels")
+			}
+			in.labels = make([]uint32, n+1)
+			for i := range in.labels {
+				if in.labels[i], err = r.u32(); err != nil {
+					return nil, err
+				}
+				if int(in.labels[i]) > len(blocks) {
+					return nil, xerrors.Errorf("invalid label %d", in.labels[i])
+				}
+			}
+		case b == opUnreachable || b == opNop || b == opReturn ||
+			b == opDrop || b == opSelect:
+		case b == opSelectT:
+			if _, err = r.valueTypes(); err != nil {
+				return nil, err
+			}
+			in.op = opSelect
+		case b == opCall || (b >= opLocalGet && b <= opGlobalSet):
+			if in.imm, err = r.u32(); err != nil {
+				return nil, err
+			}
+		case b == opCallIndirect:
+			if in.imm, err = r.u32(); err != nil {
+				return nil, err
+			}
+			if err = r.zero(); err != nil {
+				return nil, err
+			}
+		case b >= opI32Load && b <= opI64Store32:
+			if b == 0x2a || b == 0x2b || b == 0x38 || b == 0x39 {
+				return nil, errFloat
+			}
+			// The alignment is only a hint.
+			if _, err = r.u32(); err != nil {
+				return nil, err
+			}
+			if in.imm, err = r.u32(); err != nil {
+				return nil, err
+			}
+		case b == opMemorySize || b == opMemoryGrow:
+			if err = r.zero(); err != nil {
+				return nil, err
+			}
+		case b == opI32Const:
+			v, err := r.signed(32)
+			if err != nil {
+				return nil, err
+			}
+			in.value = uint64(uint32(v))
+		case b == opI64Const:
+			v, err := r.signed(64)
+			if err != nil {
+				return nil, err
+			}
+			in.value = uint64(v)
+		case (b >= opI32Eqz && b <= opI64GeU) ||
+			(b >= opI32Clz && b <= opI64Rotr) ||
+			b == opI32WrapI64 || b == opI64ExtendI32S || b == opI64ExtendI32U ||
+			(b >= opI32Extend8S && b <= opI64Extend32S):
+		case b == opPrefix:
+			sub, err := r.u32()
+			if err != nil {
+				return nil, err
+			}
+			in.op = opPrefix<<8 | uint16(sub)
+			switch in.op {
+			case opMemoryCopy:
+				if err = r.zero(); err != nil {
+					return nil, err
+				}
+				err = r.zero()
+			case opMemoryFill:
+				err = r.zero()
+			default:
+				if sub < 8 {
+					return nil, errFloat
+				}
+				err = xerrors.Errorf("unsupported instruction 0xfc %d", sub)
+			}
+			if err != nil {
+				return nil, err
+			}
+		case b >= 0x43 && b <= 0xbf:
+			return nil, errFloat
+		default:
+			return nil, xerrors.Errorf("unsupported instruction %#x", b)
+		}
+		code = append(code, in)
+	}
+}
+
+var errFloat = xerrors.New("floating point numbers are not supported")
+
+// blockType returns the number of parameters and results of a block.
+func (m *Module) blockType(r *reader) (int, int, error) {
+	if r.pos >= len(r.buf) {
+		return 0, 0, xerrors.New("missing block type")
+	}
+	switch b := r.buf[r.pos]; b {
+	case blockTypeEmpty:
+		r.pos++
+		return 0, 0, nil
+	case byte(I32), byte(I64):
+		r.pos++
+		return 0, 1, nil
+	case 0x7d, 0x7c:
+		return 0, 0, errFloat
+	}
+	idx, err := r.signed(33)
+	if err != nil {
+		return 0, 0, err
+	}
+	if idx < 0 || idx >= int64(len(m.types)) {
+		return 0, 0, xerrors.Errorf("invalid block type %d", idx)
+	}
+	t := m.types[idx]
+	return len(t.Params), len(t.Results), nil
+}
+
+// zero reads the index of the memory or the table, which can only be 0.
+func (r *reader) zero() error {
+	b, err := r.byte()
+	if err != nil {
+		return err
+	}
+	if b != 0 {
+		return xerrors.New("only the memory and the table 0 are supported")
+	}
+	return nil
+}
@@ -0,0 +1,462 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	// fuelPerPage is the fuel used to add a page to the memory.
+	fuelPerPage = 1024
+	// bytesPerFuel is the number of bytes copied or filled by
+	// memory.copy and memory.fill for each unit of fuel.
+	bytesPerFuel = 64
+)
+
+// label is the target of a branch: the stack is cut at height, keeping the
+// arity values on top, and the execution continues at cont.
+type label struct {
+	height int
+	arity  int
+	cont   int
+}
+
+// run executes the body of a function and returns its results.
+func (in *Instance) run(f *function, locals []uint64) []uint64 {
+	code := f.code
+	stack := make([]uint64, 0, 16)
+	labels := []label{{arity: len(f.typ.Results), cont: len(code)}}
+	pop := func() uint64 {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v
+	}
+	branch := func(depth uint32) int {
+		i := len(labels) - 1 - int(depth)
+		l := labels[i]
+		if l.height < 0 || l.height+l.arity > len(stack) {
+			in.trapf("invalid stack height at branch")
+		}
+		copy(stack[l.height:], stack[len(stack)-l.arity:])
+		stack = stack[:l.height+l.arity]
+		labels = labels[:i]
+		return l.cont
+	}
+
+	for pc := 0; pc < len(code); {
+		in.useFuel(1)
+		ins := &code[pc]
+		pc++
+		switch op := ins.op; {
+		case op == opUnreachable:
+			in.trapf("unreachable")
+		case op == opNop:
+		case op == opBlock:
+			labels = append(labels, label{len(stack) - ins.params, ins.results, ins.end + 1})
+		case op == opLoop:
+			labels = append(labels, label{len(stack) - ins.params, ins.params, pc - 1})
+		case op == opIf:
+			c := pop()
+			labels = append(labels, label{len(stack) - ins.params, ins.results, ins.end + 1})
+			if c == 0 {
+				if ins.els >= 0 {
+					pc = ins.els + 1
+				} else {
+					pc = ins.end
+				}
+			}
+		case op == opElse:
+			pc = ins.end
+		case op == opEnd:
+			labels = labels[:len(labels)-1]
+		case op == opBr:
+			pc = branch(ins.imm)
+		case op == opBrIf:
+			if pop() != 0 {
+				pc = branch(ins.imm)
+			}
+		case op == opBrTable:
+			i := uint32(pop())
+			depth := ins.labels[len(ins.labels)-1]
+			if int(i) < len(ins.labels)-1 {
+				depth = ins.labels[i]
+			}
+			pc = branch(depth)
+		case op == opReturn:
+			return stack[len(stack)-len(f.typ.Results):]
+		case op == opCall:
+			stack = in.callFromStack(ins.imm, stack)
+		case op == opCallIndirect:
+			i := uint32(pop())
+			if int(i) >= len(in.table) || in.table[i] < 0 {
+				in.trapf("undefined element %d", i)
+			}
+			idx := uint32(in.table[i])
+			if !in.module.funcType(idx).equal(in.module.types[ins.imm]) {
+				in.trapf("indirect call type mismatch")
+			}
+			stack = in.callFromStack(idx, stack)
+		case op == opDrop:
+			pop()
+		case op == opSelect:
+			c := pop()
+			v2 := pop()
+			if c == 0 {
+				stack[len(stack)-1] = v2
+			}
+		case op == opLocalGet:
+			stack = append(stack, locals[ins.imm])
+		case op == opLocalSet:
+			locals[ins.imm] = pop()
+		case op == opLocalTee:
+			locals[ins.imm] = stack[len(stack)-1]
+		case op == opGlobalGet:
+			stack = append(stack, in.globals[ins.imm])
+		case op == opGlobalSet:
+			in.globals[ins.imm] = pop()
+		case op >= opI32Load && op <= opI64Load32U:
+			stack[len(stack)-1] = in.load(op, stack[len(stack)-1], ins.imm)
+		case op >= opI32Store && op <= opI64Store32:
+			v := pop()
+			in.store(op, pop(), ins.imm, v)
+		case op == opMemorySize:
+			stack = append(stack, uint64(len(in.memory)/PageSize))
+		case op == opMemoryGrow:
+			stack[len(stack)-1] = in.grow(uint32(stack[len(stack)-1]))
+		case op == opI32Const || op == opI64Const:
+			stack = append(stack, ins.value)
+		case op == opI32Eqz:
+			stack[len(stack)-1] = b2u(uint32(stack[len(stack)-1]) == 0)
+		case op == opI64Eqz:
+			stack[len(stack)-1] = b2u(stack[len(stack)-1] == 0)
+		case op > opI32Eqz && op <= opI32GeU:
+			b := uint32(pop())
+			stack[len(stack)-1] = b2u(i32Compare(op, uint32(stack[len(stack)-1]), b))
+		case op > opI64Eqz && op <= opI64GeU:
+			b := pop()
+			stack[len(stack)-1] = b2u(i64Compare(op, stack[len(stack)-1], b))
+		case op >= opI32Clz && op <= opI32Popcnt:
+			stack[len(stack)-1] = uint64(i32Unary(op, uint32(stack[len(stack)-1])))
+		case op >= opI64Clz && op <= opI64Popcnt:
+			stack[len(stack)-1] = i64Unary(op, stack[len(stack)-1])
+		case op >= opI32Add && op <= opI32Rotr:
+			b := uint32(pop())
+			stack[len(stack)-1] = uint64(in.i32Binary(op, uint32(stack[len(stack)-1]), b))
+		case op >= opI64Add && op <= opI64Rotr:
+			b := pop()
+			stack[len(stack)-1] = in.i64Binary(op, stack[len(stack)-1], b)
+		case op == opI32WrapI64:
+			stack[len(stack)-1] = uint64(uint32(stack[len(stack)-1]))
+		case op == opI64ExtendI32S:
+			stack[len(stack)-1] = uint64(int64(int32(stack[len(stack)-1])))
+		case op == opI64ExtendI32U:
+			stack[len(stack)-1] = uint64(uint32(stack[len(stack)-1]))
+		case op == opI32Extend8S:
+			stack[len(stack)-1] = uint64(uint32(int32(int8(stack[len(stack)-1]))))
+		case op == opI32Extend16S:
+			stack[len(stack)-1] = uint64(uint32(int32(int16(stack[len(stack)-1]))))
+		case op == opI64Extend8S:
+			stack[len(stack)-1] = uint64(int64(int8(stack[len(stack)-1])))
+		case op == opI64Extend16S:
+			stack[len(stack)-1] = uint64(int64(int16(stack[len(stack)-1])))
+		case op == opI64Extend32S:
+			stack[len(stack)-1] = uint64(int64(int32(stack[len(stack)-1])))
+		case op == opMemoryCopy:
+			n := uint32(pop())
+			src := uint32(pop())
+			dst := uint32(pop())
+			in.checkMemory(uint64(src), uint64(n))
+			in.checkMemory(uint64(dst), uint64(n))
+			in.useFuel(uint64(n) / bytesPerFuel)
+			copy(in.memory[dst:dst+n], in.memory[src:src+n])
+		case op == opMemoryFill:
+			n := uint32(pop())
+			v := byte(pop())
+			dst := uint32(pop())
+			in.checkMemory(uint64(dst), uint64(n))
+			in.useFuel(uint64(n) / bytesPerFuel)
+			for i := dst; i < dst+n; i++ {
+				in.memory[i] = v
+			}
+		default:
+			in.trapf("unsupported instruction %#x", op)
+		}
+	}
+	return stack[len(stack)-len(f.typ.Results):]
+}
+
+// callFromStack calls the function with the arguments on top of the stack,
+// which are replaced by the results.
+func (in *Instance) callFromStack(idx uint32, stack []uint64) []uint64 {
+	n := len(in.module.funcType(idx).Params)
+	args := append([]uint64{}, stack[len(stack)-n:]...)
+	return append(stack[:len(stack)-n], in.call(idx, args)...)
+}
+
+func b2u(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// checkMemory traps if the memory doesn't hold size bytes at addr.
+func (in *Instance) checkMemory(addr, size uint64) {
+	if addr+size > uint64(len(in.memory)) {
+		in.trapf("out of bounds memory access")
+	}
+}
+
+func (in *Instance) load(op uint16, base uint64, offset uint32) uint64 {
+	addr := uint64(uint32(base)) + uint64(offset)
+	var size uint64
+	switch op {
+	case opI32Load8S, opI32Load8U, opI64Load8S, opI64Load8U:
+		size = 1
+	case opI32Load16S, opI32Load16U, opI64Load16S, opI64Load16U:
+		size = 2
+	case opI32Load, opI64Load32S, opI64Load32U:
+		size = 4
+	default:
+		size = 8
+	}
+	in.checkMemory(addr, size)
+	mem := in.memory[addr:]
+	switch op {
+	case opI32Load, opI64Load32U:
+		return uint64(binary.LittleEndian.Uint32(mem))
+	case opI64Load:
+		return binary.LittleEndian.Uint64(mem)
+	case opI32Load8S:
+		return uint64(uint32(int32(int8(mem[0]))))
+	case opI32Load8U, opI64Load8U:
+		return uint64(mem[0])
+	case opI32Load16S:
+		return uint64(uint32(int32(int16(binary.LittleEndian.Uint16(mem)))))
+	case opI32Load16U, opI64Load16U:
+		return uint64(binary.LittleEndian.Uint16(mem))
+	case opI64Load8S:
+		return uint64(int64(int8(mem[0])))
+	case opI64Load16S:
+		return uint64(int64(int16(binary.LittleEndian.Uint16(mem))))
+	default:
+		return uint64(int64(int32(binary.LittleEndian.Uint32(mem))))
+	}
+}
+
+func (in *Instance) store(op uint16, base uint64, offset uint32, v uint64) {
+	addr := uint64(uint32(base)) + uint64(offset)
+	var size uint64
+	switch op {
+	case opI32Store8, opI64Store8:
+		size = 1
+	case opI32Store16, opI64Store16:
+		size = 2
+	case opI32Store, opI64Store32:
+		size = 4
+	default:
+		size = 8
+	}
+	in.checkMemory(addr, size)
+	mem := in.memory[addr:]
+	switch size {
+	case 1:
+		mem[0] = byte(v)
+	case 2:
+		binary.LittleEndian.PutUint16(mem, uint16(v))
+	case 4:
+		binary.LittleEndian.PutUint32(mem, uint32(v))
+	default:
+		binary.LittleEndian.PutUint64(mem, v)
+	}
+}
+
+// grow adds n pages to the memory and returns the previous number of pages,
+// or -1 if the memory cannot grow that much.
+func (in *Instance) grow(n uint32) uint64 {
+	pages := uint32(len(in.memory) / PageSize)
+	if in.module.memory == nil || uint64(pages)+uint64(n) > uint64(in.maxPages) {
+		return uint64(uint32(0xffffffff))
+	}
+	in.useFuel(uint64(n) * fuelPerPage)
+	in.memory = append(in.memory, make([]byte, int(n)*PageSize)...)
+	return uint64(pages)
+}
+
+func i32Compare(op uint16, a, b uint32) bool {
+	switch op - opI32Eqz {
+	case 1:
+		return a == b
+	case 2:
+		return a != b
+	case 3:
+		return int32(a) < int32(b)
+	case 4:
+		return a < b
+	case 5:
+		return int32(a) > int32(b)
+	case 6:
+		return a > b
+	case 7:
+		return int32(a) <= int32(b)
+	case 8:
+		return a <= b
+	case 9:
+		return int32(a) >= int32(b)
+	default:
+		return a >= b
+	}
+}
+
+func i64Compare(op uint16, a, b uint64) bool {
+	switch op - opI64Eqz {
+	case 1:
+		return a == b
+	case 2:
+		return a != b
+	case 3:
+		return int64(a) < int64(b)
+	case 4:
+		return a < b
+	case 5:
+		return int64(a) > int64(b)
+	case 6:
+		return a > b
+	case 7:
+		return int64(a) <= int64(b)
+	case 8:
+		return a <= b
+	case 9:
+		return int64(a) >= int64(b)
+	default:
+		return a >= b
+	}
+}
+
+func i32Unary(op uint16, a uint32) uint32 {
+	switch op - opI32Clz {
+	case 0:
+		return uint32(bits.LeadingZeros32(a))
+	case 1:
+		return uint32(bits.TrailingZeros32(a))
+	default:
+		return uint32(bits.OnesCount32(a))
+	}
+}
+
+func i64Unary(op uint16, a uint64) uint64 {
+	switch op - opI64Clz {
+	case 0:
+		return uint64(bits.LeadingZeros64(a))
+	case 1:
+		return uint64(bits.TrailingZeros64(a))
+	default:
+		return uint64(bits.OnesCount64(a))
+	}
+}
+
+func (in *Instance) i32Binary(op uint16, a, b uint32) uint32 {
+	switch op - opI32Add {
+	case 0:
+		return a + b
+	case 1:
+		return a - b
+	case 2:
+		return a * b
+	case 3:
+		if b == 0 {
+			in.trapf("integer divide by zero")
+		}
+		if int32(a) == -1<<31 && int32(b) == -1 {
+			in.trapf("integer overflow")
+		}
+		return uint32(int32(a) / int32(b))
+	case 4:
+		if b == 0 {
+			in.trapf("integer divide by zero")
+		}
+		return a / b
+	case 5:
+		if b == 0 {
+			in.trapf("integer divide by zero")
+		}
+		if int32(b) == -1 {
+			return 0
+		}
+		return uint32(int32(a) % int32(b))
+	case 6:
+		if b == 0 {
+			in.trapf("integer divide by zero")
+		}
+		return a % b
+	case 7:
+		return a & b
+	case 8:
+		return a | b
+	case 9:
+		return a ^ b
+	case 10:
+		return a << (b & 31)
+	case 11:
+		return uint32(int32(a) >> (b & 31))
+	case 12:
+		return a >> (b & 31)
+	case 13:
+		return bits.RotateLeft32(a, int(b&31))
+	default:
+		return bits.RotateLeft32(a, -int(b&31))
+	}
+}
+
+func (in *Instance) i64Binary(op uint16, a, b uint64) uint64 {
+	switch op - opI64Add {
+	case 0:
+		return a + b
+	case 1:
+		return a - b
+	case 2:
+		return a * b
+	case 3:
+		if b == 0 {
+			in.trapf("integer divide by zero")
+		}
+		if int64(a) == -1<<63 && int64(b) == -1 {
+			in.trapf("integer overflow")
+		}
+		return uint64(int64(a) / int64(b))
+	case 4:
+		if b == 0 {
+			in.trapf("integer divide by zero")
+		}
+		return a / b
+	case 5:
+		if b == 0 {
+			in.trapf("integer divide by zero")
+		}
+		if int64(b) == -1 {
+			return 0
+		}
+		return uint64(int64(a) % int64(b))
+	case 6:
+		if b == 0 {
+			in.trapf("integer divide by zero")
+		}
+		return a % b
+	case 7:
+		return a & b
+	case 8:
+		return a | b
+	case 9:
+		return a ^ b
+	case 10:
+		return a << (b & 63)
+	case 11:
+		return uint64(int64(a) >> (b & 63))
+	case 12:
+		return a >> (b & 63)
+	case 13:
+		return bits.RotateLeft64(a, int(b&63))
+	default:
+		return bits.RotateLeft64(a, -int(b&63))
+	}
+}
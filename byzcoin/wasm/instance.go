@@ -0,0 +1,218 @@
+package wasm
+
+import (
+	"fmt"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrOutOfFuel is returned when a call uses all the fuel of the instance.
+var ErrOutOfFuel = xerrors.New("out of fuel")
+
+// maxCallDepth limits the number of nested calls of functions.
+const maxCallDepth = 512
+
+// HostFunc is a function of the host that can be imported by the modules. It
+// gets the arguments of the call, as the i32 and i64 values of WebAssembly,
+// and returns the results. An error stops the execution of the call.
+type HostFunc func(in *Instance, args []uint64) ([]uint64, error)
+
+// Imports are the host functions given to an instance, by module and by name.
+type Imports map[string]map[string]HostFunc
+
+// Instance is a module with its own memory, globals and table. An instance
+// is not safe for concurrent use.
+type Instance struct {
+	module   *Module
+	host     []HostFunc
+	memory   []byte
+	maxPages uint32
+	globals  []uint64
+	// table holds the indexes of the functions, or -1 for a null element.
+	table []int64
+	fuel  uint64
+	depth int
+}
+
+// trap is raised as a panic to stop the execution of a call, and is
+// recovered by Call.
+type trap struct {
+	err error
+}
+
+// NewInstance instantiates the module with the host functions. The fuel is
+// used by the start function, if any, and then by the calls. The memory can
+// never grow beyond MaxPages.
+func NewInstance(m *Module, imports Imports, fuel uint64) (*Instance, error) {
+	in := &Instance{module: m, fuel: fuel}
+	for _, imp := range m.imports {
+		f, ok := imports[imp.Module][imp.Name]
+		if !ok {
+			return nil, xerrors.Errorf("missing import %s.%s", imp.Module, imp.Name)
+		}
+		in.host = append(in.host, f)
+	}
+	if m.memory != nil {
+		in.memory = make([]byte, int(m.memory.min)*PageSize)
+		in.maxPages = MaxPages
+		if m.memory.hasMax && m.memory.max < MaxPages {
+			in.maxPages = m.memory.max
+		}
+	}
+	for _, g := range m.globals {
+		in.globals = append(in.globals, g.init.value)
+	}
+	if m.table != nil {
+		in.table = make([]int64, m.table.min)
+		for i := range in.table {
+			in.table[i] = -1
+		}
+	}
+	for _, e := range m.elements {
+		offset := e.offset.value
+		if offset+uint64(len(e.funcs)) > uint64(len(in.table)) {
+			return nil, xerrors.New("elements are out of the table")
+		}
+		for i, f := range e.funcs {
+			in.table[offset+uint64(i)] = int64(f)
+		}
+	}
+	for _, d := range m.data {
+		offset := d.offset.value
+		if offset+uint64(len(d.init)) > uint64(len(in.memory)) {
+			return nil, xerrors.New("data is out of the memory")
+		}
+		copy(in.memory[offset:], d.init)
+	}
+	if m.start != nil {
+		if _, err := in.safeCall(*m.start, nil); err != nil {
+			return nil, xerrors.Errorf("start function: %v", err)
+		}
+	}
+	return in, nil
+}
+
+// Call calls the exported function with the arguments and returns its
+// results. The i32 values are given and returned in the lower 32 bits.
+func (in *Instance) Call(name string, args ...uint64) ([]uint64, error) {
+	idx, ok := in.module.exports[name]
+	if !ok {
+		return nil, xerrors.Errorf("no exported function %s", name)
+	}
+	t := in.module.funcType(idx)
+	if len(args) != len(t.Params) {
+		return nil, xerrors.Errorf("%s needs %d arguments, got %d", name,
+			len(t.Params), len(args))
+	}
+	args = append([]uint64{}, args...)
+	for i, vt := range t.Params {
+		if vt == I32 {
+			args[i] = uint64(uint32(args[i]))
+		}
+	}
+	return in.safeCall(idx, args)
+}
+
+// safeCall calls the function and turns the traps into errors.
+func (in *Instance) safeCall(idx uint32, args []uint64) (res []uint64, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			in.depth = 0
+			if t, ok := r.(trap); ok {
+				err = t.err
+			} else {
+				// A function that is not well typed ends up with
+				// the runtime errors of the interpreter, which are
+				// the same on all the nodes.
+				err = xerrors.Errorf("trap: %v", r)
+			}
+			res = nil
+		}
+	}()
+	res = in.call(idx, args)
+	return
+}
+
+// Fuel returns the fuel left.
+func (in *Instance) Fuel() uint64 {
+	return in.fuel
+}
+
+// UseFuel uses some fuel, for the work done by a host function. ErrOutOfFuel
+// is returned if there isn't enough fuel, which the host function should
+// return to stop the call.
+func (in *Instance) UseFuel(n uint64) error {
+	if n > in.fuel {
+		in.fuel = 0
+		return ErrOutOfFuel
+	}
+	in.fuel -= n
+	return nil
+}
+
+func (in *Instance) useFuel(n uint64) {
+	if err := in.UseFuel(n); err != nil {
+		panic(trap{err})
+	}
+}
+
+// Memory returns the linear memory of the instance. It changes when the
+// memory grows.
+func (in *Instance) Memory() []byte {
+	return in.memory
+}
+
+// Read returns a copy of size bytes of the memory, starting at ptr.
+func (in *Instance) Read(ptr, size uint32) ([]byte, error) {
+	if uint64(ptr)+uint64(size) > uint64(len(in.memory)) {
+		return nil, xerrors.New("out of bounds memory access")
+	}
+	return append([]byte{}, in.memory[ptr:ptr+size]...), nil
+}
+
+// Write copies buf to the memory, starting at ptr.
+func (in *Instance) Write(ptr uint32, buf []byte) error {
+	if uint64(ptr)+uint64(len(buf)) > uint64(len(in.memory)) {
+		return xerrors.New("out of bounds memory access")
+	}
+	copy(in.memory[ptr:], buf)
+	return nil
+}
+
+func (in *Instance) trapf(format string, a ...interface{}) {
+	panic(trap{xerrors.New(fmt.Sprintf(format, a...))})
+}
+
+// call calls a function of the host or of the module.
+func (in *Instance) call(idx uint32, args []uint64) []uint64 {
+	m := in.module
+	if int(idx) < len(m.imports) {
+		in.useFuel(1)
+		imp := m.imports[idx]
+		res, err := in.host[idx](in, args)
+		if err != nil {
+			panic(trap{xerrors.Errorf("%s.%s: %w", imp.Module, imp.Name, err)})
+		}
+		if len(res) != len(imp.Type.Results) {
+			in.trapf("%s.%s returned %d results instead of %d", imp.Module,
+				imp.Name, len(res), len(imp.Type.Results))
+		}
+		for i, vt := range imp.Type.Results {
+			if vt == I32 {
+				res[i] = uint64(uint32(res[i]))
+			}
+		}
+		return res
+	}
+	if in.depth >= maxCallDepth {
+		in.trapf("call stack exhausted")
+	}
+	f := &m.functions[int(idx)-len(m.imports)]
+	in.useFuel(uint64(len(f.locals)) + 1)
+	locals := make([]uint64, len(args)+len(f.locals))
+	copy(locals, args)
+	in.depth++
+	res := in.run(f, locals)
+	in.depth--
+	return res
+}
@@ -0,0 +1,773 @@
+// Package wasm is a small WebAssembly interpreter written in pure Go. It is
+// meant to run contracts deployed by the users of ByzCoin, so it only
+// supports what can be executed deterministically on all the nodes: the
+// instructions on floating point numbers are refused when the module is
+// decoded, the memory is limited and every instruction uses fuel, so that a
+// call always stops.
+//
+// The functions of the modules are not type-checked: a function that doesn't
+// follow the typing rules of WebAssembly traps when it runs, the same way on
+// every node.
+package wasm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+// ValueType is the type of a value in WebAssembly. Only the integer types are
+// supported.
+type ValueType byte
+
+const (
+	// I32 is a 32-bit integer.
+	I32 ValueType = 0x7f
+	// I64 is a 64-bit integer.
+	I64 ValueType = 0x7e
+)
+
+// FuncType is the signature of a function.
+type FuncType struct {
+	Params  []ValueType
+	Results []ValueType
+}
+
+func (t FuncType) equal(o FuncType) bool {
+	return bytes.Equal(valueTypes(t.Params), valueTypes(o.Params)) &&
+		bytes.Equal(valueTypes(t.Results), valueTypes(o.Results))
+}
+
+func valueTypes(vts []ValueType) []byte {
+	buf := make([]byte, len(vts))
+	for i, vt := range vts {
+		buf[i] = byte(vt)
+	}
+	return buf
+}
+
+// PageSize is the size of a page of the linear memory.
+const PageSize = 1 << 16
+
+// MaxPages is the maximum number of pages of the linear memory of an
+// instance, whatever the module asks for.
+const MaxPages = 256
+
+// maxFunctionLocals limits the number of locals of a function, so that a
+// small module cannot make the nodes allocate a lot of memory.
+const maxFunctionLocals = 1 << 14
+
+// Import is a function imported by the module.
+type Import struct {
+	Module string
+	Name   string
+	Type   FuncType
+}
+
+const (
+	externFunc   = 0x00
+	externTable  = 0x01
+	externMemory = 0x02
+	externGlobal = 0x03
+)
+
+type limits struct {
+	min    uint32
+	max    uint32
+	hasMax bool
+}
+
+type global struct {
+	typ     ValueType
+	mutable bool
+	init    constExpr
+}
+
+// constExpr is an initializer, which is either a constant or the value of an
+// imported global. As there are no imported globals, only constants are
+// supported.
+type constExpr struct {
+	value uint64
+}
+
+type element struct {
+	offset constExpr
+	funcs  []uint32
+}
+
+type data struct {
+	offset constExpr
+	init   []byte
+}
+
+type function struct {
+	typ    FuncType
+	locals []ValueType
+	code   []instr
+}
+
+// Module is a decoded WebAssembly module, which can be instantiated any
+// number of times.
+type Module struct {
+	types     []FuncType
+	imports   []Import
+	functions []function
+	table     *limits
+	memory    *limits
+	globals   []global
+	exports   map[string]uint32
+	start     *uint32
+	elements  []element
+	data      []data
+}
+
+// Imports returns the functions imported by the module.
+func (m *Module) Imports() []Import {
+	return m.imports
+}
+
+// ExportedFunction returns the type of the exported function, and false if
+// there is no such function.
+func (m *Module) ExportedFunction(name string) (FuncType, bool) {
+	idx, ok := m.exports[name]
+	if !ok {
+		return FuncType{}, false
+	}
+	return m.funcType(idx), true
+}
+
+// funcType returns the type of a function, imported or not.
+func (m *Module) funcType(idx uint32) FuncType {
+	if int(idx) < len(m.imports) {
+		return m.imports[idx].Type
+	}
+	return m.functions[int(idx)-len(m.imports)].typ
+}
+
+func (m *Module) numFuncs() int {
+	return len(m.imports) + len(m.functions)
+}
+
+const (
+	sectionCustom    = 0
+	sectionType      = 1
+	sectionImport    = 2
+	sectionFunction  = 3
+	sectionTable     = 4
+	sectionMemory    = 5
+	sectionGlobal    = 6
+	sectionExport    = 7
+	sectionStart     = 8
+	sectionElement   = 9
+	sectionCode      = 10
+	sectionData      = 11
+	sectionDataCount = 12
+)
+
+var magic = []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+
+// Decode parses the binary format of a WebAssembly module. Only the features
+// needed by the integer programs produced by the usual compilers are
+// supported.
+func Decode(buf []byte) (*Module, error) {
+	if !bytes.HasPrefix(buf, magic) {
+		return nil, xerrors.New("not a WebAssembly module of version 1")
+	}
+	m := &Module{exports: make(map[string]uint32)}
+	r := &reader{buf: buf, pos: len(magic)}
+	var funcTypes []uint32
+	last := 0
+	for r.pos < len(r.buf) {
+		id, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		content, err := r.bytes(int(size))
+		if err != nil {
+			return nil, xerrors.Errorf("section %d: %v", id, err)
+		}
+		if id == sectionCustom {
+			continue
+		}
+		// The data count section comes between the element and the code
+		// sections.
+		order := int(id)
+		if id == sectionDataCount {
+			order = sectionElement + 1
+		} else if id >= sectionCode {
+			order++
+		}
+		if order <= last {
+			return nil, xerrors.Errorf("section %d is out of order", id)
+		}
+		last = order
+
+		s := &reader{buf: content}
+		switch id {
+		case sectionType:
+			err = m.decodeTypes(s)
+		case sectionImport:
+			err = m.decodeImports(s)
+		case sectionFunction:
+			funcTypes, err = m.decodeFunctions(s)
+		case sectionTable:
+			err = m.decodeTable(s)
+		case sectionMemory:
+			err = m.decodeMemory(s)
+		case sectionGlobal:
+			err = m.decodeGlobals(s)
+		case sectionExport:
+			err = m.decodeExports(s)
+		case sectionStart:
+			err = m.decodeStart(s)
+		case sectionElement:
+			err = m.decodeElements(s)
+		case sectionDataCount:
+			_, err = s.u32()
+		case sectionCode:
+			err = m.decodeCode(s, funcTypes)
+			funcTypes = nil
+		case sectionData:
+			err = m.decodeData(s)
+		default:
+			err = xerrors.New("unknown section")
+		}
+		if err != nil {
+			return nil, xerrors.Errorf("section %d: %v", id, err)
+		}
+		if s.pos != len(s.buf) {
+			return nil, xerrors.Errorf("section %d is longer than its content", id)
+		}
+	}
+	if len(funcTypes) > 0 {
+		return nil, xerrors.New("functions without code")
+	}
+	return m, nil
+}
+
+func (m *Module) decodeTypes(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		form, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if form != 0x60 {
+			return xerrors.Errorf("invalid function type %#x", form)
+		}
+		var t FuncType
+		if t.Params, err = r.valueTypes(); err != nil {
+			return err
+		}
+		if t.Results, err = r.valueTypes(); err != nil {
+			return err
+		}
+		m.types = append(m.types, t)
+	}
+	return nil
+}
+
+func (m *Module) decodeImports(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		var imp Import
+		if imp.Module, err = r.name(); err != nil {
+			return err
+		}
+		if imp.Name, err = r.name(); err != nil {
+			return err
+		}
+		kind, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if kind != externFunc {
+			return xerrors.Errorf("%s.%s: only functions can be imported",
+				imp.Module, imp.Name)
+		}
+		idx, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if int(idx) >= len(m.types) {
+			return xerrors.Errorf("invalid type %d", idx)
+		}
+		imp.Type = m.types[idx]
+		m.imports = append(m.imports, imp)
+	}
+	return nil
+}
+
+func (m *Module) decodeFunctions(r *reader) ([]uint32, error) {
+	n, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+	if int(n) > len(r.buf) {
+		return nil, xerrors.New("too many functions")
+	}
+	types := make([]uint32, n)
+	for i := range types {
+		if types[i], err = r.u32(); err != nil {
+			return nil, err
+		}
+		if int(types[i]) >= len(m.types) {
+			return nil, xerrors.Errorf("invalid type %d", types[i])
+		}
+	}
+	return types, nil
+}
+
+func (m *Module) decodeTable(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	if n > 1 {
+		return xerrors.New("only one table is supported")
+	}
+	if n == 0 {
+		return nil
+	}
+	elemType, err := r.byte()
+	if err != nil {
+		return err
+	}
+	if elemType != 0x70 {
+		return xerrors.New("only tables of functions are supported")
+	}
+	l, err := r.limits()
+	if err != nil {
+		return err
+	}
+	if l.min > 1<<16 {
+		return xerrors.New("table is too big")
+	}
+	m.table = &l
+	return nil
+}
+
+func (m *Module) decodeMemory(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	if n > 1 {
+		return xerrors.New("only one memory is supported")
+	}
+	if n == 0 {
+		return nil
+	}
+	l, err := r.limits()
+	if err != nil {
+		return err
+	}
+	if l.min > MaxPages {
+		return xerrors.Errorf("memory needs more than %d pages", MaxPages)
+	}
+	m.memory = &l
+	return nil
+}
+
+func (m *Module) decodeGlobals(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		var g global
+		if g.typ, err = r.valueType(); err != nil {
+			return err
+		}
+		mut, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if mut > 1 {
+			return xerrors.Errorf("invalid mutability %d", mut)
+		}
+		g.mutable = mut == 1
+		if g.init, err = r.constExpr(); err != nil {
+			return err
+		}
+		m.globals = append(m.globals, g)
+	}
+	return nil
+}
+
+func (m *Module) decodeExports(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		name, err := r.name()
+		if err != nil {
+			return err
+		}
+		kind, err := r.byte()
+		if err != nil {
+			return err
+		}
+		idx, err := r.u32()
+		if err != nil {
+			return err
+		}
+		// Only the functions can be used from the outside, the other
+		// exports are ignored.
+		if kind != externFunc {
+			continue
+		}
+		if _, ok := m.exports[name]; ok {
+			return xerrors.Errorf("duplicate export %s", name)
+		}
+		m.exports[name] = idx
+	}
+	return nil
+}
+
+func (m *Module) decodeStart(r *reader) error {
+	idx, err := r.u32()
+	if err != nil {
+		return err
+	}
+	m.start = &idx
+	return nil
+}
+
+func (m *Module) decodeElements(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		flags, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if flags != 0 {
+			return xerrors.New("only active elements of table 0 are supported")
+		}
+		var e element
+		if e.offset, err = r.constExpr(); err != nil {
+			return err
+		}
+		count, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if int(count) > len(r.buf) {
+			return xerrors.New("too many elements")
+		}
+		e.funcs = make([]uint32, count)
+		for j := range e.funcs {
+			if e.funcs[j], err = r.u32(); err != nil {
+				return err
+			}
+		}
+		m.elements = append(m.elements, e)
+	}
+	return nil
+}
+
+func (m *Module) decodeCode(r *reader, types []uint32) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	if int(n) != len(types) {
+		return xerrors.New("number of functions and bodies don't match")
+	}
+	for i := uint32(0); i < n; i++ {
+		size, err := r.u32()
+		if err != nil {
+			return err
+		}
+		body, err := r.bytes(int(size))
+		if err != nil {
+			return err
+		}
+		f := function{typ: m.types[types[i]]}
+		b := &reader{buf: body}
+		groups, err := b.u32()
+		if err != nil {
+			return err
+		}
+		for j := uint32(0); j < groups; j++ {
+			count, err := b.u32()
+			if err != nil {
+				return err
+			}
+			vt, err := b.valueType()
+			if err != nil {
+				return err
+			}
+			if len(f.locals)+int(count) > maxFunctionLocals {
+				return xerrors.Errorf("function %d has too many locals", i)
+			}
+			for k := uint32(0); k < count; k++ {
+				f.locals = append(f.locals, vt)
+			}
+		}
+		if f.code, err = m.compile(b); err != nil {
+			return xerrors.Errorf("function %d: %v", i, err)
+		}
+		m.functions = append(m.functions, f)
+	}
+	return m.checkIndices()
+}
+
+// checkIndices makes sure that the functions, globals and types referred to
+// by the code and the exports exist.
+func (m *Module) checkIndices() error {
+	for name, idx := range m.exports {
+		if int(idx) >= m.numFuncs() {
+			return xerrors.Errorf("export %s: invalid function %d", name, idx)
+		}
+	}
+	if m.start != nil {
+		if int(*m.start) >= m.numFuncs() {
+			return xerrors.Errorf("invalid start function %d", *m.start)
+		}
+		t := m.funcType(*m.start)
+		if len(t.Params) > 0 || len(t.Results) > 0 {
+			return xerrors.New("start function must not have parameters or results")
+		}
+	}
+	for _, e := range m.elements {
+		if m.table == nil {
+			return xerrors.New("elements without a table")
+		}
+		for _, idx := range e.funcs {
+			if int(idx) >= m.numFuncs() {
+				return xerrors.Errorf("element: invalid function %d", idx)
+			}
+		}
+	}
+	for i, f := range m.functions {
+		for _, in := range f.code {
+			var ok bool
+			switch in.op {
+			case opCall:
+				ok = int(in.imm) < m.numFuncs()
+			case opCallIndirect:
+				ok = int(in.imm) < len(m.types) && m.table != nil
+			case opGlobalGet:
+				ok = int(in.imm) < len(m.globals)
+			case opGlobalSet:
+				ok = int(in.imm) < len(m.globals) && m.globals[in.imm].mutable
+			case opLocalGet, opLocalSet, opLocalTee:
+				ok = int(in.imm) < len(f.typ.Params)+len(f.locals)
+			default:
+				continue
+			}
+			if !ok {
+				return xerrors.Errorf("function %d: invalid index %d for "+
+					"instruction %#x", i, in.imm, in.op)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Module) decodeData(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		flags, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if flags != 0 {
+			return xerrors.New("only active data of memory 0 is supported")
+		}
+		var d data
+		if d.offset, err = r.constExpr(); err != nil {
+			return err
+		}
+		size, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if d.init, err = r.bytes(int(size)); err != nil {
+			return err
+		}
+		m.data = append(m.data, d)
+	}
+	return nil
+}
+
+// reader reads the values of the binary format.
+type reader struct {
+	buf []byte
+	pos int
+}
+
+func (r *reader) byte() (byte, error) {
+	if r.pos >= len(r.buf) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *reader) bytes(n int) ([]byte, error) {
+	if n < 0 || n > len(r.buf)-r.pos {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *reader) u32() (uint32, error) {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 || n > 5 || v > 0xffffffff {
+		return 0, xerrors.New("invalid unsigned integer")
+	}
+	r.pos += n
+	return uint32(v), nil
+}
+
+// signed reads a signed LEB128 integer of the given size in bits.
+func (r *reader) signed(size uint) (int64, error) {
+	var result int64
+	var shift uint
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		if shift >= size+7 {
+			return 0, xerrors.New("invalid signed integer")
+		}
+		result |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				result |= -1 << shift
+			}
+			break
+		}
+	}
+	if size < 64 {
+		min, max := int64(-1)<<(size-1), int64(1)<<(size-1)-1
+		if result < min || result > max {
+			return 0, xerrors.New("signed integer out of range")
+		}
+	}
+	return result, nil
+}
+
+func (r *reader) name() (string, error) {
+	n, err := r.u32()
+	if err != nil {
+		return "", err
+	}
+	b, err := r.bytes(int(n))
+	return string(b), err
+}
+
+func (r *reader) valueType() (ValueType, error) {
+	b, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	switch vt := ValueType(b); vt {
+	case I32, I64:
+		return vt, nil
+	case 0x7d, 0x7c:
+		return 0, xerrors.New("floating point numbers are not supported")
+	default:
+		return 0, xerrors.Errorf("invalid value type %#x", b)
+	}
+}
+
+func (r *reader) valueTypes() ([]ValueType, error) {
+	n, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+	if int(n) > len(r.buf) {
+		return nil, xerrors.New("too many value types")
+	}
+	vts := make([]ValueType, n)
+	for i := range vts {
+		if vts[i], err = r.valueType(); err != nil {
+			return nil, err
+		}
+	}
+	return vts, nil
+}
+
+func (r *reader) limits() (limits, error) {
+	flag, err := r.byte()
+	if err != nil {
+		return limits{}, err
+	}
+	var l limits
+	if l.min, err = r.u32(); err != nil {
+		return l, err
+	}
+	switch flag {
+	case 0:
+	case 1:
+		if l.max, err = r.u32(); err != nil {
+			return l, err
+		}
+		if l.max < l.min {
+			return l, xerrors.New("maximum is smaller than minimum")
+		}
+		l.hasMax = true
+	default:
+		return l, xerrors.Errorf("invalid limits %#x", flag)
+	}
+	return l, nil
+}
+
+func (r *reader) constExpr() (constExpr, error) {
+	op, err := r.byte()
+	if err != nil {
+		return constExpr{}, err
+	}
+	var e constExpr
+	switch op {
+	case opI32Const:
+		v, err := r.signed(32)
+		if err != nil {
+			return e, err
+		}
+		e.value = uint64(uint32(v))
+	case opI64Const:
+		v, err := r.signed(64)
+		if err != nil {
+			return e, err
+		}
+		e.value = uint64(v)
+	default:
+		return e, xerrors.Errorf("unsupported initializer %#x", op)
+	}
+	end, err := r.byte()
+	if err != nil {
+		return e, err
+	}
+	if end != opEnd {
+		return e, xerrors.New("initializer must be a single constant")
+	}
+	return e, nil
+}
@@ -0,0 +1,251 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+// The modules of the tests are assembled by hand, as there is no compiler
+// to WebAssembly in the tests.
+
+func leb(v uint32) []byte {
+	buf := make([]byte, binary.MaxVarintLen32)
+	return buf[:binary.PutUvarint(buf, uint64(v))]
+}
+
+func vec(items ...[]byte) []byte {
+	buf := leb(uint32(len(items)))
+	for _, item := range items {
+		buf = append(buf, item...)
+	}
+	return buf
+}
+
+func section(id byte, content []byte) []byte {
+	return append(append([]byte{id}, leb(uint32(len(content)))...), content...)
+}
+
+func str(s string) []byte {
+	return append(leb(uint32(len(s))), s...)
+}
+
+func funcType(params, results []byte) []byte {
+	return append(append([]byte{0x60}, vec(bytesOf(params)...)...),
+		vec(bytesOf(results)...)...)
+}
+
+func bytesOf(buf []byte) [][]byte {
+	items := make([][]byte, len(buf))
+	for i := range buf {
+		items[i] = buf[i : i+1]
+	}
+	return items
+}
+
+func export(name string, idx uint32) []byte {
+	return append(append(str(name), externFunc), leb(idx)...)
+}
+
+func body(locals []byte, code ...byte) []byte {
+	b := append(locals, code...)
+	return append(leb(uint32(len(b))), b...)
+}
+
+const (
+	i32 = byte(I32)
+	i64 = byte(I64)
+)
+
+func testModule() []byte {
+	m := append([]byte{}, magic...)
+	m = append(m, section(sectionType, vec(
+		funcType([]byte{i64}, []byte{i64}),
+		funcType([]byte{i32}, []byte{i32}),
+		funcType(nil, nil),
+		funcType([]byte{i32, i32}, []byte{i32}),
+	))...)
+	m = append(m, section(sectionImport, vec(
+		append(append(str("env"), str("add")...), externFunc, 3),
+	))...)
+	m = append(m, section(sectionFunction, vec(
+		[]byte{0}, []byte{1}, []byte{2}, []byte{2}, []byte{1}, []byte{1},
+		[]byte{1}, []byte{1}, []byte{1}, []byte{1},
+	))...)
+	m = append(m, section(sectionTable, vec([]byte{0x70, 0, 2}))...)
+	m = append(m, section(sectionMemory, vec([]byte{1, 1, 2}))...)
+	m = append(m, section(sectionExport, vec(
+		export("fac", 1), export("sum", 2), export("forever", 3),
+		export("recurse", 4), export("host", 5), export("div", 6),
+		export("mem", 7), export("grow", 8), export("switch", 9),
+		export("indirect", 10),
+	))...)
+	m = append(m, section(sectionElement, vec(
+		[]byte{0, opI32Const, 0, opEnd, 1, 2},
+	))...)
+	m = append(m, section(sectionCode, vec(
+		// fac: recursive factorial
+		body([]byte{0}, 0x20, 0, 0x50, 0x04, i64, 0x42, 1, 0x05, 0x20, 0,
+			0x20, 0, 0x42, 1, 0x7d, 0x10, 1, 0x7e, 0x0b, 0x0b),
+		// sum: 1 + 2 + ... + n with a loop
+		body([]byte{1, 1, i32}, 0x02, 0x40, 0x03, 0x40, 0x20, 0, 0x45,
+			0x0d, 1, 0x20, 1, 0x20, 0, 0x6a, 0x21, 1, 0x20, 0, 0x41, 1,
+			0x6b, 0x21, 0, 0x0c, 0, 0x0b, 0x0b, 0x20, 1, 0x0b),
+		// forever: an endless loop
+		body([]byte{0}, 0x03, 0x40, 0x0c, 0, 0x0b, 0x0b),
+		// recurse: an endless recursion
+		body([]byte{0}, 0x10, 4, 0x0b),
+		// host: env.add(n, 2)
+		body([]byte{0}, 0x20, 0, 0x41, 2, 0x10, 0, 0x0b),
+		// div: 1 / n
+		body([]byte{0}, 0x41, 1, 0x20, 0, 0x6e, 0x0b),
+		// mem: stores n at 8 and adds its low byte to the data at 0
+		body([]byte{0}, 0x41, 8, 0x20, 0, 0x36, 2, 0, 0x41, 8, 0x2d, 0, 0,
+			0x41, 0, 0x28, 2, 0, 0x6a, 0x0b),
+		// grow: memory.grow(n)
+		body([]byte{0}, 0x20, 0, 0x40, 0, 0x0b),
+		// switch: 10, 20 or 30 with br_table
+		body([]byte{0}, 0x02, 0x40, 0x02, 0x40, 0x02, 0x40, 0x20, 0, 0x0e,
+			2, 0, 1, 2, 0x0b, 0x41, 10, 0x0f, 0x0b, 0x41, 20, 0x0f, 0x0b,
+			0x41, 30, 0x0b),
+		// indirect: sum(n) through the table
+		body([]byte{0}, 0x20, 0, 0x41, 0, 0x11, 1, 0, 0x0b),
+	))...)
+	m = append(m, section(sectionData, vec(
+		[]byte{0, opI32Const, 0, opEnd, 4, 5, 0, 0, 0},
+	))...)
+	return m
+}
+
+var errHost = xerrors.New("unlucky number")
+
+func newTestInstance(t *testing.T, fuel uint64) *Instance {
+	m, err := Decode(testModule())
+	require.NoError(t, err)
+	require.Len(t, m.Imports(), 1)
+	ft, ok := m.ExportedFunction("fac")
+	require.True(t, ok)
+	require.Equal(t, []ValueType{I64}, ft.Results)
+
+	in, err := NewInstance(m, Imports{"env": {
+		"add": func(in *Instance, args []uint64) ([]uint64, error) {
+			if args[0] == 13 {
+				return nil, errHost
+			}
+			return []uint64{args[0] + args[1]}, nil
+		},
+	}}, fuel)
+	require.NoError(t, err)
+	return in
+}
+
+func TestInstance_Call(t *testing.T) {
+	in := newTestInstance(t, 1e6)
+
+	for _, c := range []struct {
+		name string
+		arg  uint64
+		res  uint64
+	}{
+		{"fac", 20, 2432902008176640000},
+		{"sum", 100, 5050},
+		{"host", 40, 42},
+		{"div", 1, 1},
+		{"mem", 0x1ff, 0xff + 5},
+		{"switch", 0, 10},
+		{"switch", 1, 20},
+		{"switch", 5, 30},
+		{"indirect", 10, 55},
+		{"grow", 1, 1},
+		{"grow", 1, 0xffffffff},
+	} {
+		res, err := in.Call(c.name, c.arg)
+		require.NoError(t, err, c.name)
+		require.Equal(t, []uint64{c.res}, res, c.name)
+	}
+	require.Len(t, in.Memory(), 2*PageSize)
+	buf, err := in.Read(8, 4)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0xff, 1, 0, 0}, buf)
+	require.Error(t, in.Write(2*PageSize-1, []byte{1, 2}))
+
+	_, err = in.Call("div", 0)
+	require.Error(t, err)
+	_, err = in.Call("host", 13)
+	require.True(t, xerrors.Is(err, errHost))
+	_, err = in.Call("recurse")
+	require.Error(t, err)
+	_, err = in.Call("sum")
+	require.Error(t, err)
+	_, err = in.Call("missing")
+	require.Error(t, err)
+
+	// The instance can still be used after the errors.
+	res, err := in.Call("sum", 3)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{6}, res)
+
+	_, err = in.Call("forever")
+	require.True(t, xerrors.Is(err, ErrOutOfFuel))
+	require.Equal(t, uint64(0), in.Fuel())
+	_, err = in.Call("sum", 3)
+	require.True(t, xerrors.Is(err, ErrOutOfFuel))
+}
+
+func TestInstance_Fuel(t *testing.T) {
+	// The fuel used by a call is always the same.
+	in1 := newTestInstance(t, 1e6)
+	in2 := newTestInstance(t, 1e6)
+	_, err := in1.Call("fac", 10)
+	require.NoError(t, err)
+	_, err = in2.Call("fac", 10)
+	require.NoError(t, err)
+	require.Equal(t, in1.Fuel(), in2.Fuel())
+	require.True(t, in1.Fuel() < 1e6)
+
+	// A call that doesn't have enough fuel stops.
+	in := newTestInstance(t, 100)
+	_, err = in.Call("sum", 1000)
+	require.True(t, xerrors.Is(err, ErrOutOfFuel))
+}
+
+func TestDecode(t *testing.T) {
+	_, err := Decode([]byte("not wasm"))
+	require.Error(t, err)
+	_, err = Decode(testModule()[:100])
+	require.Error(t, err)
+
+	// Floating point numbers are refused.
+	m := append([]byte{}, magic...)
+	_, err = Decode(append(m, section(sectionType, vec(
+		funcType([]byte{0x7d}, nil)))...))
+	require.Error(t, err)
+	m = append(m, section(sectionType, vec(funcType(nil, nil)))...)
+	m = append(m, section(sectionFunction, vec([]byte{0}))...)
+	_, err = Decode(append(m, section(sectionCode, vec(
+		body([]byte{0}, 0x43, 0, 0, 0, 0, 0x1a, 0x0b)))...))
+	require.Error(t, err)
+
+	// Only functions can be imported.
+	m = append([]byte{}, magic...)
+	_, err = Decode(append(m, section(sectionImport, vec(
+		append(append(str("env"), str("memory")...), externMemory, 0, 1),
+	))...))
+	require.Error(t, err)
+
+	// Blocks must be closed.
+	m = append([]byte{}, magic...)
+	m = append(m, section(sectionType, vec(funcType(nil, nil)))...)
+	m = append(m, section(sectionFunction, vec([]byte{0}))...)
+	_, err = Decode(append(m, section(sectionCode, vec(
+		body([]byte{0}, 0x02, 0x40, 0x0b)))...))
+	require.Error(t, err)
+
+	// Missing imports are refused when instantiating.
+	mod, err := Decode(testModule())
+	require.NoError(t, err)
+	_, err = NewInstance(mod, nil, 1e6)
+	require.Error(t, err)
+}
@@ -250,7 +250,7 @@ func (s *Service) getPopContract(bcID skipchain.SkipBlockID, phIID []byte) (*con
 	if cid != contracts.ContractPopPartyID {
 		return nil, errors.New("this is not a personhood contract")
 	}
-	cpop, err := s.byzcoinService().GetContractInstance(contracts.ContractPopPartyID, val)
+	cpop, err := s.byzcoinService().GetActiveContractInstance(bcID, contracts.ContractPopPartyID, val)
 	return cpop.(*contracts.ContractPopParty), err
 }
 
@@ -294,7 +294,7 @@ func (s *Service) RoPaSciList(rq *RoPaSciList) (*RoPaSciListResponse, error) {
 			if err != nil {
 				return err
 			}
-			cbc, err := s.byzcoinService().GetContractInstance(contracts.ContractRoPaSciID, buf)
+			cbc, err := s.byzcoinService().GetActiveContractInstance(rps.ByzcoinID, contracts.ContractRoPaSciID, buf)
 			if err != nil {
 				return err
 			}
@@ -1267,6 +1267,23 @@ func (db *SkipBlockDB) RemoveBlock(blockID SkipBlockID) error {
 	})
 }
 
+// PrunePayload removes the payload of the block, but keeps the block itself so
+// that it can still be used in proofs. As the hash of the block doesn't cover
+// the payload, the block stays valid.
+func (db *SkipBlockDB) PrunePayload(blockID SkipBlockID) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		sb, err := db.getFromTx(tx, blockID)
+		if err != nil {
+			return err
+		}
+		if sb == nil {
+			return errors.New("couldn't find the block")
+		}
+		sb.Payload = nil
+		return db.storeToTx(tx, sb)
+	})
+}
+
 // storeToTx stores the skipblock into the database.
 // An error is returned on failure.
 // The caller must ensure that this function is called from within a valid transaction.
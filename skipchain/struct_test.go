@@ -415,6 +415,29 @@ func TestSkipBlockDB_GetProof(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestSkipBlockDB_PrunePayload(t *testing.T) {
+	local := onet.NewLocalTest(suite)
+	_, ro, _ := local.GenTree(2, false)
+	defer local.CloseAll()
+
+	db, file := setupSkipBlockDB(t)
+	defer os.Remove(file)
+
+	sb := NewSkipBlock()
+	sb.Roster = ro
+	sb.Payload = []byte("payload")
+	sb.updateHash()
+	db.Store(sb)
+
+	require.NoError(t, db.PrunePayload(sb.Hash))
+	pruned := db.GetByID(sb.Hash)
+	require.NotNil(t, pruned)
+	require.Nil(t, pruned.Payload)
+	require.True(t, pruned.Hash.Equal(pruned.CalculateHash()))
+
+	require.Error(t, db.PrunePayload(SkipBlockID{1}))
+}
+
 // Test the edge cases of the verification function
 func TestProof_Verify(t *testing.T) {
 	sb := NewSkipBlock()